@@ -0,0 +1,333 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package conformance checks the GTP message codecs against a corpus of messages,
+// which is meant to be captured from real equipment.
+//
+// Each message in the corpus is decoded, re-encoded and compared byte by byte with
+// the original. The IEs that the typed messages could not put in their fields
+// (AdditionalIEs), and the GTPv2 IEs whose payloads are only partially consumed by
+// the corresponding Parse*Fields functions, are reported as well.
+//
+// The corpus is a directory that contains pcap files and hex files. See LoadDir
+// for the formats, and testdata/corpus for the corpus used in the tests of this package.
+// Note that the corpus in testdata is synthetic, not captured from real equipment,
+// so it does not cover the quirks of real vendors yet.
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	v0msg "github.com/wmnsk/go-gtp/gtpv0/message"
+	v1msg "github.com/wmnsk/go-gtp/gtpv1/message"
+	v2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	v2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Capture is a GTP message in the corpus.
+type Capture struct {
+	// Name identifies the message, e.g., "foo.pcap#12" or "bar.hex:3".
+	Name string
+
+	// Data is the UDP payload.
+	Data []byte
+}
+
+// Version returns the GTP version of the message, taken from the first octet.
+func (c *Capture) Version() int {
+	if len(c.Data) == 0 {
+		return -1
+	}
+	return int(c.Data[0] >> 5)
+}
+
+// Result is the result of Check.
+type Result struct {
+	Capture     *Capture
+	MessageType string
+
+	// Err is the error in decoding or encoding the message.
+	Err error
+
+	// Mismatch is the offset of the first octet that differs between the original
+	// and the re-encoded message, or -1 if they are identical.
+	Mismatch int
+	Encoded  []byte
+
+	// AdditionalIEs are the names of the IEs in AdditionalIEs of the typed message.
+	AdditionalIEs []string
+
+	// PartialIEs describe the IEs whose payloads are not fully decoded.
+	PartialIEs []string
+}
+
+// OK reports whether the message is decoded and re-encoded byte-exactly.
+func (r *Result) OK() bool {
+	return r.Err == nil && r.Mismatch < 0
+}
+
+// String returns the Result in human readable format.
+func (r *Result) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (%s): ", r.Capture.Name, r.MessageType)
+	switch {
+	case r.Err != nil:
+		fmt.Fprintf(&sb, "error: %v", r.Err)
+	case r.Mismatch >= 0:
+		fmt.Fprintf(&sb, "mismatch at offset %d\n  original: %x\n  encoded:  %x", r.Mismatch, r.Capture.Data, r.Encoded)
+	default:
+		sb.WriteString("ok")
+	}
+	if len(r.AdditionalIEs) > 0 {
+		fmt.Fprintf(&sb, ", additional IEs: %s", strings.Join(r.AdditionalIEs, ", "))
+	}
+	if len(r.PartialIEs) > 0 {
+		fmt.Fprintf(&sb, ", partially decoded IEs: %s", strings.Join(r.PartialIEs, ", "))
+	}
+	return sb.String()
+}
+
+type message interface {
+	MessageTypeName() string
+	MarshalLen() int
+}
+
+// Check decodes and re-encodes the message in c, and reports the result.
+func Check(c *Capture) *Result {
+	r := &Result{Capture: c, Mismatch: -1}
+
+	var (
+		msg     message
+		raw     = c.Data
+		marshal func() ([]byte, error)
+	)
+	switch c.Version() {
+	case 0:
+		m, err := v0msg.Parse(raw)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		msg, marshal = m, func() ([]byte, error) { return v0msg.Marshal(m) }
+	case 1:
+		m, err := v1msg.Parse(raw)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		msg, marshal = m, func() ([]byte, error) { return v1msg.Marshal(m) }
+	case 2:
+		// only the first message is compared if piggybacked.
+		if h, err := v2msg.ParseHeader(raw); err == nil && h.MarshalLen() < len(raw) {
+			raw = raw[:h.MarshalLen()]
+		}
+		m, err := v2msg.Parse(raw)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		msg, marshal = m, func() ([]byte, error) { return v2msg.Marshal(m) }
+		r.PartialIEs = partialIEs(raw)
+	default:
+		r.Err = fmt.Errorf("unsupported GTP version: %d", c.Version())
+		return r
+	}
+
+	r.MessageType = msg.MessageTypeName()
+	r.AdditionalIEs = additionalIEs(msg)
+
+	b, err := marshal()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Encoded = b
+	r.Mismatch = mismatch(raw, b)
+	return r
+}
+
+// mismatch returns the offset of the first different octet, or -1.
+func mismatch(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+	for i := range a {
+		if i >= len(b) || a[i] != b[i] {
+			return i
+		}
+	}
+	return len(a)
+}
+
+// additionalIEs returns the names of IEs in the AdditionalIEs field of msg.
+func additionalIEs(msg message) []string {
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	f := v.Elem().FieldByName("AdditionalIEs")
+	if !f.IsValid() || f.Kind() != reflect.Slice {
+		return nil
+	}
+
+	var names []string
+	for i := 0; i < f.Len(); i++ {
+		if n, ok := f.Index(i).Interface().(interface{ Name() string }); ok {
+			names = append(names, n.Name())
+		}
+	}
+	return names
+}
+
+// fieldParsers parse the payload of GTPv2 IEs and return the length consumed.
+var fieldParsers = map[uint8]func(b []byte) (int, error){
+	v2ie.AdditionalProtocolConfigurationOptions: func(b []byte) (int, error) {
+		f, err := v2ie.ParseProtocolConfigurationOptionsFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.AggregateMaximumBitRate: func(b []byte) (int, error) {
+		f, err := v2ie.ParseAggregateMaximumBitRateFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.BearerQoS: func(b []byte) (int, error) {
+		f, err := v2ie.ParseBearerQoSFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.ExtendedProtocolConfigurationOptions: func(b []byte) (int, error) {
+		f, err := v2ie.ParseExtendedProtocolConfigurationOptionsFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.FlowQoS: func(b []byte) (int, error) {
+		f, err := v2ie.ParseFlowQoSFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.FullyQualifiedTEID: func(b []byte) (int, error) {
+		f, err := v2ie.ParseFullyQualifiedTEIDFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.FullyQualifiedCSID: func(b []byte) (int, error) {
+		f, err := v2ie.ParseFullyQualifiedCSIDFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.GUTI: func(b []byte) (int, error) {
+		f, err := v2ie.ParseGUTIFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.PDNAddressAllocation: func(b []byte) (int, error) {
+		f, err := v2ie.ParsePDNAddressAllocationFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.ProtocolConfigurationOptions: func(b []byte) (int, error) {
+		f, err := v2ie.ParseProtocolConfigurationOptionsFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.RANNASCause: func(b []byte) (int, error) {
+		f, err := v2ie.ParseRANNASCauseFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.Throttling: func(b []byte) (int, error) {
+		f, err := v2ie.ParseThrottlingFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.TraceReference: func(b []byte) (int, error) {
+		f, err := v2ie.ParseTraceReferenceFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.UserCSGInformation: func(b []byte) (int, error) {
+		f, err := v2ie.ParseUserCSGInformationFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+	v2ie.UserLocationInformation: func(b []byte) (int, error) {
+		f, err := v2ie.ParseUserLocationInformationFields(b)
+		if err != nil {
+			return 0, err
+		}
+		return f.MarshalLen(), nil
+	},
+}
+
+// partialIEs returns the descriptions of the GTPv2 IEs in raw that are not
+// fully consumed by the Parse*Fields functions.
+func partialIEs(raw []byte) []string {
+	h, err := v2msg.ParseHeader(raw)
+	if err != nil {
+		return nil
+	}
+	ies, err := v2ie.ParseMultiIEs(h.Payload)
+	if err != nil {
+		return []string{fmt.Sprintf("IEs: %v", err)}
+	}
+
+	var partial []string
+	var walk func(prefix string, ies []*v2ie.IE)
+	walk = func(prefix string, ies []*v2ie.IE) {
+		for _, i := range ies {
+			name := fmt.Sprintf("%s%s[%d]", prefix, i.Name(), i.Instance())
+			if i.IsGrouped() {
+				walk(name+"/", i.ChildIEs)
+				continue
+			}
+
+			parse, ok := fieldParsers[i.Type]
+			if !ok {
+				continue
+			}
+			n, err := parse(i.Payload)
+			switch {
+			case err != nil:
+				partial = append(partial, fmt.Sprintf("%s: %v", name, err))
+			case n != len(i.Payload):
+				partial = append(partial, fmt.Sprintf("%s: %d/%d octets decoded", name, n, len(i.Payload)))
+			}
+		}
+	}
+	walk("", ies)
+	return partial
+}
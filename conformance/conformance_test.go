@@ -0,0 +1,142 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package conformance_test
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/wmnsk/go-gtp/conformance"
+)
+
+// run with `go test ./conformance -corpus /path/to/captures` to check the other corpus.
+var corpus = flag.String("corpus", "testdata/corpus", "directory of the corpus to check")
+
+func TestCorpus(t *testing.T) {
+	captures, err := conformance.LoadDir(*corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) == 0 {
+		t.Fatalf("no captures found in %s", *corpus)
+	}
+
+	for _, c := range captures {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			r := conformance.Check(c)
+			if !r.OK() {
+				t.Error(r)
+				return
+			}
+			if len(r.AdditionalIEs) > 0 || len(r.PartialIEs) > 0 {
+				t.Log(r)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		description string
+		hex         string
+		ok          bool
+		additional  []string
+		partial     int
+	}{
+		{
+			"round-trip",
+			"40010009000001000300010080",
+			true, nil, 0,
+		}, {
+			"unknown IE in AdditionalIEs",
+			"482000290000000000000100" + "0100080000011010325476f9" + "570009008a0000c0dfc000020b" + "fa000400deadbeef",
+			true, []string{"Undefined"}, 0,
+		}, {
+			"IEs out of order are re-ordered",
+			"4001000e00000100" + "9800010001" + "0300010080",
+			false, nil, 0,
+		}, {
+			"F-TEID with trailing octets",
+			"482000170000000000000100" + "57000b008a0000c0dfc000020b0000",
+			true, nil, 1,
+		}, {
+			"unsupported version",
+			"e0010009000001000300010080",
+			false, nil, 0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			captures, err := conformance.LoadHex(c.description, strings.NewReader(c.hex))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := conformance.Check(captures[0])
+			if got := r.OK(); got != c.ok {
+				t.Errorf("wrong result. got: %v, want: %v: %s", got, c.ok, r)
+			}
+			if got, want := strings.Join(r.AdditionalIEs, ","), strings.Join(c.additional, ","); !strings.HasPrefix(got, want) {
+				t.Errorf("wrong AdditionalIEs. got: %s, want: %s", got, want)
+			}
+			if got := len(r.PartialIEs); got != c.partial {
+				t.Errorf("wrong number of PartialIEs. got: %d, want: %d: %s", got, c.partial, r)
+			}
+		})
+	}
+}
+
+func TestLoadHex(t *testing.T) {
+	captures, err := conformance.LoadHex("test.hex", strings.NewReader(`# file comment
+
+# Echo Request
+40 01 00 09 00:00:01:00 03 00 01 00 80
+40010009000001000300010080
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(captures), 2; got != want {
+		t.Fatalf("wrong number of captures. got: %d, want: %d", got, want)
+	}
+	if got, want := captures[0].Name, "test.hex:4 Echo Request"; got != want {
+		t.Errorf("wrong name. got: %s, want: %s", got, want)
+	}
+	if got, want := captures[1].Name, "test.hex:5"; got != want {
+		t.Errorf("wrong name. got: %s, want: %s", got, want)
+	}
+	if string(captures[0].Data) != string(captures[1].Data) || captures[0].Version() != 2 {
+		t.Errorf("wrong data: %x, %x", captures[0].Data, captures[1].Data)
+	}
+
+	if _, err := conformance.LoadHex("invalid.hex", strings.NewReader("zz")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLoadPcap(t *testing.T) {
+	captures, err := conformance.LoadDir("testdata/corpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, c := range captures {
+		if strings.HasPrefix(c.Name, "synthetic-echo-tpdu.pcap") {
+			names = append(names, c.Name)
+		}
+	}
+	if got, want := strings.Join(names, ","), "synthetic-echo-tpdu.pcap#1,synthetic-echo-tpdu.pcap#2,synthetic-echo-tpdu.pcap#3"; got != want {
+		t.Errorf("wrong captures. got: %s, want: %s", got, want)
+	}
+
+	if _, err := conformance.LoadPcap("pcapng", strings.NewReader(strings.Repeat("\x0a\x0d\x0d\x0a", 6))); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package conformance
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GTP ports. The UDP datagrams from/to other ports are ignored in pcap files.
+const (
+	portGTPv0  = 3386
+	portGTPC   = 2123
+	portGTPU   = 2152
+	pcapMagic  = 0xa1b2c3d4
+	pcapMagicN = 0xa1b23c4d // nanosecond resolution
+)
+
+// link types supported in pcap files.
+const (
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+// ErrUnsupportedFormat indicates that the capture file is not in the supported format.
+var ErrUnsupportedFormat = errors.New("unsupported capture format")
+
+// LoadDir loads all the captures in the files in dir, sorted by the file names.
+//
+// The files are read by the extension;
+//
+// .pcap: classic libpcap format(not pcapng; convert it with `editcap -F pcap`)
+// with Ethernet, Linux cooked(v1/v2) or raw IP link type. The UDP payloads to/from
+// the port 2123, 2152 and 3386 are loaded. IP fragments are ignored.
+//
+// .hex: a message per line in hexadecimal. The whitespaces and colons in a line are
+// ignored, and the lines starting with "#" are comments. A comment just before a
+// message is used as its name.
+//
+// The other files are ignored.
+func LoadDir(dir string) ([]*Capture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var captures []*Capture
+	for _, name := range names {
+		var load func(name string, r io.Reader) ([]*Capture, error)
+		switch filepath.Ext(name) {
+		case ".pcap":
+			load = LoadPcap
+		case ".hex":
+			load = LoadHex
+		default:
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		cs, err := load(name, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", name, err)
+		}
+		captures = append(captures, cs...)
+	}
+	return captures, nil
+}
+
+// LoadHex loads the captures in the hex format from r. name is used as the prefix
+// of the name of each Capture. See LoadDir for the format.
+func LoadHex(name string, r io.Reader) ([]*Capture, error) {
+	var (
+		captures []*Capture
+		comment  string
+		lineNum  int
+	)
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			comment = ""
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			comment = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			continue
+		}
+
+		line = strings.NewReplacer(" ", "", "\t", "", ":", "").Replace(line)
+		b, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		c := &Capture{Name: fmt.Sprintf("%s:%d", name, lineNum), Data: b}
+		if comment != "" {
+			c.Name += " " + comment
+			comment = ""
+		}
+		captures = append(captures, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return captures, nil
+}
+
+// LoadPcap loads the GTP messages in the pcap format from r. name is used as the
+// prefix of the name of each Capture. See LoadDir for the format.
+func LoadPcap(name string, r io.Reader) ([]*Capture, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(hdr) == pcapMagic, binary.LittleEndian.Uint32(hdr) == pcapMagicN:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr) == pcapMagic, binary.BigEndian.Uint32(hdr) == pcapMagicN:
+		order = binary.BigEndian
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	linkType := order.Uint32(hdr[20:24]) & 0x0fffffff
+
+	var captures []*Capture
+	rec := make([]byte, 16)
+	for n := 1; ; n++ {
+		if _, err := io.ReadFull(r, rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return captures, nil
+			}
+			return nil, err
+		}
+
+		frame := make([]byte, order.Uint32(rec[8:12]))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+
+		payload, ok := udpPayload(linkType, frame)
+		if !ok {
+			continue
+		}
+		captures = append(captures, &Capture{Name: fmt.Sprintf("%s#%d", name, n), Data: payload})
+	}
+}
+
+// udpPayload returns the payload of the UDP datagram on the GTP ports in frame.
+func udpPayload(linkType uint32, frame []byte) ([]byte, bool) {
+	var (
+		etherType uint16
+		b         []byte
+	)
+	switch linkType {
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return nil, false
+		}
+		etherType, b = binary.BigEndian.Uint16(frame[12:14]), frame[14:]
+		for etherType == 0x8100 || etherType == 0x88a8 { // VLAN tags
+			if len(b) < 4 {
+				return nil, false
+			}
+			etherType, b = binary.BigEndian.Uint16(b[2:4]), b[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil, false
+		}
+		etherType, b = binary.BigEndian.Uint16(frame[14:16]), frame[16:]
+	case linkTypeLinuxSLL2:
+		if len(frame) < 20 {
+			return nil, false
+		}
+		etherType, b = binary.BigEndian.Uint16(frame[0:2]), frame[20:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		if len(frame) < 1 {
+			return nil, false
+		}
+		etherType, b = 0x0800, frame
+		if frame[0]>>4 == 6 {
+			etherType = 0x86dd
+		}
+	default:
+		return nil, false
+	}
+
+	switch etherType {
+	case 0x0800:
+		if len(b) < 20 || b[0]>>4 != 4 {
+			return nil, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:4]))
+		fragment := binary.BigEndian.Uint16(b[6:8]) & 0x3fff
+		if b[9] != 17 || fragment != 0 || total > len(b) || ihl > total {
+			return nil, false
+		}
+		b = b[ihl:total]
+	case 0x86dd:
+		if len(b) < 40 || b[6] != 17 {
+			return nil, false
+		}
+		total := 40 + int(binary.BigEndian.Uint16(b[4:6]))
+		if total > len(b) {
+			return nil, false
+		}
+		b = b[40:total]
+	default:
+		return nil, false
+	}
+
+	if len(b) < 8 {
+		return nil, false
+	}
+	src, dst := binary.BigEndian.Uint16(b[0:2]), binary.BigEndian.Uint16(b[2:4])
+	if !isGTPPort(src) && !isGTPPort(dst) {
+		return nil, false
+	}
+	l := int(binary.BigEndian.Uint16(b[4:6]))
+	if l < 8 || l > len(b) {
+		return nil, false
+	}
+	return b[8:l], true
+}
+
+func isGTPPort(p uint16) bool {
+	return p == portGTPC || p == portGTPU || p == portGTPv0
+}
@@ -0,0 +1,133 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Command loadgen generates GTPv2-C signalling load towards S-GW (as an MME on S11)
+// or P-GW (as an S-GW on S5/S8), and reports the throughput, latency percentiles
+// and Cause distribution of each procedure.
+//
+//	loadgen -role mme -local 127.0.0.111:2123 -peer 127.0.0.112:2123 -rate 100 -duration 1m
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/loadgen"
+)
+
+// command-line arguments
+var (
+	role        = flag.String("role", "mme", "Role to emulate: mme(S11 towards S-GW) or sgw(S5/S8 towards P-GW).")
+	local       = flag.String("local", "127.0.0.111:2123", "Local IP:Port.")
+	peer        = flag.String("peer", "127.0.0.112:2123", "Peer IP:Port.")
+	imsi        = flag.String("imsi", "001010000000001", "First IMSI in the range.")
+	subscribers = flag.Int("subscribers", 1000, "Number of IMSIs in the range.")
+	mcc         = flag.String("mcc", "001", "MCC of Serving Network.")
+	mnc         = flag.String("mnc", "01", "MNC of Serving Network.")
+	apn         = flag.String("apn", "some.apn.example", "APN.")
+	pgw         = flag.String("pgw", "127.0.0.113", "P-GW IP put in Create Session Request on mme role.")
+	rate        = flag.Float64("rate", 10, "Session attempts per second.")
+	duration    = flag.Duration("duration", 10*time.Second, "Duration to keep starting new sessions.")
+	hold        = flag.Duration("hold", time.Second, "Time between Create Session and Delete Session.")
+	modify      = flag.Int("modify", 1, "Number of Modify Bearer per session.")
+	rab         = flag.Int("rab", 0, "Number of Release Access Bearers per session(mme role only).")
+	t3          = flag.Duration("t3", 3*time.Second, "Retransmission timer.")
+	n3          = flag.Int("n3", 2, "Maximum number of retransmissions.")
+	interval    = flag.Duration("interval", 5*time.Second, "Interval to print the statistics. 0 to disable.")
+)
+
+func main() {
+	flag.Parse()
+	log.SetPrefix("[loadgen] ")
+
+	cfg := &loadgen.Config{
+		IMSIStart:   *imsi,
+		Subscribers: *subscribers,
+		MCC:         *mcc,
+		MNC:         *mnc,
+		APN:         *apn,
+		PGWAddress:  *pgw,
+		Rate:        *rate,
+		Duration:    *duration,
+		HoldTime:    *hold,
+		Mix:         map[loadgen.Procedure]int{},
+		T3:          *t3,
+		N3:          *n3,
+	}
+	if *modify > 0 {
+		cfg.Mix[loadgen.ProcedureModifyBearer] = *modify
+	}
+	if *rab > 0 {
+		cfg.Mix[loadgen.ProcedureReleaseAccessBearers] = *rab
+	}
+
+	var ifType uint8
+	switch *role {
+	case "mme":
+		cfg.Role = loadgen.RoleMME
+		ifType = gtpv2.IFTypeS11MMEGTPC
+	case "sgw":
+		cfg.Role = loadgen.RoleSGW
+		ifType = gtpv2.IFTypeS5S8SGWGTPC
+	default:
+		log.Fatalf("unknown role: %s", *role)
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", *local)
+	if err != nil {
+		log.Fatal(err)
+	}
+	raddr, err := net.ResolveUDPAddr("udp", *peer)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	// the Generator does not register Sessions to the Conn.
+	conn := gtpv2.NewConn(laddr, ifType, 0)
+	conn.DisableValidation()
+	if err := conn.Listen(ctx); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := conn.Serve(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	gen, err := loadgen.New(conn, raddr, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *interval > 0 {
+		go func() {
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					fmt.Print(gen.Report())
+				}
+			}
+		}()
+	}
+
+	rep, err := gen.Run(ctx)
+	fmt.Print(rep)
+	if err != nil {
+		log.Println(err)
+	}
+}
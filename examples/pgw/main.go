@@ -25,6 +25,7 @@ import (
 	"flag"
 	"log"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/wmnsk/go-gtp/gtpv1"
@@ -34,14 +35,27 @@ import (
 
 // command-line arguments
 var (
-	s5c = flag.String("s5c", "127.0.0.52", "IP for S5-C interface.")
-	s5u = flag.String("s5u", "127.0.0.4", "IP for S5-U interface.")
+	s5c  = flag.String("s5c", "127.0.0.52", "IP for S5-C interface.")
+	s5u  = flag.String("s5u", "127.0.0.4", "IP for S5-U interface.")
+	apns = flag.String("pool", "some-apn-1.example=10.10.10.0/24,some-apn-2.example=10.10.20.0/24", "Comma-separated APN=prefix to allocate UE IP from.")
 )
 
 func main() {
 	flag.Parse()
 	log.SetPrefix("[P-GW] ")
 
+	for _, kv := range strings.Split(*apns, ",") {
+		apn, prefix, ok := strings.Cut(kv, "=")
+		if !ok {
+			log.Printf("invalid pool: %s", kv)
+			return
+		}
+		if err := pool.AddIPv4Prefix(apn, prefix); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
 	s5cAddr, err := net.ResolveUDPAddr("udp", *s5c+gtpv2.GTPCPort)
 	if err != nil {
 		log.Println(err)
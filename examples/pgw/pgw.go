@@ -12,34 +12,22 @@ import (
 	"github.com/wmnsk/go-gtp/gtpv1"
 	"github.com/wmnsk/go-gtp/gtpv2"
 	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/ippool"
 	"github.com/wmnsk/go-gtp/gtpv2/message"
 )
 
-// getSubscriberIP is to get IP address to be assigned to the subscriber.
-//
-// In the real case, P-GW may ask AAA and PCRF retrieve required information for subscriber,
-// but here, to keep the example simple, this just returns subscriber's IP address defined in
-// the map "subIPMap".
-func getSubscriberIP(sub *gtpv2.Subscriber) (string, error) {
-	subIPMap := map[string]string{
-		"123451234567891": "10.10.10.1",
-		"123451234567892": "10.10.10.2",
-		"123451234567893": "10.10.10.3",
-		"123451234567894": "10.10.10.4",
-		"123451234567895": "10.10.10.5",
-	}
-
-	if ip, ok := subIPMap[sub.IMSI]; ok {
-		return ip, nil
-	}
-	return "", fmt.Errorf("subscriber %s not found", sub.IMSI)
-}
-
 var (
 	loggerCh = make(chan string)
 	errCh    = make(chan error)
 
 	uConn *gtpv1.UPlaneConn
+
+	// pool is to allocate IP address to be assigned to the subscriber.
+	//
+	// In the real case, P-GW may ask AAA and PCRF retrieve required information for subscriber,
+	// but here, to keep the example simple, the addresses are allocated from the prefixes
+	// given with "pool" flag for each APN.
+	pool = ippool.NewPool()
 )
 
 func handleCreateSessionRequest(c *gtpv2.Conn, sgwAddr net.Addr, msg message.Message) error {
@@ -151,7 +139,8 @@ func handleCreateSessionRequest(c *gtpv2.Conn, sgwAddr net.Addr, msg message.Mes
 		return &gtpv2.RequiredIEMissingError{Type: ie.BearerContext}
 	}
 
-	bearer.SubscriberIP, err = getSubscriberIP(session.Subscriber)
+	// the address is released when the session is removed from c.
+	alloc, err := pool.AllocateForSession(session, csReqFromSGW)
 	if err != nil {
 		return err
 	}
@@ -168,7 +157,7 @@ func handleCreateSessionRequest(c *gtpv2.Conn, sgwAddr net.Addr, msg message.Mes
 		s5sgwTEID, 0,
 		ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
 		s5cFTEID,
-		ie.NewPDNAddressAllocation(bearer.SubscriberIP),
+		alloc.PAA(),
 		ie.NewAPNRestriction(gtpv2.APNRestrictionPublic2),
 		ie.NewBearerContext(
 			ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
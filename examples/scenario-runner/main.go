@@ -0,0 +1,91 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Command scenario-runner runs GTPv2-C call flows described in YAML.
+//
+// The local and peer addresses and the local interface type are taken from the
+// scenario file, and can be overridden by the command-line flags. It exits with
+// non-zero status if any of the steps fails.
+//
+//	scenario-runner -f attach.yml -local 127.0.0.111:2123 -peer 127.0.0.112:2123
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/scenario"
+)
+
+// command-line arguments
+var (
+	file   = flag.String("f", "attach.yml", "Path to the scenario file.")
+	local  = flag.String("local", "", "Local IP:Port. Overrides the one in scenario file.")
+	peer   = flag.String("peer", "", "Peer IP:Port. Overrides the one in scenario file.")
+	ifType = flag.Uint("if", uint(gtpv2.IFTypeS11MMEGTPC), "Local interface type, used when the scenario file does not have one.")
+)
+
+func main() {
+	flag.Parse()
+	log.SetPrefix("[scenario] ")
+
+	sc, err := scenario.LoadFile(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *local != "" {
+		sc.Local = *local
+	}
+	if *peer != "" {
+		sc.Peer = *peer
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", sc.Local)
+	if err != nil {
+		log.Fatal(err)
+	}
+	raddr, err := net.ResolveUDPAddr("udp", sc.Peer)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	it, err := sc.LocalIFType(uint8(*ifType))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the Runner does not register Sessions to the Conn.
+	conn := gtpv2.NewConn(laddr, it, 0)
+	conn.DisableValidation()
+	if err := conn.Listen(ctx); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := conn.Serve(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	res, err := scenario.NewRunner(conn, raddr).Run(ctx, sc)
+	for _, s := range res.Steps {
+		fmt.Println(s)
+	}
+	fmt.Printf("%s: %d steps in %s\n", sc.Name, len(res.Steps), res.Elapsed)
+
+	if err != nil {
+		log.Println(err)
+		cancel()
+		os.Exit(1)
+	}
+}
@@ -22,6 +22,10 @@
 //
 // 6. If some U-Plane message comes from eNB/P-GW, relay it to P-GW/eNB with TEID and IP
 // properly set as told while exchanging the C-Plane signals.
+//
+// 7. If MME sends Release Access Bearers Request, buffer the packets from P-GW and
+// notify MME with Downlink Data Notification. The packets are sent to eNB when MME
+// sends Modify Bearer Request again.
 package main
 
 import (
@@ -32,7 +36,9 @@ import (
 	"time"
 
 	"github.com/wmnsk/go-gtp/gtpv1"
+	v1msg "github.com/wmnsk/go-gtp/gtpv1/message"
 	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/dlbuffer"
 	"github.com/wmnsk/go-gtp/gtpv2/message"
 )
 
@@ -50,6 +56,9 @@ type sGateway struct {
 	s11Conn, s5cConn *gtpv2.Conn
 	s1uConn, s5uConn *gtpv1.UPlaneConn
 
+	// dlBuffer buffers the downlink packets while the UE is in idle mode.
+	dlBuffer *dlbuffer.Buffer
+
 	loggerCh chan string
 	errCh    chan error
 }
@@ -89,14 +98,16 @@ func newSGW(s11, s5c, s1u, s5u net.Addr) (*sGateway, error) {
 		}
 	}()
 
+	s.s5uConn = gtpv1.NewUPlaneConn(s5u)
 	go func() {
-		s.s5uConn = gtpv1.NewUPlaneConn(s5u)
 		if err = s.s5uConn.ListenAndServe(ctx); err != nil {
 			log.Println(err)
 			return
 		}
 	}()
 
+	s.dlBuffer = dlbuffer.NewBuffer(s.s11Conn, s.s1uConn, s.s5uConn)
+
 	return s, nil
 }
 
@@ -168,10 +179,12 @@ func main() {
 
 	// register handlers for ALL the message you expect remote endpoint to send.
 	sgw.s11Conn.AddHandlers(map[uint8]gtpv2.HandlerFunc{
-		message.MsgTypeCreateSessionRequest: handleCreateSessionRequest,
-		message.MsgTypeModifyBearerRequest:  handleModifyBearerRequest,
-		message.MsgTypeDeleteSessionRequest: handleDeleteSessionRequest,
-		message.MsgTypeDeleteBearerResponse: handleDeleteBearerResponse,
+		message.MsgTypeCreateSessionRequest:                handleCreateSessionRequest,
+		message.MsgTypeModifyBearerRequest:                 handleModifyBearerRequest,
+		message.MsgTypeDeleteSessionRequest:                handleDeleteSessionRequest,
+		message.MsgTypeDeleteBearerResponse:                handleDeleteBearerResponse,
+		message.MsgTypeReleaseAccessBearersRequest:         handleReleaseAccessBearersRequest,
+		message.MsgTypeDownlinkDataNotificationAcknowledge: sgw.dlBuffer.HandleDownlinkDataNotificationAcknowledge,
 	})
 	sgw.s5cConn.AddHandlers(map[uint8]gtpv2.HandlerFunc{
 		message.MsgTypeCreateSessionResponse: handleCreateSessionResponse,
@@ -179,5 +192,9 @@ func main() {
 		message.MsgTypeDeleteBearerRequest:   handleDeleteBearerRequest,
 	})
 
+	// buffer the downlink packets for the UEs in idle mode instead of responding
+	// with Error Indication.
+	sgw.s5uConn.AddHandler(v1msg.MsgTypeTPDU, sgw.dlBuffer.HandleTPDU)
+
 	log.Fatal(sgw.run())
 }
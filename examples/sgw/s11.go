@@ -6,11 +6,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/wmnsk/go-gtp/gtpv1"
 	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/dlbuffer"
 	"github.com/wmnsk/go-gtp/gtpv2/ie"
 	"github.com/wmnsk/go-gtp/gtpv2/message"
 )
@@ -299,10 +302,29 @@ func handleModifyBearerRequest(s11Conn *gtpv2.Conn, mmeAddr net.Addr, msg messag
 	); err != nil {
 		return err
 	}
-	if err := sgw.s5uConn.RelayTo(
-		sgw.s1uConn, s5usgwTEID, s1uBearer.OutgoingTEID(), s1uBearer.RemoteAddress(),
+
+	// send the packets buffered while the UE was in idle mode first, if any.
+	if err := sgw.dlBuffer.Flush(
+		s5usgwTEID, s1uBearer.OutgoingTEID(), s1uBearer.RemoteAddress(),
 	); err != nil {
-		return err
+		if !errors.Is(err, dlbuffer.ErrNotHeld) {
+			return err
+		}
+
+		// switch the path to the new eNB with End Marker sent on the old one
+		// if the eNB has been changed by handover, or just start relaying.
+		if err := sgw.s5uConn.SwitchRelay(
+			s5usgwTEID, s1uBearer.OutgoingTEID(), s1uBearer.RemoteAddress(),
+		); err != nil {
+			if !errors.Is(err, gtpv1.ErrTunnelNotFound) {
+				return err
+			}
+			if err := sgw.s5uConn.RelayTo(
+				sgw.s1uConn, s5usgwTEID, s1uBearer.OutgoingTEID(), s1uBearer.RemoteAddress(),
+			); err != nil {
+				return err
+			}
+		}
 	}
 
 	s1uIP, _, err := net.SplitHostPort(*s1u + gtpv2.GTPCPort)
@@ -399,11 +421,48 @@ func handleDeleteSessionRequest(s11Conn *gtpv2.Conn, mmeAddr net.Addr, msg messa
 	}
 
 	sgw.loggerCh <- fmt.Sprintf("Session deleted for Subscriber: %s", s11Session.IMSI)
+	sgw.dlBuffer.DiscardSession(s11Session)
 	s11Conn.RemoveSession(s11Session)
 
 	return nil
 }
 
+func handleReleaseAccessBearersRequest(s11Conn *gtpv2.Conn, mmeAddr net.Addr, msg message.Message) error {
+	sgw.loggerCh <- fmt.Sprintf("Received %s from %s", msg.MessageTypeName(), mmeAddr)
+
+	s11Session, err := s11Conn.GetSessionByTEID(msg.TEID(), mmeAddr)
+	if err != nil {
+		return err
+	}
+	s5cSession, err := sgw.s5cConn.GetSessionByIMSI(s11Session.IMSI)
+	if err != nil {
+		return err
+	}
+
+	s11mmeTEID, err := s11Session.GetTEID(gtpv2.IFTypeS11MMEGTPC)
+	if err != nil {
+		return err
+	}
+	s5usgwTEID, err := s5cSession.GetTEID(gtpv2.IFTypeS5S8SGWGTPU)
+	if err != nil {
+		return err
+	}
+
+	// the UE goes idle; hold the downlink packets until it gets connected again.
+	if err := sgw.dlBuffer.Hold(s11Session, s11Session.GetDefaultBearer(), s5usgwTEID); err != nil {
+		return err
+	}
+
+	if err := s11Conn.RespondTo(mmeAddr, msg, message.NewReleaseAccessBearersResponse(
+		s11mmeTEID, 0, ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+	)); err != nil {
+		return err
+	}
+
+	sgw.loggerCh <- fmt.Sprintf("Started buffering downlink packets for Subscriber: %s", s11Session.IMSI)
+	return nil
+}
+
 func handleDeleteBearerResponse(s11Conn *gtpv2.Conn, mmeAddr net.Addr, msg message.Message) error {
 	sgw.loggerCh <- fmt.Sprintf("Received %s from %s", msg.MessageTypeName(), mmeAddr)
 
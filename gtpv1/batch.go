@@ -0,0 +1,306 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultBatchSize is the number of packets read at once in batch I/O mode,
+// which is used if the size given to EnableBatchIO is zero or less.
+const DefaultBatchSize = 64
+
+// bufferSize is the size of the buffers in bufferPool.
+const bufferSize = 2048
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, bufferSize)
+		return &b
+	},
+}
+
+// getBuffer returns a buffer of length n, which is taken from bufferPool if it
+// fits in. It should be returned by putBuffer after use.
+func getBuffer(n int) *[]byte {
+	if n > bufferSize {
+		b := make([]byte, n)
+		return &b
+	}
+	bp := bufferPool.Get().(*[]byte)
+	*bp = (*bp)[:n]
+	return bp
+}
+
+func putBuffer(bp *[]byte) {
+	if cap(*bp) != bufferSize {
+		return
+	}
+	*bp = (*bp)[:bufferSize]
+	bufferPool.Put(bp)
+}
+
+// EnableBatchIO makes UPlaneConn read the packets in batches of up to size
+// (with recvmmsg(2) on Linux) into the pooled buffers, and handle them in the
+// serving goroutine instead of spawning a goroutine per packet. The T-PDUs
+// relayed by RelayTo are also sent in batches (with sendmmsg(2) on Linux).
+// If size is zero or less, DefaultBatchSize is used.
+//
+// In this mode, HandlerFuncs and TPDUHandlerFuncs are called in the serving
+// goroutine, which means they should not block.
+//
+// This should be called before ListenAndServe.
+func (u *UPlaneConn) EnableBatchIO(size int) {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+
+	u.mu.Lock()
+	u.batchSize = size
+	u.mu.Unlock()
+}
+
+// DisableBatchIO makes UPlaneConn read the packets one by one and handle each
+// of them in a goroutine, which is the default.
+//
+// See also: EnableBatchIO.
+func (u *UPlaneConn) DisableBatchIO() {
+	u.mu.Lock()
+	u.batchSize = 0
+	u.mu.Unlock()
+}
+
+// EnableReusePort makes ListenAndServe open n sockets bound to the same local
+// address with SO_REUSEPORT, and serve each of them in its own goroutine with
+// batch I/O. Kernel distributes the incoming packets across the sockets by the
+// hash of the source address and port, so that they are handled on multiple
+// cores. The packets are always sent on the first socket.
+//
+// This is available only on Linux, and ListenAndServe returns
+// ErrReusePortNotSupported on the other platforms. This should be called before
+// ListenAndServe or EnableUserspaceGTP. It has no effect with Kernel GTP-U, and
+// it does not work on UPlaneConn created with NewUPlaneConnWithPacketConn.
+func (u *UPlaneConn) EnableReusePort(n int) {
+	u.mu.Lock()
+	u.reusePorts = n
+	if u.batchSize == 0 {
+		u.batchSize = DefaultBatchSize
+	}
+	u.mu.Unlock()
+}
+
+// listenReusePort opens the sockets bound to the address of the existing one
+// with SO_REUSEPORT, up to the number given by EnableReusePort.
+func (u *UPlaneConn) listenReusePort() error {
+	if u.KernelGTP.enabled {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for i := len(u.reuseConns) + 1; i < u.reusePorts; i++ {
+		pc, err := newPktConn(u.pktConn.LocalAddr(), true)
+		if err != nil {
+			return fmt.Errorf("failed to open socket with SO_REUSEPORT: %w", err)
+		}
+		u.reuseConns = append(u.reuseConns, pc)
+	}
+	return nil
+}
+
+// batchPktConn is a pktConn that can read and write multiple packets at once.
+type batchPktConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// readBatch reads the packets into ms, or reads one packet into ms[0] if pc
+// does not support batch I/O.
+func readBatch(pc pktConn, ms []ipv4.Message) (int, error) {
+	if bc, ok := pc.(batchPktConn); ok {
+		return bc.ReadBatch(ms, 0)
+	}
+
+	n, addr, err := pc.ReadFrom(ms[0].Buffers[0])
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N, ms[0].Addr = n, addr
+	return 1, nil
+}
+
+// writeBatch writes all the packets in ms, or writes them one by one if pc does
+// not support batch I/O.
+func writeBatch(pc pktConn, ms []ipv4.Message) error {
+	bc, ok := pc.(batchPktConn)
+	if !ok {
+		for _, m := range ms {
+			if _, err := pc.WriteTo(m.Buffers[0], m.Addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for len(ms) > 0 {
+		n, err := bc.WriteBatch(ms, 0)
+		if err != nil {
+			return err
+		}
+		ms = ms[n:]
+	}
+	return nil
+}
+
+// relayBatch is the T-PDUs to be relayed, grouped by the conn to send them on.
+type relayBatch struct {
+	conns []pktConn
+	msgs  [][]ipv4.Message
+	bufs  [][]byte
+	peers []*peer
+}
+
+func newRelayBatch(size int) *relayBatch {
+	return &relayBatch{bufs: make([][]byte, 0, size)}
+}
+
+// add queues b to be sent to p on pc. b should not be modified until flush,
+// which calls done on p after sending it.
+func (r *relayBatch) add(pc pktConn, b []byte, p *peer) {
+	i := 0
+	for ; i < len(r.conns); i++ {
+		if r.conns[i] == pc {
+			break
+		}
+	}
+	if i == len(r.conns) {
+		r.conns = append(r.conns, pc)
+		if len(r.msgs) < len(r.conns) {
+			r.msgs = append(r.msgs, nil)
+		}
+	}
+
+	r.bufs = append(r.bufs, b)
+	r.peers = append(r.peers, p)
+	r.msgs[i] = append(r.msgs[i], ipv4.Message{
+		Buffers: r.bufs[len(r.bufs)-1 : len(r.bufs)],
+		Addr:    p.addr,
+	})
+}
+
+// flush sends all the T-PDUs queued.
+func (r *relayBatch) flush() {
+	for i, pc := range r.conns {
+		if err := writeBatch(pc, r.msgs[i]); err != nil {
+			// should not stop serving with this error
+			logf("error sending on UPlaneConn %s: %v", pc.LocalAddr(), err)
+		}
+		r.msgs[i] = r.msgs[i][:0]
+		r.conns[i] = nil
+	}
+	r.conns = r.conns[:0]
+	r.bufs = r.bufs[:0]
+	for i, p := range r.peers {
+		p.done()
+		r.peers[i] = nil
+	}
+	r.peers = r.peers[:0]
+}
+
+// serveBatch reads the packets from pc in batches and handles them until ctx
+// is canceled or pc is closed.
+func (u *UPlaneConn) serveBatch(ctx context.Context, pc pktConn, size, bufSize int) error {
+	ms := make([]ipv4.Message, size)
+	bps := make([]*[]byte, size)
+	for i := range ms {
+		// one more byte to detect the packets larger than the buffer.
+		bps[i] = getBuffer(bufSize + 1)
+		ms[i].Buffers = [][]byte{*bps[i]}
+	}
+	defer func() {
+		for _, bp := range bps {
+			putBuffer(bp)
+		}
+	}()
+
+	relays := newRelayBatch(size)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-u.closed():
+			return nil
+		default:
+			// do nothing and go forward.
+		}
+
+		n, err := readBatch(pc, ms)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("error reading from UPlaneConn %s: %w", pc.LocalAddr(), err)
+		}
+
+		for _, m := range ms[:n] {
+			if m.N > bufSize {
+				u.oversize.truncated.Add(1)
+				continue
+			}
+			u.handleBatched(m.Addr, m.Buffers[0][:m.N], relays)
+		}
+		relays.flush()
+	}
+}
+
+// handleBatched handles the packet in b, which is reused after the batch is
+// handled. The T-PDUs and End Markers to be relayed are queued to relays without copying.
+func (u *UPlaneConn) handleBatched(raddr net.Addr, b []byte, relays *relayBatch) {
+	fast := true
+	if len(b) >= 8 && b[1] == message.MsgTypeTPDU {
+		teid := binary.BigEndian.Uint32(b[4:8])
+
+		// the T-PDUs with QoS or rules are handled in handlePacket.
+		if _, ok := u.qosIn.Load(teid); ok {
+			fast = false
+		} else if u.rules.hasTEID(teid) {
+			fast = false
+		} else if u.userspaceEnabled() && u.handleUserspaceTPDU(b) {
+			return
+		} else if _, ok := u.Tunnel(teid); ok {
+			fast = false
+		}
+	}
+
+	if fast {
+		if peer, ok := u.relayPeer(b); ok {
+			if peer.policer() == nil {
+				binary.BigEndian.PutUint32(b[4:8], peer.teid)
+				relays.add(peer.srcConn.pktConn, b, peer)
+				return
+			}
+			peer.done()
+		}
+	}
+
+	// send the T-PDUs queued so far first not to reorder them with the ones
+	// relayed in handlePacket.
+	relays.flush()
+
+	// the handlers may retain the packet.
+	raw := make([]byte, len(b))
+	copy(raw, b)
+	u.handlePacket(raddr, raw)
+}
@@ -0,0 +1,386 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func TestBatchIO(t *testing.T) {
+	cases := []struct {
+		description string
+		newConns    func(t *testing.T) (relay, peer *gtpv1.UPlaneConn)
+	}{
+		{
+			"udp",
+			func(t *testing.T) (*gtpv1.UPlaneConn, *gtpv1.UPlaneConn) {
+				t.Helper()
+				var us []*gtpv1.UPlaneConn
+				for _, ip := range []net.IP{net.IPv4(127, 0, 0, 21), net.IPv4(127, 0, 0, 22)} {
+					pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: 2152})
+					if err != nil {
+						t.Fatal(err)
+					}
+					us = append(us, gtpv1.NewUPlaneConnWithPacketConn(pc))
+				}
+				return us[0], us[1]
+			},
+		}, {
+			"generic",
+			func(t *testing.T) (*gtpv1.UPlaneConn, *gtpv1.UPlaneConn) {
+				t.Helper()
+				n := vnet.New(1)
+				relay, err := n.NewUPlaneConn("10.0.0.1:2152")
+				if err != nil {
+					t.Fatal(err)
+				}
+				peer, err := n.NewUPlaneConn("10.0.0.2:2152")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return relay, peer
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			relay, peer := c.newConns(t)
+			relay.EnableBatchIO(4)
+			peer.DisableErrorIndication()
+			for _, u := range []*gtpv1.UPlaneConn{relay, peer} {
+				go func(u *gtpv1.UPlaneConn) {
+					if err := u.ListenAndServe(ctx); err != nil {
+						t.Errorf("failed to serve: %v", err)
+					}
+				}(u)
+			}
+			if err := relay.RelayTo(relay, 0x11111111, 0x22222222, peer.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			tun, err := relay.RegisterTunnelChannel(0x33333333, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want []string
+			for i := 0; i < 10; i++ {
+				p := fmt.Sprintf("pkt%d", i)
+				want = append(want, p)
+				if _, err := peer.WriteToGTP(0x11111111, []byte(p), relay.LocalAddr()); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := peer.WriteToGTP(0x33333333, []byte(p), relay.LocalAddr()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var relayed, received []string
+			buf := make([]byte, 1500)
+			for range want {
+				n, _, teid, err := peer.ReadFromGTP(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if teid != 0x22222222 {
+					t.Errorf("wrong TEID: %#x", teid)
+				}
+				relayed = append(relayed, string(buf[:n]))
+
+				select {
+				case pdu := <-tun.Receive():
+					received = append(received, string(pdu.Payload))
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out while waiting for T-PDU")
+				}
+			}
+
+			// the packets passed to the tunnel should not be overwritten by
+			// the following ones.
+			for _, got := range [][]string{relayed, received} {
+				sort.Strings(got)
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Error(diff)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchIOOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 25), Port: 2152})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := gtpv1.NewUPlaneConnWithPacketConn(pc)
+	defer relay.Close()
+	relay.EnableBatchIO(4)
+
+	// read the relayed packets from the plain socket, as UPlaneConn may
+	// reorder them while handling.
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 26), Port: 2152})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// the T-PDUs with 0x11111111 are relayed in the batch, and the ones with
+	// 0x33333333 are relayed one by one as they are policed.
+	if err := relay.RelayTo(relay, 0x11111111, 0x22222222, peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.RelayTo(relay, 0x33333333, 0x44444444, peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	relay.SetIncomingQoS(0x33333333, &gtpv1.QoS{MBR: 1 << 30, Burst: 1 << 20})
+
+	// queue the packets before serving so that they are read in batches.
+	var want []string
+	for i := 0; i < 8; i++ {
+		teid := uint32(0x11111111)
+		if i%2 == 1 {
+			teid = 0x33333333
+		}
+		p := fmt.Sprintf("pkt%d", i)
+		want = append(want, p)
+		b, err := message.NewTPDU(teid, []byte(p)).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := peer.WriteTo(b, relay.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go func() {
+		if err := relay.ListenAndServe(ctx); err != nil {
+			t.Errorf("failed to serve: %v", err)
+		}
+	}()
+
+	var got []string
+	buf := make([]byte, 1500)
+	for range want {
+		if err := peer.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := peer.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdu, err := message.ParseTPDU(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(pdu.Payload))
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is available only on Linux")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 23), Port: 2152}
+	relay := gtpv1.NewUPlaneConn(raddr)
+	relay.EnableReusePort(4)
+	go func() {
+		if err := relay.ListenAndServe(ctx); err != nil {
+			t.Errorf("failed to serve: %v", err)
+		}
+	}()
+
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 24)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := relay.RelayTo(relay, 0x11111111, 0x22222222, sink.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the packets from the different ports may be received on different sockets.
+	pkt, err := gtpv1.Encapsulate(0x11111111, []byte("pkt")).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 16; i++ {
+		c, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write(pkt); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+
+	if err := sink.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	for i := 0; i < 16; i++ {
+		n, _, err := sink.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("received %d packets: %v", i, err)
+		}
+		teid, payload, err := gtpv1.Decapsulate(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if teid != 0x22222222 || string(payload) != "pkt" {
+			t.Errorf("got unexpected T-PDU: %#x, %x", teid, payload)
+		}
+	}
+}
+
+// BenchmarkRelay measures the throughput of relaying T-PDUs on loopback.
+//
+// The senders keep up to relayWindow packets in flight not to overflow the
+// socket buffers, and the ratio of the packets delivered is reported as
+// delivered/op.
+func BenchmarkRelay(b *testing.B) {
+	cases := []struct {
+		description string
+		setup       func(u *gtpv1.UPlaneConn)
+	}{
+		{"default", func(u *gtpv1.UPlaneConn) {}},
+		{"batch", func(u *gtpv1.UPlaneConn) { u.EnableBatchIO(0) }},
+	}
+	if runtime.GOOS == "linux" {
+		cases = append(cases, struct {
+			description string
+			setup       func(u *gtpv1.UPlaneConn)
+		}{"reuseport", func(u *gtpv1.UPlaneConn) { u.EnableReusePort(runtime.NumCPU()) }})
+	}
+
+	for i, c := range cases {
+		b.Run(c.description, func(b *testing.B) {
+			benchmarkRelay(b, net.IPv4(127, 0, 1, byte(i+1)), c.setup)
+		})
+	}
+}
+
+const relayWindow = 128
+
+func benchmarkRelay(b *testing.B, ip net.IP, setup func(u *gtpv1.UPlaneConn)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	raddr := &net.UDPAddr{IP: ip, Port: 2152}
+	relay := gtpv1.NewUPlaneConn(raddr)
+	setup(relay)
+	go func() {
+		_ = relay.ListenAndServe(ctx)
+	}()
+
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sink.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := relay.RelayTo(relay, 0x11111111, 0x22222222, sink.LocalAddr()); err != nil {
+		b.Fatal(err)
+	}
+
+	const senders = 8
+	pkt, err := gtpv1.Encapsulate(0x11111111, make([]byte, 1200)).Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var conns []*net.UDPConn
+	for i := 0; i < senders; i++ {
+		c, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+
+	// inFlight limits the number of packets sent but not received yet.
+	inFlight := make(chan struct{}, relayWindow)
+	var received atomic.Int64
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sink.ReadFrom(buf); err != nil {
+				return
+			}
+			received.Add(1)
+			select {
+			case <-inFlight:
+			default:
+			}
+		}
+	}()
+
+	// the packets not received for a while are regarded as lost.
+	timer := time.NewTimer(0)
+	acquire := func() {
+		select {
+		case inFlight <- struct{}{}:
+			return
+		default:
+		}
+
+		timer.Reset(100 * time.Millisecond)
+		select {
+		case inFlight <- struct{}{}:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+			for len(inFlight) > 0 {
+				<-inFlight
+			}
+			inFlight <- struct{}{}
+		}
+	}
+	<-timer.C
+
+	b.SetBytes(int64(len(pkt)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		acquire()
+		if _, err := conns[i%senders].Write(pkt); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < relayWindow; i++ {
+		acquire()
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(received.Load())/float64(b.N), "delivered/op")
+}
@@ -0,0 +1,125 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// maxHeldTPDUs is the maximum number of T-PDUs held on a Tunnel until End
+// Marker arrives. The T-PDUs exceeding this are dropped.
+const maxHeldTPDUs = 1024
+
+// SwitchRelay switches the peer that the T-PDUs with teidIn are relayed to by
+// RelayTo, and then sends End Marker to the previous peer with its TEID on the
+// UPlaneConn given to RelayTo. End Marker is sent after the T-PDUs being relayed
+// to the previous peer are sent, including the ones delayed by QoS, so that it
+// is the last packet on the old path.
+//
+// This is typically used on S-GW when the eNB F-TEID is changed by Modify
+// Bearer Request after X2/S1 handover, so that the target eNB can tell the end
+// of the T-PDUs on the old path. End Marker is not sent if the peer is not
+// changed.
+func (u *UPlaneConn) SwitchRelay(teidIn, teidOut uint32, raddr net.Addr) error {
+	if u.KernelGTP.enabled {
+		return errors.New("cannot call SwitchRelay when using Kernel GTP-U")
+	}
+
+	u.mu.Lock()
+	old, ok := u.relayMap[teidIn]
+	if !ok {
+		u.mu.Unlock()
+		return fmt.Errorf("failed to switch relay for %#08x: %w", teidIn, ErrTunnelNotFound)
+	}
+	u.relayMap[teidIn] = &peer{teid: teidOut, addr: raddr, srcConn: old.srcConn}
+	u.mu.Unlock()
+
+	if old.teid == teidOut && old.addr.String() == raddr.String() {
+		return nil
+	}
+
+	// the T-PDUs already read can still be sent to the previous peer.
+	old.inflight.Wait()
+	return old.srcConn.EndMarker(old.addr, old.teid)
+}
+
+type endMarkerHold struct {
+	tunnel *Tunnel
+	timer  *time.Timer
+}
+
+// HoldUntilEndMarker holds the T-PDUs arriving on the Tunnel registered with
+// newTEID until End Marker with oldTEID arrives, and then passes them to the
+// Tunnel in the order received. This is for the target side of the path
+// switch, to preserve the order of the T-PDUs on the old path and the new one.
+//
+// The T-PDUs are released after timeout even if End Marker does not arrive.
+// Up to 1024 T-PDUs are held, and the rest are counted as drops.
+func (u *UPlaneConn) HoldUntilEndMarker(oldTEID, newTEID uint32, timeout time.Duration) error {
+	t, ok := u.Tunnel(newTEID)
+	if !ok {
+		return fmt.Errorf("failed to hold tunnel with %#08x: %w", newTEID, ErrTunnelNotFound)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	h := &endMarkerHold{tunnel: t}
+	if _, loaded := u.holds.LoadOrStore(oldTEID, h); loaded {
+		return fmt.Errorf("already waiting for End Marker with %#08x", oldTEID)
+	}
+
+	t.mu.Lock()
+	t.holding = true
+	t.mu.Unlock()
+
+	h.timer = time.AfterFunc(timeout, func() {
+		if u.holds.CompareAndDelete(oldTEID, h) {
+			logf("timed out waiting for End Marker with %#08x", oldTEID)
+			h.tunnel.release()
+		}
+	})
+	return nil
+}
+
+// releaseHold releases the T-PDUs held until End Marker with the TEID, and
+// reports whether any Tunnel has been waiting for it.
+func (u *UPlaneConn) releaseHold(teid uint32) bool {
+	u.mu.Lock()
+	v, ok := u.holds.LoadAndDelete(teid)
+	if !ok {
+		u.mu.Unlock()
+		return false
+	}
+	h := v.(*endMarkerHold)
+	h.timer.Stop()
+	u.mu.Unlock()
+
+	h.tunnel.release()
+	return true
+}
+
+// release passes the T-PDUs held to the Tunnel. The T-PDUs arriving while
+// releasing are held and passed after the preceding ones.
+func (t *Tunnel) release() {
+	for {
+		t.mu.Lock()
+		held := t.held
+		t.held = nil
+		if len(held) == 0 {
+			t.holding = false
+			t.mu.Unlock()
+			return
+		}
+		t.mu.Unlock()
+
+		for _, h := range held {
+			t.dispatch(h.senderAddr, h.pdu)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func TestEndMarker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	pgw, err := n.NewUPlaneConn("10.0.0.1:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgw, err := n.NewUPlaneConn("10.0.0.2:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcENB, err := n.NewUPlaneConn("10.0.0.3:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgtENB, err := n.NewUPlaneConn("10.0.0.4:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endMarkerCh := make(chan uint32, 10)
+	srcENB.AddHandler(message.MsgTypeEndMarker, func(c gtpv1.Conn, senderAddr net.Addr, msg message.Message) error {
+		endMarkerCh <- msg.TEID()
+		return nil
+	})
+
+	// T-PDUs from P-GW are relayed to the source eNB first.
+	if err := sgw.RelayTo(sgw, 0x11111111, 0x22222222, srcENB.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sgw.SwitchRelay(0x99999999, 0x33333333, tgtENB.LocalAddr()); !errors.Is(err, gtpv1.ErrTunnelNotFound) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+
+	// the target eNB receives the T-PDUs forwarded by the source eNB with
+	// 0x44444444, and the ones from S-GW with 0x33333333 after switching.
+	fwd, err := tgtENB.RegisterTunnelChannel(0x44444444, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := tgtENB.RegisterTunnelChannel(0x33333333, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tgtENB.HoldUntilEndMarker(0x44444444, 0x55555555, time.Second); !errors.Is(err, gtpv1.ErrTunnelNotFound) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+
+	for _, u := range []*gtpv1.UPlaneConn{pgw, sgw, srcENB, tgtENB} {
+		go func(u *gtpv1.UPlaneConn) {
+			_ = u.ListenAndServe(ctx)
+		}(u)
+	}
+
+	receive := func(t *testing.T, tun *gtpv1.Tunnel) string {
+		t.Helper()
+		select {
+		case pdu := <-tun.Receive():
+			return string(pdu.Payload)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for T-PDU")
+		}
+		return ""
+	}
+
+	t.Run("switch", func(t *testing.T) {
+		if err := tgtENB.HoldUntilEndMarker(0x44444444, 0x33333333, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := tgtENB.HoldUntilEndMarker(0x44444444, 0x33333333, 5*time.Second); err == nil {
+			t.Error("expected error but got nil")
+		}
+
+		if err := sgw.SwitchRelay(0x11111111, 0x33333333, tgtENB.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case teid := <-endMarkerCh:
+			if teid != 0x22222222 {
+				t.Errorf("wrong TEID: %#x", teid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for End Marker")
+		}
+
+		// the T-PDUs on the new path are held until End Marker on the old one.
+		for _, p := range []string{"new1", "new2"} {
+			if _, err := pgw.WriteToGTP(0x11111111, []byte(p), sgw.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		select {
+		case pdu := <-tun.Receive():
+			t.Fatalf("got T-PDU before End Marker: %s", pdu.Payload)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if _, err := srcENB.WriteToGTP(0x44444444, []byte("old"), tgtENB.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if got := receive(t, fwd); got != "old" {
+			t.Errorf("wrong payload: %s", got)
+		}
+		if err := srcENB.EndMarker(tgtENB.LocalAddr(), 0x44444444); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{"new1", "new2"} {
+			if got := receive(t, tun); got != want {
+				t.Errorf("wrong payload: got %s, want %s", got, want)
+			}
+		}
+		want := gtpv1.TunnelStats{Packets: 2, Bytes: 8}
+		if diff := cmp.Diff(want, tun.Stats()); diff != "" {
+			t.Error(diff)
+		}
+
+		// switching to the same peer does not send End Marker.
+		if err := sgw.SwitchRelay(0x11111111, 0x33333333, tgtENB.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case teid := <-endMarkerCh:
+			t.Errorf("got unexpected End Marker for %#x", teid)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("relay", func(t *testing.T) {
+		if err := sgw.SwitchRelay(0x11111111, 0x22222222, srcENB.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+
+		// End Marker from P-GW is relayed with the TEID replaced.
+		if err := pgw.EndMarker(sgw.LocalAddr(), 0x11111111); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case teid := <-endMarkerCh:
+			if teid != 0x22222222 {
+				t.Errorf("wrong TEID: %#x", teid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for End Marker")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		if err := tgtENB.HoldUntilEndMarker(0x44444444, 0x33333333, 100*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sgw.WriteToGTP(0x33333333, []byte("new3"), tgtENB.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if got := receive(t, tun); got != "new3" {
+			t.Errorf("wrong payload: %s", got)
+		}
+	})
+}
+
+func TestSwitchRelayInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	pgw, err := n.NewUPlaneConn("10.0.0.1:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgw, err := n.NewUPlaneConn("10.0.0.2:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read the packets on the old path from the plain socket, as UPlaneConn
+	// may reorder them while handling.
+	srcENB, err := n.Listen("10.0.0.3:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgtENB, err := n.Listen("10.0.0.4:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sgw.RelayTo(sgw, 0x11111111, 0x22222222, srcENB.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	// the second T-PDU is delayed for 100ms at 10000 bytes/s.
+	sgw.SetQoS(0x22222222, &gtpv1.QoS{MBR: 80000, Burst: 1000, MaxDelay: time.Second})
+
+	for _, u := range []*gtpv1.UPlaneConn{pgw, sgw} {
+		go func(u *gtpv1.UPlaneConn) {
+			_ = u.ListenAndServe(ctx)
+		}(u)
+	}
+
+	payload := make([]byte, 1000)
+	for i := 0; i < 2; i++ {
+		if _, err := pgw.WriteToGTP(0x11111111, payload, sgw.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if err := sgw.SwitchRelay(0x11111111, 0x33333333, tgtENB.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// End Marker is the last one on the old path.
+	var got []uint8
+	buf := make([]byte, 1500)
+	for len(got) < 3 {
+		if err := srcENB.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := srcENB.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[1])
+	}
+	want := []uint8{message.MsgTypeTPDU, message.MsgTypeTPDU, message.MsgTypeEndMarker}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
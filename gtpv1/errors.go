@@ -20,6 +20,38 @@ var (
 	// ErrConnNotOpened indicates that some operation is failed due to the status of
 	// Conn is not valid.
 	ErrConnNotOpened = errors.New("connection is not opened")
+
+	// ErrFileNotAvailable indicates that the underlying connection does not have
+	// os.File, which is required to use Kernel GTP-U.
+	ErrFileNotAvailable = errors.New("file is not available on the underlying connection")
+
+	// ErrTunnelExists indicates that the tunnel with the same MS address or incoming
+	// TEID already exists.
+	ErrTunnelExists = errors.New("tunnel already exists")
+
+	// ErrTunnelNotFound indicates that no tunnel is found by the key given.
+	ErrTunnelNotFound = errors.New("no tunnel found")
+
+	// ErrReusePortNotSupported indicates that SO_REUSEPORT is not supported on
+	// the platform.
+	ErrReusePortNotSupported = errors.New("SO_REUSEPORT is not supported on this platform")
+
+	// ErrPacketTooBig indicates that the packet is not sent as it exceeds the MTU.
+	ErrPacketTooBig = errors.New("packet exceeds the MTU")
+
+	// ErrRateExceeded indicates that the packet is not sent as it exceeds the
+	// rates of QoS.
+	ErrRateExceeded = errors.New("packet exceeds the rate limit")
+
+	// ErrInvalidRule indicates that the forwarding rules given are inconsistent.
+	ErrInvalidRule = errors.New("invalid forwarding rule")
+
+	// ErrRuleNotSupported indicates that the forwarding rules cannot be handled
+	// by the data path in use.
+	ErrRuleNotSupported = errors.New("forwarding rule not supported")
+
+	// ErrRulesNotFound indicates that no forwarding rules are found by the key given.
+	ErrRulesNotFound = errors.New("no forwarding rules found")
 )
 
 // ErrorIndicatedError indicates that Error Indication message is received on U-Plane Connection.
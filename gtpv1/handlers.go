@@ -49,6 +49,7 @@ func newDefaultMsgHandlerMap() *msgHandlerMap {
 			message.MsgTypeEchoRequest:     handleEchoRequest,
 			message.MsgTypeEchoResponse:    handleEchoResponse,
 			message.MsgTypeErrorIndication: handleErrorIndication,
+			message.MsgTypeEndMarker:       handleEndMarker,
 		},
 	)
 }
@@ -69,7 +70,8 @@ func handleTPDU(c Conn, senderAddr net.Addr, msg message.Message) error {
 		return ErrInvalidConnection
 	}
 
-	if u.errIndEnabled {
+	// T-PDU with the known TEID, e.g., allocated by NewFTEID, is passed to ReadFromGTP.
+	if u.errIndEnabled && !u.knownTEID(pdu.TEID()) {
 		if err := u.ErrorIndication(senderAddr, pdu); err != nil {
 			logf("failed to send Error Indication to %s: %v", senderAddr, err)
 		}
@@ -135,3 +137,23 @@ func handleErrorIndication(c Conn, senderAddr net.Addr, msg message.Message) err
 	})
 	return nil
 }
+
+// handleEndMarker releases the T-PDUs held until End Marker by
+// HoldUntilEndMarker, if any.
+func handleEndMarker(c Conn, senderAddr net.Addr, msg message.Message) error {
+	// this should never happen, as the type should have been assured by
+	// msgHandlerMap before this function is called.
+	em, ok := msg.(*message.EndMarker)
+	if !ok {
+		return ErrUnexpectedType
+	}
+
+	u, ok := c.(*UPlaneConn)
+	if !ok {
+		return ErrInvalidConnection
+	}
+
+	// End Marker for the path that no one is waiting for is just ignored.
+	u.releaseHold(em.TEID())
+	return nil
+}
@@ -67,6 +67,9 @@ func (d *DeletePDPContextResponse) MarshalTo(b []byte) error {
 	if len(b) < d.MarshalLen() {
 		return ErrTooShortToMarshal
 	}
+	if d.Header.Payload != nil {
+		d.Header.Payload = nil
+	}
 	d.Header.Payload = make([]byte, d.MarshalLen()-d.Header.MarshalLen())
 
 	offset := 0
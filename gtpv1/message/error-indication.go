@@ -56,6 +56,9 @@ func (e *ErrorIndication) MarshalTo(b []byte) error {
 	if len(b) < e.MarshalLen() {
 		return ErrTooShortToMarshal
 	}
+	if e.Header.Payload != nil {
+		e.Header.Payload = nil
+	}
 	e.Header.Payload = make([]byte, e.MarshalLen()-e.Header.MarshalLen())
 
 	offset := 0
@@ -15,6 +15,8 @@ var (
 	ErrTooShortToMarshal  = errors.New("too short to serialize")
 	ErrTooShortToParse    = errors.New("too short to decode as GTPv1")
 	ErrInvalidMessageType = errors.New("got invalid message type")
+
+	ErrExtensionHeaderNotFound = errors.New("extension header not found")
 )
 
 // InvalidTypeError indicates the type of an ExtensionHeader is invalid.
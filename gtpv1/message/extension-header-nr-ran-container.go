@@ -0,0 +1,59 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package message
+
+import "fmt"
+
+// PDU Type definitions of NR RAN Container, defined in §5.5.2, TS 38.425.
+const (
+	PDUTypeDLUserData                uint8 = 0
+	PDUTypeDLDataDeliveryStatus      uint8 = 1
+	PDUTypeAssistanceInformationData uint8 = 2
+)
+
+// NRRANContainer represents the content of NR RAN Container Extension Header,
+// which is a frame of NR user plane protocol defined in TS 38.425.
+//
+// Only the PDU Type and the flags in the first octet are decoded, and the rest
+// of the frame is kept as it is in Payload, including the padding.
+type NRRANContainer struct {
+	PDUType uint8
+	Flags   uint8 // the lower 4 bits of the first octet, which depend on PDU Type.
+	Payload []byte
+}
+
+// NewNRRANContainerExtensionHeader creates a new ExtensionHeader of NR RAN
+// Container.
+func NewNRRANContainerExtensionHeader(c *NRRANContainer, nextType uint8) *ExtensionHeader {
+	b := make([]byte, 1+len(c.Payload))
+	b[0] = c.PDUType<<4 | c.Flags&0x0f
+	copy(b[1:], c.Payload)
+	return newPaddedExtensionHeader(ExtHeaderTypeNRRANContainer, b, nextType)
+}
+
+// NRRANContainer decodes the content of NR RAN Container.
+func (e *ExtensionHeader) NRRANContainer() (*NRRANContainer, error) {
+	if e.Type != ExtHeaderTypeNRRANContainer {
+		return nil, &InvalidTypeError{Type: e.Type}
+	}
+	if len(e.Content) < 1 {
+		return nil, ErrTooShortToParse
+	}
+
+	return &NRRANContainer{
+		PDUType: e.Content[0] >> 4,
+		Flags:   e.Content[0] & 0x0f,
+		Payload: e.Content[1:],
+	}, nil
+}
+
+// String returns the NRRANContainer values in human readable format.
+func (c *NRRANContainer) String() string {
+	return fmt.Sprintf("{PDUType: %d, Flags: %#x, Payload: %#x}",
+		c.PDUType,
+		c.Flags,
+		c.Payload,
+	)
+}
@@ -0,0 +1,52 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package message
+
+import "encoding/binary"
+
+// NewPDCPPDUNumberExtensionHeader creates a new ExtensionHeader of PDCP PDU
+// Number, defined in §5.2.2.2, TS 29.281.
+func NewPDCPPDUNumberExtensionHeader(num uint16, nextType uint8) *ExtensionHeader {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, num)
+	return newPaddedExtensionHeader(ExtHeaderTypePDCPPDUNumber, b, nextType)
+}
+
+// PDCPPDUNumber decodes the content of PDCP PDU Number.
+func (e *ExtensionHeader) PDCPPDUNumber() (uint16, error) {
+	if e.Type != ExtHeaderTypePDCPPDUNumber {
+		return 0, &InvalidTypeError{Type: e.Type}
+	}
+	if len(e.Content) < 2 {
+		return 0, ErrTooShortToParse
+	}
+	return binary.BigEndian.Uint16(e.Content[0:2]), nil
+}
+
+// NewLongPDCPPDUNumberExtensionHeader creates a new ExtensionHeader of Long PDCP
+// PDU Number, defined in §5.2.2.2A, TS 29.281. The number is 18 bits long.
+//
+// The type is ExtHeaderTypeLongPDCPPDUNumber, which can be changed to
+// ExtHeaderTypeLongPDCPPDUNumberRequired if the receiver is required to
+// comprehend it.
+func NewLongPDCPPDUNumberExtensionHeader(num uint32, nextType uint8) *ExtensionHeader {
+	b := make([]byte, 6) // with 3 spare octets
+	b[0] = uint8(num>>16) & 0x03
+	binary.BigEndian.PutUint16(b[1:3], uint16(num))
+	return newPaddedExtensionHeader(ExtHeaderTypeLongPDCPPDUNumber, b, nextType)
+}
+
+// LongPDCPPDUNumber decodes the content of Long PDCP PDU Number.
+func (e *ExtensionHeader) LongPDCPPDUNumber() (uint32, error) {
+	switch e.Type {
+	case ExtHeaderTypeLongPDCPPDUNumber, ExtHeaderTypeLongPDCPPDUNumberRequired:
+	default:
+		return 0, &InvalidTypeError{Type: e.Type}
+	}
+	if len(e.Content) < 3 {
+		return 0, ErrTooShortToParse
+	}
+	return uint32(e.Content[0]&0x03)<<16 | uint32(binary.BigEndian.Uint16(e.Content[1:3])), nil
+}
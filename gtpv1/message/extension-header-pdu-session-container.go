@@ -0,0 +1,92 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package message
+
+import "fmt"
+
+// PDU Type definitions of PDU Session Container, defined in §5.5.2, TS 38.415.
+const (
+	PDUTypeDLPDUSessionInformation uint8 = 0
+	PDUTypeULPDUSessionInformation uint8 = 1
+)
+
+// PDUSessionContainer represents the content of PDU Session Container Extension
+// Header, which is DL or UL PDU Session Information defined in §5.5.2, TS 38.415.
+//
+// The optional fields indicated by QMP, SNP and the other flags, e.g., time
+// stamps and QFI sequence numbers, are not supported.
+type PDUSessionContainer struct {
+	PDUType uint8
+	QFI     uint8
+
+	// PPP, RQI and PPI are available only in DL PDU Session Information.
+	// PPI is present only if PPP is true.
+	PPP bool
+	RQI bool
+	PPI uint8
+}
+
+// NewPDUSessionContainerExtensionHeader creates a new ExtensionHeader of PDU
+// Session Container.
+func NewPDUSessionContainerExtensionHeader(c *PDUSessionContainer, nextType uint8) *ExtensionHeader {
+	return newPaddedExtensionHeader(ExtHeaderTypePDUSessionContainer, c.marshal(), nextType)
+}
+
+func (c *PDUSessionContainer) marshal() []byte {
+	b := []byte{c.PDUType << 4, c.QFI & 0x3f}
+	if c.PDUType != PDUTypeDLPDUSessionInformation {
+		return b
+	}
+
+	if c.PPP {
+		b[1] |= 0x80
+	}
+	if c.RQI {
+		b[1] |= 0x40
+	}
+	if c.PPP {
+		b = append(b, (c.PPI&0x07)<<5)
+	}
+	return b
+}
+
+// PDUSessionContainer decodes the content of PDU Session Container.
+func (e *ExtensionHeader) PDUSessionContainer() (*PDUSessionContainer, error) {
+	if e.Type != ExtHeaderTypePDUSessionContainer {
+		return nil, &InvalidTypeError{Type: e.Type}
+	}
+	if len(e.Content) < 2 {
+		return nil, ErrTooShortToParse
+	}
+
+	c := &PDUSessionContainer{
+		PDUType: e.Content[0] >> 4,
+		QFI:     e.Content[1] & 0x3f,
+	}
+	if c.PDUType != PDUTypeDLPDUSessionInformation {
+		return c, nil
+	}
+
+	c.PPP = e.Content[1]&0x80 != 0
+	c.RQI = e.Content[1]&0x40 != 0
+	if c.PPP {
+		if len(e.Content) < 3 {
+			return nil, ErrTooShortToParse
+		}
+		c.PPI = e.Content[2] >> 5
+	}
+	return c, nil
+}
+
+// String returns the PDUSessionContainer values in human readable format.
+func (c *PDUSessionContainer) String() string {
+	return fmt.Sprintf("{PDUType: %d, QFI: %d, PPP: %v, RQI: %v, PPI: %d}",
+		c.PDUType,
+		c.QFI,
+		c.PPP,
+		c.RQI,
+		c.PPI,
+	)
+}
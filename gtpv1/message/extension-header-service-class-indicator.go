@@ -0,0 +1,22 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package message
+
+// NewServiceClassIndicatorExtensionHeader creates a new ExtensionHeader of
+// Service Class Indicator, defined in §5.2.2.5, TS 29.281.
+func NewServiceClassIndicatorExtensionHeader(sci uint8, nextType uint8) *ExtensionHeader {
+	return newPaddedExtensionHeader(ExtHeaderTypeServiceClassIndicator, []byte{sci}, nextType)
+}
+
+// ServiceClassIndicator decodes the content of Service Class Indicator.
+func (e *ExtensionHeader) ServiceClassIndicator() (uint8, error) {
+	if e.Type != ExtHeaderTypeServiceClassIndicator {
+		return 0, &InvalidTypeError{Type: e.Type}
+	}
+	if len(e.Content) < 1 {
+		return 0, ErrTooShortToParse
+	}
+	return e.Content[0], nil
+}
@@ -0,0 +1,26 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package message
+
+import "encoding/binary"
+
+// NewUDPPortExtensionHeader creates a new ExtensionHeader of UDP Port, defined
+// in §5.2.2.1, TS 29.281.
+func NewUDPPortExtensionHeader(port uint16, nextType uint8) *ExtensionHeader {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, port)
+	return newPaddedExtensionHeader(ExtHeaderTypeUDPPort, b, nextType)
+}
+
+// UDPPort decodes the content of UDP Port.
+func (e *ExtensionHeader) UDPPort() (uint16, error) {
+	if e.Type != ExtHeaderTypeUDPPort {
+		return 0, &InvalidTypeError{Type: e.Type}
+	}
+	if len(e.Content) < 2 {
+		return 0, ErrTooShortToParse
+	}
+	return binary.BigEndian.Uint16(e.Content[0:2]), nil
+}
@@ -69,7 +69,11 @@ func (e *ExtensionHeader) MarshalTo(b []byte) error {
 	if l < offset+1 {
 		return ErrTooShortToMarshal
 	}
-	copy(b[1:offset], e.Content)
+	n := copy(b[1:offset], e.Content)
+	// padding
+	for i := 1 + n; i < offset; i++ {
+		b[i] = 0
+	}
 	b[offset] = e.NextType
 
 	return nil
@@ -138,6 +142,15 @@ func (e *ExtensionHeader) SetLength() {
 	e.Length = uint8(pad4Len(len(e.Content)+2) / 4)
 }
 
+// newPaddedExtensionHeader creates a new ExtensionHeader with content padded
+// with zeros to fit in the multiple of 4 octets, so that the Content matches the
+// one decoded from the packet.
+func newPaddedExtensionHeader(typ uint8, content []byte, nextType uint8) *ExtensionHeader {
+	padded := make([]byte, pad4Len(len(content)+2)-2)
+	copy(padded, content)
+	return NewExtensionHeader(typ, padded, nextType)
+}
+
 // String returns an ExtensionHeader fields in human readable format.
 func (e *ExtensionHeader) String() string {
 	return fmt.Sprintf("{Type: %#x, Length: %d, Content: %#x, NextType: %x}",
@@ -0,0 +1,147 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package message_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+)
+
+func TestTypedExtensionHeaders(t *testing.T) {
+	cases := []struct {
+		description string
+		eh          *message.ExtensionHeader
+		decode      func(eh *message.ExtensionHeader) (any, error)
+		want        any
+	}{
+		{
+			"PDUSessionContainer/DL",
+			message.NewPDUSessionContainerExtensionHeader(&message.PDUSessionContainer{
+				PDUType: message.PDUTypeDLPDUSessionInformation, QFI: 9, RQI: true,
+			}, 0),
+			func(eh *message.ExtensionHeader) (any, error) { return eh.PDUSessionContainer() },
+			&message.PDUSessionContainer{PDUType: message.PDUTypeDLPDUSessionInformation, QFI: 9, RQI: true},
+		}, {
+			"PDUSessionContainer/UL",
+			message.NewPDUSessionContainerExtensionHeader(&message.PDUSessionContainer{
+				PDUType: message.PDUTypeULPDUSessionInformation, QFI: 63,
+			}, 0),
+			func(eh *message.ExtensionHeader) (any, error) { return eh.PDUSessionContainer() },
+			&message.PDUSessionContainer{PDUType: message.PDUTypeULPDUSessionInformation, QFI: 63},
+		}, {
+			"NRRANContainer",
+			message.NewNRRANContainerExtensionHeader(&message.NRRANContainer{
+				PDUType: message.PDUTypeDLUserData, Flags: 0x04, Payload: []byte{0x00, 0x00, 0x01, 0x02, 0x03},
+			}, 0),
+			func(eh *message.ExtensionHeader) (any, error) { return eh.NRRANContainer() },
+			&message.NRRANContainer{
+				PDUType: message.PDUTypeDLUserData, Flags: 0x04, Payload: []byte{0x00, 0x00, 0x01, 0x02, 0x03},
+			},
+		}, {
+			"PDCPPDUNumber",
+			message.NewPDCPPDUNumberExtensionHeader(0xbeef, 0),
+			func(eh *message.ExtensionHeader) (any, error) { return eh.PDCPPDUNumber() },
+			uint16(0xbeef),
+		}, {
+			"LongPDCPPDUNumber",
+			message.NewLongPDCPPDUNumberExtensionHeader(0x3ffff, 0),
+			func(eh *message.ExtensionHeader) (any, error) { return eh.LongPDCPPDUNumber() },
+			uint32(0x3ffff),
+		}, {
+			"UDPPort",
+			message.NewUDPPortExtensionHeader(2152, 0),
+			func(eh *message.ExtensionHeader) (any, error) { return eh.UDPPort() },
+			uint16(2152),
+		}, {
+			"ServiceClassIndicator",
+			message.NewServiceClassIndicatorExtensionHeader(0x80, 0),
+			func(eh *message.ExtensionHeader) (any, error) { return eh.ServiceClassIndicator() },
+			uint8(0x80),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			b, err := c.eh.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b)%4 != 0 {
+				t.Errorf("not padded: %x", b)
+			}
+
+			parsed, err := message.ParseExtensionHeader(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed.Type = c.eh.Type
+
+			got, err := c.decode(parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Error(diff)
+			}
+
+			// decoding as the other type fails.
+			parsed.Type = message.ExtHeaderTypeSuspendRequest
+			var ite *message.InvalidTypeError
+			if _, err := c.decode(parsed); !errors.As(err, &ite) {
+				t.Errorf("got unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTPDUExtensionHeaders(t *testing.T) {
+	pdu := message.NewTPDU(0xdeadbeef, []byte{0xde, 0xad, 0xbe, 0xef})
+	if _, err := pdu.QFI(); !errors.Is(err, message.ErrExtensionHeaderNotFound) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+
+	pdu.SetQFI(message.PDUTypeULPDUSessionInformation, 5)
+	pdu.SetLongPDCPPDUNumber(0x12345)
+	pdu.SetQFI(message.PDUTypeDLPDUSessionInformation, 6)
+
+	b, err := pdu.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := message.ParseTPDU(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PDU Type is kept as the container exists.
+	c, err := parsed.PDUSessionContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&message.PDUSessionContainer{PDUType: message.PDUTypeULPDUSessionInformation, QFI: 6}, c); diff != "" {
+		t.Error(diff)
+	}
+	if num, err := parsed.LongPDCPPDUNumber(); err != nil || num != 0x12345 {
+		t.Errorf("got unexpected number: %#x, %v", num, err)
+	}
+
+	parsed.RemoveExtensionHeader(message.ExtHeaderTypePDUSessionContainer)
+	parsed.RemoveExtensionHeader(message.ExtHeaderTypeLongPDCPPDUNumber)
+	if parsed.HasExtensionHeader() {
+		t.Error("E flag is not cleared")
+	}
+	b, err = parsed.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x30, 0xff, 0x00, 0x04, 0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef}
+	if diff := cmp.Diff(want, b); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -241,6 +241,67 @@ func (h *Header) WithExtensionHeaders(extHdrs ...*ExtensionHeader) *Header {
 	return h
 }
 
+// ExtensionHeader returns the first ExtensionHeader of the type given.
+func (h *Header) ExtensionHeader(typ uint8) (*ExtensionHeader, error) {
+	for _, eh := range h.ExtensionHeaders {
+		if eh.Type == typ {
+			return eh, nil
+		}
+	}
+	return nil, ErrExtensionHeaderNotFound
+}
+
+// SetExtensionHeader replaces the ExtensionHeader of the same type as eh with it,
+// or appends it if not exists. The next types of ExtensionHeaders and the E flag
+// are updated accordingly.
+func (h *Header) SetExtensionHeader(eh *ExtensionHeader) {
+	replaced := false
+	for i, e := range h.ExtensionHeaders {
+		if e.Type == eh.Type {
+			h.ExtensionHeaders[i] = eh
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		h.ExtensionHeaders = append(h.ExtensionHeaders, eh)
+	}
+	h.chainExtensionHeaders()
+}
+
+// RemoveExtensionHeader removes the ExtensionHeaders of the type given. The next
+// types of ExtensionHeaders and the E flag are updated accordingly.
+func (h *Header) RemoveExtensionHeader(typ uint8) {
+	ehs := h.ExtensionHeaders[:0]
+	for _, eh := range h.ExtensionHeaders {
+		if eh.Type != typ {
+			ehs = append(ehs, eh)
+		}
+	}
+	h.ExtensionHeaders = ehs
+	h.chainExtensionHeaders()
+}
+
+// chainExtensionHeaders sets the next types of ExtensionHeaders in the order.
+func (h *Header) chainExtensionHeaders() {
+	if len(h.ExtensionHeaders) == 0 {
+		h.Flags &^= 0x04
+		h.NextExtensionHeaderType = ExtHeaderTypeNoMoreExtensionHeaders
+		h.ExtensionHeaders = nil
+		h.SetLength()
+		return
+	}
+
+	h.SetNextExtensionHeaderType(h.ExtensionHeaders[0].Type)
+	for i, eh := range h.ExtensionHeaders {
+		eh.NextType = ExtHeaderTypeNoMoreExtensionHeaders
+		if i+1 < len(h.ExtensionHeaders) {
+			eh.NextType = h.ExtensionHeaders[i+1].Type
+		}
+	}
+	h.SetLength()
+}
+
 // MarshalLen returns the serial length of Header.
 func (h *Header) MarshalLen() int {
 	l := len(h.Payload) + 8
@@ -33,6 +33,114 @@ func NewTPDUWithExtentionHeader(teid uint32, payload []byte, extHdrs ...*Extensi
 	return t
 }
 
+// PDUSessionContainer returns the content of PDU Session Container Extension Header.
+func (t *TPDU) PDUSessionContainer() (*PDUSessionContainer, error) {
+	eh, err := t.ExtensionHeader(ExtHeaderTypePDUSessionContainer)
+	if err != nil {
+		return nil, err
+	}
+	return eh.PDUSessionContainer()
+}
+
+// SetPDUSessionContainer sets PDU Session Container Extension Header.
+func (t *TPDU) SetPDUSessionContainer(c *PDUSessionContainer) {
+	t.SetExtensionHeader(NewPDUSessionContainerExtensionHeader(c, ExtHeaderTypeNoMoreExtensionHeaders))
+}
+
+// QFI returns the QFI in PDU Session Container Extension Header.
+func (t *TPDU) QFI() (uint8, error) {
+	c, err := t.PDUSessionContainer()
+	if err != nil {
+		return 0, err
+	}
+	return c.QFI, nil
+}
+
+// SetQFI sets the QFI in PDU Session Container Extension Header, keeping the other
+// fields if it exists. Otherwise, PDU Session Container with the PDU Type given is
+// added.
+func (t *TPDU) SetQFI(pduType, qfi uint8) {
+	c, err := t.PDUSessionContainer()
+	if err != nil {
+		c = &PDUSessionContainer{PDUType: pduType}
+	}
+	c.QFI = qfi
+	t.SetPDUSessionContainer(c)
+}
+
+// NRRANContainer returns the content of NR RAN Container Extension Header.
+func (t *TPDU) NRRANContainer() (*NRRANContainer, error) {
+	eh, err := t.ExtensionHeader(ExtHeaderTypeNRRANContainer)
+	if err != nil {
+		return nil, err
+	}
+	return eh.NRRANContainer()
+}
+
+// SetNRRANContainer sets NR RAN Container Extension Header.
+func (t *TPDU) SetNRRANContainer(c *NRRANContainer) {
+	t.SetExtensionHeader(NewNRRANContainerExtensionHeader(c, ExtHeaderTypeNoMoreExtensionHeaders))
+}
+
+// PDCPPDUNumber returns the number in PDCP PDU Number Extension Header.
+func (t *TPDU) PDCPPDUNumber() (uint16, error) {
+	eh, err := t.ExtensionHeader(ExtHeaderTypePDCPPDUNumber)
+	if err != nil {
+		return 0, err
+	}
+	return eh.PDCPPDUNumber()
+}
+
+// SetPDCPPDUNumber sets PDCP PDU Number Extension Header.
+func (t *TPDU) SetPDCPPDUNumber(num uint16) {
+	t.SetExtensionHeader(NewPDCPPDUNumberExtensionHeader(num, ExtHeaderTypeNoMoreExtensionHeaders))
+}
+
+// LongPDCPPDUNumber returns the number in Long PDCP PDU Number Extension Header,
+// of either type.
+func (t *TPDU) LongPDCPPDUNumber() (uint32, error) {
+	eh, err := t.ExtensionHeader(ExtHeaderTypeLongPDCPPDUNumber)
+	if err != nil {
+		if eh, err = t.ExtensionHeader(ExtHeaderTypeLongPDCPPDUNumberRequired); err != nil {
+			return 0, err
+		}
+	}
+	return eh.LongPDCPPDUNumber()
+}
+
+// SetLongPDCPPDUNumber sets Long PDCP PDU Number Extension Header.
+func (t *TPDU) SetLongPDCPPDUNumber(num uint32) {
+	t.SetExtensionHeader(NewLongPDCPPDUNumberExtensionHeader(num, ExtHeaderTypeNoMoreExtensionHeaders))
+}
+
+// UDPPort returns the port in UDP Port Extension Header.
+func (t *TPDU) UDPPort() (uint16, error) {
+	eh, err := t.ExtensionHeader(ExtHeaderTypeUDPPort)
+	if err != nil {
+		return 0, err
+	}
+	return eh.UDPPort()
+}
+
+// SetUDPPort sets UDP Port Extension Header.
+func (t *TPDU) SetUDPPort(port uint16) {
+	t.SetExtensionHeader(NewUDPPortExtensionHeader(port, ExtHeaderTypeNoMoreExtensionHeaders))
+}
+
+// ServiceClassIndicator returns the value in Service Class Indicator Extension Header.
+func (t *TPDU) ServiceClassIndicator() (uint8, error) {
+	eh, err := t.ExtensionHeader(ExtHeaderTypeServiceClassIndicator)
+	if err != nil {
+		return 0, err
+	}
+	return eh.ServiceClassIndicator()
+}
+
+// SetServiceClassIndicator sets Service Class Indicator Extension Header.
+func (t *TPDU) SetServiceClassIndicator(sci uint8) {
+	t.SetExtensionHeader(NewServiceClassIndicatorExtensionHeader(sci, ExtHeaderTypeNoMoreExtensionHeaders))
+}
+
 // Marshal returns the byte sequence generated from a TPDU.
 func (t *TPDU) Marshal() ([]byte, error) {
 	b := make([]byte, t.MarshalLen())
@@ -48,6 +48,32 @@ func TestTPDU(t *testing.T) {
 				// Payload
 				0xde, 0xad, 0xbe, 0xef,
 			},
+		}, {
+			Description: "With-TypedExtensionHeaders",
+			Structured: func() *message.TPDU {
+				pdu := message.NewTPDU(0xdeadbeef, []byte{0xde, 0xad, 0xbe, 0xef})
+				pdu.SetUDPPort(2152)
+				pdu.SetPDUSessionContainer(&message.PDUSessionContainer{
+					PDUType: message.PDUTypeDLPDUSessionInformation,
+					QFI:     9,
+					PPP:     true,
+					RQI:     true,
+					PPI:     3,
+				})
+				return pdu
+			}(),
+			Serialized: []byte{
+				0x34, 0xff, 0x00, 0x14, 0xde, 0xad, 0xbe,
+				0xef, 0x00, 0x00, 0x00,
+				// Next extension header type
+				0x40,
+				// UDP Port
+				0x01, 0x08, 0x68, 0x85,
+				// PDU Session Container
+				0x02, 0x00, 0xc9, 0x60, 0x00, 0x00, 0x00, 0x00,
+				// Payload
+				0xde, 0xad, 0xbe, 0xef,
+			},
 		},
 	}
 
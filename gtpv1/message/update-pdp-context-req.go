@@ -144,6 +144,9 @@ func (u *UpdatePDPContextRequest) MarshalTo(b []byte) error {
 	if len(b) < u.MarshalLen() {
 		return ErrTooShortToMarshal
 	}
+	if u.Header.Payload != nil {
+		u.Header.Payload = nil
+	}
 	u.Header.Payload = make([]byte, u.MarshalLen()-u.Header.MarshalLen())
 
 	offset := 0
@@ -46,6 +46,9 @@ func (v *VersionNotSupported) MarshalTo(b []byte) error {
 	if len(b) < v.MarshalLen() {
 		return ErrTooShortToMarshal
 	}
+	if v.Header.Payload != nil {
+		v.Header.Payload = nil
+	}
 	v.Header.Payload = make([]byte, v.MarshalLen()-v.Header.MarshalLen())
 
 	offset := 0
@@ -0,0 +1,334 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+)
+
+const (
+	// DefaultReadBufferSize is the default size of the buffer to read a packet
+	// into, which can be changed by SetReadBufferSize.
+	DefaultReadBufferSize = 1500
+
+	// DefaultMTU is the default MTU of the tunnels, which can be changed by SetMTU.
+	DefaultMTU = 1500
+)
+
+// OversizeAction is the action taken on the packet that exceeds the MTU of the
+// tunnels when it is encapsulated.
+type OversizeAction int
+
+// OversizeAction definitions.
+const (
+	// OversizeSend sends the packet as it is, which is the default.
+	OversizeSend OversizeAction = iota
+
+	// OversizeDrop drops the packet.
+	OversizeDrop
+
+	// OversizeFragment sends the packet without Don't Fragment bit in the
+	// outer IPv4 header (or allowing fragmentation by Kernel for IPv6), so that
+	// the outer packet is fragmented on the path.
+	OversizeFragment
+
+	// OversizeICMP drops the packet and sends ICMP Fragmentation Needed (for
+	// IPv4) or ICMPv6 Packet Too Big (for IPv6) with the MTU back to the source
+	// of the packet. The ICMP is written to the device of userspace GTP-U, and
+	// it is not sent if the userspace GTP-U is not enabled or the packet is IPv4
+	// without Don't Fragment bit.
+	OversizeICMP
+)
+
+// OversizeStats is the counters of the packets that exceed the size limits.
+type OversizeStats struct {
+	// Truncated is the number of packets received that exceed the read buffer,
+	// which are dropped.
+	Truncated uint64
+
+	// Exceeded is the number of packets that exceed the MTU when encapsulated.
+	Exceeded uint64
+
+	// Dropped is the number of packets dropped as they exceed the MTU.
+	Dropped uint64
+
+	// ICMPSent is the number of ICMP Fragmentation Needed or Packet Too Big sent.
+	ICMPSent uint64
+}
+
+type oversizeCounters struct {
+	truncated, exceeded, dropped, icmpSent atomic.Uint64
+}
+
+// SetReadBufferSize sets the size of the buffer to read a packet into, which is
+// DefaultReadBufferSize by default. The packets larger than the size are dropped,
+// and counted as Truncated in OversizeStats. Set it large enough to receive the
+// jumbo frames.
+//
+// This should be called before ListenAndServe.
+func (u *UPlaneConn) SetReadBufferSize(n int) {
+	u.mu.Lock()
+	u.readBufferSize = n
+	u.mu.Unlock()
+}
+
+func (u *UPlaneConn) readBufSize() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.readBufferSize <= 0 {
+		return DefaultReadBufferSize
+	}
+	return u.readBufferSize
+}
+
+// SetMTU sets the MTU of the path the encapsulated packets are sent on, which is
+// DefaultMTU by default. The packets to be encapsulated are allowed up to the MTU
+// minus the size of the outer IP, UDP and GTP headers, and the larger ones are
+// handled by WriteToGTP as specified with SetOversizeAction.
+//
+// The MTU minus the outer headers over IPv4 is also used as the MTU of the device
+// of Kernel GTP-U, which means it should be called before EnableKernelGTP to take
+// effect.
+func (u *UPlaneConn) SetMTU(mtu int) {
+	u.mu.Lock()
+	u.mtu = mtu
+	u.mu.Unlock()
+}
+
+// MTU returns the MTU of the path the encapsulated packets are sent on.
+func (u *UPlaneConn) MTU() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.mtu <= 0 {
+		return DefaultMTU
+	}
+	return u.mtu
+}
+
+// SetOversizeAction sets the action taken on the packets that exceed the MTU of
+// the tunnels when encapsulated by WriteToGTP, which is OversizeSend by default.
+// The socket option required by the action is applied to all the sockets of
+// UPlaneConn, including the ones opened with EnableReusePort.
+//
+// Note that the T-PDUs relayed by RelayTo are not affected by this, as they are
+// not encapsulated again.
+func (u *UPlaneConn) SetOversizeAction(action OversizeAction) error {
+	u.mu.Lock()
+	u.oversizeAction = action
+	u.mu.Unlock()
+
+	return u.applyOversizeAction()
+}
+
+// OversizeStats returns the counters of the packets that exceed the size limits.
+func (u *UPlaneConn) OversizeStats() OversizeStats {
+	return OversizeStats{
+		Truncated: u.oversize.truncated.Load(),
+		Exceeded:  u.oversize.exceeded.Load(),
+		Dropped:   u.oversize.dropped.Load(),
+		ICMPSent:  u.oversize.icmpSent.Load(),
+	}
+}
+
+// applyOversizeAction sets the socket option required by the OversizeAction to
+// all the sockets opened, which should be called whenever a socket is opened.
+func (u *UPlaneConn) applyOversizeAction() error {
+	u.mu.Lock()
+	action := u.oversizeAction
+	pcs := make([]pktConn, 0, len(u.reuseConns)+1)
+	if u.pktConn != nil {
+		pcs = append(pcs, u.pktConn)
+	}
+	pcs = append(pcs, u.reuseConns...)
+	u.mu.Unlock()
+
+	for _, pc := range pcs {
+		if err := setDontFragment(pc, action != OversizeFragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	// udpHeaderLen is the size of UDP header.
+	udpHeaderLen = 8
+
+	// ipv4HeaderLen and ipv6HeaderLen are the size of the outer IP headers
+	// without options or extension headers.
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+
+	// minOverhead is the size of the outer headers of the T-PDU without any
+	// optional fields over IPv4.
+	minOverhead = ipv4HeaderLen + udpHeaderLen + 8
+)
+
+// overhead returns the size of the outer IP, UDP and GTP headers of pdu sent to
+// addr, including the Sequence Number to be added if it is enabled.
+func (u *UPlaneConn) overhead(pdu *message.TPDU, addr net.Addr) int {
+	n := pdu.MarshalLen() - len(pdu.Payload) + udpHeaderLen
+	if pdu.Flags&0x07 == 0 {
+		if _, ok := u.seqNums.Load(pdu.TEID()); ok {
+			n += 4
+		}
+	}
+
+	if a, ok := addr.(*net.UDPAddr); ok && a.IP.To4() == nil {
+		return n + ipv6HeaderLen
+	}
+	return n + ipv4HeaderLen
+}
+
+// checkOversize checks if pdu exceeds the MTU when sent to addr, and returns
+// ErrPacketTooBig if it should not be sent.
+func (u *UPlaneConn) checkOversize(pdu *message.TPDU, addr net.Addr) error {
+	mtu := u.MTU() - u.overhead(pdu, addr)
+	p := pdu.Payload
+	if len(p) <= mtu {
+		return nil
+	}
+	u.oversize.exceeded.Add(1)
+
+	u.mu.Lock()
+	action := u.oversizeAction
+	u.mu.Unlock()
+
+	switch action {
+	case OversizeDrop:
+		u.oversize.dropped.Add(1)
+		return ErrPacketTooBig
+	case OversizeICMP:
+		u.oversize.dropped.Add(1)
+		if u.sendPacketTooBig(p, mtu) {
+			u.oversize.icmpSent.Add(1)
+		}
+		return ErrPacketTooBig
+	default:
+		return nil
+	}
+}
+
+// sendPacketTooBig writes ICMP Fragmentation Needed or ICMPv6 Packet Too Big
+// for the packet pkt to the device of userspace GTP-U, and reports whether it
+// is sent.
+func (u *UPlaneConn) sendPacketTooBig(pkt []byte, mtu int) bool {
+	u.userspace.mu.RLock()
+	dev := u.userspace.dev
+	u.userspace.mu.RUnlock()
+	if dev == nil {
+		return false
+	}
+
+	var icmp []byte
+	switch pkt[0] >> 4 {
+	case 4:
+		// the packet without DF bit should have been fragmented instead.
+		if len(pkt) < 20 || pkt[6]&0x40 == 0 {
+			return false
+		}
+		icmp = newICMPFragmentationNeeded(u.icmpSource(pkt, net.IPv4len), pkt, mtu)
+	case 6:
+		if len(pkt) < 40 {
+			return false
+		}
+		icmp = newICMPv6PacketTooBig(u.icmpSource(pkt, net.IPv6len), pkt, mtu)
+	default:
+		return false
+	}
+
+	if _, err := dev.Write(icmp); err != nil {
+		logf("error writing to the device: %v", err)
+		return false
+	}
+	return true
+}
+
+// icmpSource returns the local address if it is the same family as pkt, or the
+// destination of pkt otherwise.
+func (u *UPlaneConn) icmpSource(pkt []byte, iplen int) net.IP {
+	if addr, ok := u.LocalAddr().(*net.UDPAddr); ok {
+		if ip := normalizeIP(addr.IP); len(ip) == iplen && !ip.IsUnspecified() {
+			return ip
+		}
+	}
+	_, dst := ipAddrsOf(pkt)
+	return dst
+}
+
+// newICMPFragmentationNeeded creates ICMP Destination Unreachable with the code
+// Fragmentation Needed and DF Set for the IPv4 packet orig.
+func newICMPFragmentationNeeded(src net.IP, orig []byte, mtu int) []byte {
+	// as much of the original packet as possible without exceeding 576 bytes.
+	if len(orig) > 576-28 {
+		orig = orig[:576-28]
+	}
+
+	b := make([]byte, 28+len(orig))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64 // TTL
+	b[9] = 1  // ICMP
+	copy(b[12:16], src)
+	copy(b[16:20], orig[12:16])
+	binary.BigEndian.PutUint16(b[10:12], checksum(b[:20], 0))
+
+	icmp := b[20:]
+	icmp[0] = 3 // Destination Unreachable
+	icmp[1] = 4 // Fragmentation Needed and DF Set
+	binary.BigEndian.PutUint16(icmp[6:8], uint16(mtu))
+	copy(icmp[8:], orig)
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(icmp, 0))
+	return b
+}
+
+// newICMPv6PacketTooBig creates ICMPv6 Packet Too Big for the IPv6 packet orig.
+func newICMPv6PacketTooBig(src net.IP, orig []byte, mtu int) []byte {
+	// as much of the original packet as possible without exceeding the minimum
+	// IPv6 MTU.
+	if len(orig) > 1280-48 {
+		orig = orig[:1280-48]
+	}
+
+	b := make([]byte, 48+len(orig))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:6], uint16(8+len(orig)))
+	b[6] = 58 // ICMPv6
+	b[7] = 64 // Hop Limit
+	copy(b[8:24], src)
+	copy(b[24:40], orig[8:24])
+
+	icmp := b[40:]
+	icmp[0] = 2 // Packet Too Big
+	binary.BigEndian.PutUint32(icmp[4:8], uint32(mtu))
+	copy(icmp[8:], orig)
+
+	// pseudo header: addresses, length and next header.
+	var sum uint32
+	for i := 8; i < 40; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	sum += uint32(len(icmp)) + 58
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(icmp, sum))
+	return b
+}
+
+// checksum calculates the Internet checksum of b with the initial sum given.
+func checksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,53 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setDontFragment sets whether the outer packets sent on pc are allowed to be
+// fragmented. The Path MTU Discovery is disabled to let them fragmented.
+func setDontFragment(pc pktConn, df bool) error {
+	var (
+		level, opt, val int
+		c               interface {
+			Control(func(fd uintptr)) error
+		}
+	)
+	switch p := pc.(type) {
+	case pktConn4:
+		level, opt, val = unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_WANT
+		if !df {
+			val = unix.IP_PMTUDISC_DONT
+		}
+		rc, err := p.udpConn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		c = rc
+	case pktConn6:
+		level, opt, val = unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_WANT
+		if !df {
+			val = unix.IPV6_PMTUDISC_DONT
+		}
+		rc, err := p.udpConn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		c = rc
+	default:
+		// nothing to do with the conn that is not a socket.
+		return nil
+	}
+
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), level, opt, val)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,13 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+//go:build !linux
+
+package gtpv1
+
+// setDontFragment does nothing, as the outer packets are sent without Don't
+// Fragment bit by default on the platforms other than Linux.
+func setDontFragment(pc pktConn, df bool) error {
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func TestReadBufferSize(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		n := vnet.New(1)
+		srv, err := n.NewUPlaneConn("10.0.0.1:2152")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cli, err := n.NewUPlaneConn("10.0.0.2:2152")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.DisableErrorIndication()
+		srv.SetReadBufferSize(100)
+		if batch {
+			srv.EnableBatchIO(0)
+		}
+		go func() {
+			_ = srv.ListenAndServe(ctx)
+		}()
+
+		// the first one is dropped as it does not fit in the buffer with the header.
+		for _, p := range []string{strings.Repeat("x", 100), "pkt"} {
+			if _, err := cli.WriteToGTP(0x11111111, []byte(p), srv.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		buf := make([]byte, 1500)
+		l, _, _, err := srv.ReadFromGTP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:l]); got != "pkt" {
+			t.Errorf("wrong payload: %s", got)
+		}
+		if diff := cmp.Diff(gtpv1.OversizeStats{Truncated: 1}, srv.OversizeStats()); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestOversizeAction(t *testing.T) {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 31), Port: 2152})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := gtpv1.NewUPlaneConnWithPacketConn(pc)
+	defer pc.Close()
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	u.SetMTU(100)
+	if got := u.MTU(); got != 100 {
+		t.Errorf("wrong MTU: %d", got)
+	}
+
+	// the outer IPv4, UDP and GTP headers take 36 bytes of the MTU.
+	small, large := make([]byte, 64), make([]byte, 65)
+	if _, err := u.WriteToGTP(0x11111111, large, peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.SetOversizeAction(gtpv1.OversizeDrop); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.WriteToGTP(0x11111111, small, peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.WriteToGTP(0x11111111, large, peer.LocalAddr()); !errors.Is(err, gtpv1.ErrPacketTooBig) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+
+	if err := u.SetOversizeAction(gtpv1.OversizeFragment); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.WriteToGTP(0x11111111, large, peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the ICMP is not sent without userspace GTP-U.
+	if err := u.SetOversizeAction(gtpv1.OversizeICMP); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.WriteToGTP(0x11111111, large, peer.LocalAddr()); !errors.Is(err, gtpv1.ErrPacketTooBig) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+
+	// the Sequence Number takes 4 more bytes.
+	if err := u.SetOversizeAction(gtpv1.OversizeDrop); err != nil {
+		t.Fatal(err)
+	}
+	u.EnableSequenceNumber(0x22222222)
+	if _, err := u.WriteToGTP(0x22222222, small, peer.LocalAddr()); !errors.Is(err, gtpv1.ErrPacketTooBig) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+	if _, err := u.WriteToGTP(0x22222222, small[:60], peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := gtpv1.OversizeStats{Exceeded: 5, Dropped: 3}
+	if diff := cmp.Diff(want, u.OversizeStats()); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,397 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the burst size of the token buckets is the bytes sent at the rate in this
+	// duration by default, but no less than minBurstSize.
+	defaultBurstDuration = 100 * time.Millisecond
+	minBurstSize         = 2 * DefaultMTU
+)
+
+// DefaultMaxDelayed is the maximum number of T-PDUs delayed at a time by a
+// Policer when QoS.MaxDelayed is zero.
+const DefaultMaxDelayed = 256
+
+// QoS is the QoS parameters of a bearer enforced on the T-PDUs in a direction.
+// The bit rates are in bits per second, and zero means unlimited.
+type QoS struct {
+	// QCI is used to derive the DSCP of the T-PDUs sent, unless DSCP is set.
+	QCI uint8
+
+	// DSCP is the DSCP value marked on the T-PDUs sent, which overrides the one
+	// derived from QCI by DSCPFromQCI if non-zero.
+	DSCP uint8
+
+	// MBR is the maximum bit rate of the bearer. Burst is the maximum bytes sent
+	// at once exceeding MBR, which is the bytes sent at MBR in 100ms (but no less
+	// than twice of DefaultMTU) if zero.
+	MBR, Burst uint64
+
+	// GBR is the guaranteed bit rate of the bearer, which is capped by MBR if
+	// MBR is set. If AMBR is set anyway, the T-PDUs within GBR are never dropped
+	// nor delayed by AMBR, and only the ones exceeding GBR (up to MBR) are
+	// limited by it.
+	GBR uint64
+
+	// AMBR is the aggregate maximum bit rate shared among the bearers, e.g.,
+	// APN-AMBR of a PDN connection, which should be set only to the non-GBR
+	// bearers as defined in TS 23.401.
+	AMBR *AMBR
+
+	// MaxDelay is the maximum time a T-PDU is delayed to conform to the rates
+	// (shaping) instead of being dropped (policing). The T-PDUs that need to be
+	// delayed longer than this are dropped. Zero means policing only.
+	MaxDelay time.Duration
+
+	// MaxDelayed is the maximum number of T-PDUs being delayed at a time, and
+	// the ones to be delayed beyond this are dropped. Each of them holds the
+	// goroutine handling it. DefaultMaxDelayed is used if zero.
+	MaxDelayed int
+}
+
+// NewBearerQoS returns the QoS of a bearer in a direction with the QCI, MBR and
+// GBR, which are in kbps as in Bearer QoS IE and QoSProfile of gtpv2.Bearer.
+// ambr is shared among the bearers in the PDN connection, which can be nil, and
+// is not set to the GBR bearers as APN-AMBR applies only to non-GBR bearers.
+func NewBearerQoS(qci uint8, mbr, gbr uint64, ambr *AMBR) *QoS {
+	qos := &QoS{QCI: qci, MBR: mbr * 1000, GBR: gbr * 1000}
+	if gbr == 0 && !IsGBRQCI(qci) {
+		qos.AMBR = ambr
+	}
+	return qos
+}
+
+// IsGBRQCI reports whether the QCI is the one of GBR bearers, defined in
+// TS 23.203.
+func IsGBRQCI(qci uint8) bool {
+	switch qci {
+	case 1, 2, 3, 4, 65, 66, 67, 71, 72, 73, 74, 75, 76, 82, 83, 84, 85:
+		return true
+	default:
+		return false
+	}
+}
+
+// AMBR is the aggregate maximum bit rate shared among multiple bearers.
+type AMBR struct {
+	bucket *tokenBucket
+}
+
+// NewAMBR creates a new AMBR with the rate in bits per second. If burst is zero,
+// the default one described in QoS is used.
+func NewAMBR(rate, burst uint64) *AMBR {
+	return &AMBR{bucket: newTokenBucket(rate, burst)}
+}
+
+// Policer enforces QoS on the T-PDUs of a bearer, which is created with SetQoS or
+// SetIncomingQoS.
+type Policer struct {
+	qos     QoS
+	dscp    int
+	mbr     *tokenBucket
+	gbr     *tokenBucket
+	ambr    *tokenBucket
+	waiting atomic.Int64
+
+	passed     atomic.Uint64
+	guaranteed atomic.Uint64
+	bytes      atomic.Uint64
+	delays     atomic.Uint64
+	drops      atomic.Uint64
+	ambrs      atomic.Uint64
+}
+
+// PolicerStats is the counters of a Policer.
+type PolicerStats struct {
+	// Packets and Bytes are the number of T-PDUs and the total length of their
+	// payload passed, including the delayed ones.
+	Packets, Bytes uint64
+
+	// Guaranteed is the number of T-PDUs passed within GBR.
+	Guaranteed uint64
+
+	// Delayed is the number of T-PDUs delayed to conform to the rates.
+	Delayed uint64
+
+	// Dropped is the number of T-PDUs dropped as they exceed the rates, and
+	// DroppedByAMBR is the number of the ones among them that exceed AMBR.
+	Dropped, DroppedByAMBR uint64
+}
+
+func newPolicer(qos *QoS) *Policer {
+	p := &Policer{qos: *qos, dscp: int(qos.DSCP)}
+	if p.dscp == 0 {
+		p.dscp = int(DSCPFromQCI(qos.QCI))
+	}
+	if p.qos.MBR > 0 {
+		p.mbr = newTokenBucket(p.qos.MBR, p.qos.Burst)
+		if p.qos.GBR > p.qos.MBR {
+			p.qos.GBR = p.qos.MBR
+		}
+	}
+	if p.qos.GBR > 0 {
+		p.gbr = newTokenBucket(p.qos.GBR, 0)
+	}
+	if p.qos.AMBR != nil {
+		p.ambr = p.qos.AMBR.bucket
+	}
+	if p.qos.MaxDelayed <= 0 {
+		p.qos.MaxDelayed = DefaultMaxDelayed
+	}
+	return p
+}
+
+// QoS returns the QoS parameters enforced by the Policer.
+func (p *Policer) QoS() QoS {
+	return p.qos
+}
+
+// Stats returns the counters of the Policer.
+func (p *Policer) Stats() PolicerStats {
+	return PolicerStats{
+		Packets:       p.passed.Load(),
+		Bytes:         p.bytes.Load(),
+		Guaranteed:    p.guaranteed.Load(),
+		Delayed:       p.delays.Load(),
+		Dropped:       p.drops.Load(),
+		DroppedByAMBR: p.ambrs.Load(),
+	}
+}
+
+// dscpecn returns the value to be given to WriteToWithDSCPECN.
+func (p *Policer) dscpecn() int {
+	if p == nil {
+		return 0
+	}
+	return p.dscp << 2
+}
+
+// police reports whether the T-PDU with the payload of n bytes conforms to the
+// rates. If it needs to be delayed to conform, it blocks until then.
+//
+// The T-PDU within GBR is not limited by AMBR, while the one exceeding GBR is.
+func (p *Policer) police(n int) bool {
+	if p == nil {
+		return true
+	}
+
+	now := time.Now()
+	guaranteed := false
+	if p.gbr != nil {
+		_, guaranteed = p.gbr.reserve(now, n, 0)
+	}
+
+	var wait time.Duration
+	if p.mbr != nil {
+		d, ok := p.mbr.reserve(now, n, p.qos.MaxDelay)
+		if !ok {
+			if guaranteed {
+				p.gbr.cancel(n)
+			}
+			p.drops.Add(1)
+			return false
+		}
+		wait = d
+	}
+	if p.ambr != nil && !guaranteed {
+		d, ok := p.ambr.reserve(now, n, p.qos.MaxDelay)
+		if !ok {
+			if p.mbr != nil {
+				p.mbr.cancel(n)
+			}
+			p.drops.Add(1)
+			p.ambrs.Add(1)
+			return false
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		if p.waiting.Add(1) > int64(p.qos.MaxDelayed) {
+			p.waiting.Add(-1)
+			p.cancel(n, guaranteed)
+			p.drops.Add(1)
+			return false
+		}
+		p.delays.Add(1)
+		time.Sleep(wait)
+		p.waiting.Add(-1)
+	}
+	if guaranteed {
+		p.guaranteed.Add(1)
+	}
+	p.passed.Add(1)
+	p.bytes.Add(uint64(n))
+	return true
+}
+
+// cancel gives back the tokens reserved for the T-PDU with n bytes.
+func (p *Policer) cancel(n int, guaranteed bool) {
+	if p.mbr != nil {
+		p.mbr.cancel(n)
+	}
+	if guaranteed {
+		p.gbr.cancel(n)
+	} else if p.ambr != nil {
+		p.ambr.cancel(n)
+	}
+}
+
+// tokenBucket is a token bucket with the tokens in bytes. The tokens can be
+// negative while the T-PDUs reserved are being delayed.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst uint64) *tokenBucket {
+	b := &tokenBucket{rate: float64(rate) / 8, burst: float64(burst)}
+	if b.burst == 0 {
+		b.burst = b.rate * defaultBurstDuration.Seconds()
+		if b.burst < minBurstSize {
+			b.burst = minBurstSize
+		}
+	}
+	b.tokens = b.burst
+	return b
+}
+
+// reserve takes n tokens and returns the time to wait until they are available.
+// If it is longer than maxDelay, no tokens are taken and false is returned.
+func (b *tokenBucket) reserve(now time.Time, n int, maxDelay time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	left := b.tokens - float64(n)
+	var wait time.Duration
+	if left < 0 {
+		wait = time.Duration(-left / b.rate * float64(time.Second))
+		if wait > maxDelay {
+			return 0, false
+		}
+	}
+	b.tokens = left
+	return wait, true
+}
+
+// cancel gives back n tokens reserved.
+func (b *tokenBucket) cancel(n int) {
+	b.mu.Lock()
+	b.tokens += float64(n)
+	b.mu.Unlock()
+}
+
+// DSCPFromQCI returns the DSCP value for the QCI, based on the mapping commonly
+// used in mobile networks, e.g., EF for conversational voice. The unknown QCIs
+// are mapped to the default (best effort).
+func DSCPFromQCI(qci uint8) uint8 {
+	switch qci {
+	case 1, 65, 66:
+		return 46 // EF
+	case 2:
+		return 36 // AF42
+	case 3:
+		return 34 // AF41
+	case 4:
+		return 26 // AF31
+	case 5, 69:
+		return 40 // CS5
+	case 6, 70:
+		return 18 // AF21
+	case 7:
+		return 20 // AF22
+	case 8:
+		return 10 // AF11
+	default:
+		return 0
+	}
+}
+
+// SetQoS enforces QoS on the T-PDUs sent with the outgoing TEID by WriteToGTP,
+// the userspace GTP-U data path and RelayTo, and returns the Policer to see the
+// counters. The T-PDUs are marked with the DSCP specified in QoS. WriteToGTP
+// returns ErrRateExceeded for the T-PDUs dropped.
+//
+// The T-PDUs to be delayed block the goroutine handling them, which is the
+// serving one when EnableBatchIO is used, up to QoS.MaxDelayed at a time.
+// Kernel GTP-U is not affected.
+//
+// Giving nil as qos removes QoS set for the TEID.
+func (u *UPlaneConn) SetQoS(oteid uint32, qos *QoS) *Policer {
+	return setPolicer(&u.qosOut, oteid, qos)
+}
+
+// SetIncomingQoS enforces QoS on the T-PDUs received with the incoming TEID,
+// before they are passed to the userspace GTP-U data path, the Tunnel or the
+// relayed peer. See SetQoS for the details.
+func (u *UPlaneConn) SetIncomingQoS(iteid uint32, qos *QoS) *Policer {
+	return setPolicer(&u.qosIn, iteid, qos)
+}
+
+func setPolicer(m *sync.Map, teid uint32, qos *QoS) *Policer {
+	if qos == nil {
+		m.Delete(teid)
+		return nil
+	}
+
+	p := newPolicer(qos)
+	m.Store(teid, p)
+	return p
+}
+
+func loadPolicer(m *sync.Map, teid uint32) *Policer {
+	v, ok := m.Load(teid)
+	if !ok {
+		return nil
+	}
+	return v.(*Policer)
+}
+
+// policeIncoming reports whether the T-PDU in raw conforms to QoS set for its
+// TEID by SetIncomingQoS, and returns the Policer if any.
+func (u *UPlaneConn) policeIncoming(raw []byte) (*Policer, bool) {
+	p := loadPolicer(&u.qosIn, binary.BigEndian.Uint32(raw[4:8]))
+	return p, p.police(tpduPayloadLen(raw))
+}
+
+// policer returns the Policer set for the TEID relayed to by SetQoS on the
+// UPlaneConn to send on, if any.
+func (p *peer) policer() *Policer {
+	return loadPolicer(&p.srcConn.qosOut, p.teid)
+}
+
+// tpduPayloadLen returns the length of the payload of the T-PDU in raw, without
+// taking the Extension Headers into account.
+func tpduPayloadLen(raw []byte) int {
+	n := len(raw) - 8
+	if raw[0]&0x07 != 0 {
+		n -= 4
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,260 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func TestQoS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	srv, err := n.NewUPlaneConn("10.0.0.1:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := n.NewUPlaneConn("10.0.0.2:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := n.NewUPlaneConn("10.0.0.3:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the T-PDUs to srv are not handled in the subtests for the outgoing ones.
+	srv.DisableErrorIndication()
+
+	for _, u := range []*gtpv1.UPlaneConn{srv, cli, sink} {
+		go func(u *gtpv1.UPlaneConn) {
+			_ = u.ListenAndServe(ctx)
+		}(u)
+	}
+
+	payload := bytes.Repeat([]byte{0xff}, 1000)
+	send := func(t *testing.T, u *gtpv1.UPlaneConn, teid uint32, count int) (sent int) {
+		t.Helper()
+		for i := 0; i < count; i++ {
+			_, err := u.WriteToGTP(teid, payload, srv.LocalAddr())
+			switch {
+			case err == nil:
+				sent++
+			case errors.Is(err, gtpv1.ErrRateExceeded):
+			default:
+				t.Fatal(err)
+			}
+		}
+		return sent
+	}
+	receive := func(t *testing.T, tun *gtpv1.Tunnel, count int) {
+		t.Helper()
+		for i := 0; i < count; i++ {
+			select {
+			case <-tun.Receive():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out while waiting for T-PDU #%d", i)
+			}
+		}
+		select {
+		case <-tun.Receive():
+			t.Error("got unexpected T-PDU")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	t.Run("policing", func(t *testing.T) {
+		// the burst is twice of DefaultMTU, which is enough for 3 T-PDUs.
+		p := cli.SetQoS(0x11111111, &gtpv1.QoS{QCI: 9, MBR: 8000})
+		if got := send(t, cli, 0x11111111, 5); got != 3 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+
+		want := gtpv1.PolicerStats{Packets: 3, Bytes: 3000, Dropped: 2}
+		if diff := cmp.Diff(want, p.Stats()); diff != "" {
+			t.Error(diff)
+		}
+
+		if p := cli.SetQoS(0x11111111, nil); p != nil {
+			t.Errorf("got unexpected Policer: %v", p)
+		}
+		if got := send(t, cli, 0x11111111, 5); got != 5 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+	})
+
+	t.Run("ambr", func(t *testing.T) {
+		ambr := gtpv1.NewAMBR(8000, 0)
+		p1 := cli.SetQoS(0x22222222, &gtpv1.QoS{AMBR: ambr})
+		p2 := cli.SetQoS(0x22222223, &gtpv1.QoS{AMBR: ambr})
+		gbr := cli.SetQoS(0x22222224, &gtpv1.QoS{QCI: 1, GBR: 8000, MBR: 800000, AMBR: ambr})
+
+		if got := send(t, cli, 0x22222222, 2); got != 2 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+		if got := send(t, cli, 0x22222223, 2); got != 1 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+		// the GBR bearer is not limited by AMBR within GBR, but is beyond it.
+		if got := send(t, cli, 0x22222224, 5); got != 3 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+
+		want := gtpv1.PolicerStats{Packets: 1, Bytes: 1000, Dropped: 1, DroppedByAMBR: 1}
+		if diff := cmp.Diff(want, p2.Stats()); diff != "" {
+			t.Error(diff)
+		}
+		if got := p1.Stats().Dropped; got != 0 {
+			t.Errorf("wrong number of T-PDUs dropped: %d", got)
+		}
+		want = gtpv1.PolicerStats{Packets: 3, Bytes: 3000, Guaranteed: 3, Dropped: 2, DroppedByAMBR: 2}
+		if diff := cmp.Diff(want, gbr.Stats()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("shaping", func(t *testing.T) {
+		p := cli.SetQoS(0x33333333, &gtpv1.QoS{MBR: 80000, MaxDelay: time.Second})
+
+		// 2000 bytes exceeding the burst are delayed for 200ms at 10000 bytes/s.
+		start := time.Now()
+		if got := send(t, cli, 0x33333333, 5); got != 5 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Errorf("T-PDUs are not delayed enough: %s", elapsed)
+		}
+
+		want := gtpv1.PolicerStats{Packets: 5, Bytes: 5000, Delayed: 2}
+		if diff := cmp.Diff(want, p.Stats()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("max-delayed", func(t *testing.T) {
+		p := cli.SetQoS(0x33333334, &gtpv1.QoS{MBR: 80000, MaxDelay: time.Second, MaxDelayed: 1})
+		if got := send(t, cli, 0x33333334, 3); got != 3 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+
+		// only one of them is delayed, and the others are dropped.
+		sentCh := make(chan int, 3)
+		for i := 0; i < 3; i++ {
+			go func() {
+				_, err := cli.WriteToGTP(0x33333334, payload, srv.LocalAddr())
+				if err != nil {
+					sentCh <- 0
+					return
+				}
+				sentCh <- 1
+			}()
+		}
+		sent := 0
+		for i := 0; i < 3; i++ {
+			sent += <-sentCh
+		}
+		if sent != 1 {
+			t.Errorf("wrong number of T-PDUs sent: %d", sent)
+		}
+
+		want := gtpv1.PolicerStats{Packets: 4, Bytes: 4000, Delayed: 1, Dropped: 2}
+		if diff := cmp.Diff(want, p.Stats()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("incoming", func(t *testing.T) {
+		tun, err := srv.RegisterTunnelChannel(0x44444444, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := srv.SetIncomingQoS(0x44444444, &gtpv1.QoS{MBR: 8000})
+
+		if got := send(t, cli, 0x44444444, 5); got != 5 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+		receive(t, tun, 3)
+
+		want := gtpv1.PolicerStats{Packets: 3, Bytes: 3000, Dropped: 2}
+		if diff := cmp.Diff(want, p.Stats()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("relay", func(t *testing.T) {
+		tun, err := sink.RegisterTunnelChannel(0x66666666, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.RelayTo(srv, 0x55555555, 0x66666666, sink.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		p := srv.SetQoS(0x66666666, &gtpv1.QoS{QCI: 1, MBR: 8000})
+
+		if got := send(t, cli, 0x55555555, 5); got != 5 {
+			t.Errorf("wrong number of T-PDUs sent: %d", got)
+		}
+		receive(t, tun, 3)
+
+		want := gtpv1.PolicerStats{Packets: 3, Bytes: 3000, Dropped: 2}
+		if diff := cmp.Diff(want, p.Stats()); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
+
+func TestDSCPFromQCI(t *testing.T) {
+	cases := []struct {
+		qci  uint8
+		dscp uint8
+	}{
+		{1, 46}, {2, 36}, {3, 34}, {4, 26}, {5, 40},
+		{6, 18}, {7, 20}, {8, 10}, {9, 0}, {65, 46}, {128, 0},
+	}
+
+	for _, c := range cases {
+		if got := gtpv1.DSCPFromQCI(c.qci); got != c.dscp {
+			t.Errorf("wrong DSCP for QCI %d: got %d, want %d", c.qci, got, c.dscp)
+		}
+	}
+}
+
+func TestNewBearerQoS(t *testing.T) {
+	ambr := gtpv1.NewAMBR(8000, 0)
+	cases := []struct {
+		description string
+		qci         uint8
+		mbr, gbr    uint64
+		want        *gtpv1.QoS
+	}{
+		{"non-GBR", 9, 100, 0, &gtpv1.QoS{QCI: 9, MBR: 100000, AMBR: ambr}},
+		{"GBR", 1, 100, 10, &gtpv1.QoS{QCI: 1, MBR: 100000, GBR: 10000}},
+		{"GBR-QCI-without-GBR", 1, 100, 0, &gtpv1.QoS{QCI: 1, MBR: 100000}},
+		{"GBR-with-non-GBR-QCI", 9, 100, 10, &gtpv1.QoS{QCI: 9, MBR: 100000, GBR: 10000}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			got := gtpv1.NewBearerQoS(c.qci, c.mbr, c.gbr, ambr)
+			if got.AMBR != c.want.AMBR {
+				t.Errorf("wrong AMBR: got %p, want %p", got.AMBR, c.want.AMBR)
+			}
+			got.AMBR, c.want.AMBR = nil, nil
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+//go:build !linux
+
+package gtpv1
+
+import "syscall"
+
+// reusePortControl always returns ErrReusePortNotSupported.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return ErrReusePortNotSupported
+}
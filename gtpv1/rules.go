@@ -0,0 +1,901 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+	v2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/tft"
+)
+
+// Interface is the interface the packets come from or go to, which is Source
+// Interface of PDR or Destination Interface of FAR defined in TS 29.244.
+// The packets from Access are uplink, and the ones from Core are downlink.
+type Interface uint8
+
+// Interface definitions.
+const (
+	InterfaceAccess Interface = iota
+	InterfaceCore
+)
+
+// ApplyAction is the set of actions of FAR, which is Apply Action defined in
+// TS 29.244. Exactly one of ActionDrop, ActionForward and ActionBuffer should be
+// set, optionally with ActionNotify and ActionDuplicate, and SetRules returns
+// ErrInvalidRule otherwise, e.g., for ActionBuffer with ActionForward.
+//
+// ActionDuplicate sends the copies of the packets forwarded or dropped. The
+// packets buffered are duplicated when they are handled again after the FAR
+// stops buffering them.
+type ApplyAction uint8
+
+// ApplyAction definitions.
+const (
+	ActionDrop ApplyAction = 1 << iota
+	ActionForward
+	ActionBuffer
+	ActionNotify
+	ActionDuplicate
+)
+
+// PDR is a Packet Detection Rule, which detects the packets to apply the FAR,
+// QERs and URRs to. The PDR with the lowest Precedence among the ones matching
+// all the fields set is applied.
+type PDR struct {
+	ID         uint16
+	Precedence uint32
+
+	// SourceInterface is the interface the packets come from, which determines
+	// the direction to evaluate UEIP and SDFFilters.
+	SourceInterface Interface
+
+	// TEID is the local TEID of the T-PDUs to be detected. If zero, the PDR
+	// detects the IP packets read from the device of userspace GTP-U by UEIP.
+	TEID uint32
+
+	// UEIP is the address of UE, which is the source of the uplink packets and
+	// the destination of the downlink ones. It is required if TEID is zero.
+	UEIP net.IP
+
+	// SDFFilters are the packet filters, any of which should match the packet
+	// if given.
+	SDFFilters []*v2ie.TFTPacketFilter
+
+	// QFI is the QFI in PDU Session Container of the T-PDUs, if non-zero.
+	QFI uint8
+
+	// OuterHeaderRemoval removes the GTP-U header of the T-PDUs detected, and
+	// the FAR creates the new one to forward them. Otherwise the header is kept
+	// with only the TEID replaced, as well as RelayTo.
+	OuterHeaderRemoval bool
+
+	FARID  uint32
+	QERIDs []uint32
+	URRIDs []uint32
+}
+
+// OuterHeaderCreation is the GTP-U header added to the packets forwarded.
+type OuterHeaderCreation struct {
+	TEID uint32
+	Addr net.Addr
+}
+
+// FAR is a Forwarding Action Rule, which tells how to handle the packets detected.
+type FAR struct {
+	ID     uint32
+	Action ApplyAction
+
+	// DestinationInterface is the interface the packets go to, which determines
+	// the PDU Type of PDU Session Container added with the QFI of QER.
+	DestinationInterface Interface
+
+	// OuterHeaderCreation is where ActionForward sends the packets to. If nil,
+	// the packets decapsulated are written to the device of userspace GTP-U, or
+	// passed to the Tunnel or the HandlerFunc for T-PDU if it is not enabled.
+	OuterHeaderCreation *OuterHeaderCreation
+
+	// Duplicates are where ActionDuplicate sends the copies of the packets to.
+	Duplicates []*OuterHeaderCreation
+}
+
+// QER is a QoS Enforcement Rule, which enforces QoS on the packets forwarded.
+type QER struct {
+	ID uint32
+
+	// GateClosed makes the packets dropped.
+	GateClosed bool
+
+	// QoS is enforced on the packets as well as SetQoS, if given.
+	QoS *QoS
+
+	// QFI is set in PDU Session Container of the T-PDUs sent, if non-zero.
+	QFI uint8
+}
+
+// URR is a Usage Reporting Rule, which measures the packets forwarded or
+// duplicated that pass the QERs, once for each packet. The packets buffered are
+// not measured until they are handled again after the FAR stops buffering them,
+// not to count them twice, and the ones dropped by the FAR are not measured.
+type URR struct {
+	ID uint32
+
+	// VolumeThreshold is the total bytes in both directions that triggers
+	// ReportUsage, after which the usage is reset. Zero means no report.
+	VolumeThreshold uint64
+}
+
+// Usage is the usage measured by URR. The bytes are the length of the packets
+// without GTP-U header.
+type Usage struct {
+	UplinkPackets, UplinkBytes     uint64
+	DownlinkPackets, DownlinkBytes uint64
+}
+
+// Rules is the set of the rules of a session, e.g., a PFCP session on UPF.
+type Rules struct {
+	PDRs []*PDR
+	FARs []*FAR
+	QERs []*QER
+	URRs []*URR
+}
+
+// ReportType is the type of Report.
+type ReportType uint8
+
+// ReportType definitions.
+const (
+	// ReportDownlinkData is reported on the first packet handled by the FAR
+	// with ActionNotify, e.g., to page the UE on the downlink data buffered.
+	ReportDownlinkData ReportType = iota + 1
+
+	// ReportUsage is reported when the usage of URR reaches VolumeThreshold.
+	ReportUsage
+)
+
+// Report is the event on the rules reported to the control plane.
+type Report struct {
+	SEID uint64
+	Type ReportType
+
+	// PDRID is the PDR that detected the packet for ReportDownlinkData.
+	PDRID uint16
+
+	// URRID and Usage are the URR and its usage for ReportUsage.
+	URRID uint32
+	Usage Usage
+}
+
+// ReportHandlerFunc is a handler for the Reports. It is called in the goroutine
+// handling the packet, and should not block.
+type ReportHandlerFunc func(r *Report)
+
+// ruleTable is the rules set by SetRules, indexed to detect the packets.
+// The slices of PDRs are sorted by Precedence, and replaced on update.
+type ruleTable struct {
+	mu       sync.RWMutex
+	sessions map[uint64]*ruleSession
+	byTEID   map[uint32][]*pdrEntry
+	byUEIP   map[string][]*pdrEntry
+	report   ReportHandlerFunc
+}
+
+// ruleSession is the rules of a session compiled to be applied.
+type ruleSession struct {
+	seid   uint64
+	pdrs   []*pdrEntry
+	fars   map[uint32]*farEntry
+	urrs   map[uint32]*urrEntry
+	kernel []kernelTunnel
+}
+
+type pdrEntry struct {
+	seid uint64
+	pdr  PDR
+	ueIP net.IP
+	sdf  []*tft.Filter
+	far  *farEntry
+	qers []*qerEntry
+	urrs []*urrEntry
+}
+
+type farEntry struct {
+	far      FAR
+	notified atomic.Bool
+	buffer   *ruleBuffer
+}
+
+type qerEntry struct {
+	qer     QER
+	policer *Policer
+}
+
+type urrEntry struct {
+	mu    sync.Mutex
+	urr   URR
+	usage Usage
+}
+
+// ruleBuffer is the packets buffered by FAR with ActionBuffer.
+type ruleBuffer struct {
+	mu      sync.Mutex
+	closed  bool
+	packets []bufferedPacket
+}
+
+// bufferedPacket is the T-PDU received from raddr, or the IP packet read from
+// the device if raddr is nil.
+type bufferedPacket struct {
+	raddr net.Addr
+	raw   []byte
+}
+
+// SetRules sets the rules of the session identified by seid, replacing the
+// existing ones if any. This works like PFCP Session Establishment and
+// Modification on UPF, and the rules should not be modified after set.
+//
+// The T-PDUs with the TEIDs of the PDRs are detected before they are passed to
+// the userspace GTP-U data path, the Tunnel or the relayed peer, and the IP
+// packets read from the device of userspace GTP-U are detected by UEIP of the
+// PDRs without TEID. The packets that no PDR detects are handled as usual.
+//
+// The usage of the URRs and the packets buffered by the FARs are carried over
+// to the new rules with the same IDs. The buffered packets are handled again
+// if the FAR no longer buffers them, e.g., to forward them after paging.
+//
+// With Kernel GTP-U, the rules are installed as the tunnels added by
+// AddTunnelOverride. Only the pairs of an uplink PDR that removes the outer
+// header and forwards the packets without creating it, and a downlink PDR with
+// UEIP whose FAR creates it, are supported, without SDF filters, QFI, QERs and
+// URRs. ErrRuleNotSupported is returned for the others.
+func (u *UPlaneConn) SetRules(seid uint64, r *Rules) error {
+	sess, err := compileRules(seid, r)
+	if err != nil {
+		return err
+	}
+	if u.KernelGTP.enabled {
+		if sess.kernel, err = kernelTunnels(r); err != nil {
+			return err
+		}
+	}
+
+	t := &u.rules
+	t.mu.Lock()
+	if t.sessions == nil {
+		t.sessions = map[uint64]*ruleSession{}
+		t.byTEID = map[uint32][]*pdrEntry{}
+		t.byUEIP = map[string][]*pdrEntry{}
+	}
+	old := t.sessions[seid]
+
+	if u.KernelGTP.enabled {
+		defer t.mu.Unlock()
+		var installed []kernelTunnel
+		if old != nil {
+			installed = old.kernel
+		}
+		if err := u.installKernelTunnels(installed, sess.kernel); err != nil {
+			return err
+		}
+		t.sessions[seid] = sess
+		return nil
+	}
+
+	var replayed []bufferedPacket
+	if old != nil {
+		replayed = sess.carryOver(old)
+		t.unindex(old)
+	}
+	t.sessions[seid] = sess
+	t.index(sess)
+	t.mu.Unlock()
+
+	u.replay(replayed)
+	return nil
+}
+
+// RemoveRules removes the rules of the session identified by seid, which works
+// like PFCP Session Deletion. The packets buffered are discarded.
+func (u *UPlaneConn) RemoveRules(seid uint64) error {
+	t := &u.rules
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sess, ok := t.sessions[seid]
+	if !ok {
+		return fmt.Errorf("failed to remove rules of %#x: %w", seid, ErrRulesNotFound)
+	}
+	if len(sess.kernel) != 0 {
+		if err := u.installKernelTunnels(sess.kernel, nil); err != nil {
+			return err
+		}
+	}
+
+	t.unindex(sess)
+	delete(t.sessions, seid)
+	for _, far := range sess.fars {
+		if far.buffer != nil {
+			_ = far.buffer.close()
+		}
+	}
+	return nil
+}
+
+// Usage returns the usage measured by the URR in the rules of the session.
+func (u *UPlaneConn) Usage(seid uint64, urrID uint32) (Usage, error) {
+	var urr *urrEntry
+	u.rules.mu.RLock()
+	if sess, ok := u.rules.sessions[seid]; ok {
+		urr = sess.urrs[urrID]
+	}
+	u.rules.mu.RUnlock()
+	if urr == nil {
+		return Usage{}, fmt.Errorf("failed to get usage of URR %d in %#x: %w", urrID, seid, ErrRulesNotFound)
+	}
+
+	urr.mu.Lock()
+	defer urr.mu.Unlock()
+	return urr.usage, nil
+}
+
+// SetReportHandler sets the handler for the Reports on the rules set by
+// SetRules. The Reports are discarded if no handler is set.
+func (u *UPlaneConn) SetReportHandler(fn ReportHandlerFunc) {
+	u.rules.mu.Lock()
+	defer u.rules.mu.Unlock()
+	u.rules.report = fn
+}
+
+func compileRules(seid uint64, r *Rules) (*ruleSession, error) {
+	sess := &ruleSession{
+		seid: seid,
+		fars: map[uint32]*farEntry{},
+		urrs: map[uint32]*urrEntry{},
+	}
+
+	for _, far := range r.FARs {
+		if _, ok := sess.fars[far.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate FAR %d", ErrInvalidRule, far.ID)
+		}
+		if err := validateFAR(far); err != nil {
+			return nil, err
+		}
+		e := &farEntry{far: *far}
+		if far.Action&ActionBuffer != 0 {
+			e.buffer = &ruleBuffer{}
+		}
+		sess.fars[far.ID] = e
+	}
+
+	qers := map[uint32]*qerEntry{}
+	for _, qer := range r.QERs {
+		if _, ok := qers[qer.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate QER %d", ErrInvalidRule, qer.ID)
+		}
+		e := &qerEntry{qer: *qer}
+		if qer.QoS != nil {
+			e.policer = newPolicer(qer.QoS)
+		}
+		qers[qer.ID] = e
+	}
+
+	for _, urr := range r.URRs {
+		if _, ok := sess.urrs[urr.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate URR %d", ErrInvalidRule, urr.ID)
+		}
+		sess.urrs[urr.ID] = &urrEntry{urr: *urr}
+	}
+
+	ids := map[uint16]bool{}
+	for _, pdr := range r.PDRs {
+		if ids[pdr.ID] {
+			return nil, fmt.Errorf("%w: duplicate PDR %d", ErrInvalidRule, pdr.ID)
+		}
+		ids[pdr.ID] = true
+
+		e, err := sess.compilePDR(pdr, qers)
+		if err != nil {
+			return nil, err
+		}
+		sess.pdrs = append(sess.pdrs, e)
+	}
+	return sess, nil
+}
+
+func validateFAR(far *FAR) error {
+	n := 0
+	for _, a := range []ApplyAction{ActionDrop, ActionForward, ActionBuffer} {
+		if far.Action&a != 0 {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("%w: FAR %d should have one of drop, forward and buffer actions", ErrInvalidRule, far.ID)
+	}
+	if far.Action&ActionDuplicate != 0 && len(far.Duplicates) == 0 {
+		return fmt.Errorf("%w: FAR %d has no destination to duplicate to", ErrInvalidRule, far.ID)
+	}
+
+	ohcs := append([]*OuterHeaderCreation{far.OuterHeaderCreation}, far.Duplicates...)
+	for i, o := range ohcs {
+		if (i > 0 && o == nil) || (o != nil && o.Addr == nil) {
+			return fmt.Errorf("%w: FAR %d has outer header creation without address", ErrInvalidRule, far.ID)
+		}
+	}
+	return nil
+}
+
+func (s *ruleSession) compilePDR(pdr *PDR, qers map[uint32]*qerEntry) (*pdrEntry, error) {
+	e := &pdrEntry{seid: s.seid, pdr: *pdr}
+	if pdr.UEIP != nil {
+		if e.ueIP = normalizeIP(pdr.UEIP); e.ueIP == nil {
+			return nil, fmt.Errorf("%w: PDR %d has invalid UE IP address: %s", ErrInvalidRule, pdr.ID, pdr.UEIP)
+		}
+	}
+	if pdr.TEID == 0 && e.ueIP == nil {
+		return nil, fmt.Errorf("%w: PDR %d should have TEID or UE IP address", ErrInvalidRule, pdr.ID)
+	}
+
+	for _, pf := range pdr.SDFFilters {
+		f, err := tft.NewFilter(pf)
+		if err != nil {
+			return nil, fmt.Errorf("%w: PDR %d has invalid SDF filter: %w", ErrInvalidRule, pdr.ID, err)
+		}
+		e.sdf = append(e.sdf, f)
+	}
+
+	var ok bool
+	if e.far, ok = s.fars[pdr.FARID]; !ok {
+		return nil, fmt.Errorf("%w: FAR %d for PDR %d not found", ErrInvalidRule, pdr.FARID, pdr.ID)
+	}
+	for _, id := range pdr.QERIDs {
+		qer, ok := qers[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: QER %d for PDR %d not found", ErrInvalidRule, id, pdr.ID)
+		}
+		e.qers = append(e.qers, qer)
+	}
+	for _, id := range pdr.URRIDs {
+		urr, ok := s.urrs[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: URR %d for PDR %d not found", ErrInvalidRule, id, pdr.ID)
+		}
+		e.urrs = append(e.urrs, urr)
+	}
+	return e, nil
+}
+
+// carryOver takes over the usage and the buffered packets from the old rules,
+// and returns the packets buffered that are no longer buffered by the FAR.
+func (s *ruleSession) carryOver(old *ruleSession) []bufferedPacket {
+	urrs := map[*urrEntry]*urrEntry{}
+	for id, e := range s.urrs {
+		o, ok := old.urrs[id]
+		if !ok {
+			continue
+		}
+		o.mu.Lock()
+		o.urr = e.urr
+		o.mu.Unlock()
+		s.urrs[id] = o
+		urrs[e] = o
+	}
+	for _, pdr := range s.pdrs {
+		for i, e := range pdr.urrs {
+			if o, ok := urrs[e]; ok {
+				pdr.urrs[i] = o
+			}
+		}
+	}
+
+	var replayed []bufferedPacket
+	for id, o := range old.fars {
+		if o.buffer == nil {
+			continue
+		}
+		if e, ok := s.fars[id]; ok && e.buffer != nil {
+			e.buffer = o.buffer
+			e.notified.Store(o.notified.Load())
+			continue
+		}
+		replayed = append(replayed, o.buffer.close()...)
+	}
+	return replayed
+}
+
+func (t *ruleTable) index(sess *ruleSession) {
+	for _, e := range sess.pdrs {
+		if e.pdr.TEID != 0 {
+			t.byTEID[e.pdr.TEID] = insertPDR(t.byTEID[e.pdr.TEID], e)
+			continue
+		}
+		key := string(e.ueIP)
+		t.byUEIP[key] = insertPDR(t.byUEIP[key], e)
+	}
+}
+
+func (t *ruleTable) unindex(sess *ruleSession) {
+	for _, e := range sess.pdrs {
+		if e.pdr.TEID != 0 {
+			if entries := removePDRs(t.byTEID[e.pdr.TEID], sess.seid); len(entries) != 0 {
+				t.byTEID[e.pdr.TEID] = entries
+			} else {
+				delete(t.byTEID, e.pdr.TEID)
+			}
+			continue
+		}
+
+		key := string(e.ueIP)
+		if entries := removePDRs(t.byUEIP[key], sess.seid); len(entries) != 0 {
+			t.byUEIP[key] = entries
+		} else {
+			delete(t.byUEIP, key)
+		}
+	}
+}
+
+// insertPDR returns a copy of entries with e inserted in the order of Precedence.
+func insertPDR(entries []*pdrEntry, e *pdrEntry) []*pdrEntry {
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].pdr.Precedence > e.pdr.Precedence
+	})
+
+	s := make([]*pdrEntry, 0, len(entries)+1)
+	s = append(s, entries[:i]...)
+	s = append(s, e)
+	return append(s, entries[i:]...)
+}
+
+// removePDRs returns a copy of entries without the ones of the session.
+func removePDRs(entries []*pdrEntry, seid uint64) []*pdrEntry {
+	var s []*pdrEntry
+	for _, e := range entries {
+		if e.seid != seid {
+			s = append(s, e)
+		}
+	}
+	return s
+}
+
+// hasTEID reports whether any PDR detects the T-PDUs with the TEID.
+func (t *ruleTable) hasTEID(teid uint32) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.byTEID[teid]
+	return ok
+}
+
+func (t *ruleTable) notify(r *Report) {
+	t.mu.RLock()
+	fn := t.report
+	t.mu.RUnlock()
+	if fn != nil {
+		fn(r)
+	}
+}
+
+// direction returns the direction of the packets detected by the PDR.
+func (e *pdrEntry) direction() tft.Direction {
+	if e.pdr.SourceInterface == InterfaceAccess {
+		return tft.Uplink
+	}
+	return tft.Downlink
+}
+
+// match reports whether the IP packet with the QFI given matches the PDR,
+// assuming that TEID is already matched.
+func (e *pdrEntry) match(pkt []byte, qfi uint8) bool {
+	if e.pdr.QFI != 0 && e.pdr.QFI != qfi {
+		return false
+	}
+
+	dir := e.direction()
+	if e.ueIP != nil {
+		src, dst := ipAddrsOf(pkt)
+		ue := dst
+		if dir == tft.Uplink {
+			ue = src
+		}
+		if !e.ueIP.Equal(ue) {
+			return false
+		}
+	}
+
+	if len(e.sdf) == 0 {
+		return true
+	}
+	for _, f := range e.sdf {
+		if f.Match(pkt, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// measure adds the packet of n bytes to the usage, and returns the Report if
+// it reaches the threshold.
+func (e *urrEntry) measure(dir tft.Direction, n int) *Report {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if dir == tft.Uplink {
+		e.usage.UplinkPackets++
+		e.usage.UplinkBytes += uint64(n)
+	} else {
+		e.usage.DownlinkPackets++
+		e.usage.DownlinkBytes += uint64(n)
+	}
+
+	th := e.urr.VolumeThreshold
+	if th == 0 || e.usage.UplinkBytes+e.usage.DownlinkBytes < th {
+		return nil
+	}
+	r := &Report{Type: ReportUsage, URRID: e.urr.ID, Usage: e.usage}
+	e.usage = Usage{}
+	return r
+}
+
+// push buffers the packet, and reports whether it is taken, which is false if
+// the buffer is already closed. The packets exceeding the limit are dropped.
+func (b *ruleBuffer) push(p bufferedPacket) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return false
+	}
+	if len(b.packets) < maxHeldTPDUs {
+		b.packets = append(b.packets, p)
+	}
+	return true
+}
+
+// close stops buffering and returns the packets buffered.
+func (b *ruleBuffer) close() []bufferedPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	packets := b.packets
+	b.packets = nil
+	return packets
+}
+
+// handleRuleTPDU applies the rules to the T-PDU in raw if any PDR detects it,
+// and reports whether it is consumed.
+func (u *UPlaneConn) handleRuleTPDU(raddr net.Addr, raw []byte) bool {
+	u.rules.mu.RLock()
+	entries := u.rules.byTEID[binary.BigEndian.Uint32(raw[4:8])]
+	u.rules.mu.RUnlock()
+	if len(entries) == 0 {
+		return false
+	}
+
+	pdu, err := message.ParseTPDU(raw)
+	if err != nil {
+		return false
+	}
+	qfi, _ := pdu.QFI()
+	payload := pdu.Decapsulate()
+
+	for _, e := range entries {
+		if e.match(payload, qfi) {
+			return u.applyRules(e, raddr, raw, payload)
+		}
+	}
+	return false
+}
+
+// handleRulePacket applies the rules to the IP packet read from the device if
+// any PDR detects it, and reports whether it is consumed.
+func (u *UPlaneConn) handleRulePacket(pkt []byte) bool {
+	src, dst := ipAddrsOf(pkt)
+	if src == nil {
+		return false
+	}
+
+	u.rules.mu.RLock()
+	candidates := [][]*pdrEntry{u.rules.byUEIP[string(dst)], u.rules.byUEIP[string(src)]}
+	u.rules.mu.RUnlock()
+
+	var matched *pdrEntry
+	for _, entries := range candidates {
+		for _, e := range entries {
+			if matched != nil && e.pdr.Precedence >= matched.pdr.Precedence {
+				break
+			}
+			if e.match(pkt, 0) {
+				matched = e
+				break
+			}
+		}
+	}
+	if matched == nil {
+		return false
+	}
+	return u.applyRules(matched, nil, nil, pkt)
+}
+
+// applyRules applies the FAR, QERs and URRs of the PDR to the IP packet in pkt,
+// which is the payload of the T-PDU in raw, or the one read from the device if
+// raw is nil. It reports whether the packet is consumed.
+func (u *UPlaneConn) applyRules(e *pdrEntry, raddr net.Addr, raw, pkt []byte) bool {
+	far := &e.far.far
+	if far.Action&ActionBuffer != 0 {
+		p := bufferedPacket{raddr: raddr, raw: raw}
+		if raw == nil {
+			p.raw = append([]byte(nil), pkt...)
+		}
+		if !e.far.buffer.push(p) {
+			// the FAR has been replaced while buffering.
+			u.replay([]bufferedPacket{p})
+			return true
+		}
+	}
+	if far.Action&ActionNotify != 0 && e.far.notified.CompareAndSwap(false, true) {
+		u.rules.notify(&Report{SEID: e.seid, Type: ReportDownlinkData, PDRID: e.pdr.ID})
+	}
+
+	// ActionBuffer is never set with ActionForward (see validateFAR), and the
+	// packets buffered are duplicated and measured when they are replayed.
+	if far.Action&ActionBuffer != 0 || far.Action&(ActionForward|ActionDuplicate) == 0 {
+		return true
+	}
+
+	var dscpecn int
+	var qfi uint8
+	for _, qer := range e.qers {
+		if qer.qer.GateClosed || !qer.policer.police(len(pkt)) {
+			return true
+		}
+		if qer.policer != nil {
+			dscpecn = qer.policer.dscpecn()
+		}
+		if qer.qer.QFI != 0 {
+			qfi = qer.qer.QFI
+		}
+	}
+
+	for _, urr := range e.urrs {
+		if r := urr.measure(e.direction(), len(pkt)); r != nil {
+			r.SEID = e.seid
+			u.rules.notify(r)
+		}
+	}
+
+	pduType := message.PDUTypeULPDUSessionInformation
+	if far.DestinationInterface == InterfaceAccess {
+		pduType = message.PDUTypeDLPDUSessionInformation
+	}
+	if far.Action&ActionDuplicate != 0 {
+		for _, o := range far.Duplicates {
+			u.sendRuleTPDU(o, pkt, pduType, qfi, dscpecn)
+		}
+	}
+	if far.Action&ActionForward == 0 {
+		return true
+	}
+
+	o := far.OuterHeaderCreation
+	switch {
+	case o != nil && raw != nil && !e.pdr.OuterHeaderRemoval:
+		binary.BigEndian.PutUint32(raw[4:8], o.TEID)
+		if _, err := u.WriteToWithDSCPECN(raw, o.Addr, dscpecn); err != nil {
+			logf("error sending on UPlaneConn %s: %v", u.LocalAddr(), err)
+		}
+	case o != nil:
+		u.sendRuleTPDU(o, pkt, pduType, qfi, dscpecn)
+	case raw == nil:
+		// the packets read from the device cannot be written back.
+	case u.userspaceEnabled():
+		u.userspace.mu.RLock()
+		dev := u.userspace.dev
+		u.userspace.mu.RUnlock()
+		if _, err := dev.Write(pkt); err != nil {
+			logf("error writing to the device: %v", err)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// sendRuleTPDU encapsulates the IP packet in pkt with the outer header and
+// sends it, with the QFI in PDU Session Container if non-zero.
+func (u *UPlaneConn) sendRuleTPDU(o *OuterHeaderCreation, pkt []byte, pduType, qfi uint8, dscpecn int) {
+	pdu := Encapsulate(o.TEID, pkt)
+	if qfi != 0 {
+		pdu.SetQFI(pduType, qfi)
+	}
+	if err := u.checkOversize(pdu, o.Addr); err != nil {
+		return
+	}
+	if _, err := u.writeTPDU(pdu, o.Addr, dscpecn); err != nil {
+		logf("error sending on UPlaneConn %s: %v", u.LocalAddr(), err)
+	}
+}
+
+// replay handles the packets buffered again as if they are received now.
+func (u *UPlaneConn) replay(packets []bufferedPacket) {
+	for _, p := range packets {
+		if p.raddr == nil {
+			u.handleDevicePacket(p.raw)
+			continue
+		}
+		u.handlePacket(p.raddr, p.raw)
+	}
+}
+
+// kernelTunnel is a tunnel of Kernel GTP-U translated from the rules.
+type kernelTunnel struct {
+	peerIP, msIP net.IP
+	otei, itei   uint32
+}
+
+// kernelTunnels translates the rules into the tunnels of Kernel GTP-U, which
+// consist of the pairs of an uplink and a downlink PDRs described in SetRules.
+func kernelTunnels(r *Rules) ([]kernelTunnel, error) {
+	fars := map[uint32]*FAR{}
+	for _, far := range r.FARs {
+		fars[far.ID] = far
+	}
+
+	var uplinks, downlinks []*PDR
+	for _, pdr := range r.PDRs {
+		if len(pdr.SDFFilters) != 0 || pdr.QFI != 0 || len(pdr.QERIDs) != 0 || len(pdr.URRIDs) != 0 {
+			return nil, fmt.Errorf("%w by Kernel GTP-U: PDR %d has SDF filters, QFI, QERs or URRs", ErrRuleNotSupported, pdr.ID)
+		}
+
+		far := fars[pdr.FARID]
+		switch {
+		case far.Action == ActionDrop:
+			continue
+		case far.Action != ActionForward:
+			return nil, fmt.Errorf("%w by Kernel GTP-U: FAR %d has actions other than forward or drop", ErrRuleNotSupported, far.ID)
+		case pdr.TEID != 0 && pdr.OuterHeaderRemoval && far.OuterHeaderCreation == nil:
+			uplinks = append(uplinks, pdr)
+		case pdr.TEID == 0 && far.OuterHeaderCreation != nil:
+			downlinks = append(downlinks, pdr)
+		default:
+			return nil, fmt.Errorf("%w by Kernel GTP-U: PDR %d neither decapsulates nor encapsulates packets", ErrRuleNotSupported, pdr.ID)
+		}
+	}
+
+	var tunnels []kernelTunnel
+	paired := map[uint16]bool{}
+	for _, dl := range downlinks {
+		var ul *PDR
+		for _, pdr := range uplinks {
+			if pdr.UEIP.Equal(dl.UEIP) || (pdr.UEIP == nil && len(uplinks) == 1) {
+				ul = pdr
+				break
+			}
+		}
+		if ul == nil || paired[ul.ID] {
+			return nil, fmt.Errorf("%w by Kernel GTP-U: no uplink PDR is paired with PDR %d", ErrRuleNotSupported, dl.ID)
+		}
+		paired[ul.ID] = true
+
+		o := fars[dl.FARID].OuterHeaderCreation
+		peer, ok := o.Addr.(*net.UDPAddr)
+		if !ok {
+			return nil, fmt.Errorf("%w by Kernel GTP-U: FAR %d has non-UDP address", ErrRuleNotSupported, dl.FARID)
+		}
+		tunnels = append(tunnels, kernelTunnel{peerIP: peer.IP, msIP: dl.UEIP, otei: o.TEID, itei: ul.TEID})
+	}
+
+	for _, ul := range uplinks {
+		if !paired[ul.ID] {
+			return nil, fmt.Errorf("%w by Kernel GTP-U: no downlink PDR is paired with PDR %d", ErrRuleNotSupported, ul.ID)
+		}
+	}
+	return tunnels, nil
+}
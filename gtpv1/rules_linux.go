@@ -0,0 +1,27 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+// installKernelTunnels adds the tunnels to Kernel GTP-U, and deletes the ones
+// installed previously that are no longer used.
+func (u *UPlaneConn) installKernelTunnels(installed, tunnels []kernelTunnel) error {
+	iteis := map[uint32]bool{}
+	for _, t := range tunnels {
+		if err := u.AddTunnelOverride(t.peerIP, t.msIP, t.otei, t.itei); err != nil {
+			return err
+		}
+		iteis[t.itei] = true
+	}
+
+	for _, t := range installed {
+		if iteis[t.itei] {
+			continue
+		}
+		if err := u.DelTunnelByITEI(t.itei); err != nil {
+			logf("failed to delete tunnel with %#08x: %v", t.itei, err)
+		}
+	}
+	return nil
+}
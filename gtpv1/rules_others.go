@@ -0,0 +1,12 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+//go:build !linux
+
+package gtpv1
+
+// installKernelTunnels does nothing, as Kernel GTP-U is available only on Linux.
+func (u *UPlaneConn) installKernelTunnels(installed, tunnels []kernelTunnel) error {
+	return nil
+}
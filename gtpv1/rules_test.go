@@ -0,0 +1,358 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+	v2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/tft"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+// udpPacket returns an IPv4 packet with UDP header from src to dst.
+func udpPacket(src, dst string, sport, dport uint16) []byte {
+	b := make([]byte, 32)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64
+	b[9] = 17
+	copy(b[12:16], net.ParseIP(src).To4())
+	copy(b[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(b[20:22], sport)
+	binary.BigEndian.PutUint16(b[22:24], dport)
+	binary.BigEndian.PutUint16(b[24:26], 12)
+	copy(b[28:], []byte{0xde, 0xad, 0xbe, 0xef})
+	return b
+}
+
+func TestRules(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	upf, err := n.NewUPlaneConn("10.0.0.1:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enb, err := n.NewUPlaneConn("10.0.0.2:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dn, err := n.NewUPlaneConn("10.0.0.3:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	li, err := n.NewUPlaneConn("10.0.0.4:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upf.DisableErrorIndication()
+
+	reportCh := make(chan *gtpv1.Report, 10)
+	upf.SetReportHandler(func(r *gtpv1.Report) {
+		reportCh <- r
+	})
+
+	for _, u := range []*gtpv1.UPlaneConn{upf, enb, dn, li} {
+		go func(u *gtpv1.UPlaneConn) {
+			_ = u.ListenAndServe(ctx)
+		}(u)
+	}
+
+	register := func(t *testing.T, u *gtpv1.UPlaneConn, teid uint32) *gtpv1.Tunnel {
+		t.Helper()
+		tun, err := u.RegisterTunnelChannel(teid, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tun
+	}
+	send := func(t *testing.T, u *gtpv1.UPlaneConn, teid uint32, pkt []byte) {
+		t.Helper()
+		if _, err := u.WriteToGTP(teid, pkt, upf.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func(t *testing.T, tun *gtpv1.Tunnel, pkt []byte) *message.TPDU {
+		t.Helper()
+		select {
+		case pdu := <-tun.Receive():
+			if diff := cmp.Diff(pkt, pdu.Decapsulate()); diff != "" {
+				t.Error(diff)
+			}
+			return pdu
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out while waiting for T-PDU on %#x", tun.ITEI)
+		}
+		return nil
+	}
+	noReceive := func(t *testing.T, tun *gtpv1.Tunnel) {
+		t.Helper()
+		select {
+		case <-tun.Receive():
+			t.Errorf("got unexpected T-PDU on %#x", tun.ITEI)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	report := func(t *testing.T) *gtpv1.Report {
+		t.Helper()
+		select {
+		case r := <-reportCh:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for Report")
+		}
+		return nil
+	}
+
+	ueIP := "10.10.0.1"
+	web := udpPacket(ueIP, "192.0.2.1", 40000, 80)
+	dns := udpPacket(ueIP, "192.0.2.53", 40000, 53)
+
+	t.Run("uplink", func(t *testing.T) {
+		def, sdf, dup := register(t, dn, 0x22222222), register(t, dn, 0x33333333), register(t, li, 0x44444444)
+		if err := upf.SetRules(1, &gtpv1.Rules{
+			PDRs: []*gtpv1.PDR{{
+				ID: 1, Precedence: 100, SourceInterface: gtpv1.InterfaceAccess,
+				TEID: 0x11111111, UEIP: net.ParseIP(ueIP), OuterHeaderRemoval: true,
+				FARID: 1, URRIDs: []uint32{1},
+			}, {
+				ID: 2, Precedence: 10, SourceInterface: gtpv1.InterfaceAccess,
+				TEID: 0x11111111, OuterHeaderRemoval: true,
+				SDFFilters: []*v2ie.TFTPacketFilter{
+					v2ie.NewTFTPacketFilter(v2ie.TFTPFBidirectional, 1, 10, v2ie.NewTFTPFComponentSingleRemotePort(53)),
+				},
+				FARID: 2, QERIDs: []uint32{1},
+			}},
+			FARs: []*gtpv1.FAR{{
+				ID: 1, Action: gtpv1.ActionForward, DestinationInterface: gtpv1.InterfaceCore,
+				OuterHeaderCreation: &gtpv1.OuterHeaderCreation{TEID: 0x22222222, Addr: dn.LocalAddr()},
+			}, {
+				ID: 2, Action: gtpv1.ActionForward | gtpv1.ActionDuplicate, DestinationInterface: gtpv1.InterfaceCore,
+				OuterHeaderCreation: &gtpv1.OuterHeaderCreation{TEID: 0x33333333, Addr: dn.LocalAddr()},
+				Duplicates:          []*gtpv1.OuterHeaderCreation{{TEID: 0x44444444, Addr: li.LocalAddr()}},
+			}},
+			QERs: []*gtpv1.QER{{ID: 1, QFI: 9}},
+			URRs: []*gtpv1.URR{{ID: 1, VolumeThreshold: uint64(2 * len(web))}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		send(t, enb, 0x11111111, web)
+		receive(t, def, web)
+		usage, err := upf.Usage(1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(gtpv1.Usage{UplinkPackets: 1, UplinkBytes: uint64(len(web))}, usage); diff != "" {
+			t.Error(diff)
+		}
+
+		// the usage is reported and reset on reaching the threshold.
+		send(t, enb, 0x11111111, web)
+		receive(t, def, web)
+		want := &gtpv1.Report{
+			SEID: 1, Type: gtpv1.ReportUsage, URRID: 1,
+			Usage: gtpv1.Usage{UplinkPackets: 2, UplinkBytes: uint64(2 * len(web))},
+		}
+		if diff := cmp.Diff(want, report(t)); diff != "" {
+			t.Error(diff)
+		}
+		if usage, _ := upf.Usage(1, 1); usage != (gtpv1.Usage{}) {
+			t.Errorf("usage is not reset: %+v", usage)
+		}
+
+		send(t, enb, 0x11111111, dns)
+		if qfi, err := receive(t, sdf, dns).QFI(); err != nil || qfi != 9 {
+			t.Errorf("wrong QFI: %d, %v", qfi, err)
+		}
+		receive(t, dup, dns)
+
+		// the packets from the address not assigned to the UE are not detected.
+		send(t, enb, 0x11111111, udpPacket("10.10.0.2", "192.0.2.1", 40000, 80))
+		noReceive(t, def)
+	})
+
+	t.Run("buffer", func(t *testing.T) {
+		tun := register(t, enb, 0x66666666)
+		rules := &gtpv1.Rules{
+			PDRs: []*gtpv1.PDR{{
+				ID: 1, SourceInterface: gtpv1.InterfaceCore, TEID: 0x55555555, OuterHeaderRemoval: true,
+				FARID: 1, URRIDs: []uint32{1},
+			}},
+			FARs: []*gtpv1.FAR{{ID: 1, Action: gtpv1.ActionBuffer | gtpv1.ActionNotify}},
+			URRs: []*gtpv1.URR{{ID: 1}},
+		}
+		if err := upf.SetRules(2, rules); err != nil {
+			t.Fatal(err)
+		}
+
+		pkts := [][]byte{
+			udpPacket("192.0.2.1", ueIP, 80, 40000),
+			udpPacket("192.0.2.1", ueIP, 80, 40001),
+			udpPacket("192.0.2.1", ueIP, 80, 40002),
+		}
+		for _, pkt := range pkts {
+			send(t, dn, 0x55555555, pkt)
+		}
+		want := &gtpv1.Report{SEID: 2, Type: gtpv1.ReportDownlinkData, PDRID: 1}
+		if diff := cmp.Diff(want, report(t)); diff != "" {
+			t.Error(diff)
+		}
+		noReceive(t, tun)
+
+		// the packets buffered are not measured until they are forwarded.
+		if usage, _ := upf.Usage(2, 1); usage != (gtpv1.Usage{}) {
+			t.Errorf("buffered packets are measured: %+v", usage)
+		}
+
+		// the packets buffered are forwarded after the FAR is updated.
+		rules.FARs = []*gtpv1.FAR{{
+			ID: 1, Action: gtpv1.ActionForward, DestinationInterface: gtpv1.InterfaceAccess,
+			OuterHeaderCreation: &gtpv1.OuterHeaderCreation{TEID: 0x66666666, Addr: enb.LocalAddr()},
+		}}
+		if err := upf.SetRules(2, rules); err != nil {
+			t.Fatal(err)
+		}
+
+		// the order is not guaranteed as the packets are handled concurrently.
+		got := map[string]bool{}
+		for range pkts {
+			select {
+			case pdu := <-tun.Receive():
+				got[string(pdu.Decapsulate())] = true
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out while waiting for T-PDU")
+			}
+		}
+		for _, pkt := range pkts {
+			if !got[string(pkt)] {
+				t.Errorf("packet not forwarded: %x", pkt)
+			}
+		}
+		if usage, _ := upf.Usage(2, 1); usage.DownlinkPackets != uint64(len(pkts)) {
+			t.Errorf("wrong usage: %+v", usage)
+		}
+		select {
+		case r := <-reportCh:
+			t.Errorf("got unexpected Report: %+v", r)
+		default:
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		dup := register(t, li, 0x99999999)
+		if err := upf.SetRules(5, &gtpv1.Rules{
+			PDRs: []*gtpv1.PDR{{
+				ID: 1, SourceInterface: gtpv1.InterfaceAccess, TEID: 0x88888888, OuterHeaderRemoval: true,
+				FARID: 1, URRIDs: []uint32{1},
+			}},
+			FARs: []*gtpv1.FAR{{
+				ID: 1, Action: gtpv1.ActionDrop | gtpv1.ActionDuplicate,
+				Duplicates: []*gtpv1.OuterHeaderCreation{{TEID: 0x99999999, Addr: li.LocalAddr()}},
+			}},
+			URRs: []*gtpv1.URR{{ID: 1}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the packets only duplicated are measured as well.
+		send(t, enb, 0x88888888, web)
+		receive(t, dup, web)
+		want := gtpv1.Usage{UplinkPackets: 1, UplinkBytes: uint64(len(web))}
+		if usage, _ := upf.Usage(5, 1); usage != want {
+			t.Errorf("wrong usage: %+v", usage)
+		}
+	})
+
+	t.Run("local", func(t *testing.T) {
+		tun := register(t, upf, 0x77777777)
+		if err := upf.SetRules(3, &gtpv1.Rules{
+			PDRs: []*gtpv1.PDR{{ID: 1, TEID: 0x77777777, FARID: 1, URRIDs: []uint32{1}}},
+			FARs: []*gtpv1.FAR{{ID: 1, Action: gtpv1.ActionForward}},
+			URRs: []*gtpv1.URR{{ID: 1}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		send(t, enb, 0x77777777, web)
+		receive(t, tun, web)
+		if usage, _ := upf.Usage(3, 1); usage.UplinkPackets != 1 {
+			t.Errorf("wrong usage: %+v", usage)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		tun, _ := dn.Tunnel(0x22222222)
+		if err := upf.RemoveRules(1); err != nil {
+			t.Fatal(err)
+		}
+		send(t, enb, 0x11111111, web)
+		noReceive(t, tun)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cases := []struct {
+			description string
+			rules       *gtpv1.Rules
+		}{
+			{
+				"no-FAR",
+				&gtpv1.Rules{PDRs: []*gtpv1.PDR{{ID: 1, TEID: 1, FARID: 1}}},
+			}, {
+				"multiple-actions",
+				&gtpv1.Rules{FARs: []*gtpv1.FAR{{ID: 1, Action: gtpv1.ActionForward | gtpv1.ActionDrop}}},
+			}, {
+				"buffer-and-forward",
+				&gtpv1.Rules{FARs: []*gtpv1.FAR{{ID: 1, Action: gtpv1.ActionBuffer | gtpv1.ActionForward}}},
+			}, {
+				"no-TEID-or-UE-IP",
+				&gtpv1.Rules{
+					PDRs: []*gtpv1.PDR{{ID: 1, FARID: 1}},
+					FARs: []*gtpv1.FAR{{ID: 1, Action: gtpv1.ActionDrop}},
+				},
+			}, {
+				"no-duplicates",
+				&gtpv1.Rules{FARs: []*gtpv1.FAR{{ID: 1, Action: gtpv1.ActionDrop | gtpv1.ActionDuplicate}}},
+			},
+		}
+		for _, c := range cases {
+			if err := upf.SetRules(4, c.rules); !errors.Is(err, gtpv1.ErrInvalidRule) {
+				t.Errorf("%s: got unexpected error: %v", c.description, err)
+			}
+		}
+
+		var uerr *tft.UnsupportedComponentError
+		err := upf.SetRules(4, &gtpv1.Rules{
+			PDRs: []*gtpv1.PDR{{
+				ID: 1, TEID: 1, FARID: 1,
+				SDFFilters: []*v2ie.TFTPacketFilter{
+					v2ie.NewTFTPacketFilter(v2ie.TFTPFBidirectional, 1, 10, v2ie.NewTFTPFComponent(0xff, []byte{0})),
+				},
+			}},
+			FARs: []*gtpv1.FAR{{ID: 1, Action: gtpv1.ActionDrop}},
+		})
+		if !errors.As(err, &uerr) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+
+		if err := upf.RemoveRules(4); !errors.Is(err, gtpv1.ErrRulesNotFound) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+		if _, err := upf.Usage(3, 2); !errors.Is(err, gtpv1.ErrRulesNotFound) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+	})
+}
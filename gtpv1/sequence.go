@@ -0,0 +1,229 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+)
+
+// Default values for reordering the T-PDUs by the Sequence Number.
+const (
+	DefaultReorderingWindow  = 64
+	DefaultReorderingTimeout = 100 * time.Millisecond
+
+	// the Sequence Numbers ahead of the expected one by more than this are
+	// regarded as the ones behind it, as they wrap around.
+	maxReorderingWindow = 0x8000
+)
+
+// EnableSequenceNumber makes WriteToGTP put the Sequence Number on the T-PDUs
+// sent with the outgoing TEID. The Sequence Number starts from zero and is
+// incremented by one for each T-PDU.
+func (u *UPlaneConn) EnableSequenceNumber(oteid uint32) {
+	u.seqNums.LoadOrStore(oteid, &atomic.Uint32{})
+}
+
+// DisableSequenceNumber stops putting the Sequence Number on the T-PDUs sent
+// with the outgoing TEID.
+func (u *UPlaneConn) DisableSequenceNumber(oteid uint32) {
+	u.seqNums.Delete(oteid)
+}
+
+// nextSequence returns the Sequence Number to be put on the T-PDU sent with the
+// outgoing TEID, if enabled.
+func (u *UPlaneConn) nextSequence(oteid uint32) (uint16, bool) {
+	v, ok := u.seqNums.Load(oteid)
+	if !ok {
+		return 0, false
+	}
+	return uint16(v.(*atomic.Uint32).Add(1) - 1), true
+}
+
+// EnableReordering makes the Tunnel registered with the incoming TEID deliver
+// the T-PDUs in the order of the Sequence Number, which is required for the
+// tunnels negotiated with Reordering Required IE set.
+//
+// The T-PDUs arriving ahead of the expected one are held until the missing
+// ones arrive, or the timeout expires after which the missing ones are given
+// up as lost. The T-PDUs ahead by window or more are not held but make the
+// oldest missing ones given up. If window or timeout is zero or less,
+// DefaultReorderingWindow or DefaultReorderingTimeout is used respectively.
+//
+// The T-PDUs without the Sequence Number are delivered as they arrive.
+func (u *UPlaneConn) EnableReordering(itei uint32, window int, timeout time.Duration) error {
+	t, ok := u.Tunnel(itei)
+	if !ok {
+		return fmt.Errorf("failed to enable reordering on tunnel with %#08x: %w", itei, ErrTunnelNotFound)
+	}
+
+	if window <= 0 {
+		window = DefaultReorderingWindow
+	}
+	if window > maxReorderingWindow {
+		window = maxReorderingWindow
+	}
+	if timeout <= 0 {
+		timeout = DefaultReorderingTimeout
+	}
+
+	r := &reorderer{
+		tunnel:  t,
+		window:  uint16(window - 1),
+		timeout: timeout,
+		pending: map[uint16]heldTPDU{},
+	}
+	if old := t.reorder.Swap(r); old != nil {
+		old.drain()
+	}
+	return nil
+}
+
+// DisableReordering stops reordering the T-PDUs on the Tunnel registered with
+// the incoming TEID. The T-PDUs held are delivered immediately.
+func (u *UPlaneConn) DisableReordering(itei uint32) error {
+	t, ok := u.Tunnel(itei)
+	if !ok {
+		return fmt.Errorf("failed to disable reordering on tunnel with %#08x: %w", itei, ErrTunnelNotFound)
+	}
+
+	if old := t.reorder.Swap(nil); old != nil {
+		old.drain()
+	}
+	return nil
+}
+
+// reorderer holds the T-PDUs on a Tunnel to deliver them in the order of the
+// Sequence Number.
+type reorderer struct {
+	mu      sync.Mutex
+	tunnel  *Tunnel
+	window  uint16 // the maximum distance from next to be held.
+	timeout time.Duration
+
+	started bool
+	next    uint16
+	pending map[uint16]heldTPDU
+	timer   *time.Timer
+}
+
+func (r *reorderer) push(senderAddr net.Addr, pdu *message.TPDU) {
+	if !pdu.HasSequence() {
+		r.tunnel.forward(senderAddr, pdu)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seq := pdu.Sequence()
+	if !r.started {
+		r.started = true
+		r.next = seq
+	}
+
+	d := seq - r.next
+	if d >= maxReorderingWindow {
+		// behind the expected one, which is already delivered or given up.
+		r.tunnel.duplicates.Add(1)
+		return
+	}
+	if _, ok := r.pending[seq]; ok {
+		r.tunnel.duplicates.Add(1)
+		return
+	}
+	if d != 0 {
+		r.tunnel.outOfOrder.Add(1)
+	}
+	if d > r.window {
+		r.skip(d - r.window)
+	}
+
+	r.pending[seq] = heldTPDU{senderAddr, pdu}
+	r.flush()
+}
+
+// flush delivers the consecutive T-PDUs from the expected one, and starts or
+// stops the timer depending on whether any T-PDUs are left.
+func (r *reorderer) flush() {
+	for {
+		h, ok := r.pending[r.next]
+		if !ok {
+			break
+		}
+		delete(r.pending, r.next)
+		r.next++
+		r.tunnel.forward(h.senderAddr, h.pdu)
+	}
+
+	if len(r.pending) == 0 {
+		if r.timer != nil {
+			r.timer.Stop()
+			r.timer = nil
+		}
+		return
+	}
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.timeout, r.expire)
+	}
+}
+
+// skip moves the expected Sequence Number forward by n, delivering the T-PDUs
+// held on the way and giving up the missing ones.
+func (r *reorderer) skip(n uint16) {
+	for i := uint16(0); i < n; i++ {
+		if h, ok := r.pending[r.next]; ok {
+			delete(r.pending, r.next)
+			r.tunnel.forward(h.senderAddr, h.pdu)
+		} else {
+			r.tunnel.lost.Add(1)
+		}
+		r.next++
+	}
+}
+
+// skipToPending gives up the missing ones until the oldest T-PDU held.
+func (r *reorderer) skipToPending() {
+	oldest := uint16(maxReorderingWindow)
+	for seq := range r.pending {
+		if d := seq - r.next; d < oldest {
+			oldest = d
+		}
+	}
+	r.skip(oldest)
+}
+
+func (r *reorderer) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.timer = nil
+	if len(r.pending) == 0 {
+		return
+	}
+	r.skipToPending()
+	r.flush()
+}
+
+// drain delivers all the T-PDUs held, which is called when the reorderer is
+// detached from the Tunnel.
+func (r *reorderer) drain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(r.pending) > 0 {
+		r.skipToPending()
+		r.flush()
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func TestSequenceNumber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	srv, err := n.NewUPlaneConn("10.0.0.1:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := n.NewUPlaneConn("10.0.0.2:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numbered, err := srv.RegisterTunnelChannel(0x11111111, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reordered, err := srv.RegisterTunnelChannel(0x22222222, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.EnableReordering(0x33333333, 0, 0); !errors.Is(err, gtpv1.ErrTunnelNotFound) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+	if err := srv.EnableReordering(0x22222222, 4, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []*gtpv1.UPlaneConn{srv, cli} {
+		go func(u *gtpv1.UPlaneConn) {
+			_ = u.ListenAndServe(ctx)
+		}(u)
+	}
+
+	receive := func(t *testing.T, tun *gtpv1.Tunnel) *message.TPDU {
+		t.Helper()
+		select {
+		case pdu := <-tun.Receive():
+			return pdu
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for T-PDU")
+		}
+		return nil
+	}
+
+	t.Run("outgoing", func(t *testing.T) {
+		cli.EnableSequenceNumber(0x11111111)
+		for i := 0; i < 3; i++ {
+			if _, err := cli.WriteToGTP(0x11111111, []byte("pkt"), srv.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			pdu := receive(t, numbered)
+			if !pdu.HasSequence() {
+				t.Fatal("no Sequence Number")
+			}
+			if got := pdu.Sequence(); got != uint16(i) {
+				t.Errorf("wrong Sequence Number: got %d, want %d", got, i)
+			}
+		}
+
+		cli.DisableSequenceNumber(0x11111111)
+		if _, err := cli.WriteToGTP(0x11111111, []byte("pkt"), srv.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if pdu := receive(t, numbered); pdu.HasSequence() {
+			t.Errorf("got unexpected Sequence Number: %d", pdu.Sequence())
+		}
+	})
+
+	t.Run("reordering", func(t *testing.T) {
+		send := func(seq int, payload string) {
+			t.Helper()
+			var pdu *message.TPDU
+			if seq < 0 {
+				pdu = message.NewTPDU(0x22222222, []byte(payload))
+			} else {
+				pdu = message.NewTPDUWithSequence(0x22222222, uint16(seq), []byte(payload))
+			}
+			b, err := pdu.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cli.WriteTo(b, srv.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		expect := func(payloads ...string) {
+			t.Helper()
+			for _, want := range payloads {
+				if got := string(receive(t, reordered).Payload); got != want {
+					t.Errorf("wrong payload: got %s, want %s", got, want)
+				}
+			}
+			select {
+			case pdu := <-reordered.Receive():
+				t.Errorf("got unexpected T-PDU: %s", pdu.Payload)
+			default:
+			}
+		}
+
+		send(0, "0")
+		expect("0")
+
+		// held until the missing one arrives.
+		send(2, "2")
+		expect()
+		send(1, "1")
+		expect("1", "2")
+
+		send(1, "1")
+		expect()
+
+		// the missing one is given up after timeout.
+		send(4, "4")
+		expect("4")
+
+		// 5-7 are given up as 11 is out of the window.
+		send(11, "11")
+		send(8, "8")
+		send(9, "9")
+		send(10, "10")
+		expect("8", "9", "10", "11")
+
+		send(-1, "x")
+		expect("x")
+
+		want := gtpv1.TunnelStats{
+			Packets:    10,
+			Bytes:      12,
+			OutOfOrder: 3,
+			Duplicates: 1,
+			Lost:       4,
+		}
+		if diff := cmp.Diff(want, reordered.Stats()); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
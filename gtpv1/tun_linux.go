@@ -0,0 +1,74 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// TUN is a Linux TUN device, which is used as the device of userspace GTP-U.
+//
+// Each Read and Write on TUN reads or writes an IP packet.
+type TUN struct {
+	*os.File
+	name string
+}
+
+// OpenTUN creates a TUN device with the name given, and brings it up with the MTU.
+// If name is empty, the name is chosen by Kernel.
+//
+// It requires CAP_NET_ADMIN. The addresses and routes for the device should be
+// configured by the caller, e.g., to route the traffic to the UEs to the device
+// on P-GW.
+func OpenTUN(name string, mtu int) (*TUN, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/net/tun: %w", err)
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to create TUN device %s: %w", name, err)
+	}
+
+	// make it non-blocking so that Close unblocks Read.
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	t := &TUN{File: os.NewFile(uintptr(fd), "/dev/net/tun"), name: ifr.Name()}
+
+	link, err := netlink.LinkByName(t.name)
+	if err != nil {
+		_ = t.Close()
+		return nil, fmt.Errorf("failed to find device %s: %w", t.name, err)
+	}
+	if mtu > 0 {
+		if err := netlink.LinkSetMTU(link, mtu); err != nil {
+			_ = t.Close()
+			return nil, fmt.Errorf("failed to set MTU for device %s: %w", t.name, err)
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		_ = t.Close()
+		return nil, fmt.Errorf("failed to setup device %s: %w", t.name, err)
+	}
+	return t, nil
+}
+
+// Name returns the name of the TUN device.
+func (t *TUN) Name() string {
+	return t.name
+}
@@ -5,17 +5,45 @@
 package gtpv1
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+	"github.com/wmnsk/go-gtp/teid"
+)
+
+// Role is a role for Kernel GTP-U and userspace GTP-U.
+type Role int
+
+// Role definitions.
+const (
+	RoleGGSN Role = iota
+	RoleSGSN
 )
 
 type peer struct {
 	teid    uint32
 	addr    net.Addr
 	srcConn *UPlaneConn
+
+	// inflight is the T-PDUs and End Markers being relayed to the peer, which
+	// SwitchRelay waits for before sending End Marker to the peer.
+	inflight sync.WaitGroup
+}
+
+// done marks the T-PDU or End Marker returned with the peer by relayPeer as
+// sent, or as not to be relayed.
+func (p *peer) done() {
+	p.inflight.Done()
 }
 
 // RelayTo relays T-PDU type of packet to peer node(specified by raddr) from the UPlaneConn given.
+// End Marker with teidIn is also relayed, with its TEID replaced with teidOut.
 //
 // By using this, owner of UPlaneConn won't be able to Read and Write the packets that has teidIn.
 func (u *UPlaneConn) RelayTo(c *UPlaneConn, teidIn, teidOut uint32, raddr net.Addr) error {
@@ -43,5 +71,239 @@ func (u *UPlaneConn) CloseRelay(teidIn uint32) error {
 	u.mu.Unlock()
 
 	u.iteiMap.delete(teidIn)
+	u.freeTEID(teidIn)
+	return nil
+}
+
+// PauseRelay stops relaying T-PDU from a conn to conn without releasing teidIn,
+// so that the T-PDUs with teidIn are passed to the HandlerFunc for T-PDU, e.g., to
+// be buffered while the UE is in idle mode. Call RelayTo again to resume relaying.
+func (u *UPlaneConn) PauseRelay(teidIn uint32) error {
+	if u.KernelGTP.enabled {
+		return errors.New("cannot call PauseRelay when using Kernel GTP-U")
+	}
+
+	u.mu.Lock()
+	delete(u.relayMap, teidIn)
+	u.mu.Unlock()
+	return nil
+}
+
+// relayPeer returns the peer to relay the T-PDU or End Marker in raw to. done
+// should be called on the peer after sending it.
+func (u *UPlaneConn) relayPeer(raw []byte) (*peer, bool) {
+	if len(raw) < 8 {
+		return nil, false
+	}
+	if raw[1] != message.MsgTypeTPDU && raw[1] != message.MsgTypeEndMarker {
+		return nil, false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p, ok := u.relayMap[binary.BigEndian.Uint32(raw[4:8])]
+	if ok {
+		p.inflight.Add(1)
+	}
+	return p, ok
+}
+
+// TPDUHandlerFunc is a handler for the T-PDUs received on a registered Tunnel.
+type TPDUHandlerFunc func(senderAddr net.Addr, pdu *message.TPDU) error
+
+// Tunnel is a tunnel registered to UPlaneConn with its incoming TEID, which
+// receives the T-PDUs with the TEID by a handler or a channel.
+type Tunnel struct {
+	ITEI uint32
+
+	handler TPDUHandlerFunc
+	ch      chan *message.TPDU
+
+	packets, bytes, drops atomic.Uint64
+
+	// the T-PDUs reordered by the Sequence Number, see EnableReordering.
+	reorder                      atomic.Pointer[reorderer]
+	outOfOrder, duplicates, lost atomic.Uint64
+
+	// the T-PDUs held until End Marker arrives, see HoldUntilEndMarker.
+	mu      sync.Mutex
+	holding bool
+	held    []heldTPDU
+}
+
+type heldTPDU struct {
+	senderAddr net.Addr
+	pdu        *message.TPDU
+}
+
+// TunnelStats is the counters of a Tunnel.
+type TunnelStats struct {
+	// Packets and Bytes are the number of T-PDUs and the total length of their
+	// payload received on the Tunnel, including the dropped ones.
+	Packets, Bytes uint64
+
+	// Drops is the number of T-PDUs dropped, as the handler returned error,
+	// the channel was full, or too many T-PDUs were held.
+	Drops uint64
+
+	// OutOfOrder, Duplicates and Lost are counted only while reordering is
+	// enabled with EnableReordering.
+	//
+	// OutOfOrder is the number of T-PDUs arrived ahead of the expected Sequence
+	// Number. Duplicates is the number of T-PDUs discarded as the Sequence
+	// Number had already been received or given up. Lost is the number of
+	// Sequence Numbers given up waiting for, due to the timeout or the window.
+	OutOfOrder, Duplicates, Lost uint64
+}
+
+// Stats returns the counters of the Tunnel.
+func (t *Tunnel) Stats() TunnelStats {
+	return TunnelStats{
+		Packets: t.packets.Load(),
+		Bytes:   t.bytes.Load(),
+		Drops:   t.drops.Load(),
+
+		OutOfOrder: t.outOfOrder.Load(),
+		Duplicates: t.duplicates.Load(),
+		Lost:       t.lost.Load(),
+	}
+}
+
+// Receive returns the channel that receives the T-PDUs on the Tunnel registered
+// by RegisterTunnelChannel, or nil if registered by RegisterTunnel.
+func (t *Tunnel) Receive() <-chan *message.TPDU {
+	return t.ch
+}
+
+func (t *Tunnel) deliver(senderAddr net.Addr, pdu *message.TPDU) {
+	t.packets.Add(1)
+	t.bytes.Add(uint64(len(pdu.Decapsulate())))
+
+	if r := t.reorder.Load(); r != nil {
+		r.push(senderAddr, pdu)
+		return
+	}
+	t.forward(senderAddr, pdu)
+}
+
+// forward passes the T-PDU to the handler or channel, or holds it until End
+// Marker arrives.
+func (t *Tunnel) forward(senderAddr net.Addr, pdu *message.TPDU) {
+	t.mu.Lock()
+	if t.holding {
+		if len(t.held) < maxHeldTPDUs {
+			t.held = append(t.held, heldTPDU{senderAddr, pdu})
+		} else {
+			t.drops.Add(1)
+		}
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+
+	t.dispatch(senderAddr, pdu)
+}
+
+func (t *Tunnel) dispatch(senderAddr net.Addr, pdu *message.TPDU) {
+	if t.ch != nil {
+		select {
+		case t.ch <- pdu:
+		default:
+			t.drops.Add(1)
+		}
+		return
+	}
+
+	if err := t.handler(senderAddr, pdu); err != nil {
+		t.drops.Add(1)
+		logf("error handling T-PDU on tunnel %#08x: %v", t.ITEI, err)
+	}
+}
+
+// RegisterTunnel registers a Tunnel with the incoming TEID, and makes fn handle
+// the T-PDUs with the TEID instead of the HandlerFunc for T-PDU.
+//
+// The T-PDUs for the TEIDs that are neither registered, relayed nor allocated
+// by NewFTEID are regarded as unknown and responded with Error Indication unless
+// DisableErrorIndication is called.
+func (u *UPlaneConn) RegisterTunnel(itei uint32, fn TPDUHandlerFunc) (*Tunnel, error) {
+	return u.registerTunnel(&Tunnel{ITEI: itei, handler: fn})
+}
+
+// RegisterTunnelChannel registers a Tunnel with the incoming TEID, and makes the
+// T-PDUs with the TEID delivered to the channel returned by Receive of the Tunnel,
+// which can buffer the number of T-PDUs given as size. The T-PDUs are dropped
+// while the channel is full.
+//
+// See RegisterTunnel for how the T-PDUs for the other TEIDs are handled.
+func (u *UPlaneConn) RegisterTunnelChannel(itei uint32, size int) (*Tunnel, error) {
+	return u.registerTunnel(&Tunnel{ITEI: itei, ch: make(chan *message.TPDU, size)})
+}
+
+func (u *UPlaneConn) registerTunnel(t *Tunnel) (*Tunnel, error) {
+	if _, loaded := u.tunnels.LoadOrStore(t.ITEI, t); loaded {
+		return nil, fmt.Errorf("failed to register tunnel with %#08x: %w", t.ITEI, ErrTunnelExists)
+	}
+
+	// the TEID allocated by NewFTEID is already in use.
+	u.iteiMap.tryStore(t.ITEI, time.Now())
+	if err := u.TEIDAllocator().Reserve(t.ITEI); err != nil && !errors.Is(err, teid.ErrInUse) {
+		logf("failed to reserve TEID-U %#08x: %v", t.ITEI, err)
+	}
+	return t, nil
+}
+
+// UnregisterTunnel removes the Tunnel registered with the incoming TEID, and
+// releases the TEID.
+func (u *UPlaneConn) UnregisterTunnel(itei uint32) error {
+	if _, loaded := u.tunnels.LoadAndDelete(itei); !loaded {
+		return fmt.Errorf("failed to unregister tunnel with %#08x: %w", itei, ErrTunnelNotFound)
+	}
+
+	u.iteiMap.delete(itei)
+	u.freeTEID(itei)
 	return nil
 }
+
+// Tunnel returns the Tunnel registered with the incoming TEID.
+func (u *UPlaneConn) Tunnel(itei uint32) (*Tunnel, bool) {
+	t, ok := u.tunnels.Load(itei)
+	if !ok {
+		return nil, false
+	}
+	return t.(*Tunnel), true
+}
+
+// knownTEID reports whether the T-PDU with the TEID is expected on UPlaneConn.
+func (u *UPlaneConn) knownTEID(teid uint32) bool {
+	if _, ok := u.tunnels.Load(teid); ok {
+		return true
+	}
+	_, ok := u.iteiMap.syncMap.Load(teid)
+	return ok
+}
+
+// handleTunnelTPDU passes the T-PDU in raw to the Tunnel registered with its
+// TEID, and reports whether it is consumed.
+func (u *UPlaneConn) handleTunnelTPDU(raddr net.Addr, raw []byte) bool {
+	if len(raw) < 8 {
+		return false
+	}
+	t, ok := u.Tunnel(binary.BigEndian.Uint32(raw[4:8]))
+	if !ok {
+		return false
+	}
+
+	msg, err := message.Parse(raw)
+	if err != nil {
+		t.drops.Add(1)
+		return true
+	}
+	pdu, ok := msg.(*message.TPDU)
+	if !ok {
+		return false
+	}
+
+	t.deliver(raddr, pdu)
+	return true
+}
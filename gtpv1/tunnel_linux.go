@@ -12,15 +12,6 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
-// Role is a role for Kernel GTP-U.
-type Role int
-
-// Role definitions.
-const (
-	RoleGGSN Role = iota
-	RoleSGSN
-)
-
 // EnableKernelGTP enables Linux Kernel GTP-U.
 // Note that this removes all the existing userland tunnels, and cannot be disabled while
 // the program is working (at least at this moment).
@@ -38,9 +29,13 @@ const (
 //
 // Please see the examples/gw-tester for how each node handles routing from the program.
 func (u *UPlaneConn) EnableKernelGTP(devname string, role Role) error {
+	if u.userspaceEnabled() {
+		return errors.New("cannot enable Kernel GTP-U when using userspace GTP-U")
+	}
+
 	if u.pktConn == nil {
 		var err error
-		u.pktConn, err = newPktConn(u.laddr)
+		u.pktConn, err = newPktConn(u.laddr, false)
 		if err != nil {
 			return err
 		}
@@ -67,7 +62,7 @@ func (u *UPlaneConn) EnableKernelGTP(devname string, role Role) error {
 		_ = f.Close()
 		return fmt.Errorf("failed to setup device %s: %w", u.KernelGTP.Link.Name, err)
 	}
-	if err := netlink.LinkSetMTU(u.KernelGTP.Link, 1500); err != nil {
+	if err := netlink.LinkSetMTU(u.KernelGTP.Link, u.MTU()-minOverhead); err != nil {
 		_ = f.Close()
 		return fmt.Errorf("failed to set MTU for device %s: %w", u.KernelGTP.Link.Name, err)
 	}
@@ -84,10 +79,14 @@ func (u *UPlaneConn) EnableKernelGTP(devname string, role Role) error {
 	return nil
 }
 
-// AddTunnel adds a GTP-U tunnel with Linux Kernel GTP-U via netlink.
+// AddTunnel adds a GTP-U tunnel with Linux Kernel GTP-U via netlink, or to the
+// table of userspace GTP-U if EnableUserspaceGTP is called.
 func (u *UPlaneConn) AddTunnel(peerIP, msIP net.IP, otei, itei uint32) error {
+	if u.userspaceEnabled() {
+		return u.addUserTunnel(peerIP, msIP, otei, itei, false)
+	}
 	if !u.KernelGTP.enabled {
-		return errors.New("cannot call AddTunnel when not using Kernel GTP-U")
+		return errors.New("cannot call AddTunnel when not using Kernel GTP-U or userspace GTP-U")
 	}
 
 	pdp := &netlink.PDP{
@@ -103,12 +102,16 @@ func (u *UPlaneConn) AddTunnel(peerIP, msIP net.IP, otei, itei uint32) error {
 	return nil
 }
 
-// AddTunnelOverride adds a GTP-U tunnel with Linux Kernel GTP-U via netlink.
+// AddTunnelOverride adds a GTP-U tunnel with Linux Kernel GTP-U via netlink, or to
+// the table of userspace GTP-U if EnableUserspaceGTP is called.
 // If there is already an existing tunnel that has the same msIP and/or incoming TEID,
 // this deletes it before adding the tunnel.
 func (u *UPlaneConn) AddTunnelOverride(peerIP, msIP net.IP, otei, itei uint32) error {
+	if u.userspaceEnabled() {
+		return u.addUserTunnel(peerIP, msIP, otei, itei, true)
+	}
 	if !u.KernelGTP.enabled {
-		return errors.New("cannot call AddTunnelOverride when not using Kernel GTP-U")
+		return errors.New("cannot call AddTunnelOverride when not using Kernel GTP-U or userspace GTP-U")
 	}
 
 	if pdp, _ := netlink.GTPPDPByMSAddress(u.KernelGTP.Link, msIP); pdp != nil {
@@ -123,10 +126,14 @@ func (u *UPlaneConn) AddTunnelOverride(peerIP, msIP net.IP, otei, itei uint32) e
 	return u.AddTunnel(peerIP, msIP, otei, itei)
 }
 
-// DelTunnelByITEI deletes a Linux Kernel GTP-U tunnel specified with the incoming TEID.
+// DelTunnelByITEI deletes a Linux Kernel GTP-U or userspace GTP-U tunnel specified
+// with the incoming TEID.
 func (u *UPlaneConn) DelTunnelByITEI(itei uint32) error {
+	if u.userspaceEnabled() {
+		return u.delUserTunnelByITEI(itei)
+	}
 	if !u.KernelGTP.enabled {
-		return errors.New("cannot call DelTunnel when not using Kernel GTP-U")
+		return errors.New("cannot call DelTunnel when not using Kernel GTP-U or userspace GTP-U")
 	}
 
 	pdp, err := netlink.GTPPDPByITEI(u.KernelGTP.Link, int(itei))
@@ -139,13 +146,18 @@ func (u *UPlaneConn) DelTunnelByITEI(itei uint32) error {
 	}
 
 	u.iteiMap.delete(itei)
+	u.freeTEID(itei)
 	return nil
 }
 
-// DelTunnelByMSAddress deletes a Linux Kernel GTP-U tunnel specified with the subscriber's IP.
+// DelTunnelByMSAddress deletes a Linux Kernel GTP-U or userspace GTP-U tunnel specified
+// with the subscriber's IP.
 func (u *UPlaneConn) DelTunnelByMSAddress(msIP net.IP) error {
+	if u.userspaceEnabled() {
+		return u.delUserTunnelByMSAddress(msIP)
+	}
 	if !u.KernelGTP.enabled {
-		return errors.New("cannot call DelTunnel when not using Kernel GTP-U")
+		return errors.New("cannot call DelTunnel when not using Kernel GTP-U or userspace GTP-U")
 	}
 
 	pdp, err := netlink.GTPPDPByMSAddress(u.KernelGTP.Link, msIP)
@@ -159,5 +171,6 @@ func (u *UPlaneConn) DelTunnelByMSAddress(msIP net.IP) error {
 	}
 
 	u.iteiMap.delete(itei)
+	u.freeTEID(itei)
 	return nil
 }
@@ -0,0 +1,170 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func TestTunnel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	srv, err := n.NewUPlaneConn("10.0.0.1:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := n.NewUPlaneConn("10.0.0.2:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errIndCh := make(chan uint32, 10)
+	cli.AddHandler(message.MsgTypeErrorIndication, func(c gtpv1.Conn, senderAddr net.Addr, msg message.Message) error {
+		ind := msg.(*message.ErrorIndication)
+		teid, err := ind.TEIDDataI.TEID()
+		if err != nil {
+			return err
+		}
+		errIndCh <- teid
+		return nil
+	})
+
+	handled := make(chan string, 10)
+	if _, err := srv.RegisterTunnel(0x11111111, func(senderAddr net.Addr, pdu *message.TPDU) error {
+		if string(pdu.Payload) == "bad" {
+			return errors.New("bad payload")
+		}
+		handled <- string(pdu.Payload)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.RegisterTunnelChannel(0x22222222, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.RegisterTunnel(0x22222222, nil); !errors.Is(err, gtpv1.ErrTunnelExists) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+
+	for _, u := range []*gtpv1.UPlaneConn{srv, cli} {
+		go func(u *gtpv1.UPlaneConn) {
+			_ = u.ListenAndServe(ctx)
+		}(u)
+	}
+
+	send := func(teid uint32, payload string) {
+		t.Helper()
+		if _, err := cli.WriteToGTP(teid, []byte(payload), srv.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Run("handler", func(t *testing.T) {
+		send(0x11111111, "pkt1")
+		send(0x11111111, "bad")
+
+		select {
+		case got := <-handled:
+			if got != "pkt1" {
+				t.Errorf("wrong payload: %s", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for T-PDU")
+		}
+
+		tun, ok := srv.Tunnel(0x11111111)
+		if !ok {
+			t.Fatal("tunnel not found")
+		}
+		want := gtpv1.TunnelStats{Packets: 2, Bytes: 7, Drops: 1}
+		if diff := cmp.Diff(want, tun.Stats()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("channel", func(t *testing.T) {
+		// the second one is dropped as nobody reads the channel.
+		send(0x22222222, "pkt1")
+		send(0x22222222, "pkt2")
+
+		tun, ok := srv.Tunnel(0x22222222)
+		if !ok {
+			t.Fatal("tunnel not found")
+		}
+		select {
+		case pdu := <-tun.Receive():
+			if string(pdu.Payload) != "pkt1" {
+				t.Errorf("wrong payload: %s", pdu.Payload)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for T-PDU")
+		}
+
+		want := gtpv1.TunnelStats{Packets: 2, Bytes: 8, Drops: 1}
+		if diff := cmp.Diff(want, tun.Stats()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if err := srv.UnregisterTunnel(0x22222222); err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.UnregisterTunnel(0x22222222); !errors.Is(err, gtpv1.ErrTunnelNotFound) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+
+		send(0x22222222, "pkt3")
+		select {
+		case teid := <-errIndCh:
+			if teid != 0x22222222 {
+				t.Errorf("wrong TEID: %#x", teid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for Error Indication")
+		}
+	})
+
+	t.Run("allocated", func(t *testing.T) {
+		fteid := srv.NewFTEID(0, "10.0.0.1", "")
+		teid, err := fteid.TEID()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the T-PDU with the TEID allocated is passed to ReadFromGTP.
+		send(teid, "pkt4")
+		buf := make([]byte, 1500)
+		l, _, got, err := srv.ReadFromGTP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != teid {
+			t.Errorf("wrong TEID: %#x", got)
+		}
+		if string(buf[:l]) != "pkt4" {
+			t.Errorf("wrong payload: %s", buf[:l])
+		}
+
+		select {
+		case teid := <-errIndCh:
+			t.Errorf("got unexpected Error Indication for %#x", teid)
+		default:
+		}
+	})
+}
@@ -0,0 +1,263 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// userTunnel is a GTP-U tunnel handled in userspace.
+type userTunnel struct {
+	peerAddr   *net.UDPAddr
+	msIP       net.IP
+	otei, itei uint32
+}
+
+// userspaceGTP consists of the userspace GTP-U related objects.
+type userspaceGTP struct {
+	mu      sync.RWMutex
+	enabled bool
+	role    Role
+	dev     io.ReadWriteCloser
+	byITEI  map[uint32]*userTunnel
+	byMSIP  map[string]*userTunnel
+}
+
+// EnableUserspaceGTP enables the GTP-U data path in userspace, which works like
+// Linux Kernel GTP-U without the gtp kernel module.
+//
+// The IP packets read from dev are encapsulated with the tunnel whose MS address
+// matches the destination(RoleGGSN) or the source(RoleSGSN) of the packet, and the
+// T-PDUs received with the incoming TEID of the tunnels are decapsulated and written
+// to dev. dev is typically a TUN device opened by OpenTUN, and it is closed when
+// UPlaneConn is closed.
+//
+// After enabled, users should add tunnels by AddTunnel func, and also add appropriate
+// routing entries to the TUN device, as well as Kernel GTP-U.
+func (u *UPlaneConn) EnableUserspaceGTP(dev io.ReadWriteCloser, role Role) error {
+	if u.KernelGTP.enabled {
+		return errors.New("cannot enable userspace GTP-U when using Kernel GTP-U")
+	}
+
+	u.mu.Lock()
+	if u.pktConn == nil {
+		var err error
+		u.pktConn, err = newPktConn(u.laddr, u.reusePorts > 1)
+		if err != nil {
+			u.mu.Unlock()
+			return err
+		}
+	}
+	u.mu.Unlock()
+
+	u.userspace.mu.Lock()
+	u.userspace.enabled = true
+	u.userspace.role = role
+	u.userspace.dev = dev
+	u.userspace.byITEI = map[uint32]*userTunnel{}
+	u.userspace.byMSIP = map[string]*userTunnel{}
+	u.userspace.mu.Unlock()
+
+	go u.serveUserspaceGTP(dev)
+	return nil
+}
+
+// userspaceEnabled reports whether the userspace GTP-U is enabled.
+func (u *UPlaneConn) userspaceEnabled() bool {
+	u.userspace.mu.RLock()
+	defer u.userspace.mu.RUnlock()
+	return u.userspace.enabled
+}
+
+// serveUserspaceGTP reads the IP packets from dev and sends them to the peers of
+// the tunnels, until dev is closed.
+func (u *UPlaneConn) serveUserspaceGTP(dev io.ReadWriteCloser) {
+	go func() {
+		<-u.closed()
+		if err := dev.Close(); err != nil {
+			logf("error closing the device: %s", err)
+		}
+	}()
+
+	buf := make([]byte, 0xffff)
+	for {
+		n, err := dev.Read(buf)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logf("error reading from the device: %s", err)
+			}
+			return
+		}
+
+		u.handleDevicePacket(buf[:n])
+	}
+}
+
+// handleDevicePacket applies the rules to the IP packet read from the device, or
+// sends it to the peer of the tunnel if no PDR detects it.
+func (u *UPlaneConn) handleDevicePacket(pkt []byte) {
+	if u.handleRulePacket(pkt) {
+		return
+	}
+
+	key := u.msAddrOf(pkt, true)
+	if key == nil {
+		return
+	}
+
+	u.userspace.mu.RLock()
+	t, ok := u.userspace.byMSIP[string(key)]
+	u.userspace.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	if _, err := u.WriteToGTP(t.otei, pkt, t.peerAddr); err != nil && !errors.Is(err, ErrPacketTooBig) && !errors.Is(err, ErrRateExceeded) {
+		logf("error sending on UPlaneConn %s: %v", u.LocalAddr(), err)
+	}
+}
+
+// handleUserspaceTPDU writes the payload of T-PDU in raw to the device if its TEID
+// is the incoming TEID of any tunnel, and reports whether it is consumed.
+func (u *UPlaneConn) handleUserspaceTPDU(raw []byte) bool {
+	teid, payload, err := Decapsulate(raw)
+	if err != nil {
+		return false
+	}
+
+	u.userspace.mu.RLock()
+	t, ok := u.userspace.byITEI[teid]
+	dev := u.userspace.dev
+	u.userspace.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	// drop the packets from/to the address not assigned to the UE.
+	if key := u.msAddrOf(payload, false); key == nil || string(key) != string(t.msIP) {
+		return true
+	}
+
+	if _, err := dev.Write(payload); err != nil {
+		logf("error writing to the device: %v", err)
+	}
+	return true
+}
+
+// msAddrOf returns the address of the UE in the IP packet. The UE is the
+// destination of the packets read from the device on GGSN(=P-GW), and the source
+// on SGSN, and vice versa for the packets decapsulated.
+func (u *UPlaneConn) msAddrOf(pkt []byte, fromDevice bool) net.IP {
+	u.userspace.mu.RLock()
+	role := u.userspace.role
+	u.userspace.mu.RUnlock()
+
+	src, dst := ipAddrsOf(pkt)
+	if (role == RoleGGSN) == fromDevice {
+		return dst
+	}
+	return src
+}
+
+// ipAddrsOf returns the source and destination addresses of the IP packet, in
+// 4-byte form for IPv4 and 16-byte form for IPv6.
+func ipAddrsOf(pkt []byte) (src, dst net.IP) {
+	if len(pkt) < 1 {
+		return nil, nil
+	}
+
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return nil, nil
+		}
+		return net.IP(pkt[12:16]), net.IP(pkt[16:20])
+	case 6:
+		if len(pkt) < 40 {
+			return nil, nil
+		}
+		return net.IP(pkt[8:24]), net.IP(pkt[24:40])
+	default:
+		return nil, nil
+	}
+}
+
+// normalizeIP returns ip in 4-byte form if it is IPv4, otherwise in 16-byte form.
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+func (u *UPlaneConn) addUserTunnel(peerIP, msIP net.IP, otei, itei uint32, override bool) error {
+	ms := normalizeIP(msIP)
+	if ms == nil {
+		return fmt.Errorf("invalid MS address: %s", msIP)
+	}
+	t := &userTunnel{
+		peerAddr: &net.UDPAddr{IP: peerIP, Port: 2152},
+		msIP:     ms,
+		otei:     otei,
+		itei:     itei,
+	}
+
+	u.userspace.mu.Lock()
+	defer u.userspace.mu.Unlock()
+
+	old1, ok1 := u.userspace.byMSIP[string(ms)]
+	old2, ok2 := u.userspace.byITEI[itei]
+	if !override && (ok1 || ok2) {
+		return fmt.Errorf("failed to add tunnel for %s with %s: %w", msIP, peerIP, ErrTunnelExists)
+	}
+	for _, old := range []*userTunnel{old1, old2} {
+		if old != nil {
+			delete(u.userspace.byMSIP, string(old.msIP))
+			delete(u.userspace.byITEI, old.itei)
+		}
+	}
+
+	u.userspace.byMSIP[string(ms)] = t
+	u.userspace.byITEI[itei] = t
+	return nil
+}
+
+func (u *UPlaneConn) delUserTunnel(t *userTunnel) {
+	u.userspace.mu.Lock()
+	delete(u.userspace.byMSIP, string(t.msIP))
+	delete(u.userspace.byITEI, t.itei)
+	u.userspace.mu.Unlock()
+
+	u.iteiMap.delete(t.itei)
+	u.freeTEID(t.itei)
+}
+
+func (u *UPlaneConn) delUserTunnelByITEI(itei uint32) error {
+	u.userspace.mu.RLock()
+	t, ok := u.userspace.byITEI[itei]
+	u.userspace.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("failed to delete tunnel with %d: %w", itei, ErrTunnelNotFound)
+	}
+
+	u.delUserTunnel(t)
+	return nil
+}
+
+func (u *UPlaneConn) delUserTunnelByMSAddress(msIP net.IP) error {
+	u.userspace.mu.RLock()
+	t, ok := u.userspace.byMSIP[string(normalizeIP(msIP))]
+	u.userspace.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("failed to delete tunnel with %s: %w", msIP, ErrTunnelNotFound)
+	}
+
+	u.delUserTunnel(t)
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv1_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+// fakeTUN is a TUN device that passes the packets via channels.
+type fakeTUN struct {
+	in, out chan []byte
+	closeCh chan struct{}
+}
+
+func newFakeTUN() *fakeTUN {
+	return &fakeTUN{
+		in:      make(chan []byte, 10),
+		out:     make(chan []byte, 10),
+		closeCh: make(chan struct{}),
+	}
+}
+
+func (f *fakeTUN) Read(b []byte) (int, error) {
+	select {
+	case p := <-f.in:
+		return copy(b, p), nil
+	case <-f.closeCh:
+		return 0, io.EOF
+	}
+}
+
+func (f *fakeTUN) Write(b []byte) (int, error) {
+	f.out <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func (f *fakeTUN) Close() error {
+	close(f.closeCh)
+	return nil
+}
+
+func ipv4Packet(src, dst string) []byte {
+	b := make([]byte, 24)
+	b[0] = 0x45
+	b[3] = 24
+	b[9] = 17
+	copy(b[12:16], net.ParseIP(src).To4())
+	copy(b[16:20], net.ParseIP(dst).To4())
+	copy(b[20:], []byte{0xde, 0xad, 0xbe, 0xef})
+	return b
+}
+
+func validChecksum(b []byte) bool {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return sum == 0xffff
+}
+
+func TestUserspaceGTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	sgw, err := n.NewUPlaneConn("10.0.0.1:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgw.DisableErrorIndication()
+	pgw, err := n.NewUPlaneConn("10.0.0.2:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tun := newFakeTUN()
+	if err := pgw.EnableUserspaceGTP(tun, gtpv1.RoleGGSN); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*gtpv1.UPlaneConn{sgw, pgw} {
+		go func(u *gtpv1.UPlaneConn) {
+			_ = u.ListenAndServe(ctx)
+		}(u)
+	}
+
+	msIP := net.ParseIP("10.10.0.1")
+	if err := pgw.AddTunnel(net.ParseIP("10.0.0.1"), msIP, 0x11111111, 0x22222222); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("uplink", func(t *testing.T) {
+		// the packet from the address not assigned to the UE is dropped.
+		for _, src := range []string{"10.10.0.2", "10.10.0.1"} {
+			if _, err := sgw.WriteToGTP(0x22222222, ipv4Packet(src, "192.0.2.1"), pgw.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		select {
+		case got := <-tun.out:
+			if diff := cmp.Diff(ipv4Packet("10.10.0.1", "192.0.2.1"), got); diff != "" {
+				t.Error(diff)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for the packet on TUN")
+		}
+	})
+
+	t.Run("downlink", func(t *testing.T) {
+		tun.in <- ipv4Packet("192.0.2.1", "10.10.0.3") // unknown UE
+		tun.in <- ipv4Packet("192.0.2.1", "10.10.0.1")
+
+		buf := make([]byte, 1500)
+		l, _, teid, err := sgw.ReadFromGTP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if teid != 0x11111111 {
+			t.Errorf("wrong TEID: %#x", teid)
+		}
+		if diff := cmp.Diff(ipv4Packet("192.0.2.1", "10.10.0.1"), buf[:l]); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("oversize", func(t *testing.T) {
+		pgw.SetMTU(100)
+		defer pgw.SetMTU(gtpv1.DefaultMTU)
+		if err := pgw.SetOversizeAction(gtpv1.OversizeICMP); err != nil {
+			t.Fatal(err)
+		}
+
+		pkt := append(ipv4Packet("192.0.2.1", "10.10.0.1"), make([]byte, 100)...)
+		binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+		pkt[6] = 0x40 // DF
+		tun.in <- pkt
+
+		var got []byte
+		select {
+		case got = <-tun.out:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out while waiting for ICMP on TUN")
+		}
+
+		// Fragmentation Needed to the source with MTU, containing the original.
+		if len(got) < 28 {
+			t.Fatalf("too short: %x", got)
+		}
+		if !net.IP(got[16:20]).Equal(net.ParseIP("192.0.2.1")) {
+			t.Errorf("wrong destination: %s", net.IP(got[16:20]))
+		}
+		if got[9] != 1 || got[20] != 3 || got[21] != 4 {
+			t.Errorf("not ICMP Fragmentation Needed: %x", got[:24])
+		}
+		// the MTU without the outer IPv4, UDP and GTP headers.
+		if mtu := binary.BigEndian.Uint16(got[26:28]); mtu != 100-36 {
+			t.Errorf("wrong MTU: %d", mtu)
+		}
+		if diff := cmp.Diff(pkt, got[28:]); diff != "" {
+			t.Error(diff)
+		}
+		for _, b := range [][]byte{got[:20], got[20:]} {
+			if !validChecksum(b) {
+				t.Errorf("invalid checksum: %x", b)
+			}
+		}
+		if got := pgw.OversizeStats(); got.ICMPSent != 1 {
+			t.Errorf("wrong number of ICMP sent: %d", got.ICMPSent)
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		err := pgw.AddTunnel(net.ParseIP("10.0.0.1"), msIP, 0x11111111, 0x33333333)
+		if !errors.Is(err, gtpv1.ErrTunnelExists) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+		if err := pgw.AddTunnelOverride(net.ParseIP("10.0.0.1"), msIP, 0x11111111, 0x33333333); err != nil {
+			t.Fatal(err)
+		}
+		if err := pgw.DelTunnelByITEI(0x22222222); !errors.Is(err, gtpv1.ErrTunnelNotFound) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+		if err := pgw.DelTunnelByMSAddress(msIP); err != nil {
+			t.Fatal(err)
+		}
+		if err := pgw.DelTunnelByITEI(0x33333333); !errors.Is(err, gtpv1.ErrTunnelNotFound) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+	})
+}
@@ -6,11 +6,11 @@ package gtpv1
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"strings"
@@ -21,6 +21,7 @@ import (
 	"github.com/wmnsk/go-gtp/gtpv1/ie"
 	"github.com/wmnsk/go-gtp/gtpv1/message"
 	v2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/teid"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 )
@@ -173,10 +174,14 @@ func (pkt pktConn6) File() (f *os.File, err error) {
 	return pkt.udpConn.File()
 }
 
-// newPktConn creates a new pktConn initialized with a given local UDP address
-func newPktConn(laddr net.Addr) (pktConn, error) {
-	var err error
-	pktC, err := net.ListenPacket(laddr.Network(), laddr.String())
+// newPktConn creates a new pktConn initialized with a given local UDP address,
+// with SO_REUSEPORT set if reusePort is true.
+func newPktConn(laddr net.Addr, reusePort bool) (pktConn, error) {
+	var lc net.ListenConfig
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+	pktC, err := lc.ListenPacket(context.Background(), laddr.Network(), laddr.String())
 	if err != nil {
 		return nil, err
 	}
@@ -202,6 +207,47 @@ func newPktConn(laddr net.Addr) (pktConn, error) {
 	}
 }
 
+// genericPktConn is a pktConn built on top of any net.PacketConn that is not
+// a *net.UDPConn, e.g., the in-memory one used in tests.
+//
+// DSCP/ECN values are ignored, and File() is not available, which means
+// Kernel GTP-U cannot be used with it.
+type genericPktConn struct {
+	net.PacketConn
+}
+
+// WriteToWithDSCPECN implements the pktConn WriteToWithDSCPECN method.
+// The DSCP/ECN value is ignored.
+func (pkt genericPktConn) WriteToWithDSCPECN(p []byte, addr net.Addr, dscpecn int) (n int, err error) {
+	return pkt.WriteTo(p, addr)
+}
+
+// File always returns ErrFileNotAvailable.
+func (pkt genericPktConn) File() (f *os.File, err error) {
+	return nil, ErrFileNotAvailable
+}
+
+// wrapPktConn creates a new pktConn from the given net.PacketConn.
+func wrapPktConn(pc net.PacketConn) pktConn {
+	udpConn, ok := pc.(*net.UDPConn)
+	if !ok {
+		return genericPktConn{pc}
+	}
+
+	if addr, ok := udpConn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		return pktConn6{
+			mu:         &sync.Mutex{},
+			udpConn:    udpConn,
+			PacketConn: ipv6.NewPacketConn(udpConn),
+		}
+	}
+	return pktConn4{
+		mu:         &sync.Mutex{},
+		udpConn:    udpConn,
+		PacketConn: ipv4.NewPacketConn(udpConn),
+	}
+}
+
 // UPlaneConn represents a U-Plane Connection of GTPv1.
 type UPlaneConn struct {
 	mu      sync.Mutex
@@ -214,9 +260,31 @@ type UPlaneConn struct {
 	closeCh chan struct{}
 
 	relayMap map[uint32]*peer
+	tunnels  sync.Map // map[uint32]*Tunnel
+	holds    sync.Map // map[uint32]*endMarkerHold
+	seqNums  sync.Map // map[uint32]*atomic.Uint32
+	qosIn    sync.Map // map[uint32]*Policer
+	qosOut   sync.Map // map[uint32]*Policer
+	rules    ruleTable
 
 	errIndEnabled bool
 
+	teidAllocator teid.Allocator
+
+	// for batch I/O and SO_REUSEPORT
+	batchSize  int
+	reusePorts int
+	reuseConns []pktConn
+
+	// for the packets exceeding the size limits
+	readBufferSize int
+	mtu            int
+	oversizeAction OversizeAction
+	oversize       oversizeCounters
+
+	// for GTP-U data path in userspace with TUN device
+	userspace userspaceGTP
+
 	// for Linux kernel GTP with netlink
 	KernelGTP
 }
@@ -243,6 +311,18 @@ func NewUPlaneConn(laddr net.Addr) *UPlaneConn {
 	}
 }
 
+// NewUPlaneConnWithPacketConn creates a new UPlaneConn that works on top of the
+// given net.PacketConn instead of opening a UDP socket by itself.
+// ListenAndServe starts serving on pc immediately.
+//
+// This is useful to run UPlaneConn on an in-memory network in tests. Note that
+// DSCP/ECN values and Kernel GTP-U are not available if pc is not *net.UDPConn.
+func NewUPlaneConnWithPacketConn(pc net.PacketConn) *UPlaneConn {
+	u := NewUPlaneConn(pc.LocalAddr())
+	u.pktConn = wrapPktConn(pc)
+	return u
+}
+
 // DialUPlane sends Echo Request to raddr to check if the endpoint is alive and returns UPlaneConn.
 func DialUPlane(ctx context.Context, laddr, raddr net.Addr) (*UPlaneConn, error) {
 	u := &UPlaneConn{
@@ -260,11 +340,14 @@ func DialUPlane(ctx context.Context, laddr, raddr net.Addr) (*UPlaneConn, error)
 	// setup UDPConn first.
 	var err error
 	if u.pktConn == nil {
-		u.pktConn, err = newPktConn(u.laddr)
+		u.pktConn, err = newPktConn(u.laddr, false)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if err := u.applyOversizeAction(); err != nil {
+		return nil, err
+	}
 
 	// if no response coming within 5 seconds, returns error.
 	if err := u.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
@@ -321,12 +404,18 @@ func (u *UPlaneConn) ListenAndServe(ctx context.Context) error {
 	if u.pktConn == nil {
 		var err error
 		u.mu.Lock()
-		u.pktConn, err = newPktConn(u.laddr)
+		u.pktConn, err = newPktConn(u.laddr, u.reusePorts > 1)
 		u.mu.Unlock()
 		if err != nil {
 			return err
 		}
 	}
+	if err := u.listenReusePort(); err != nil {
+		return err
+	}
+	if err := u.applyOversizeAction(); err != nil {
+		return err
+	}
 	return u.listenAndServe(ctx)
 }
 
@@ -357,9 +446,37 @@ func (u *UPlaneConn) serve(ctx context.Context) error {
 				logf("error closing the underlying conn: %s", err)
 			}
 		}
+		u.mu.Lock()
+		for _, pc := range u.reuseConns {
+			if err := pc.Close(); err != nil {
+				logf("error closing the underlying conn: %s", err)
+			}
+		}
+		u.mu.Unlock()
 	}()
 
-	buf := make([]byte, 1500)
+	bufSize := u.readBufSize()
+	u.mu.Lock()
+	size := u.batchSize
+	pcs := append([]pktConn{u.pktConn}, u.reuseConns...)
+	u.mu.Unlock()
+	if size > 0 {
+		errCh := make(chan error, len(pcs))
+		for _, pc := range pcs {
+			go func(pc pktConn) {
+				errCh <- u.serveBatch(ctx, pc, size, bufSize)
+			}(pc)
+		}
+		for range pcs {
+			if err := <-errCh; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// one more byte to detect the packets larger than the buffer.
+	buf := make([]byte, bufSize+1)
 	for {
 		select {
 		case <-ctx.Done():
@@ -382,55 +499,76 @@ func (u *UPlaneConn) serve(ctx context.Context) error {
 			}
 			return fmt.Errorf("error reading from UPlaneConn %s: %w", u.LocalAddr(), err)
 		}
+		if n > bufSize {
+			u.oversize.truncated.Add(1)
+			continue
+		}
 
 		raw := make([]byte, n)
 		copy(raw, buf)
-		go func() {
-			// just forward T-PDU instead of passing it to reader if relayer is
-			// configured and the message type is T-PDU.
-			if len(u.relayMap) != 0 && raw[1] == message.MsgTypeTPDU {
-				// ignore if the packet size is smaller than minimum header size
-				if n < 11 {
-					return
-				}
-
-				u.mu.Lock()
-				peer, ok := u.relayMap[binary.BigEndian.Uint32(raw[4:8])]
-				u.mu.Unlock()
-				if !ok { // pass message to handler if TEID is unknown
-					msg, err := message.Parse(raw[:n])
-					if err != nil {
-						return
-					}
-
-					if err := u.handleMessage(raddr, msg); err != nil {
-						// should not stop serving with this error
-						logf("error handling message on UPlaneConn %s: %v", u.LocalAddr(), err)
-					}
-					return
-				}
-
-				// just use original packet not to get it slow.
-				binary.BigEndian.PutUint32(raw[4:8], peer.teid)
-				if _, err := peer.srcConn.WriteToWithDSCPECN(raw[:n], peer.addr, 0); err != nil {
-					// should not stop serving with this error
-					logf("error sending on UPlaneConn %s: %v", u.LocalAddr(), err)
-				}
-				return
-			}
+		go u.handlePacket(raddr, raw)
+	}
+}
 
-			msg, err := message.Parse(raw[:n])
-			if err != nil {
-				logf("error parsing message on UPlaneConn %s: %v", u.LocalAddr(), err)
-				return
-			}
+// handlePacket handles the packet in raw, which may be retained by the handlers.
+func (u *UPlaneConn) handlePacket(raddr net.Addr, raw []byte) {
+	var in *Policer
+	if len(raw) >= 8 && raw[1] == message.MsgTypeTPDU {
+		// drop T-PDU exceeding the rates set by SetIncomingQoS.
+		var ok bool
+		if in, ok = u.policeIncoming(raw); !ok {
+			return
+		}
 
-			if err := u.handleMessage(raddr, msg); err != nil {
-				// should not stop serving with this error
-				logf("error handling message on UPlaneConn %s: %v", u.LocalAddr(), err)
+		// apply the forwarding rules if any PDR detects T-PDU.
+		if u.handleRuleTPDU(raddr, raw) {
+			return
+		}
+
+		// write T-PDU to the device if it belongs to the userspace tunnels.
+		if u.userspaceEnabled() && u.handleUserspaceTPDU(raw) {
+			return
+		}
+
+		// pass T-PDU to the tunnel registered with its TEID.
+		if u.handleTunnelTPDU(raddr, raw) {
+			return
+		}
+	}
+
+	// just forward T-PDU and End Marker instead of passing it to reader if
+	// relayer is configured for the TEID.
+	if peer, ok := u.relayPeer(raw); ok {
+		defer peer.done()
+		dscpecn := in.dscpecn()
+		if raw[1] == message.MsgTypeTPDU {
+			out := peer.policer()
+			if !out.police(tpduPayloadLen(raw)) {
 				return
 			}
-		}()
+			if out != nil {
+				dscpecn = out.dscpecn()
+			}
+		}
+
+		// just use original packet not to get it slow.
+		binary.BigEndian.PutUint32(raw[4:8], peer.teid)
+		if _, err := peer.srcConn.WriteToWithDSCPECN(raw, peer.addr, dscpecn); err != nil {
+			// should not stop serving with this error
+			logf("error sending on UPlaneConn %s: %v", u.LocalAddr(), err)
+		}
+		return
+	}
+
+	msg, err := message.Parse(raw)
+	if err != nil {
+		logf("error parsing message on UPlaneConn %s: %v", u.LocalAddr(), err)
+		return
+	}
+
+	if err := u.handleMessage(raddr, msg); err != nil {
+		// should not stop serving with this error
+		logf("error handling message on UPlaneConn %s: %v", u.LocalAddr(), err)
 	}
 }
 
@@ -490,13 +628,44 @@ func (u *UPlaneConn) WriteToWithDSCPECN(p []byte, addr net.Addr, dscpecn int) (n
 }
 
 // WriteToGTP writes a packet with TEID and payload to addr.
+//
+// If p exceeds the MTU, it is handled as specified with SetOversizeAction, and
+// ErrPacketTooBig is returned if it is not sent.
+//
+// The Sequence Number is added if it is enabled for the TEID with
+// EnableSequenceNumber, and QoS set with SetQoS is enforced, which makes it
+// return ErrRateExceeded if the T-PDU is dropped.
 func (u *UPlaneConn) WriteToGTP(teid uint32, p []byte, addr net.Addr) (n int, err error) {
-	b, err := Encapsulate(teid, p).Marshal()
-	if err != nil {
+	pdu := Encapsulate(teid, p)
+	if err = u.checkOversize(pdu, addr); err != nil {
 		return
 	}
 
-	if _, err = u.WriteTo(b, addr); err != nil {
+	policer := loadPolicer(&u.qosOut, teid)
+	if !policer.police(len(p)) {
+		err = ErrRateExceeded
+		return
+	}
+
+	return u.writeTPDU(pdu, addr, policer.dscpecn())
+}
+
+// writeTPDU sends the T-PDU to addr with the DSCP/ECN value given, adding the
+// Sequence Number if it is enabled for the TEID.
+func (u *UPlaneConn) writeTPDU(pdu *message.TPDU, addr net.Addr, dscpecn int) (n int, err error) {
+	if seq, ok := u.nextSequence(pdu.TEID()); ok {
+		pdu.SetSequenceNumber(seq)
+	}
+
+	bp := getBuffer(pdu.MarshalLen())
+	defer putBuffer(bp)
+
+	b := *bp
+	if err = pdu.MarshalTo(b); err != nil {
+		return
+	}
+
+	if _, err = u.WriteToWithDSCPECN(b, addr, dscpecn); err != nil {
 		return
 	}
 	return len(b), nil
@@ -625,6 +794,23 @@ func (u *UPlaneConn) EchoResponse(raddr net.Addr) error {
 	return nil
 }
 
+// EndMarker sends End Marker with the TEID given, which indicates the end of
+// the T-PDUs sent to raddr with the TEID.
+func (u *UPlaneConn) EndMarker(raddr net.Addr, teid uint32) error {
+	em := message.NewEndMarker()
+	em.SetTEID(teid)
+
+	b, err := em.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if _, err := u.WriteTo(b, raddr); err != nil {
+		return err
+	}
+	return nil
+}
+
 // ErrorIndication just sends ErrorIndication message.
 func (u *UPlaneConn) ErrorIndication(raddr net.Addr, received message.Message) error {
 	ip, _, err := net.SplitHostPort(u.LocalAddr().String())
@@ -669,51 +855,86 @@ func (u *UPlaneConn) Restarts() uint8 {
 	return 0
 }
 
-// NewFTEID creates a new GTPv2 F-TEID with random TEID value that is unique within UPlaneConn.
+// NewFTEID creates a new GTPv2 F-TEID with TEID value that is unique within UPlaneConn.
 // To ensure the uniqueness, don't create in the other way if you once use this method.
 // This is meant to be used for creating F-TEID IE for non-local interface type, such as
 // the ones that are used in U-Plane. For local interface, use (*Conn).NewSenderFTEID instead.
 //
-// Note that in the case there's a lot of Session on the Conn, it may take a long
-// time to find a new unique value.
-//
-// TODO: optimize performance...
+// The TEID is allocated by the TEIDAllocator of UPlaneConn, and is freed when the
+// tunnel or relay with it is deleted. It returns nil if no TEID is available.
 func (u *UPlaneConn) NewFTEID(ifType uint8, v4, v6 string) (fteidIE *v2ie.IE) {
-	var teid uint32
-	for try := uint32(0); try < 0xffff; try++ {
-		const logEvery = 0xff
-		if try&logEvery == logEvery {
-			logf("Generating NewSenderFTEID crossed tries:%d", try)
+	alloc := u.TEIDAllocator()
+
+	// the TEIDs collided are freed after the loop, not to get the same one
+	// again from the allocator without Quarantine.
+	var collided []uint32
+	defer func() {
+		for _, t := range collided {
+			_ = alloc.Free(t)
 		}
+	}()
 
-		t := generateRandomUint32()
-		if t == 0 {
-			continue
+	for try := 0; try < maxTEIDCollisions; try++ {
+		t, err := alloc.Allocate()
+		if err != nil {
+			logf("failed to allocate TEID-U: %v", err)
+			return nil
 		}
 
 		// Try to mark TEID as taken. Fails if something exists
 		if ok := u.iteiMap.tryStore(t, time.Now()); !ok {
-			logf("TEID-U: %#08x has already been taken, trying to generate another one...", t)
+			logf("TEID-U: %#08x has already been taken, trying to allocate another one...", t)
+			collided = append(collided, t)
 			continue
 		}
 
-		teid = t
-		break
+		return v2ie.NewFullyQualifiedTEID(ifType, t, v4, v6)
 	}
 
-	if teid == 0 {
-		return nil
-	}
-	return v2ie.NewFullyQualifiedTEID(ifType, teid, v4, v6)
+	logf("failed to allocate TEID-U: collided %d times", maxTEIDCollisions)
+	return nil
 }
 
-func generateRandomUint32() uint32 {
-	b := make([]byte, 4)
-	if _, err := rand.Read(b); err != nil {
-		return 0
+// maxTEIDCollisions is the maximum number of tries in NewFTEID when the TEID
+// allocated is already taken in UPlaneConn.
+const maxTEIDCollisions = 0xff
+
+// SetTEIDAllocator sets the teid.Allocator used in NewFTEID.
+//
+// It should be set before the TEIDs are allocated, otherwise the TEIDs allocated
+// by the previous allocator are not freed.
+func (u *UPlaneConn) SetTEIDAllocator(alloc teid.Allocator) {
+	u.mu.Lock()
+	u.teidAllocator = alloc
+	u.mu.Unlock()
+}
+
+// TEIDAllocator returns the teid.Allocator used in NewFTEID.
+//
+// If nothing is set with SetTEIDAllocator, the teid.RangeAllocator that allocates
+// the whole range of TEID with teid.DefaultQuarantine is used.
+func (u *UPlaneConn) TEIDAllocator() teid.Allocator {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.teidAllocator == nil {
+		alloc, _ := teid.NewAllocator(1, math.MaxUint32)
+		alloc.Quarantine = teid.DefaultQuarantine
+		u.teidAllocator = alloc
 	}
+	return u.teidAllocator
+}
+
+func (u *UPlaneConn) freeTEID(t uint32) {
+	u.mu.Lock()
+	alloc := u.teidAllocator
+	u.mu.Unlock()
 
-	return binary.BigEndian.Uint32(b)
+	if alloc == nil || t == 0 {
+		return
+	}
+	// the TEID not from the allocator is just ignored.
+	_ = alloc.Free(t)
 }
 
 type iteiMap struct {
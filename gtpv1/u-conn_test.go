@@ -13,6 +13,8 @@ import (
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/wmnsk/go-gtp/gtpv1"
+	v2 "github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/teid"
 )
 
 type testVal struct {
@@ -103,3 +105,57 @@ func TestClientWrite(t *testing.T) {
 		t.Fatal("timed out while waiting for response to come")
 	}
 }
+
+func TestTEIDAllocator(t *testing.T) {
+	alloc, err := teid.NewPartitionedAllocator(4, 0x5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uConn := gtpv1.NewUPlaneConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2152})
+	uConn.SetTEIDAllocator(alloc)
+
+	fTEID := uConn.NewFTEID(v2.IFTypeS1UeNodeBGTPU, "127.0.0.1", "")
+	if fTEID == nil {
+		t.Fatal("failed to create F-TEID")
+	}
+	itei := fTEID.MustTEID()
+	if itei>>28 != 0x5 {
+		t.Errorf("TEID out of partition: %#08x", itei)
+	}
+
+	if err := uConn.CloseRelay(itei); err != nil {
+		t.Fatal(err)
+	}
+	if got := alloc.InUse(); got != 0 {
+		t.Errorf("TEID not freed on CloseRelay: %d", got)
+	}
+}
+
+func TestTEIDAllocatorCollision(t *testing.T) {
+	uConn := gtpv1.NewUPlaneConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2152})
+
+	// the TEIDs chosen by the user, not from the allocator.
+	for i := uint32(1); i < 64; i++ {
+		if _, err := uConn.RegisterTunnel(i, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alloc, err := teid.NewAllocator(1, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uConn.SetTEIDAllocator(alloc)
+
+	fTEID := uConn.NewFTEID(v2.IFTypeS1UeNodeBGTPU, "127.0.0.1", "")
+	if fTEID == nil {
+		t.Fatal("failed to create F-TEID")
+	}
+	if got := fTEID.MustTEID(); got != 64 {
+		t.Errorf("wrong TEID allocated: %d", got)
+	}
+	if got := alloc.InUse(); got != 1 {
+		t.Errorf("collided TEIDs not freed: %d in use", got)
+	}
+}
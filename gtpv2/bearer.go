@@ -5,6 +5,7 @@
 package gtpv2
 
 import (
+	"encoding/json"
 	"net"
 )
 
@@ -65,3 +66,88 @@ func (b *Bearer) OutgoingTEID() uint32 {
 func (b *Bearer) SetOutgoingTEID(teid uint32) {
 	b.teidOut = teid
 }
+
+// bearerJSON is the serializable form of Bearer.
+type bearerJSON struct {
+	EBI           uint8       `json:"ebi"`
+	APN           string      `json:"apn,omitempty"`
+	SubscriberIP  string      `json:"subscriber_ip,omitempty"`
+	ChargingID    uint32      `json:"charging_id,omitempty"`
+	QoSProfile    *QoSProfile `json:"qos_profile,omitempty"`
+	RemoteAddress *addrJSON   `json:"remote_address,omitempty"`
+	IncomingTEID  uint32      `json:"incoming_teid,omitempty"`
+	OutgoingTEID  uint32      `json:"outgoing_teid,omitempty"`
+}
+
+// MarshalJSON serializes Bearer into JSON, including the remote address and TEIDs.
+func (b *Bearer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&bearerJSON{
+		EBI:           b.EBI,
+		APN:           b.APN,
+		SubscriberIP:  b.SubscriberIP,
+		ChargingID:    b.ChargingID,
+		QoSProfile:    b.QoSProfile,
+		RemoteAddress: newAddrJSON(b.raddr),
+		IncomingTEID:  b.teidIn,
+		OutgoingTEID:  b.teidOut,
+	})
+}
+
+// UnmarshalJSON decodes the JSON serialized with MarshalJSON into Bearer.
+func (b *Bearer) UnmarshalJSON(data []byte) error {
+	j := &bearerJSON{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return err
+	}
+
+	raddr, err := j.RemoteAddress.addr()
+	if err != nil {
+		return err
+	}
+
+	*b = Bearer{
+		raddr:        raddr,
+		teidIn:       j.IncomingTEID,
+		teidOut:      j.OutgoingTEID,
+		EBI:          j.EBI,
+		SubscriberIP: j.SubscriberIP,
+		APN:          j.APN,
+		ChargingID:   j.ChargingID,
+		QoSProfile:   j.QoSProfile,
+	}
+	return nil
+}
+
+// addrJSON is the serializable form of net.Addr.
+type addrJSON struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+func newAddrJSON(a net.Addr) *addrJSON {
+	if a == nil {
+		return nil
+	}
+	return &addrJSON{Network: a.Network(), Address: a.String()}
+}
+
+// addr returns *net.UDPAddr for UDP, or the net.Addr that just holds the network
+// and address for the others.
+func (a *addrJSON) addr() (net.Addr, error) {
+	if a == nil {
+		return nil, nil
+	}
+	switch a.Network {
+	case "udp", "udp4", "udp6":
+		return net.ResolveUDPAddr(a.Network, a.Address)
+	default:
+		return &genericAddr{network: a.Network, address: a.Address}, nil
+	}
+}
+
+type genericAddr struct {
+	network, address string
+}
+
+func (a *genericAddr) Network() string { return a.network }
+func (a *genericAddr) String() string  { return a.address }
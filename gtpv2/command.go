@@ -0,0 +1,294 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// commandSequenceFlag is the most significant bit of the 3-octet SequenceNumber,
+// which is set to 1 in the Command messages.
+//
+// TS29.274 7.6 Reliable Delivery of Signalling Messages;
+// The Sequence Number in a Command message shall have its most significant bit
+// set to 1, and the Triggered Request shall use the same Sequence Number.
+const commandSequenceFlag = 0x800000
+
+// isCommand reports whether the message type is the one of Command messages.
+func isCommand(msgType uint8) bool {
+	switch msgType {
+	case message.MsgTypeModifyBearerCommand,
+		message.MsgTypeDeleteBearerCommand,
+		message.MsgTypeBearerResourceCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTriggeredByCommand reports whether the message type is the one that can be
+// sent in response to a Command message.
+func isTriggeredByCommand(msgType uint8) bool {
+	switch msgType {
+	case message.MsgTypeCreateBearerRequest,
+		message.MsgTypeUpdateBearerRequest,
+		message.MsgTypeDeleteBearerRequest,
+		message.MsgTypeModifyBearerFailureIndication,
+		message.MsgTypeDeleteBearerFailureIndication,
+		message.MsgTypeBearerResourceFailureIndication:
+		return true
+	default:
+		return false
+	}
+}
+
+func commandKey(raddr net.Addr, seq uint32) string {
+	return fmt.Sprintf("%s/%d", raddr, seq)
+}
+
+// SendCommandTo sends a Command message to addr.
+// Like SendMessageTo, it sets the Sequence Number properly and returns the one used
+// in the message, with the most significant bit set to 1 as required for Commands.
+//
+// The request triggered by the Command and the Failure Indication are handled by
+// the HandlerFunc registered for them. Use SendCommandAndWait to wait for them
+// instead.
+func (c *Conn) SendCommandTo(msg message.Message, addr net.Addr) (uint32, error) {
+	return c.sendCommand(msg, addr, nil)
+}
+
+// SendCommandAndWait sends a Command message to addr and waits for the request
+// triggered by it, i.e., Create/Update/Delete Bearer Request, or the Failure
+// Indication, until ctx is done.
+//
+// The message returned is not passed to the HandlerFunc registered for its type,
+// so the caller is responsible for responding to the triggered request. If the
+// Failure Indication is received, it is returned with *CauseNotOKError.
+func (c *Conn) SendCommandAndWait(ctx context.Context, msg message.Message, addr net.Addr) (message.Message, error) {
+	ch := make(chan message.Message, 1)
+	seq, err := c.sendCommand(msg, addr, ch)
+	if err != nil {
+		return nil, err
+	}
+	defer c.commandWaiters.Delete(seq)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case rcvd := <-ch:
+		if cause, ok := failureCauseOf(rcvd); ok {
+			return rcvd, &CauseNotOKError{
+				MsgType: rcvd.MessageTypeName(),
+				Cause:   cause,
+				Msg:     fmt.Sprintf("%s is rejected", msg.MessageTypeName()),
+			}
+		}
+		return rcvd, nil
+	}
+}
+
+// sendCommand sends a Command message with the SequenceNumber for Commands, and
+// registers ch to receive the message triggered by it if ch is not nil.
+func (c *Conn) sendCommand(msg message.Message, addr net.Addr, ch chan message.Message) (uint32, error) {
+	seq := c.IncSequence() | commandSequenceFlag
+	msg.SetSequenceNumber(seq)
+
+	payload, err := message.Marshal(msg)
+	if err != nil {
+		c.DecSequence()
+		return 0, fmt.Errorf("failed to send %T: %w", msg, err)
+	}
+
+	// register before sending, as the response may arrive before WriteTo returns.
+	if ch != nil {
+		c.commandWaiters.Store(seq, ch)
+	}
+	if _, err := c.WriteTo(payload, addr); err != nil {
+		if ch != nil {
+			c.commandWaiters.Delete(seq)
+		}
+		c.DecSequence()
+		return 0, fmt.Errorf("failed to send %T: %w", msg, err)
+	}
+	return seq, nil
+}
+
+// ModifyBearerCommand sends a ModifyBearerCommand with TEID and IEs given, and
+// waits for the Update Bearer Request triggered by it or the Modify Bearer Failure
+// Indication.
+//
+// See SendCommandAndWait for how the message returned is handled.
+func (c *Conn) ModifyBearerCommand(ctx context.Context, teid uint32, sess *Session, ie ...*ie.IE) (message.Message, error) {
+	return c.SendCommandAndWait(ctx, message.NewModifyBearerCommand(teid, 0, ie...), sess.PeerAddr())
+}
+
+// DeleteBearerCommand sends a DeleteBearerCommand with TEID and IEs given, and
+// waits for the Delete Bearer Request triggered by it or the Delete Bearer Failure
+// Indication.
+//
+// See SendCommandAndWait for how the message returned is handled.
+func (c *Conn) DeleteBearerCommand(ctx context.Context, teid uint32, sess *Session, ie ...*ie.IE) (message.Message, error) {
+	return c.SendCommandAndWait(ctx, message.NewDeleteBearerCommand(teid, 0, ie...), sess.PeerAddr())
+}
+
+// deliverTriggered passes msg to the one waiting in SendCommandAndWait, and
+// reports whether it is delivered.
+func (c *Conn) deliverTriggered(msg message.Message) bool {
+	if !isTriggeredByCommand(msg.MessageType()) || msg.Sequence()&commandSequenceFlag == 0 {
+		return false
+	}
+
+	v, ok := c.commandWaiters.LoadAndDelete(msg.Sequence())
+	if !ok {
+		return false
+	}
+	v.(chan message.Message) <- msg
+	return true
+}
+
+// failureCauseOf returns the value of Cause IE if msg is a Failure Indication.
+func failureCauseOf(msg message.Message) (uint8, bool) {
+	var i *ie.IE
+	switch m := msg.(type) {
+	case *message.ModifyBearerFailureIndication:
+		i = m.Cause
+	case *message.DeleteBearerFailureIndication:
+		i = m.Cause
+	case *message.Generic:
+		if m.MessageType() != message.MsgTypeBearerResourceFailureIndication {
+			return 0, false
+		}
+		for _, x := range m.IEs {
+			if x.Type == ie.Cause {
+				i = x
+				break
+			}
+		}
+	default:
+		return 0, false
+	}
+
+	if i == nil {
+		return CauseMandatoryIEMissing, true
+	}
+	cause, err := i.Cause()
+	if err != nil {
+		return CauseMandatoryIEIncorrect, true
+	}
+	return cause, true
+}
+
+// SendTriggeredRequest sends a request(specified with "toBeSent" param) triggered
+// by a Command message(specified with "command" param), e.g., Update Bearer Request
+// triggered by Modify Bearer Command.
+//
+// Unlike SendMessageTo, it reuses the SequenceNumber of the command so that the
+// peer can correlate the request with the command.
+func (c *Conn) SendTriggeredRequest(raddr net.Addr, command, toBeSent message.Message) error {
+	if !isCommand(command.MessageType()) {
+		return &UnexpectedTypeError{Msg: command}
+	}
+
+	if err := c.RespondTo(raddr, command, toBeSent); err != nil {
+		return err
+	}
+	c.markCommandAnswered(raddr, command)
+	return nil
+}
+
+// RejectCommand sends a Failure Indication with the cause and IEs given in response
+// to a Command message, e.g., Modify Bearer Failure Indication in response to Modify
+// Bearer Command.
+//
+// The TEID in the Failure Indication is the one of the peer in the Session looked
+// up by the TEID of the command, or zero if not found.
+//
+// This is called automatically with the cause derived from the error when the
+// HandlerFunc for a Command message returns an error without sending the triggered
+// request or the Failure Indication, so users don't need to call this in most cases.
+func (c *Conn) RejectCommand(raddr net.Addr, command message.Message, cause uint8, ies ...*ie.IE) error {
+	var teid uint32
+	if sess, err := c.GetSessionByTEID(command.TEID(), raddr); err == nil {
+		if peerIfType, ok := peerIfTypeOf(c.localIfType); ok {
+			teid, _ = sess.GetTEID(peerIfType)
+		}
+	}
+
+	ies = append([]*ie.IE{ie.NewCause(cause, 0, 0, 0, nil)}, ies...)
+
+	var fi message.Message
+	switch command.MessageType() {
+	case message.MsgTypeModifyBearerCommand:
+		fi = message.NewModifyBearerFailureIndication(teid, 0, ies...)
+	case message.MsgTypeDeleteBearerCommand:
+		fi = message.NewDeleteBearerFailureIndication(teid, 0, ies...)
+	case message.MsgTypeBearerResourceCommand:
+		fi = message.NewGeneric(message.MsgTypeBearerResourceFailureIndication, teid, 0, ies...)
+	default:
+		return &UnexpectedTypeError{Msg: command}
+	}
+
+	if err := c.RespondTo(raddr, command, fi); err != nil {
+		return err
+	}
+	c.markCommandAnswered(raddr, command)
+	return nil
+}
+
+// markCommandAnswered records that the triggered request or the Failure Indication
+// has been sent for the command being handled, if any.
+func (c *Conn) markCommandAnswered(raddr net.Addr, command message.Message) {
+	key := commandKey(raddr, command.Sequence())
+	if _, ok := c.handlingCommands.Load(key); ok {
+		c.handlingCommands.Store(key, true)
+	}
+}
+
+// handleCommand calls handle with the command, and sends the Failure Indication if
+// it fails without answering to the command.
+func (c *Conn) handleCommand(senderAddr net.Addr, msg message.Message, handle func() error) error {
+	key := commandKey(senderAddr, msg.Sequence())
+	c.handlingCommands.Store(key, false)
+	err := handle()
+	answered, _ := c.handlingCommands.LoadAndDelete(key)
+	if err == nil || answered.(bool) {
+		return err
+	}
+
+	var hnfErr *HandlerNotFoundError
+	if errors.As(err, &hnfErr) {
+		return err
+	}
+	if rerr := c.RejectCommand(senderAddr, msg, causeOf(err)); rerr != nil {
+		logf("failed to send Failure Indication in response to %s: %v", msg.MessageTypeName(), rerr)
+	}
+	return err
+}
+
+// causeOf returns the value of Cause IE that represents err.
+func causeOf(err error) uint8 {
+	var (
+		cnoErr  *CauseNotOKError
+		teidErr *InvalidTEIDError
+		brErr   *BearerNotFoundError
+		ieErr   *RequiredIEMissingError
+	)
+	switch {
+	case errors.As(err, &cnoErr):
+		return cnoErr.Cause
+	case errors.As(err, &teidErr), errors.As(err, &brErr), errors.Is(err, ErrSessionNotFound):
+		return CauseContextNotFound
+	case errors.As(err, &ieErr):
+		return CauseMandatoryIEMissing
+	default:
+		return CauseSystemFailure
+	}
+}
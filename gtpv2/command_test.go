@@ -0,0 +1,210 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func setupCommandTest(t *testing.T) (mme, sgw *gtpv2.Conn, mmeSess *gtpv2.Session) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	n := vnet.New(1)
+	mme, err := n.NewConn("10.0.0.1:2123", gtpv2.IFTypeS11MMEGTPC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgw, err = n.NewConn("10.0.0.2:2123", gtpv2.IFTypeS11S4SGWGTPC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mmeSess = newTestSession(t, "001010000000001")
+	mmeSess.UpdatePeerAddr(sgw.LocalAddr())
+	mme.RegisterSession(0x22222222, mmeSess)
+
+	sgwSess := newTestSession(t, "001010000000001")
+	sgwSess.UpdatePeerAddr(mme.LocalAddr())
+	sgw.RegisterSession(0x11111111, sgwSess)
+
+	for _, c := range []*gtpv2.Conn{mme, sgw} {
+		go func(c *gtpv2.Conn) {
+			_ = c.Serve(ctx)
+		}(c)
+	}
+	return mme, sgw, mmeSess
+}
+
+func TestModifyBearerCommand(t *testing.T) {
+	mme, sgw, sess := setupCommandTest(t)
+
+	cmdSeq := make(chan uint32, 1)
+	sgw.AddHandler(message.MsgTypeModifyBearerCommand, func(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+		cmdSeq <- msg.Sequence()
+		return c.SendTriggeredRequest(
+			senderAddr, msg,
+			message.NewUpdateBearerRequest(0x22222222, 0, ie.NewEPSBearerID(5)),
+		)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rcvd, err := mme.ModifyBearerCommand(ctx, 0x11111111, sess, ie.NewBearerContext(ie.NewEPSBearerID(5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rcvd.(*message.UpdateBearerRequest); !ok {
+		t.Fatalf("got unexpected type of message: %T", rcvd)
+	}
+
+	seq := <-cmdSeq
+	if seq&0x800000 == 0 {
+		t.Errorf("most significant bit of command sequence is not set: %#x", seq)
+	}
+	if rcvd.Sequence() != seq {
+		t.Errorf("wrong sequence in triggered request: got %#x, want %#x", rcvd.Sequence(), seq)
+	}
+}
+
+func TestCommandSequenceWrap(t *testing.T) {
+	mme, sgw, sess := setupCommandTest(t)
+
+	cmdSeq := make(chan uint32, 1)
+	sgw.AddHandler(message.MsgTypeModifyBearerCommand, func(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+		cmdSeq <- msg.Sequence()
+		return c.SendTriggeredRequest(
+			senderAddr, msg,
+			message.NewUpdateBearerRequest(0x22222222, 0, ie.NewEPSBearerID(5)),
+		)
+	})
+
+	// start just before the SequenceNumber wraps around.
+	snapshot := fmt.Sprintf(`{"version":%d,"local_if_type":%d,"sequence":%d}`, gtpv2.SnapshotVersion, gtpv2.IFTypeS11MMEGTPC, 0x7ffffe)
+	if err := mme.Restore(strings.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the requests other than Commands never have the most significant bit set.
+	for _, want := range []uint32{0x7fffff, 0, 1} {
+		if got := mme.IncSequence(); got != want {
+			t.Errorf("wrong sequence: got %#x, want %#x", got, want)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := mme.ModifyBearerCommand(ctx, 0x11111111, sess, ie.NewBearerContext(ie.NewEPSBearerID(5))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-cmdSeq, uint32(0x800002); got != want {
+		t.Errorf("wrong command sequence: got %#x, want %#x", got, want)
+	}
+	if got := mme.IncSequence(); got != 3 {
+		t.Errorf("wrong sequence after command: %#x", got)
+	}
+}
+
+func TestModifyBearerCommandWithPeerUpdate(t *testing.T) {
+	mme, sgw, sess := setupCommandTest(t)
+
+	sgw.AddHandler(message.MsgTypeModifyBearerCommand, func(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+		return c.SendTriggeredRequest(
+			senderAddr, msg,
+			message.NewUpdateBearerRequest(0x22222222, 0, ie.NewEPSBearerID(5)),
+		)
+	})
+
+	// the peer may be updated by a handler while the command is being sent.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				sess.UpdatePeerAddr(sgw.LocalAddr())
+			}
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := mme.ModifyBearerCommand(ctx, 0x11111111, sess, ie.NewBearerContext(ie.NewEPSBearerID(5)))
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeleteBearerCommandFailure(t *testing.T) {
+	mme, sgw, sess := setupCommandTest(t)
+
+	sgw.AddHandler(message.MsgTypeDeleteBearerCommand, func(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+		return &gtpv2.BearerNotFoundError{IMSI: "001010000000001"}
+	})
+
+	cases := []struct {
+		description string
+		teid        uint32
+		fiTEID      uint32
+	}{
+		{"rejected-by-handler", 0x11111111, 0x22222222},
+		{"unknown-teid", 0xdeadbeef, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			rcvd, err := mme.DeleteBearerCommand(ctx, c.teid, sess, ie.NewBearerContext(ie.NewEPSBearerID(6)))
+			var cnoErr *gtpv2.CauseNotOKError
+			if !errors.As(err, &cnoErr) {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if cnoErr.Cause != gtpv2.CauseContextNotFound {
+				t.Errorf("wrong cause: %d", cnoErr.Cause)
+			}
+
+			fi, ok := rcvd.(*message.DeleteBearerFailureIndication)
+			if !ok {
+				t.Fatalf("got unexpected type of message: %T", rcvd)
+			}
+			if fi.TEID() != c.fiTEID {
+				t.Errorf("wrong TEID: got %#x, want %#x", fi.TEID(), c.fiTEID)
+			}
+		})
+	}
+}
+
+func TestSendTriggeredRequestWithNonCommand(t *testing.T) {
+	_, sgw, _ := setupCommandTest(t)
+
+	err := sgw.SendTriggeredRequest(
+		sgw.LocalAddr(), message.NewModifyBearerRequest(0, 1),
+		message.NewUpdateBearerRequest(0, 0),
+	)
+	var typeErr *gtpv2.UnexpectedTypeError
+	if !errors.As(err, &typeErr) {
+		t.Errorf("got unexpected error: %v", err)
+	}
+}
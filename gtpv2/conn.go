@@ -6,17 +6,18 @@ package gtpv2
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wmnsk/go-gtp/gtpv2/ie"
 	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/teid"
 )
 
 // Conn represents a GTPv2-C connection.
@@ -28,14 +29,26 @@ import (
 // connection(=between a node to another).
 // See the docs of CreateSession, AddSession, DeleteSession methods for details.
 type Conn struct {
-	mu      sync.Mutex
-	laddr   net.Addr
-	pktConn net.PacketConn
-	*imsiSessionMap
-	*iteiSessionMap
+	mu          sync.Mutex
+	laddr       net.Addr
+	pktConn     net.PacketConn
+	sessions    SessionStore
+	index       *sessionIndex
 	localIfType uint8
 
-	validationEnabled bool
+	// validationEnabled is accessed atomically, as it can be toggled while
+	// the Conn is serving.
+	validationEnabled atomic.Bool
+	bumpOnRestore     bool
+
+	teidAllocator teid.Allocator
+
+	// commandWaiters is the channels waiting for the messages triggered by the
+	// Commands sent, keyed by the SequenceNumber of the Command.
+	commandWaiters sync.Map
+	// handlingCommands is the Commands being handled, keyed by the sender and
+	// the SequenceNumber, with whether they have been answered.
+	handlingCommands sync.Map
 
 	closeCh chan struct{}
 	*msgHandlerMap
@@ -54,18 +67,30 @@ type Conn struct {
 
 // NewConn creates a new Conn used for server. On client side, use Dial instead.
 func NewConn(laddr net.Addr, localIfType, counter uint8) *Conn {
-	return &Conn{
-		mu:                sync.Mutex{},
-		laddr:             laddr,
-		imsiSessionMap:    newimsiSessionMap(),
-		iteiSessionMap:    newiteiSessionMap(),
-		localIfType:       localIfType,
-		validationEnabled: true,
-		closeCh:           make(chan struct{}),
-		msgHandlerMap:     newDefaultMsgHandlerMap(),
-		sequence:          0,
-		RestartCounter:    counter,
-	}
+	c := &Conn{
+		mu:             sync.Mutex{},
+		laddr:          laddr,
+		sessions:       NewMemorySessionStore(),
+		index:          newSessionIndex(),
+		localIfType:    localIfType,
+		closeCh:        make(chan struct{}),
+		msgHandlerMap:  newDefaultMsgHandlerMap(),
+		sequence:       0,
+		RestartCounter: counter,
+	}
+	c.validationEnabled.Store(true)
+	return c
+}
+
+// NewConnWithPacketConn creates a new Conn that works on top of the given
+// net.PacketConn instead of opening a UDP socket by itself. Listen does nothing
+// on the Conn created by this, and Serve can be called immediately.
+//
+// This is useful to run Conn on an in-memory network in tests.
+func NewConnWithPacketConn(pc net.PacketConn, localIfType, counter uint8) *Conn {
+	c := NewConn(pc.LocalAddr(), localIfType, counter)
+	c.pktConn = pc
+	return c
 }
 
 // Dial sends Echo Request to raddr to check if the endpoint is alive and returns Conn.
@@ -76,17 +101,17 @@ func NewConn(laddr net.Addr, localIfType, counter uint8) *Conn {
 // If Echo exchange is unnecessary, use NewConn and ListenAndServe instead.
 func Dial(ctx context.Context, laddr, raddr net.Addr, localIfType, counter uint8) (*Conn, error) {
 	c := &Conn{
-		mu:                sync.Mutex{},
-		laddr:             laddr,
-		imsiSessionMap:    newimsiSessionMap(),
-		iteiSessionMap:    newiteiSessionMap(),
-		localIfType:       localIfType,
-		validationEnabled: true,
-		closeCh:           make(chan struct{}),
-		msgHandlerMap:     newDefaultMsgHandlerMap(),
-		sequence:          0,
-		RestartCounter:    counter,
-	}
+		mu:             sync.Mutex{},
+		laddr:          laddr,
+		sessions:       NewMemorySessionStore(),
+		index:          newSessionIndex(),
+		localIfType:    localIfType,
+		closeCh:        make(chan struct{}),
+		msgHandlerMap:  newDefaultMsgHandlerMap(),
+		sequence:       0,
+		RestartCounter: counter,
+	}
+	c.validationEnabled.Store(true)
 
 	// setup underlying connection first.
 	// not using net.Dial, as it binds src/dst IP:Port, which makes it harder to
@@ -146,7 +171,9 @@ func (c *Conn) ListenAndServe(ctx context.Context) error {
 func (c *Conn) Listen(ctx context.Context) error {
 	var err error
 	c.mu.Lock()
-	c.pktConn, err = net.ListenPacket(c.laddr.Network(), c.laddr.String())
+	if c.pktConn == nil {
+		c.pktConn, err = net.ListenPacket(c.laddr.Network(), c.laddr.String())
+	}
 	c.mu.Unlock()
 	if err != nil {
 		return err
@@ -310,13 +337,45 @@ func (c *Conn) AddHandlers(funcs map[uint8]HandlerFunc) {
 	}
 }
 
+// Handler returns the handler func added for the type of message, if any.
+func (c *Conn) Handler(msgType uint8) (HandlerFunc, bool) {
+	return c.msgHandlerMap.load(msgType)
+}
+
+// RemoveHandler removes the handler func added for the type of message, which
+// makes the messages of the type ignored and logged.
+func (c *Conn) RemoveHandler(msgType uint8) {
+	c.msgHandlerMap.delete(msgType)
+}
+
 func (c *Conn) handleMessage(senderAddr net.Addr, msg message.Message) error {
-	if c.validationEnabled {
+	if c.validationEnabled.Load() {
 		if err := c.validate(senderAddr, msg); err != nil {
+			// the Command for unknown TEID is rejected, as the peer waits for
+			// the triggered request or Failure Indication.
+			var teidErr *InvalidTEIDError
+			if isCommand(msg.MessageType()) && errors.As(err, &teidErr) {
+				if rerr := c.RejectCommand(senderAddr, msg, CauseContextNotFound); rerr != nil {
+					logf("failed to send Failure Indication in response to %s: %v", msg.MessageTypeName(), rerr)
+				}
+			}
 			return fmt.Errorf("failed to validate %s: %w", msg.MessageTypeName(), err)
 		}
 	}
 
+	if c.deliverTriggered(msg) {
+		return nil
+	}
+
+	if isCommand(msg.MessageType()) {
+		return c.handleCommand(senderAddr, msg, func() error {
+			return c.dispatch(senderAddr, msg)
+		})
+	}
+	return c.dispatch(senderAddr, msg)
+}
+
+func (c *Conn) dispatch(senderAddr net.Addr, msg message.Message) error {
 	handle, ok := c.msgHandlerMap.load(msg.MessageType())
 	if !ok {
 		return &HandlerNotFoundError{MsgType: msg.MessageTypeName()}
@@ -341,20 +400,20 @@ func (c *Conn) handleMessage(senderAddr net.Addr, msg message.Message) error {
 // Even the validation is failed, it does not return error to user. Instead, it just logs
 // and discards the packets so that the HandlerFunc won't get the invalid message.
 // Extra validations should be done in HandlerFunc.
+//
+// It is safe to call this while the Conn is serving.
 func (c *Conn) EnableValidation() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.validationEnabled = true
+	c.validationEnabled.Store(true)
 }
 
 // DisableValidation turns off automatic validation of incoming message.
 // It is not recommended to use this except the node is in debugging mode.
 //
 // See EnableValidation for what are validated.
+//
+// It is safe to call this while the Conn is serving.
 func (c *Conn) DisableValidation() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.validationEnabled = false
+	c.validationEnabled.Store(false)
 }
 
 func (c *Conn) validate(senderAddr net.Addr, msg message.Message) error {
@@ -394,14 +453,21 @@ func (c *Conn) SendMessageTo(msg message.Message, addr net.Addr) (uint32, error)
 	return seq, nil
 }
 
+// maxSequence is the maximum SequenceNumber of the messages other than Commands,
+// as the most significant bit of the 3-octet SequenceNumber is set only in the
+// Commands. See commandSequenceFlag.
+const maxSequence = commandSequenceFlag - 1
+
 // IncSequence increments the SequenceNumber associated with Conn.
+//
+// The SequenceNumber wraps around at 0x7fffff, and the Commands are sent with
+// its most significant bit set.
 func (c *Conn) IncSequence() uint32 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.sequence++
 
-	// SequenceNumber is 3-octet long
-	if c.sequence > 0xffffff {
+	if c.sequence > maxSequence {
 		c.sequence = 0
 	}
 
@@ -412,7 +478,11 @@ func (c *Conn) IncSequence() uint32 {
 func (c *Conn) DecSequence() uint32 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.sequence--
+	if c.sequence == 0 {
+		c.sequence = maxSequence
+	} else {
+		c.sequence--
+	}
 
 	return c.sequence
 }
@@ -502,6 +572,21 @@ func (c *Conn) ParseCreateSession(raddr net.Addr, ies ...*ie.IE) (*Session, erro
 			if err != nil {
 				return nil, err
 			}
+		case ie.FullyQualifiedCSID:
+			f, err := ParseFQCSID(i)
+			if err != nil {
+				return nil, err
+			}
+			switch i.Instance() {
+			case 0:
+				sess.SetFQCSID(CSIDNodeMME, f)
+			case 1:
+				sess.SetFQCSID(CSIDNodeSGW, f)
+			case 2:
+				sess.SetFQCSID(CSIDNodeEPDG, f)
+			case 3:
+				sess.SetFQCSID(CSIDNodeTWAN, f)
+			}
 		case ie.FullyQualifiedTEID:
 			it, err := i.InterfaceType()
 			if err != nil {
@@ -613,7 +698,7 @@ func (c *Conn) CreateSession(raddr net.Addr, ie ...*ie.IE) (*Session, uint32, er
 func (c *Conn) DeleteSession(teid uint32, sess *Session, ie ...*ie.IE) (uint32, error) {
 	msg := message.NewDeleteSessionRequest(teid, 0, ie...)
 
-	seq, err := c.SendMessageTo(msg, sess.peerAddr)
+	seq, err := c.SendMessageTo(msg, sess.PeerAddr())
 	if err != nil {
 		return 0, err
 	}
@@ -624,7 +709,7 @@ func (c *Conn) DeleteSession(teid uint32, sess *Session, ie ...*ie.IE) (uint32,
 func (c *Conn) ModifyBearer(teid uint32, sess *Session, ie ...*ie.IE) (uint32, error) {
 	msg := message.NewModifyBearerRequest(teid, 0, ie...)
 
-	seq, err := c.SendMessageTo(msg, sess.peerAddr)
+	seq, err := c.SendMessageTo(msg, sess.PeerAddr())
 	if err != nil {
 		return 0, err
 	}
@@ -635,7 +720,7 @@ func (c *Conn) ModifyBearer(teid uint32, sess *Session, ie ...*ie.IE) (uint32, e
 func (c *Conn) DeleteBearer(teid uint32, sess *Session, ie ...*ie.IE) (uint32, error) {
 	msg := message.NewDeleteBearerRequest(teid, 0, ie...)
 
-	seq, err := c.SendMessageTo(msg, sess.peerAddr)
+	seq, err := c.SendMessageTo(msg, sess.PeerAddr())
 	if err != nil {
 		return 0, err
 	}
@@ -662,11 +747,11 @@ func (c *Conn) RespondTo(raddr net.Addr, received, toBeSent message.Message) err
 
 // GetSessionByTEID returns Session looked up by TEID and sender of the message.
 func (c *Conn) GetSessionByTEID(teid uint32, peer net.Addr) (*Session, error) {
-	session, ok := c.iteiSessionMap.load(teid)
+	session, ok := c.SessionStore().LoadByTEID(teid)
 	if !ok {
 		return nil, &InvalidTEIDError{TEID: teid}
 	}
-	if peer.String() != session.peerAddrString {
+	if !session.hasPeer(peer) {
 		return nil, &InvalidTEIDError{TEID: teid}
 	}
 	return session, nil
@@ -674,7 +759,7 @@ func (c *Conn) GetSessionByTEID(teid uint32, peer net.Addr) (*Session, error) {
 
 // GetSessionByIMSI returns Session looked up by IMSI.
 func (c *Conn) GetSessionByIMSI(imsi string) (*Session, error) {
-	if session, ok := c.imsiSessionMap.load(imsi); ok {
+	if session, ok := c.SessionStore().LoadByIMSI(imsi); ok {
 		return session, nil
 	}
 	return nil, &UnknownIMSIError{IMSI: imsi}
@@ -697,38 +782,31 @@ func (c *Conn) GetIMSIByTEID(teid uint32, peer net.Addr) (string, error) {
 // e.g., if the Conn is used for S-GW on S11 I/F, itei should be the one
 // with interface type=IFTypeS11S4SGWGTPC.
 func (c *Conn) RegisterSession(itei uint32, session *Session) {
-	c.iteiSessionMap.store(itei, session)
-	c.imsiSessionMap.store(session.IMSI, session)
-
 	session.AddTEID(c.localIfType, itei)
+
+	if err := c.SessionStore().Store(itei, session); err != nil {
+		logf("failed to store session: %v", err)
+	}
+	session.addIndex(c.index)
 }
 
 // RemoveSession removes a session registered in a Conn.
+//
+// The functions added to the session with AddReleaseFunc are called.
 func (c *Conn) RemoveSession(session *Session) {
-	c.imsiSessionMap.delete(session.IMSI)
+	defer session.release()
+	session.removeIndex(c.index)
 
-	itei, err := session.GetTEID(c.localIfType)
-	if err != nil { // if incoming TEID could not be found for some reason
-		logf("failed to find incoming TEID in session: %+v", err)
-
-		c.iteiSessionMap.rangeWithFunc(func(k, v interface{}) bool {
-			s := v.(*Session)
-			if s.IMSI == session.IMSI {
-				c.iteiSessionMap.delete(k.(uint32))
-			}
-			return true
-		})
-
-		return
+	for _, itei := range c.SessionStore().Delete(session) {
+		c.freeTEID(itei)
 	}
-	c.iteiSessionMap.delete(itei)
 }
 
 // RemoveSessionByIMSI removes a session looked up by IMSI.
 //
 // Use RemoveSession instead if you already have the Session in your hand.
 func (c *Conn) RemoveSessionByIMSI(imsi string) {
-	sess, ok := c.imsiSessionMap.load(imsi)
+	sess, ok := c.SessionStore().LoadByIMSI(imsi)
 	if !ok {
 		logf("Session not found by IMSI: %s", imsi)
 		return
@@ -736,57 +814,112 @@ func (c *Conn) RemoveSessionByIMSI(imsi string) {
 	c.RemoveSession(sess)
 }
 
-// NewSenderFTEID creates a new F-TEID with random TEID value that is unique within Conn.
+// SetSessionStore sets the SessionStore that keeps the Sessions registered in Conn.
+//
+// It should be set before any Session is registered, as the Sessions in the
+// previous store are not moved to the new one.
+func (c *Conn) SetSessionStore(store SessionStore) {
+	c.mu.Lock()
+	c.sessions = store
+	c.mu.Unlock()
+}
+
+// SessionStore returns the SessionStore that keeps the Sessions registered in Conn.
+func (c *Conn) SessionStore() SessionStore {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sessions
+}
+
+// NewSenderFTEID creates a new F-TEID with TEID value that is unique within Conn.
 // To ensure the uniqueness, don't create in the other way if you once use this method.
 // This is meant to be used for creating F-TEID IE only for local interface type that is
 // specified at the creation of Conn.
 //
-// Note that in the case there's a lot of Session on the Conn, it may take a long
-// time to find a new unique value.
-//
-// TODO: optimize performance...
+// The TEID is allocated by the TEIDAllocator of Conn, and is freed when the Session
+// registered with it is removed by RemoveSession. It returns nil if no TEID is
+// available.
 func (c *Conn) NewSenderFTEID(v4, v6 string) (fteidIE *ie.IE) {
-	var teid uint32
-	for try := uint32(0); try < 0xffff; try++ {
-		const logEvery = 0xff
-		if try&logEvery == logEvery {
-			logf("Generating NewSenderFTEID crossed tries:%d", try)
+	alloc := c.TEIDAllocator()
+
+	// the TEIDs collided are freed after the loop, not to get the same one
+	// again from the allocator without Quarantine.
+	var collided []uint32
+	defer func() {
+		for _, t := range collided {
+			_ = alloc.Free(t)
 		}
+	}()
 
-		t := generateRandomUint32()
-		if t == 0 {
-			continue
+	for try := 0; try < maxTEIDCollisions; try++ {
+		t, err := alloc.Allocate()
+		if err != nil {
+			logf("failed to allocate TEID: %v", err)
+			return nil
 		}
 
-		// Try to mark TEID as taken. Fails if something exists
-		if ok := c.iteiSessionMap.tryStore(t, nil); !ok {
+		// Try to mark TEID as taken. Fails if something exists, e.g., the one
+		// registered by the user with the TEID not from the allocator.
+		if ok := c.SessionStore().Reserve(t); !ok {
+			collided = append(collided, t)
 			continue
 		}
 
-		teid = t
-		break
+		return ie.NewFullyQualifiedTEID(c.localIfType, t, v4, v6)
 	}
 
-	if teid == 0 {
-		return nil
-	}
-	return ie.NewFullyQualifiedTEID(c.localIfType, teid, v4, v6)
+	logf("failed to allocate TEID: collided %d times", maxTEIDCollisions)
+	return nil
 }
 
-func generateRandomUint32() uint32 {
-	b := make([]byte, 4)
-	if _, err := rand.Read(b); err != nil {
-		return 0
+// maxTEIDCollisions is the maximum number of tries in NewSenderFTEID when the
+// TEID allocated is already registered in Conn.
+const maxTEIDCollisions = 0xff
+
+// SetTEIDAllocator sets the teid.Allocator used in NewSenderFTEID.
+//
+// It should be set before the TEIDs are allocated, otherwise the TEIDs allocated
+// by the previous allocator are not freed.
+func (c *Conn) SetTEIDAllocator(alloc teid.Allocator) {
+	c.mu.Lock()
+	c.teidAllocator = alloc
+	c.mu.Unlock()
+}
+
+// TEIDAllocator returns the teid.Allocator used in NewSenderFTEID.
+//
+// If nothing is set with SetTEIDAllocator, the teid.RangeAllocator that allocates
+// the whole range of TEID with teid.DefaultQuarantine is used.
+func (c *Conn) TEIDAllocator() teid.Allocator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.teidAllocator == nil {
+		alloc, _ := teid.NewAllocator(1, math.MaxUint32)
+		alloc.Quarantine = teid.DefaultQuarantine
+		c.teidAllocator = alloc
 	}
+	return c.teidAllocator
+}
 
-	return binary.BigEndian.Uint32(b)
+func (c *Conn) freeTEID(t uint32) {
+	c.mu.Lock()
+	alloc := c.teidAllocator
+	c.mu.Unlock()
+
+	if alloc == nil || t == 0 {
+		return
+	}
+	// the TEID not from the allocator is just ignored.
+	_ = alloc.Free(t)
 }
 
 // Sessions returns all the sessions registered in Conn.
 func (c *Conn) Sessions() []*Session {
 	var ss []*Session
-	c.imsiSessionMap.rangeWithFunc(func(k, v interface{}) bool {
-		ss = append(ss, v.(*Session))
+	c.SessionStore().Range(func(sess *Session) bool {
+		ss = append(ss, sess)
 		return true
 	})
 
@@ -798,8 +931,7 @@ func (c *Conn) Sessions() []*Session {
 // This may have some impact on performance in case of large number of Session exists.
 func (c *Conn) SessionCount() int {
 	var count int
-	c.imsiSessionMap.rangeWithFunc(func(k, v interface{}) bool {
-		sess := v.(*Session)
+	c.SessionStore().Range(func(sess *Session) bool {
 		if sess.IsActive() {
 			count++
 		}
@@ -814,8 +946,7 @@ func (c *Conn) SessionCount() int {
 // This may have some impact on performance in case of large number of Session and Bearer exist.
 func (c *Conn) BearerCount() int {
 	var count int
-	c.imsiSessionMap.rangeWithFunc(func(k, v interface{}) bool {
-		sess := v.(*Session)
+	c.SessionStore().Range(func(sess *Session) bool {
 		if sess.IsActive() {
 			count += sess.BearerCount()
 		}
@@ -824,64 +955,3 @@ func (c *Conn) BearerCount() int {
 
 	return count
 }
-
-type imsiSessionMap struct {
-	syncMap sync.Map
-}
-
-func newimsiSessionMap() *imsiSessionMap {
-	return &imsiSessionMap{}
-}
-
-func (i *imsiSessionMap) store(imsi string, session *Session) {
-	i.syncMap.Store(imsi, session)
-}
-
-func (i *imsiSessionMap) load(imsi string) (*Session, bool) {
-	session, ok := i.syncMap.Load(imsi)
-	if ok && session != nil {
-		return session.(*Session), true
-	}
-	return nil, ok
-}
-
-func (i *imsiSessionMap) delete(imsi string) {
-	i.syncMap.Delete(imsi)
-}
-
-func (i *imsiSessionMap) rangeWithFunc(fn func(imsi, session interface{}) bool) {
-	i.syncMap.Range(fn)
-}
-
-type iteiSessionMap struct {
-	syncMap sync.Map
-}
-
-func newiteiSessionMap() *iteiSessionMap {
-	return &iteiSessionMap{}
-}
-
-func (t *iteiSessionMap) store(teid uint32, session *Session) {
-	t.syncMap.Store(teid, session)
-}
-
-func (t *iteiSessionMap) tryStore(teid uint32, session *Session) bool {
-	_, loaded := t.syncMap.LoadOrStore(teid, session)
-	return !loaded
-}
-
-func (t *iteiSessionMap) load(teid uint32) (*Session, bool) {
-	session, ok := t.syncMap.Load(teid)
-	if ok && session != nil {
-		return session.(*Session), true
-	}
-	return nil, ok
-}
-
-func (t *iteiSessionMap) delete(teid uint32) {
-	t.syncMap.Delete(teid)
-}
-
-func (t *iteiSessionMap) rangeWithFunc(fn func(imsi, session interface{}) bool) {
-	t.syncMap.Range(fn)
-}
@@ -15,6 +15,7 @@ import (
 	"github.com/wmnsk/go-gtp/gtpv2"
 	"github.com/wmnsk/go-gtp/gtpv2/ie"
 	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/teid"
 )
 
 func setup(ctx context.Context, doneCh chan struct{}) (cliConn, srvConn *gtpv2.Conn, err error) {
@@ -217,3 +218,60 @@ func TestCreateSession(t *testing.T) {
 		t.Fatal("timed out while waiting for validating Create Session Response")
 	}
 }
+
+func TestTEIDAllocator(t *testing.T) {
+	alloc, err := teid.NewPartitionedAllocator(4, 0xa)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := gtpv2.NewConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2123}, gtpv2.IFTypeS11MMEGTPC, 0)
+	conn.SetTEIDAllocator(alloc)
+
+	fTEID := conn.NewSenderFTEID("127.0.0.1", "")
+	if fTEID == nil {
+		t.Fatal("failed to create F-TEID")
+	}
+	itei := fTEID.MustTEID()
+	if itei>>28 != 0xa {
+		t.Errorf("TEID out of partition: %#08x", itei)
+	}
+
+	sess := gtpv2.NewSession(&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 2123}, &gtpv2.Subscriber{IMSI: "001010000000001"})
+	conn.RegisterSession(itei, sess)
+	if got := alloc.InUse(); got != 1 {
+		t.Errorf("wrong number of TEIDs in use: %d", got)
+	}
+
+	conn.RemoveSession(sess)
+	if got := alloc.InUse(); got != 0 {
+		t.Errorf("TEID not freed on RemoveSession: %d", got)
+	}
+}
+
+func TestTEIDAllocatorCollision(t *testing.T) {
+	conn := gtpv2.NewConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2123}, gtpv2.IFTypeS11MMEGTPC, 0)
+
+	// the TEIDs chosen by the user, not from the allocator.
+	for i := uint32(1); i < 64; i++ {
+		sess := gtpv2.NewSession(&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 2123}, &gtpv2.Subscriber{IMSI: "001010000000001"})
+		conn.RegisterSession(i, sess)
+	}
+
+	alloc, err := teid.NewAllocator(1, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetTEIDAllocator(alloc)
+
+	fTEID := conn.NewSenderFTEID("127.0.0.1", "")
+	if fTEID == nil {
+		t.Fatal("failed to create F-TEID")
+	}
+	if got := fTEID.MustTEID(); got != 64 {
+		t.Errorf("wrong TEID allocated: %d", got)
+	}
+	if got := alloc.InUse(); got != 1 {
+		t.Errorf("collided TEIDs not freed: %d in use", got)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Node type definitions of FQ-CSID, which represent the node that allocates the
+// FQ-CSID. The values are the same as the instances of FQ-CSID IEs in Delete PDN
+// Connection Set Request.
+const (
+	CSIDNodeMME uint8 = iota
+	CSIDNodeSGW
+	CSIDNodePGW
+	CSIDNodeEPDG
+	CSIDNodeTWAN
+)
+
+// FQCSID is a Fully Qualified PDN Connection Set Identifier, which is used to
+// identify the set of PDN connections that belongs to a node for the partial
+// failure handling defined in TS 23.007.
+type FQCSID struct {
+	// NodeID is the IP address of the node, or the hex string of the 4 octets
+	// for the other type of Node-ID.
+	NodeID string   `json:"node_id"`
+	CSIDs  []uint16 `json:"csids"`
+}
+
+// NewFQCSID creates a new FQCSID.
+func NewFQCSID(nodeID string, csids ...uint16) *FQCSID {
+	return &FQCSID{NodeID: nodeID, CSIDs: csids}
+}
+
+// ParseFQCSID creates a new FQCSID from FullyQualifiedCSID IE.
+func ParseFQCSID(i *ie.IE) (*FQCSID, error) {
+	f, err := i.FullyQualifiedCSID()
+	if err != nil {
+		return nil, err
+	}
+
+	fq := &FQCSID{CSIDs: f.CSIDs}
+	switch f.NodeIDType {
+	case 0, 1: // IPv4 or IPv6 address
+		fq.NodeID = net.IP(f.NodeID).String()
+	default: // MCC, MNC and the number allocated by the operator
+		fq.NodeID = hex.EncodeToString(f.NodeID)
+	}
+	return fq, nil
+}
+
+// IE returns FullyQualifiedCSID IE with the instance given.
+func (f *FQCSID) IE(instance uint8) *ie.IE {
+	i := ie.NewFullyQualifiedCSID(f.NodeID, f.CSIDs...)
+	if i == nil {
+		return nil
+	}
+	return i.WithInstance(instance)
+}
+
+// String returns FQCSID in human-readable form.
+func (f *FQCSID) String() string {
+	return fmt.Sprintf("%s%v", f.NodeID, f.CSIDs)
+}
+
+// indexKeys returns the keys of FQCSID in the secondary index of Sessions.
+func (f *FQCSID) indexKeys(nodeType uint8) []string {
+	keys := make([]string, len(f.CSIDs))
+	for n, csid := range f.CSIDs {
+		keys[n] = fqcsidIndexKey(nodeType, f.NodeID, csid)
+	}
+	return keys
+}
+
+func fqcsidIndexKey(nodeType uint8, nodeID string, csid uint16) string {
+	return fmt.Sprintf("%d/%s/%d", nodeType, nodeID, csid)
+}
+
+// SetFQCSID sets the FQ-CSID allocated by the type of node given to Session.
+//
+// Setting nil removes the FQ-CSID.
+func (s *Session) SetFQCSID(nodeType uint8, f *FQCSID) {
+	s.mu.Lock()
+	if f == nil {
+		delete(s.fqcsids, nodeType)
+	} else {
+		if s.fqcsids == nil {
+			s.fqcsids = map[uint8]*FQCSID{}
+		}
+		s.fqcsids[nodeType] = f
+	}
+	s.mu.Unlock()
+
+	s.Reindex()
+}
+
+// GetFQCSID returns the FQ-CSID allocated by the type of node given.
+func (s *Session) GetFQCSID(nodeType uint8) (*FQCSID, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.fqcsids[nodeType]
+	return f, ok
+}
+
+// fqcsidKeys returns all the keys of FQ-CSIDs in Session for the secondary index.
+func (s *Session) fqcsidKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for nodeType, f := range s.fqcsids {
+		keys = append(keys, f.indexKeys(nodeType)...)
+	}
+	return keys
+}
+
+// RecordFQCSIDs sets the FQ-CSIDs in msg to Session.
+//
+// The FQ-CSIDs in Create Session Request/Response, Modify Bearer Request and
+// Update PDN Connection Set Request/Response are recorded, and the other messages
+// are just ignored. Note that the FQ-CSIDs in Create Session Request are recorded
+// automatically when the Session is created with CreateSession or ParseCreateSession.
+func (s *Session) RecordFQCSIDs(msg message.Message) error {
+	var fqcsids map[uint8]*ie.IE
+	switch m := msg.(type) {
+	case *message.CreateSessionRequest:
+		fqcsids = map[uint8]*ie.IE{
+			CSIDNodeMME:  m.MMEFQCSID,
+			CSIDNodeSGW:  m.SGWFQCSID,
+			CSIDNodeEPDG: m.EPDGFQCSID,
+			CSIDNodeTWAN: m.TWANFQCSID,
+		}
+	case *message.CreateSessionResponse:
+		fqcsids = map[uint8]*ie.IE{
+			CSIDNodePGW: m.PGWFQCSID,
+			CSIDNodeSGW: m.SGWFQCSID,
+		}
+	case *message.ModifyBearerRequest:
+		fqcsids = map[uint8]*ie.IE{
+			CSIDNodeMME: m.MMEFQCSID,
+			CSIDNodeSGW: m.SGWFQCSID,
+		}
+	case *message.UpdatePDNConnectionSetRequest:
+		fqcsids = map[uint8]*ie.IE{
+			CSIDNodeMME: m.MMEFQCSID,
+			CSIDNodeSGW: m.SGWFQCSID,
+		}
+	case *message.UpdatePDNConnectionSetResponse:
+		fqcsids = map[uint8]*ie.IE{
+			CSIDNodePGW: m.PGWFQCSID,
+		}
+	default:
+		return nil
+	}
+
+	for nodeType, i := range fqcsids {
+		if i == nil {
+			continue
+		}
+		f, err := ParseFQCSID(i)
+		if err != nil {
+			return err
+		}
+		s.SetFQCSID(nodeType, f)
+	}
+	return nil
+}
+
+// GetSessionsByFQCSID returns all the Sessions that have any of the CSIDs in the
+// FQ-CSID allocated by the type of node given.
+func (c *Conn) GetSessionsByFQCSID(nodeType uint8, f *FQCSID) []*Session {
+	seen := map[*Session]bool{}
+	var sessions []*Session
+	for _, k := range f.indexKeys(nodeType) {
+		for _, s := range c.index.lookup(indexFQCSID, k) {
+			if !seen[s] {
+				seen[s] = true
+				sessions = append(sessions, s)
+			}
+		}
+	}
+	return sessions
+}
+
+// DeleteSessionsByFQCSID removes all the Sessions that have any of the CSIDs in the
+// FQ-CSID allocated by the type of node given from Conn, and returns them.
+//
+// It is used when the partial failure of the node is detected, or when Delete PDN
+// Connection Set Request is received. Nothing is sent to the peers.
+func (c *Conn) DeleteSessionsByFQCSID(nodeType uint8, f *FQCSID) []*Session {
+	sessions := c.GetSessionsByFQCSID(nodeType, f)
+	for _, s := range sessions {
+		c.RemoveSession(s)
+	}
+	return sessions
+}
+
+// UpdateSessionsByFQCSID replaces the FQ-CSID allocated by the type of node given
+// with newFQCSID in all the Sessions that have any of the CSIDs in oldFQCSID, and
+// returns them.
+func (c *Conn) UpdateSessionsByFQCSID(nodeType uint8, oldFQCSID, newFQCSID *FQCSID) []*Session {
+	sessions := c.GetSessionsByFQCSID(nodeType, oldFQCSID)
+	for _, s := range sessions {
+		s.SetFQCSID(nodeType, newFQCSID)
+	}
+	return sessions
+}
+
+// DeletePDNConnectionSet sends a DeletePDNConnectionSetRequest with IEs given.
+//
+// The FQ-CSIDs can be created with the IE method of FQCSID, with the node type as
+// the instance.
+func (c *Conn) DeletePDNConnectionSet(raddr net.Addr, ie ...*ie.IE) (uint32, error) {
+	msg := message.NewDeletePDNConnectionSetRequest(0, 0, ie...)
+
+	seq, err := c.SendMessageTo(msg, raddr)
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// EnablePDNConnectionSetDeletion makes Conn handle Delete PDN Connection Set
+// Request by removing all the Sessions in the PDN Connection Sets given, and
+// responding to it.
+//
+// It is disabled by default, as a single request from the peer can remove many
+// Sessions at once. The request is rejected without removing any Session if any
+// of the FQ-CSIDs in it is malformed.
+func (c *Conn) EnablePDNConnectionSetDeletion() {
+	c.AddHandler(message.MsgTypeDeletePDNConnectionSetRequest, handleDeletePDNConnectionSetRequest)
+}
+
+func handleDeletePDNConnectionSetRequest(c *Conn, senderAddr net.Addr, msg message.Message) error {
+	// this should never happen, as the type should have been assured by
+	// msgHandlerMap before this function is called.
+	req, ok := msg.(*message.DeletePDNConnectionSetRequest)
+	if !ok {
+		return &UnexpectedTypeError{Msg: msg}
+	}
+
+	// all the FQ-CSIDs are validated before removing any Session.
+	fqcsids := map[uint8]*FQCSID{}
+	cause := CauseMandatoryIEMissing
+	var err error
+	for nodeType, i := range []*ie.IE{
+		CSIDNodeMME:  req.MMEFQCSID,
+		CSIDNodeSGW:  req.SGWFQCSID,
+		CSIDNodePGW:  req.PGWFQCSID,
+		CSIDNodeEPDG: req.EPDGFQCSID,
+		CSIDNodeTWAN: req.TWANFQCSID,
+	} {
+		if i == nil {
+			continue
+		}
+		f, perr := ParseFQCSID(i)
+		if perr != nil {
+			cause, err = CauseMandatoryIEIncorrect, perr
+			break
+		}
+		cause = CauseRequestAccepted
+		fqcsids[uint8(nodeType)] = f
+	}
+
+	if cause == CauseRequestAccepted {
+		for nodeType, f := range fqcsids {
+			c.DeleteSessionsByFQCSID(nodeType, f)
+		}
+	}
+
+	if rerr := c.RespondTo(
+		senderAddr, msg, message.NewDeletePDNConnectionSetResponse(
+			0, 0, ie.NewCause(cause, 0, 0, 0, nil), ie.NewRecovery(c.RestartCounter),
+		),
+	); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+func handleUpdatePDNConnectionSetRequest(c *Conn, senderAddr net.Addr, msg message.Message) error {
+	// this should never happen, as the type should have been assured by
+	// msgHandlerMap before this function is called.
+	req, ok := msg.(*message.UpdatePDNConnectionSetRequest)
+	if !ok {
+		return &UnexpectedTypeError{Msg: msg}
+	}
+
+	sess, err := c.GetSessionByTEID(req.TEID(), senderAddr)
+	if err != nil {
+		if rerr := c.RespondTo(
+			senderAddr, msg, message.NewUpdatePDNConnectionSetResponse(
+				0, 0, ie.NewCause(CauseContextNotFound, 0, 0, 0, nil),
+			),
+		); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+
+	cause := CauseRequestAccepted
+	if err = sess.RecordFQCSIDs(req); err != nil {
+		cause = CauseMandatoryIEIncorrect
+	}
+
+	var teid uint32
+	if peerIfType, ok := peerIfTypeOf(c.localIfType); ok {
+		teid, _ = sess.GetTEID(peerIfType)
+	}
+	ies := []*ie.IE{ie.NewCause(cause, 0, 0, 0, nil)}
+	if f, ok := sess.GetFQCSID(CSIDNodePGW); ok && cause == CauseRequestAccepted {
+		ies = append(ies, f.IE(0))
+	}
+	ies = append(ies, ie.NewRecovery(c.RestartCounter))
+
+	if rerr := c.RespondTo(
+		senderAddr, msg, message.NewUpdatePDNConnectionSetResponse(teid, 0, ies...),
+	); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// peerIfTypeOf returns the interface type of the peer that sends Update PDN
+// Connection Set Request to the node with localIfType.
+func peerIfTypeOf(localIfType uint8) (uint8, bool) {
+	switch localIfType {
+	case IFTypeS11S4SGWGTPC:
+		return IFTypeS11MMEGTPC, true
+	case IFTypeS5S8PGWGTPC:
+		return IFTypeS5S8SGWGTPC, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func TestFQCSID(t *testing.T) {
+	for _, f := range []*gtpv2.FQCSID{
+		gtpv2.NewFQCSID("192.0.2.1", 1, 2),
+		gtpv2.NewFQCSID("2001:db8::1", 3),
+		gtpv2.NewFQCSID("0010f001", 4),
+	} {
+		got, err := gtpv2.ParseFQCSID(f.IE(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(f, got); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestSessionsByFQCSID(t *testing.T) {
+	conn := gtpv2.NewConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2123}, gtpv2.IFTypeS11S4SGWGTPC, 0)
+
+	// the FQ-CSIDs in Create Session Request are recorded.
+	sess1, err := conn.ParseCreateSession(
+		&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 2123},
+		ie.NewIMSI("001010000000001"),
+		ie.NewFullyQualifiedTEID(gtpv2.IFTypeS11S4SGWGTPC, 0x11111111, "127.0.0.1", ""),
+		gtpv2.NewFQCSID("192.0.2.1", 1).IE(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := sess1.GetFQCSID(gtpv2.CSIDNodeMME); !ok || f.String() != "192.0.2.1[1]" {
+		t.Errorf("FQ-CSID not recorded: %v", f)
+	}
+
+	sess2 := newTestSession(t, "001010000000002")
+	conn.RegisterSession(0x22222222, sess2)
+	if err := sess2.RecordFQCSIDs(message.NewCreateSessionResponse(
+		0, 0, gtpv2.NewFQCSID("192.0.2.3", 7).IE(0),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	sess2.SetFQCSID(gtpv2.CSIDNodeMME, gtpv2.NewFQCSID("192.0.2.1", 2))
+
+	if got := conn.GetSessionsByFQCSID(gtpv2.CSIDNodeMME, gtpv2.NewFQCSID("192.0.2.1", 1, 2)); len(got) != 2 {
+		t.Errorf("wrong number of sessions: %d", len(got))
+	}
+	if got := conn.GetSessionsByFQCSID(gtpv2.CSIDNodePGW, gtpv2.NewFQCSID("192.0.2.3", 7)); len(got) != 1 || got[0] != sess2 {
+		t.Errorf("wrong sessions: %v", got)
+	}
+	// the same CSID from the other type of node does not match.
+	if got := conn.GetSessionsByFQCSID(gtpv2.CSIDNodeSGW, gtpv2.NewFQCSID("192.0.2.1", 1)); len(got) != 0 {
+		t.Errorf("wrong number of sessions: %d", len(got))
+	}
+
+	updated := conn.UpdateSessionsByFQCSID(gtpv2.CSIDNodeMME, gtpv2.NewFQCSID("192.0.2.1", 2), gtpv2.NewFQCSID("192.0.2.2", 9))
+	if len(updated) != 1 || updated[0] != sess2 {
+		t.Errorf("wrong sessions updated: %v", updated)
+	}
+	if got := conn.GetSessionsByFQCSID(gtpv2.CSIDNodeMME, gtpv2.NewFQCSID("192.0.2.2", 9)); len(got) != 1 {
+		t.Errorf("wrong number of sessions: %d", len(got))
+	}
+
+	deleted := conn.DeleteSessionsByFQCSID(gtpv2.CSIDNodeMME, gtpv2.NewFQCSID("192.0.2.1", 1))
+	if len(deleted) != 1 || deleted[0] != sess1 {
+		t.Errorf("wrong sessions deleted: %v", deleted)
+	}
+	if got := conn.SessionCount(); got != 1 {
+		t.Errorf("wrong number of sessions: %d", got)
+	}
+}
+
+func TestDeletePDNConnectionSet(t *testing.T) {
+	cases := []struct {
+		description string
+		ies         []*ie.IE
+		cause       uint8
+		left        []string
+	}{
+		{
+			"accepted",
+			[]*ie.IE{gtpv2.NewFQCSID("10.0.0.1", 1).IE(gtpv2.CSIDNodeMME)},
+			gtpv2.CauseRequestAccepted,
+			[]string{"001010000000003"},
+		}, {
+			"malformed",
+			[]*ie.IE{
+				gtpv2.NewFQCSID("10.0.0.1", 1).IE(gtpv2.CSIDNodeMME),
+				ie.New(ie.FullyQualifiedCSID, gtpv2.CSIDNodeSGW, []byte{0x01, 0x0a}),
+			},
+			gtpv2.CauseMandatoryIEIncorrect,
+			[]string{"001010000000001", "001010000000002", "001010000000003"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			n := vnet.New(1)
+			mme, err := n.NewConn("10.0.0.1:2123", gtpv2.IFTypeS11MMEGTPC, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sgw, err := n.NewConn("10.0.0.2:2123", gtpv2.IFTypeS11S4SGWGTPC, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sgw.EnablePDNConnectionSetDeletion()
+
+			for _, imsi := range []string{"001010000000001", "001010000000002", "001010000000003"} {
+				sess := newTestSession(t, imsi)
+				sess.UpdatePeerAddr(mme.LocalAddr())
+				csid := uint16(1)
+				if imsi == "001010000000003" {
+					csid = 2
+				}
+				sess.SetFQCSID(gtpv2.CSIDNodeMME, gtpv2.NewFQCSID("10.0.0.1", csid))
+				sgw.RegisterSession(sgw.NewSenderFTEID("10.0.0.2", "").MustTEID(), sess)
+			}
+
+			rspCh := make(chan *message.DeletePDNConnectionSetResponse, 1)
+			mme.AddHandler(message.MsgTypeDeletePDNConnectionSetResponse, func(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+				rspCh <- msg.(*message.DeletePDNConnectionSetResponse)
+				return nil
+			})
+			for _, c := range []*gtpv2.Conn{mme, sgw} {
+				go func(c *gtpv2.Conn) {
+					_ = c.Serve(ctx)
+				}(c)
+			}
+
+			if _, err := mme.DeletePDNConnectionSet(sgw.LocalAddr(), c.ies...); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case rsp := <-rspCh:
+				if cause := rsp.Cause.MustCause(); cause != c.cause {
+					t.Errorf("wrong cause: %d", cause)
+				}
+				if rc := rsp.Recovery.MustRecovery(); rc != 3 {
+					t.Errorf("wrong restart counter: %d", rc)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out while waiting for Delete PDN Connection Set Response")
+			}
+
+			if diff := cmp.Diff(imsisOf(sgw.Sessions()), c.left); diff != "" {
+				t.Errorf("wrong sessions left: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,477 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package dlbuffer provides the downlink data buffering of S-GW for the UEs in
+// idle mode, with Downlink Data Notification to trigger paging.
+//
+// After Release Access Bearers, the S-GW holds the bearers with Buffer, which
+// buffers the downlink T-PDUs coming from P-GW instead of relaying them to eNB,
+// and sends Downlink Data Notification to MME. When the UE gets connected and
+// Modify Bearer Request comes with the new eNB F-TEID, the buffered T-PDUs are
+// flushed to the eNB and the relay is resumed.
+package dlbuffer
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	v1msg "github.com/wmnsk/go-gtp/gtpv1/message"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Default limits of the buffer per bearer.
+const (
+	DefaultMaxPackets = 128
+	DefaultMaxBytes   = 256 * 1024
+	DefaultTimeout    = 10 * time.Second
+)
+
+// Error definitions.
+var (
+	ErrNotHeld = errors.New("bearer is not held in the buffer")
+)
+
+// packet is a T-PDU buffered.
+type packet struct {
+	payload []byte
+	arrival time.Time
+}
+
+// bearerBuffer is the buffer of a bearer held.
+type bearerBuffer struct {
+	session *gtpv2.Session
+	ebi     uint8
+	qos     *gtpv2.QoSProfile
+
+	packets []*packet
+	bytes   int
+
+	// flushed is set after the buffer is flushed, to forward the T-PDUs
+	// that are received before the relay is resumed.
+	flushed  bool
+	dstTEID  uint32
+	dstAddr  net.Addr
+	released *time.Timer
+}
+
+// priorityLevel returns the ARP priority level of the bearer, or the lowest
+// priority if unknown.
+func (b *bearerBuffer) priorityLevel() uint8 {
+	if b.qos == nil || b.qos.PL == 0 {
+		return 15
+	}
+	return b.qos.PL
+}
+
+// sessionState is the state of Downlink Data Notification of a Session.
+type sessionState struct {
+	// notified is the ARP priority level of the bearer notified with the
+	// last Downlink Data Notification, or zero if not notified.
+	notified uint8
+
+	// delay is the Data Notification Delay given by MME, and delayed is the
+	// Downlink Data Notification waiting for the delay to expire.
+	delay   time.Duration
+	delayed *time.Timer
+}
+
+// Buffer buffers the downlink T-PDUs per bearer while the S1-U is released, and
+// notifies MME of the downlink data with Downlink Data Notification.
+//
+// The bearers are identified by the incoming TEID on S5-U(=the TEID that P-GW
+// uses to send T-PDUs to S-GW).
+type Buffer struct {
+	// MaxPackets and MaxBytes are the limits of the number of packets and the
+	// total size of payload buffered per bearer. The packets exceeding the
+	// limits are dropped. Zero means unlimited.
+	MaxPackets int
+	MaxBytes   int
+
+	// Timeout is how long the packets are kept in the buffer. The expired
+	// packets are dropped instead of being flushed.
+	Timeout time.Duration
+
+	// ThrottlingExemptPriority is the ARP priority level, with which and the
+	// ones with higher priority(=smaller value) the bearers are not subject to
+	// the throttling requested by MME in DL Low Priority Traffic Throttling IE.
+	ThrottlingExemptPriority uint8
+
+	mu        sync.Mutex
+	s11Conn   *gtpv2.Conn
+	s1uConn   *gtpv1.UPlaneConn
+	s5uConn   *gtpv1.UPlaneConn
+	bearers   map[uint32]*bearerBuffer
+	sessions  map[*gtpv2.Session]*sessionState
+	throttled time.Time
+	factor    uint8
+	acc       int
+}
+
+// NewBuffer creates a new Buffer that sends Downlink Data Notification over
+// s11Conn and flushes the T-PDUs received on s5uConn to eNB over s1uConn.
+//
+// HandleTPDU and HandleDownlinkDataNotificationAcknowledge should be registered
+// as the HandlerFunc of s5uConn and s11Conn respectively.
+func NewBuffer(s11Conn *gtpv2.Conn, s1uConn, s5uConn *gtpv1.UPlaneConn) *Buffer {
+	return &Buffer{
+		MaxPackets: DefaultMaxPackets,
+		MaxBytes:   DefaultMaxBytes,
+		Timeout:    DefaultTimeout,
+		s11Conn:    s11Conn,
+		s1uConn:    s1uConn,
+		s5uConn:    s5uConn,
+		bearers:    map[uint32]*bearerBuffer{},
+		sessions:   map[*gtpv2.Session]*sessionState{},
+	}
+}
+
+// Hold starts buffering the T-PDUs of the bearer with s5uTEID, which is typically
+// called for each bearer in the Session on S11 when Release Access Bearers Request
+// is received.
+//
+// The relay on S5-U to the eNB is paused, and the first T-PDU for the bearer
+// triggers Downlink Data Notification with the EBI and ARP of bearer.
+func (b *Buffer) Hold(session *gtpv2.Session, bearer *gtpv2.Bearer, s5uTEID uint32) error {
+	if err := b.s5uConn.PauseRelay(s5uTEID); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if old, ok := b.bearers[s5uTEID]; ok && old.released != nil {
+		old.released.Stop()
+	}
+	b.bearers[s5uTEID] = &bearerBuffer{session: session, ebi: bearer.EBI, qos: bearer.QoSProfile}
+	if _, ok := b.sessions[session]; !ok {
+		b.sessions[session] = &sessionState{}
+	}
+	return nil
+}
+
+// Flush sends the T-PDUs buffered for the bearer with s5uTEID to the eNB with
+// the TEID and address given, and resumes relaying on S5-U, which is typically
+// called when Modify Bearer Request with the new eNB F-TEID is received.
+func (b *Buffer) Flush(s5uTEID, enbTEID uint32, enbAddr net.Addr) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.bearers[s5uTEID]
+	if !ok || br.flushed {
+		return ErrNotHeld
+	}
+
+	b.expire(br, time.Now())
+	for _, p := range br.packets {
+		if _, err := b.s1uConn.WriteToGTP(enbTEID, p.payload, enbAddr); err != nil {
+			return err
+		}
+	}
+	br.packets, br.bytes = nil, 0
+
+	if err := b.s5uConn.RelayTo(b.s1uConn, s5uTEID, enbTEID, enbAddr); err != nil {
+		return err
+	}
+
+	// keep forwarding the T-PDUs passed to HandleTPDU before the relay is
+	// resumed for a while, not to send Error Indication for them.
+	br.flushed, br.dstTEID, br.dstAddr = true, enbTEID, enbAddr
+	br.released = time.AfterFunc(time.Second, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.bearers[s5uTEID] == br {
+			delete(b.bearers, s5uTEID)
+		}
+	})
+
+	b.resetSessionIfDone(br.session)
+	return nil
+}
+
+// Discard drops the T-PDUs buffered for the bearer with s5uTEID and stops holding
+// it, which is typically called when the Session is deleted or the paging fails.
+func (b *Buffer) Discard(s5uTEID uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.bearers[s5uTEID]
+	if !ok {
+		return
+	}
+	if br.released != nil {
+		br.released.Stop()
+	}
+	delete(b.bearers, s5uTEID)
+	b.resetSessionIfDone(br.session)
+}
+
+// DiscardSession drops the T-PDUs buffered for all the bearers in session, stops
+// holding them and forgets the Data Notification Delay given for session, which
+// should be called when the Session is deleted.
+func (b *Buffer) DiscardSession(session *gtpv2.Session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for teid, br := range b.bearers {
+		if br.session != session {
+			continue
+		}
+		if br.released != nil {
+			br.released.Stop()
+		}
+		delete(b.bearers, teid)
+	}
+	if st, ok := b.sessions[session]; ok && st.delayed != nil {
+		st.delayed.Stop()
+	}
+	delete(b.sessions, session)
+}
+
+// dropSession drops the T-PDUs buffered for all the bearers in session, keeping
+// them held.
+func (b *Buffer) dropSession(session *gtpv2.Session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, br := range b.bearers {
+		if br.session == session && !br.flushed {
+			br.packets, br.bytes = nil, 0
+		}
+	}
+	if st, ok := b.sessions[session]; ok {
+		st.notified = 0
+	}
+}
+
+// Buffered returns the number of T-PDUs buffered for the bearer with s5uTEID.
+func (b *Buffer) Buffered(s5uTEID uint32) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.bearers[s5uTEID]
+	if !ok {
+		return 0
+	}
+	b.expire(br, time.Now())
+	return len(br.packets)
+}
+
+// resetSessionIfDone resets the state of Downlink Data Notification of session
+// if none of its bearers are held anymore. b.mu must be held.
+func (b *Buffer) resetSessionIfDone(session *gtpv2.Session) {
+	for _, br := range b.bearers {
+		if br.session == session && !br.flushed {
+			return
+		}
+	}
+
+	st, ok := b.sessions[session]
+	if !ok {
+		return
+	}
+	if st.delayed != nil {
+		st.delayed.Stop()
+		st.delayed = nil
+	}
+	st.notified = 0
+}
+
+// expire drops the packets that have been in the buffer longer than Timeout.
+// b.mu must be held.
+func (b *Buffer) expire(br *bearerBuffer, now time.Time) {
+	if b.Timeout <= 0 {
+		return
+	}
+
+	n := 0
+	for n < len(br.packets) && now.Sub(br.packets[n].arrival) > b.Timeout {
+		br.bytes -= len(br.packets[n].payload)
+		n++
+	}
+	br.packets = br.packets[n:]
+}
+
+// HandleTPDU is the HandlerFunc for T-PDU on S5-U, which buffers the T-PDUs for
+// the bearers held. The T-PDUs for the other TEIDs are responded with Error
+// Indication, like the default handler of UPlaneConn.
+func (b *Buffer) HandleTPDU(c gtpv1.Conn, senderAddr net.Addr, msg v1msg.Message) error {
+	pdu, ok := msg.(*v1msg.TPDU)
+	if !ok {
+		return gtpv1.ErrUnexpectedType
+	}
+
+	b.mu.Lock()
+	br, ok := b.bearers[pdu.TEID()]
+	if !ok {
+		b.mu.Unlock()
+		return b.s5uConn.ErrorIndication(senderAddr, pdu)
+	}
+	if br.flushed {
+		teid, addr := br.dstTEID, br.dstAddr
+		b.mu.Unlock()
+		_, err := b.s1uConn.WriteToGTP(teid, pdu.Decapsulate(), addr)
+		return err
+	}
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.expire(br, now)
+
+	payload := pdu.Decapsulate()
+	if b.MaxPackets > 0 && len(br.packets) >= b.MaxPackets {
+		return nil
+	}
+	if b.MaxBytes > 0 && br.bytes+len(payload) > b.MaxBytes {
+		return nil
+	}
+	br.packets = append(br.packets, &packet{payload: append([]byte(nil), payload...), arrival: now})
+	br.bytes += len(payload)
+
+	return b.notify(br, now)
+}
+
+// notify sends Downlink Data Notification for the bearer, unless the Session has
+// been notified for the bearer with the same or higher priority. b.mu must be held.
+func (b *Buffer) notify(br *bearerBuffer, now time.Time) error {
+	st := b.sessions[br.session]
+	pl := br.priorityLevel()
+	if st.notified != 0 && st.notified <= pl {
+		return nil
+	}
+
+	if b.throttle(pl, now) {
+		// the packets are dropped along with the notification.
+		br.packets, br.bytes = nil, 0
+		return nil
+	}
+
+	st.notified = pl
+	if st.delay > 0 {
+		if st.delayed != nil {
+			st.delayed.Stop()
+		}
+		st.delayed = time.AfterFunc(st.delay, func() {
+			if err := b.sendDDN(br); err != nil {
+				// let the next packet retry.
+				b.mu.Lock()
+				st.notified = 0
+				b.mu.Unlock()
+			}
+		})
+		return nil
+	}
+	if err := b.sendDDN(br); err != nil {
+		st.notified = 0
+		return err
+	}
+	return nil
+}
+
+// throttle reports whether the Downlink Data Notification for the bearer with the
+// ARP priority level should be dropped by throttling. b.mu must be held.
+func (b *Buffer) throttle(pl uint8, now time.Time) bool {
+	if b.factor == 0 || now.After(b.throttled) || pl <= b.ThrottlingExemptPriority {
+		return false
+	}
+
+	// drop the factor% of notifications evenly.
+	b.acc += int(b.factor)
+	if b.acc >= 100 {
+		b.acc -= 100
+		return true
+	}
+	return false
+}
+
+func (b *Buffer) sendDDN(br *bearerBuffer) error {
+	mmeTEID, err := br.session.GetTEID(gtpv2.IFTypeS11MMEGTPC)
+	if err != nil {
+		return err
+	}
+
+	ies := []*ie.IE{ie.NewEPSBearerID(br.ebi)}
+	if br.qos != nil {
+		ies = append(ies, ie.NewAllocationRetensionPriority(
+			boolToUint8(br.qos.PCI), br.qos.PL, boolToUint8(br.qos.PVI),
+		))
+	}
+
+	_, err = b.s11Conn.SendMessageTo(
+		message.NewDownlinkDataNotification(mmeTEID, 0, ies...), br.session.PeerAddr(),
+	)
+	return err
+}
+
+// HandleDownlinkDataNotificationAcknowledge is the HandlerFunc for Downlink Data
+// Notification Acknowledge on S11.
+//
+// If the Cause is not accepted, the T-PDUs buffered for the Session are dropped,
+// and the next T-PDU triggers Downlink Data Notification again. The Data
+// Notification Delay is applied to the subsequent Downlink Data Notifications for
+// the Session, and the DL Low Priority Traffic Throttling is applied to all the
+// Sessions for the duration given.
+func (b *Buffer) HandleDownlinkDataNotificationAcknowledge(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+	ack, ok := msg.(*message.DownlinkDataNotificationAcknowledge)
+	if !ok {
+		return &gtpv2.UnexpectedTypeError{Msg: msg}
+	}
+
+	session, err := c.GetSessionByTEID(ack.TEID(), senderAddr)
+	if err != nil {
+		return err
+	}
+
+	if ack.Cause == nil {
+		return &gtpv2.RequiredIEMissingError{Type: ie.Cause}
+	}
+	cause, err := ack.Cause.Cause()
+	if err != nil {
+		return err
+	}
+
+	if ack.DLLowPriorityTrafficThrottling != nil {
+		f, err := ack.DLLowPriorityTrafficThrottling.Throttling()
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.throttled = time.Now().Add(f.DelayValue)
+		b.factor, b.acc = f.Factor, 0
+		b.mu.Unlock()
+	}
+
+	if cause != gtpv2.CauseRequestAccepted {
+		b.dropSession(session)
+		return &gtpv2.CauseNotOKError{
+			MsgType: msg.MessageTypeName(),
+			Cause:   cause,
+			Msg:     "buffered packets are discarded",
+		}
+	}
+
+	if ack.DataNotificationDelay != nil {
+		delay, err := ack.DataNotificationDelay.DelayValue()
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		if st, ok := b.sessions[session]; ok {
+			st.delay = delay
+		}
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,247 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package dlbuffer_test
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	v1msg "github.com/wmnsk/go-gtp/gtpv1/message"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/dlbuffer"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+const (
+	sgwS11TEID = 0x11111111
+	mmeS11TEID = 0x22222222
+	sgwS5UTEID = 0x33333333
+	enbS1UTEID = 0x44444444
+)
+
+type testEnv struct {
+	buf     *dlbuffer.Buffer
+	pgw     *gtpv1.UPlaneConn
+	s5u     *gtpv1.UPlaneConn
+	enb     *gtpv1.UPlaneConn
+	session *gtpv2.Session
+	bearer  *gtpv2.Bearer
+	ddnCh   chan *message.DownlinkDataNotification
+}
+
+// setup creates the S-GW with Buffer, and the MME that responds to Downlink Data
+// Notification with the IEs given.
+func setup(t *testing.T, ackIEs ...*ie.IE) *testEnv {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	n := vnet.New(1)
+	mme, err := n.NewConn("10.0.0.1:2123", gtpv2.IFTypeS11MMEGTPC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s11, err := n.NewConn("10.0.0.2:2123", gtpv2.IFTypeS11S4SGWGTPC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &testEnv{ddnCh: make(chan *message.DownlinkDataNotification, 10)}
+	var us []*gtpv1.UPlaneConn
+	for _, addr := range []string{"10.0.0.3:2152", "10.0.0.2:2152", "10.0.0.4:2152", "10.0.0.5:2152"} {
+		u, err := n.NewUPlaneConn(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		us = append(us, u)
+	}
+	var s1u *gtpv1.UPlaneConn
+	env.pgw, env.s5u, s1u, env.enb = us[0], us[1], us[2], us[3]
+	env.enb.DisableErrorIndication()
+
+	env.buf = dlbuffer.NewBuffer(s11, s1u, env.s5u)
+	env.s5u.AddHandler(v1msg.MsgTypeTPDU, env.buf.HandleTPDU)
+	s11.AddHandler(message.MsgTypeDownlinkDataNotificationAcknowledge, env.buf.HandleDownlinkDataNotificationAcknowledge)
+
+	mmeSess := gtpv2.NewSession(s11.LocalAddr(), &gtpv2.Subscriber{IMSI: "001010000000001"})
+	mmeSess.AddTEID(gtpv2.IFTypeS11S4SGWGTPC, sgwS11TEID)
+	mme.RegisterSession(mmeS11TEID, mmeSess)
+	mme.AddHandler(message.MsgTypeDownlinkDataNotification, func(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+		env.ddnCh <- msg.(*message.DownlinkDataNotification)
+		ies := append([]*ie.IE{ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil)}, ackIEs...)
+		return c.RespondTo(senderAddr, msg, message.NewDownlinkDataNotificationAcknowledge(sgwS11TEID, 0, ies...))
+	})
+
+	env.session = gtpv2.NewSession(mme.LocalAddr(), &gtpv2.Subscriber{IMSI: "001010000000001"})
+	env.session.AddTEID(gtpv2.IFTypeS11MMEGTPC, mmeS11TEID)
+	env.bearer = gtpv2.NewBearer(5, "internet", &gtpv2.QoSProfile{PL: 2, PVI: true, QCI: 9})
+	env.session.AddBearer("default", env.bearer)
+	s11.RegisterSession(sgwS11TEID, env.session)
+
+	for _, c := range []*gtpv2.Conn{mme, s11} {
+		go func(c *gtpv2.Conn) {
+			_ = c.Serve(ctx)
+		}(c)
+	}
+	for _, u := range us {
+		go func(u *gtpv1.UPlaneConn) {
+			_ = u.ListenAndServe(ctx)
+		}(u)
+	}
+	return env
+}
+
+func (env *testEnv) sendDownlink(t *testing.T, payloads ...string) {
+	t.Helper()
+	for _, p := range payloads {
+		if _, err := env.pgw.WriteToGTP(sgwS5UTEID, []byte(p), env.s5u.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (env *testEnv) waitBuffered(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for env.buf.Buffered(sgwS5UTEID) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out while waiting for %d packets buffered, got %d", n, env.buf.Buffered(sgwS5UTEID))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (env *testEnv) receive(t *testing.T, n int) []string {
+	t.Helper()
+	var got []string
+	buf := make([]byte, 1500)
+	for i := 0; i < n; i++ {
+		l, _, teid, err := env.enb.ReadFromGTP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if teid != enbS1UTEID {
+			t.Errorf("wrong TEID: %#x", teid)
+		}
+		got = append(got, string(buf[:l]))
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestBuffer(t *testing.T) {
+	env := setup(t)
+	if err := env.buf.Hold(env.session, env.bearer, sgwS5UTEID); err != nil {
+		t.Fatal(err)
+	}
+
+	env.sendDownlink(t, "pkt1", "pkt2", "pkt3")
+
+	select {
+	case ddn := <-env.ddnCh:
+		if ddn.TEID() != mmeS11TEID {
+			t.Errorf("wrong TEID: %#x", ddn.TEID())
+		}
+		if ebi := ddn.EPSBearerID.MustEPSBearerID(); ebi != 5 {
+			t.Errorf("wrong EBI: %d", ebi)
+		}
+		if pl, _ := ddn.AllocationRetensionPriority.PriorityLevel(); pl != 2 {
+			t.Errorf("wrong priority level: %d", pl)
+		}
+		if !ddn.AllocationRetensionPriority.PreemptionVulnerability() {
+			t.Error("PVI is not set")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out while waiting for Downlink Data Notification")
+	}
+	env.waitBuffered(t, 3)
+
+	select {
+	case <-env.ddnCh:
+		t.Fatal("Downlink Data Notification is sent more than once")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := env.buf.Flush(sgwS5UTEID, enbS1UTEID, env.enb.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"pkt1", "pkt2", "pkt3"}, env.receive(t, 3)); diff != "" {
+		t.Error(diff)
+	}
+
+	// relayed after flushed.
+	env.sendDownlink(t, "pkt4")
+	if diff := cmp.Diff([]string{"pkt4"}, env.receive(t, 1)); diff != "" {
+		t.Error(diff)
+	}
+
+	if err := env.buf.Flush(sgwS5UTEID, enbS1UTEID, env.enb.LocalAddr()); err != dlbuffer.ErrNotHeld {
+		t.Errorf("got unexpected error: %v", err)
+	}
+}
+
+func TestBufferLimits(t *testing.T) {
+	env := setup(t)
+	env.buf.MaxPackets = 2
+	if err := env.buf.Hold(env.session, env.bearer, sgwS5UTEID); err != nil {
+		t.Fatal(err)
+	}
+
+	env.sendDownlink(t, "pkt1", "pkt2", "pkt3")
+	<-env.ddnCh
+	time.Sleep(100 * time.Millisecond)
+	env.waitBuffered(t, 2)
+
+	env.buf.Timeout = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	env.waitBuffered(t, 0)
+}
+
+func TestBufferThrottling(t *testing.T) {
+	env := setup(t, ie.NewThrottling(2*time.Minute, 100))
+	if err := env.buf.Hold(env.session, env.bearer, sgwS5UTEID); err != nil {
+		t.Fatal(err)
+	}
+
+	env.sendDownlink(t, "pkt1")
+	<-env.ddnCh
+	env.waitBuffered(t, 1)
+
+	// the UE goes back to idle after the service request.
+	if err := env.buf.Flush(sgwS5UTEID, enbS1UTEID, env.enb.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	env.receive(t, 1)
+	if err := env.buf.Hold(env.session, env.bearer, sgwS5UTEID); err != nil {
+		t.Fatal(err)
+	}
+
+	env.sendDownlink(t, "pkt2")
+	select {
+	case <-env.ddnCh:
+		t.Fatal("Downlink Data Notification is sent while throttled")
+	case <-time.After(200 * time.Millisecond):
+	}
+	env.waitBuffered(t, 0)
+
+	// the bearers with high priority are not throttled.
+	env.buf.ThrottlingExemptPriority = 2
+	env.sendDownlink(t, "pkt3")
+	select {
+	case <-env.ddnCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out while waiting for Downlink Data Notification")
+	}
+	env.waitBuffered(t, 1)
+}
@@ -18,6 +18,13 @@ var (
 	// ErrTimeout indicates that a handler failed to complete its work due to the
 	// absence of message expected to come from another endpoint.
 	ErrTimeout = errors.New("timed out")
+
+	// ErrInvalidSnapshot indicates that the snapshot given to Restore cannot be
+	// restored to the Conn.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+	// ErrSessionNotFound indicates that no Session is found by the key given.
+	ErrSessionNotFound = errors.New("no session found")
 )
 
 // CauseNotOKError indicates that the value in Cause IE is not OK.
@@ -32,6 +32,10 @@ func (m *msgHandlerMap) load(msgType uint8) (HandlerFunc, bool) {
 	return handler.(HandlerFunc), true
 }
 
+func (m *msgHandlerMap) delete(msgType uint8) {
+	m.syncMap.Delete(msgType)
+}
+
 func newMsgHandlerMap(m map[uint8]HandlerFunc) *msgHandlerMap {
 	mhm := &msgHandlerMap{syncMap: sync.Map{}}
 	for k, v := range m {
@@ -47,6 +51,7 @@ func newDefaultMsgHandlerMap() *msgHandlerMap {
 			message.MsgTypeEchoRequest:                   handleEchoRequest,
 			message.MsgTypeEchoResponse:                  handleEchoResponse,
 			message.MsgTypeVersionNotSupportedIndication: handleVersionNotSupportedIndication,
+			message.MsgTypeUpdatePDNConnectionSetRequest: handleUpdatePDNConnectionSetRequest,
 		},
 	)
 }
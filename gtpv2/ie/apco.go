@@ -0,0 +1,41 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ie
+
+import "io"
+
+// NewAdditionalProtocolConfigurationOptions creates a new AdditionalProtocolConfigurationOptions IE.
+//
+// The payload is encoded in the same way as ProtocolConfigurationOptions.
+func NewAdditionalProtocolConfigurationOptions(proto uint8, options ...*PCOContainer) *IE {
+	v := NewProtocolConfigurationOptionsFields(proto, options...)
+	b, err := v.Marshal()
+	if err != nil {
+		return nil
+	}
+
+	return New(AdditionalProtocolConfigurationOptions, 0x00, b)
+}
+
+// AdditionalProtocolConfigurationOptions returns AdditionalProtocolConfigurationOptions in
+// ProtocolConfigurationOptionsFields type if the type of IE matches.
+func (i *IE) AdditionalProtocolConfigurationOptions() (*ProtocolConfigurationOptionsFields, error) {
+	if i.Type != AdditionalProtocolConfigurationOptions {
+		return nil, &InvalidTypeError{Type: i.Type}
+	}
+	if len(i.Payload) < 1 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return ParseProtocolConfigurationOptionsFields(i.Payload)
+}
+
+// MustAdditionalProtocolConfigurationOptions returns AdditionalProtocolConfigurationOptions in
+// *ProtocolConfigurationOptionsFields, ignoring errors.
+// This should only be used if it is assured to have the value.
+func (i *IE) MustAdditionalProtocolConfigurationOptions() *ProtocolConfigurationOptionsFields {
+	v, _ := i.AdditionalProtocolConfigurationOptions()
+	return v
+}
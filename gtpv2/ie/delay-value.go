@@ -23,7 +23,7 @@ func (i *IE) DelayValue() (time.Duration, error) {
 		return 0, io.ErrUnexpectedEOF
 	}
 
-	return time.Duration(i.Payload[0]/50) * time.Millisecond, nil
+	return time.Duration(i.Payload[0]) * 50 * time.Millisecond, nil
 }
 
 // MustDelayValue returns DelayValue in time.Duration, ignoring errors.
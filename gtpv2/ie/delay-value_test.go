@@ -0,0 +1,36 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ie_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+func TestDelayValue(t *testing.T) {
+	cases := []struct {
+		description string
+		delay       time.Duration
+	}{
+		{"zero", 0},
+		{"50ms", 50 * time.Millisecond},
+		{"500ms", 500 * time.Millisecond},
+		{"max", 255 * 50 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			got, err := ie.NewDelayValue(c.delay).DelayValue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.delay {
+				t.Errorf("got %v, want %v", got, c.delay)
+			}
+		})
+	}
+}
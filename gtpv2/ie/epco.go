@@ -0,0 +1,140 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ie
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// NewExtendedProtocolConfigurationOptions creates a new ExtendedProtocolConfigurationOptions IE.
+func NewExtendedProtocolConfigurationOptions(proto uint8, options ...*PCOContainer) *IE {
+	v := NewExtendedProtocolConfigurationOptionsFields(proto, options...)
+	b, err := v.Marshal()
+	if err != nil {
+		return nil
+	}
+
+	return New(ExtendedProtocolConfigurationOptions, 0x00, b)
+}
+
+// ExtendedProtocolConfigurationOptions returns ExtendedProtocolConfigurationOptions in
+// ExtendedProtocolConfigurationOptionsFields type if the type of IE matches.
+func (i *IE) ExtendedProtocolConfigurationOptions() (*ExtendedProtocolConfigurationOptionsFields, error) {
+	if i.Type != ExtendedProtocolConfigurationOptions {
+		return nil, &InvalidTypeError{Type: i.Type}
+	}
+	if len(i.Payload) < 1 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return ParseExtendedProtocolConfigurationOptionsFields(i.Payload)
+}
+
+// MustExtendedProtocolConfigurationOptions returns ExtendedProtocolConfigurationOptions in
+// *ExtendedProtocolConfigurationOptionsFields, ignoring errors.
+// This should only be used if it is assured to have the value.
+func (i *IE) MustExtendedProtocolConfigurationOptions() *ExtendedProtocolConfigurationOptionsFields {
+	v, _ := i.ExtendedProtocolConfigurationOptions()
+	return v
+}
+
+// ExtendedProtocolConfigurationOptionsFields is a set of fields in ExtendedProtocolConfigurationOptions IE.
+//
+// The format is the same as ProtocolConfigurationOptionsFields except that the length
+// of each container is two octets. The Length field of PCOContainer is not used here.
+type ExtendedProtocolConfigurationOptionsFields struct {
+	Extension             uint8 // bit 8 of octet 1
+	ConfigurationProtocol uint8 // bit 1-3 of octet 1
+	ProtocolOrContainers  []*PCOContainer
+}
+
+// NewExtendedProtocolConfigurationOptionsFields creates a new ExtendedProtocolConfigurationOptionsFields.
+func NewExtendedProtocolConfigurationOptionsFields(proto uint8, opts ...*PCOContainer) *ExtendedProtocolConfigurationOptionsFields {
+	f := &ExtendedProtocolConfigurationOptionsFields{ConfigurationProtocol: proto}
+	f.ProtocolOrContainers = append(f.ProtocolOrContainers, opts...)
+
+	return f
+}
+
+// Marshal serializes ExtendedProtocolConfigurationOptionsFields.
+func (f *ExtendedProtocolConfigurationOptionsFields) Marshal() ([]byte, error) {
+	b := make([]byte, f.MarshalLen())
+	if err := f.MarshalTo(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalTo serializes ExtendedProtocolConfigurationOptionsFields.
+func (f *ExtendedProtocolConfigurationOptionsFields) MarshalTo(b []byte) error {
+	if len(b) < f.MarshalLen() {
+		return io.ErrUnexpectedEOF
+	}
+
+	b[0] = (f.ConfigurationProtocol & 0x07) | 0x80
+	offset := 1
+	for _, opt := range f.ProtocolOrContainers {
+		binary.BigEndian.PutUint16(b[offset:offset+2], opt.ID)
+		binary.BigEndian.PutUint16(b[offset+2:offset+4], uint16(len(opt.Contents)))
+		copy(b[offset+4:], opt.Contents)
+		offset += 4 + len(opt.Contents)
+	}
+
+	return nil
+}
+
+// ParseExtendedProtocolConfigurationOptionsFields decodes ExtendedProtocolConfigurationOptionsFields.
+func ParseExtendedProtocolConfigurationOptionsFields(b []byte) (*ExtendedProtocolConfigurationOptionsFields, error) {
+	f := &ExtendedProtocolConfigurationOptionsFields{}
+	if err := f.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// UnmarshalBinary decodes given bytes into ExtendedProtocolConfigurationOptionsFields.
+func (f *ExtendedProtocolConfigurationOptionsFields) UnmarshalBinary(b []byte) error {
+	if len(b) < 1 {
+		return ErrTooShortToParse
+	}
+
+	f.Extension = (b[0] >> 7) & 0x01
+	f.ConfigurationProtocol = b[0] & 0x07
+
+	offset := 1
+	for offset < len(b) {
+		if len(b[offset:]) < 4 {
+			return ErrTooShortToParse
+		}
+		l := int(binary.BigEndian.Uint16(b[offset+2 : offset+4]))
+		if len(b[offset+4:]) < l {
+			return ErrInvalidLength
+		}
+
+		opt := &PCOContainer{
+			ID:       binary.BigEndian.Uint16(b[offset : offset+2]),
+			Length:   uint8(l),
+			Contents: make([]byte, l),
+		}
+		copy(opt.Contents, b[offset+4:offset+4+l])
+		f.ProtocolOrContainers = append(f.ProtocolOrContainers, opt)
+		offset += 4 + l
+	}
+
+	return nil
+}
+
+// MarshalLen returns the serial length of ExtendedProtocolConfigurationOptionsFields in int.
+func (f *ExtendedProtocolConfigurationOptionsFields) MarshalLen() int {
+	l := 1
+	for _, opt := range f.ProtocolOrContainers {
+		l += 4 + len(opt.Contents)
+	}
+
+	return l
+}
@@ -94,6 +94,22 @@ var cases = []struct {
 		"IndicationFromOctets/Short",
 		ie.NewIndicationFromOctets(0xa1, 0x08),
 		[]byte{0x4d, 0x00, 0x02, 0x00, 0xa1, 0x08},
+	}, {
+		"AdditionalProtocolConfigurationOptions",
+		ie.NewAdditionalProtocolConfigurationOptions(
+			gtpv2.ConfigProtocolPPPWithIP,
+			ie.NewPCOContainerPCSCFAddress(net.ParseIP("192.0.2.1")),
+			ie.NewPCOContainerIPv4LinkMTU(1400),
+		),
+		[]byte{0xa3, 0x00, 0x0d, 0x00, 0x80, 0x00, 0x0c, 0x04, 0xc0, 0x00, 0x02, 0x01, 0x00, 0x10, 0x02, 0x05, 0x78},
+	}, {
+		"ExtendedProtocolConfigurationOptions",
+		ie.NewExtendedProtocolConfigurationOptions(
+			gtpv2.ConfigProtocolPPPWithIP,
+			ie.NewPCOContainerDNSServerAddress(net.ParseIP("192.0.2.1")),
+			ie.NewPCOContainer(ie.PCOContainerIdentifierIPv4LinkMTU, nil),
+		),
+		[]byte{0xc5, 0x00, 0x0d, 0x00, 0x80, 0x00, 0x0d, 0x00, 0x04, 0xc0, 0x00, 0x02, 0x01, 0x00, 0x10, 0x00, 0x00},
 	}, {
 		"ProtocolConfigurationOptions",
 		ie.NewProtocolConfigurationOptions(
@@ -0,0 +1,330 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ie
+
+import "io"
+
+// IndicationFlag represents a flag in Indication IE.
+//
+// The value is the position of the flag counted from the 1st bit of the 1st octet,
+// i.e., the flag is in the (value/8)th octet and (value%8+1)th bit.
+type IndicationFlag uint8
+
+// IndicationFlag definitions, in the order of the bits in TS 29.274 8.12.
+const (
+	IndicationFlagSGWCI IndicationFlag = iota
+	IndicationFlagISRAI
+	IndicationFlagISRSI
+	IndicationFlagOI
+	IndicationFlagDFI
+	IndicationFlagHI
+	IndicationFlagDTF
+	IndicationFlagDAF
+
+	IndicationFlagMSV
+	IndicationFlagSI
+	IndicationFlagPT
+	IndicationFlagPS
+	IndicationFlagCRSI
+	IndicationFlagCFSI
+	IndicationFlagUIMSI
+	IndicationFlagSQCI
+
+	IndicationFlagCCRSI
+	IndicationFlagISRAU
+	IndicationFlagMBMDT
+	IndicationFlagS4AF
+	IndicationFlagS6AF
+	IndicationFlagSRNI
+	IndicationFlagPBIC
+	IndicationFlagRetLoc
+
+	IndicationFlagCPSR
+	IndicationFlagCLII
+	IndicationFlagCSFBI
+	IndicationFlagPPSI
+	IndicationFlagPPON
+	IndicationFlagPPOFF
+	IndicationFlagARRL
+	IndicationFlagCPRAI
+
+	IndicationFlagAOPI
+	IndicationFlagAOSI
+	IndicationFlagPCRI
+	IndicationFlagPSCI
+	IndicationFlagBDWI
+	IndicationFlagDTCI
+	IndicationFlagUASI
+	IndicationFlagNSI
+
+	IndicationFlagWPMSI
+	IndicationFlagUNACCSI
+	IndicationFlagPNSI
+	IndicationFlagS11TF
+	IndicationFlagPMTMSI
+	IndicationFlagCPOPCI
+	IndicationFlagEPCOSI
+	IndicationFlagROAAI
+
+	IndicationFlagTSPCMI
+	IndicationFlagENBCRSI
+	IndicationFlagLTEMPI
+	IndicationFlagLTEMUI
+	IndicationFlagEEVRSI
+	IndicationFlag5GSIWK
+	IndicationFlagREPREFI
+	IndicationFlag5GSNN26
+
+	IndicationFlagETHPDN
+	IndicationFlag5SRHOI
+	IndicationFlag5GCNRI
+	IndicationFlag5GCNRS
+	IndicationFlagN5GNMI
+	IndicationFlagMTEDTA
+	IndicationFlagMTEDTN
+	IndicationFlagCSRMFI
+
+	IndicationFlagEMCI
+)
+
+const (
+	// indicationLen is the number of octets that the named flags occupy.
+	indicationLen = 9
+
+	// indicationSpareMask is the mask for the spare bits in the last octet.
+	indicationSpareMask = 0xfe
+)
+
+// IndicationFlags is a set of flags in Indication IE.
+//
+// The bits that are not known to this package are kept in Spare and
+// AdditionalOctets so that they are preserved on round-trip.
+type IndicationFlags struct {
+	DAF, DTF, HI, DFI, OI, ISRSI, ISRAI, SGWCI                              bool
+	SQCI, UIMSI, CFSI, CRSI, PS, PT, SI, MSV                                bool
+	RetLoc, PBIC, SRNI, S6AF, S4AF, MBMDT, ISRAU, CCRSI                     bool
+	CPRAI, ARRL, PPOFF, PPON, PPSI, CSFBI, CLII, CPSR                       bool
+	NSI, UASI, DTCI, BDWI, PSCI, PCRI, AOSI, AOPI                           bool
+	ROAAI, EPCOSI, CPOPCI, PMTMSI, S11TF, PNSI, UNACCSI, WPMSI              bool
+	FiveGSNN26, REPREFI, FiveGSIWK, EEVRSI, LTEMUI, LTEMPI, ENBCRSI, TSPCMI bool
+	CSRMFI, MTEDTN, MTEDTA, N5GNMI, FiveGCNRS, FiveGCNRI, FiveSRHOI, ETHPDN bool
+	EMCI                                                                    bool
+
+	// Spare is the bits 8 to 2 of the 9th octet, in the same position as
+	// they are on the wire.
+	Spare uint8
+
+	// AdditionalOctets are the octets after the 9th octet.
+	AdditionalOctets []byte
+
+	// parsed and length are set when the flags are parsed from an IE, to
+	// serialize the same number of octets if possible.
+	parsed bool
+	length int
+}
+
+// flags returns the pointers to the fields in the order of IndicationFlag.
+func (f *IndicationFlags) flags() []*bool {
+	return []*bool{
+		&f.SGWCI, &f.ISRAI, &f.ISRSI, &f.OI, &f.DFI, &f.HI, &f.DTF, &f.DAF,
+		&f.MSV, &f.SI, &f.PT, &f.PS, &f.CRSI, &f.CFSI, &f.UIMSI, &f.SQCI,
+		&f.CCRSI, &f.ISRAU, &f.MBMDT, &f.S4AF, &f.S6AF, &f.SRNI, &f.PBIC, &f.RetLoc,
+		&f.CPSR, &f.CLII, &f.CSFBI, &f.PPSI, &f.PPON, &f.PPOFF, &f.ARRL, &f.CPRAI,
+		&f.AOPI, &f.AOSI, &f.PCRI, &f.PSCI, &f.BDWI, &f.DTCI, &f.UASI, &f.NSI,
+		&f.WPMSI, &f.UNACCSI, &f.PNSI, &f.S11TF, &f.PMTMSI, &f.CPOPCI, &f.EPCOSI, &f.ROAAI,
+		&f.TSPCMI, &f.ENBCRSI, &f.LTEMPI, &f.LTEMUI, &f.EEVRSI, &f.FiveGSIWK, &f.REPREFI, &f.FiveGSNN26,
+		&f.ETHPDN, &f.FiveSRHOI, &f.FiveGCNRI, &f.FiveGCNRS, &f.N5GNMI, &f.MTEDTA, &f.MTEDTN, &f.CSRMFI,
+		&f.EMCI,
+	}
+}
+
+// NewIndicationFromFlags creates a new Indication IE from IndicationFlags.
+func NewIndicationFromFlags(f *IndicationFlags) *IE {
+	b, err := f.Marshal()
+	if err != nil {
+		return nil
+	}
+
+	return New(Indication, 0x00, b)
+}
+
+// IndicationFlags returns IndicationFlags if the type of IE matches.
+func (i *IE) IndicationFlags() (*IndicationFlags, error) {
+	if i.Type != Indication {
+		return nil, &InvalidTypeError{Type: i.Type}
+	}
+
+	return ParseIndicationFlags(i.Payload)
+}
+
+// HasIndicationFlag reports whether an IE has the flag set.
+func (i *IE) HasIndicationFlag(flag IndicationFlag) bool {
+	if i.Type != Indication {
+		return false
+	}
+
+	n := int(flag / 8)
+	if n >= len(i.Payload) {
+		return false
+	}
+	return i.Payload[n]&(1<<(flag%8)) != 0
+}
+
+// SetIndicationFlags sets the flags given in Indication IE, extending the payload
+// if the IE is too short to have them. It returns the IE itself so that the calls
+// can be chained.
+//
+// It does nothing if the type of IE is not Indication.
+func (i *IE) SetIndicationFlags(flags ...IndicationFlag) *IE {
+	if i.Type != Indication {
+		return i
+	}
+
+	for _, flag := range flags {
+		n := int(flag / 8)
+		if n >= len(i.Payload) {
+			i.Payload = append(i.Payload, make([]byte, n-len(i.Payload)+1)...)
+		}
+		i.Payload[n] |= 1 << (flag % 8)
+	}
+	i.SetLength()
+	return i
+}
+
+// ClearIndicationFlags clears the flags given in Indication IE. It returns the IE
+// itself so that the calls can be chained.
+//
+// It does nothing if the type of IE is not Indication.
+func (i *IE) ClearIndicationFlags(flags ...IndicationFlag) *IE {
+	if i.Type != Indication {
+		return i
+	}
+
+	for _, flag := range flags {
+		n := int(flag / 8)
+		if n < len(i.Payload) {
+			i.Payload[n] &^= 1 << (flag % 8)
+		}
+	}
+	return i
+}
+
+// Set sets the flag given and returns f itself so that the calls can be chained.
+func (f *IndicationFlags) Set(flags ...IndicationFlag) *IndicationFlags {
+	fs := f.flags()
+	for _, flag := range flags {
+		if int(flag) < len(fs) {
+			*fs[flag] = true
+		}
+	}
+	return f
+}
+
+// Clear clears the flag given and returns f itself so that the calls can be chained.
+func (f *IndicationFlags) Clear(flags ...IndicationFlag) *IndicationFlags {
+	fs := f.flags()
+	for _, flag := range flags {
+		if int(flag) < len(fs) {
+			*fs[flag] = false
+		}
+	}
+	return f
+}
+
+// Has reports whether the flag given is set.
+func (f *IndicationFlags) Has(flag IndicationFlag) bool {
+	fs := f.flags()
+	if int(flag) >= len(fs) {
+		return false
+	}
+	return *fs[flag]
+}
+
+// Marshal serializes IndicationFlags.
+func (f *IndicationFlags) Marshal() ([]byte, error) {
+	b := make([]byte, f.MarshalLen())
+	if err := f.MarshalTo(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalTo serializes IndicationFlags.
+func (f *IndicationFlags) MarshalTo(b []byte) error {
+	l := f.MarshalLen()
+	if len(b) < l {
+		return io.ErrUnexpectedEOF
+	}
+
+	for n := range b[:l] {
+		b[n] = 0
+	}
+	for n, set := range f.flags() {
+		if *set {
+			b[n/8] |= 1 << (n % 8)
+		}
+	}
+	if l >= indicationLen {
+		b[indicationLen-1] |= f.Spare & indicationSpareMask
+		copy(b[indicationLen:l], f.AdditionalOctets)
+	}
+
+	return nil
+}
+
+// ParseIndicationFlags decodes IndicationFlags.
+func ParseIndicationFlags(b []byte) (*IndicationFlags, error) {
+	f := &IndicationFlags{}
+	if err := f.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// UnmarshalBinary decodes given bytes into IndicationFlags.
+//
+// The Indication IE can be shorter than the octets defined, in which case the
+// flags in the missing octets are treated as false.
+func (f *IndicationFlags) UnmarshalBinary(b []byte) error {
+	*f = IndicationFlags{parsed: true, length: len(b)}
+	for n, set := range f.flags() {
+		if n/8 < len(b) {
+			*set = b[n/8]&(1<<(n%8)) != 0
+		}
+	}
+	if len(b) >= indicationLen {
+		f.Spare = b[indicationLen-1] & indicationSpareMask
+	}
+	if len(b) > indicationLen {
+		f.AdditionalOctets = make([]byte, len(b)-indicationLen)
+		copy(f.AdditionalOctets, b[indicationLen:])
+	}
+
+	return nil
+}
+
+// MarshalLen returns the serial length of IndicationFlags in int.
+//
+// It is the length of the IE that the flags are parsed from, or all the octets
+// defined if the flags are created from scratch. The length is extended if it
+// is too short to have the flags set.
+func (f *IndicationFlags) MarshalLen() int {
+	if !f.parsed || f.Spare != 0 || len(f.AdditionalOctets) > 0 {
+		return indicationLen + len(f.AdditionalOctets)
+	}
+
+	l := f.length
+	if l > indicationLen {
+		l = indicationLen
+	}
+	for n, set := range f.flags() {
+		if *set && n/8+1 > l {
+			l = n/8 + 1
+		}
+	}
+	return l
+}
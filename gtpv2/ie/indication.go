@@ -8,12 +8,11 @@ import (
 	"strconv"
 )
 
-// TODO: add setters
-// TODO: consider using struct?
-
 // NewIndication creates a new Indication IE.
 // Note that each parameters should be 0 if false and 1 if true. Otherwise,
 // the value won't be set as expected in the bitwise operations.
+//
+// NewIndicationFromFlags and SetIndicationFlags are easier to use in most cases.
 func NewIndication(
 	daf, dtf, hi, dfi, oi, isrsi, israi, sgwci,
 	sqci, uimsi, cfsi, crsi, ps, pt, si, msv,
@@ -0,0 +1,126 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ie_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+func TestIndicationFlags(t *testing.T) {
+	cases := []struct {
+		description string
+		flags       *ie.IndicationFlags
+		serialized  []byte
+	}{
+		{
+			"Full",
+			&ie.IndicationFlags{
+				DAF: true, HI: true, SGWCI: true,
+				PS:   true,
+				S6AF: true, MBMDT: true, CCRSI: true,
+				PPON: true,
+				NSI:  true, PSCI: true,
+				ROAAI: true, WPMSI: true,
+				REPREFI: true,
+				CSRMFI:  true, MTEDTA: true,
+				EMCI: true,
+			},
+			[]byte{0x4d, 0x00, 0x09, 0x00, 0xa1, 0x08, 0x15, 0x10, 0x88, 0x81, 0x40, 0xa0, 0x01},
+		}, {
+			"Empty",
+			&ie.IndicationFlags{},
+			[]byte{0x4d, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		}, {
+			"Spare",
+			&ie.IndicationFlags{HI: true, EMCI: true, Spare: 0x80, AdditionalOctets: []byte{0xff}},
+			[]byte{0x4d, 0x00, 0x0a, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x81, 0xff},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			i := ie.NewIndicationFromFlags(c.flags)
+			got, err := i.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, c.serialized); diff != "" {
+				t.Error(diff)
+			}
+
+			parsed, err := ie.Parse(c.serialized)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := parsed.IndicationFlags()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(f, c.flags, cmpopts.IgnoreUnexported(ie.IndicationFlags{})); diff != "" {
+				t.Error(diff)
+			}
+
+			// round-trip
+			b, err := ie.NewIndicationFromFlags(f).Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(b, c.serialized); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestIndicationFlagsShort(t *testing.T) {
+	i := ie.NewIndicationFromOctets(0xa1, 0x08)
+
+	f, err := i.IndicationFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.DAF || !f.HI || !f.SGWCI || !f.PS || f.CCRSI {
+		t.Errorf("wrong flags: %+v", f)
+	}
+
+	// the length is kept unless the flags in later octets are set.
+	if got := ie.NewIndicationFromFlags(f).Length; got != 2 {
+		t.Errorf("wrong length. got: %d, want: 2", got)
+	}
+	if got := ie.NewIndicationFromFlags(f.Set(ie.IndicationFlagPPON)).Length; got != 4 {
+		t.Errorf("wrong length. got: %d, want: 4", got)
+	}
+}
+
+func TestSetIndicationFlags(t *testing.T) {
+	i := ie.NewIndicationFromOctets(0x01).
+		SetIndicationFlags(ie.IndicationFlagHI, ie.IndicationFlagOI, ie.IndicationFlagCSRMFI).
+		ClearIndicationFlags(ie.IndicationFlagSGWCI, ie.IndicationFlagEMCI)
+
+	want := []byte{0x4d, 0x00, 0x08, 0x00, 0x28, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}
+	got, err := i.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Error(diff)
+	}
+
+	if !i.HasIndicationFlag(ie.IndicationFlagHI) || !i.HasHI() || i.HasIndicationFlag(ie.IndicationFlagSGWCI) {
+		t.Error("wrong flags")
+	}
+	if i.HasIndicationFlag(ie.IndicationFlagEMCI) {
+		t.Error("EMCI should not be set in the short IE")
+	}
+
+	// not an Indication IE
+	if ie.NewRecovery(1).SetIndicationFlags(ie.IndicationFlagHI).HasIndicationFlag(ie.IndicationFlagHI) {
+		t.Error("flag set in the wrong IE")
+	}
+}
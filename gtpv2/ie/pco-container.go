@@ -0,0 +1,259 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ie
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// Container identifiers of PCO.
+//
+// [Table 10.5.154/3GPP TS 24.008]
+//
+// Some of the identifiers have different meanings in MS to network direction
+// and network to MS direction.
+const (
+	PCOContainerIdentifierPCSCFIPv6Address                                  uint16 = 0x0001
+	PCOContainerIdentifierIMCNSubsystemSignalingFlag                        uint16 = 0x0002
+	PCOContainerIdentifierDNSServerIPv6Address                              uint16 = 0x0003
+	PCOContainerIdentifierPolicyControlRejectionCode                        uint16 = 0x0004 // network to MS
+	PCOContainerIdentifierMSSupportOfNetworkRequestedBearerControlIndicator uint16 = 0x0005 // MS to network
+	PCOContainerIdentifierSelectedBearerControlMode                         uint16 = 0x0005 // network to MS
+	PCOContainerIdentifierIPAddressAllocationViaNASSignalling               uint16 = 0x000a // MS to network
+	PCOContainerIdentifierIPv4AddressAllocationViaDHCPv4                    uint16 = 0x000b // MS to network
+	PCOContainerIdentifierPCSCFIPv4Address                                  uint16 = 0x000c
+	PCOContainerIdentifierDNSServerIPv4Address                              uint16 = 0x000d
+	PCOContainerIdentifierMSISDN                                            uint16 = 0x000e
+	PCOContainerIdentifierIPv4LinkMTU                                       uint16 = 0x0010
+	PCOContainerIdentifierNBIFOMRequestIndicator                            uint16 = 0x0013 // MS to network
+	PCOContainerIdentifierNBIFOMAcceptedIndicator                           uint16 = 0x0013 // network to MS
+	PCOContainerIdentifierNBIFOMMode                                        uint16 = 0x0014
+	PCOContainerIdentifierNonIPLinkMTU                                      uint16 = 0x0015
+	PCOContainerIdentifierAPNRateControlSupportIndicator                    uint16 = 0x0016 // MS to network
+	PCOContainerIdentifierAPNRateControlParameters                          uint16 = 0x0016 // network to MS
+)
+
+// Bearer Control Mode definitions used in Selected Bearer Control Mode container.
+const (
+	BearerControlModeMSOnly uint8 = 0x01
+	BearerControlModeMSNW   uint8 = 0x02
+)
+
+// NBIFOM mode definitions used in NBIFOM mode container.
+const (
+	NBIFOMModeUEInitiated      uint8 = 0x00
+	NBIFOMModeNetworkInitiated uint8 = 0x01
+)
+
+// Uplink time unit definitions used in APN rate control parameters container.
+const (
+	APNRateControlUnitUnrestricted uint8 = iota
+	APNRateControlUnitMinute
+	APNRateControlUnitHour
+	APNRateControlUnitDay
+	APNRateControlUnitWeek
+)
+
+// NewPCOContainerDNSServerAddress creates a new PCOContainer with DNS Server IPv4
+// Address or DNS Server IPv6 Address, depending on the family of ip.
+func NewPCOContainerDNSServerAddress(ip net.IP) *PCOContainer {
+	if v4 := ip.To4(); v4 != nil {
+		return NewPCOContainer(PCOContainerIdentifierDNSServerIPv4Address, v4)
+	}
+	return NewPCOContainer(PCOContainerIdentifierDNSServerIPv6Address, ip.To16())
+}
+
+// NewPCOContainerPCSCFAddress creates a new PCOContainer with P-CSCF IPv4 Address
+// or P-CSCF IPv6 Address, depending on the family of ip.
+func NewPCOContainerPCSCFAddress(ip net.IP) *PCOContainer {
+	if v4 := ip.To4(); v4 != nil {
+		return NewPCOContainer(PCOContainerIdentifierPCSCFIPv4Address, v4)
+	}
+	return NewPCOContainer(PCOContainerIdentifierPCSCFIPv6Address, ip.To16())
+}
+
+// NewPCOContainerIPv4LinkMTU creates a new PCOContainer with IPv4 Link MTU.
+func NewPCOContainerIPv4LinkMTU(mtu uint16) *PCOContainer {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, mtu)
+	return NewPCOContainer(PCOContainerIdentifierIPv4LinkMTU, b)
+}
+
+// NewPCOContainerSelectedBearerControlMode creates a new PCOContainer with
+// Selected Bearer Control Mode.
+func NewPCOContainerSelectedBearerControlMode(mode uint8) *PCOContainer {
+	return NewPCOContainer(PCOContainerIdentifierSelectedBearerControlMode, []byte{mode})
+}
+
+// NewPCOContainerNBIFOMMode creates a new PCOContainer with NBIFOM mode.
+func NewPCOContainerNBIFOMMode(mode uint8) *PCOContainer {
+	return NewPCOContainer(PCOContainerIdentifierNBIFOMMode, []byte{mode})
+}
+
+// NewPCOContainerAPNRateControlParameters creates a new PCOContainer with
+// APN rate control parameters.
+func NewPCOContainerAPNRateControlParameters(params *APNRateControlParameters) *PCOContainer {
+	b, err := params.Marshal()
+	if err != nil {
+		return nil
+	}
+	return NewPCOContainer(PCOContainerIdentifierAPNRateControlParameters, b)
+}
+
+// IPAddress returns the IP address in the container if the container is any of
+// DNS Server IPv4/IPv6 Address or P-CSCF IPv4/IPv6 Address.
+func (c *PCOContainer) IPAddress() (net.IP, error) {
+	var l int
+	switch c.ID {
+	case PCOContainerIdentifierDNSServerIPv4Address, PCOContainerIdentifierPCSCFIPv4Address:
+		l = net.IPv4len
+	case PCOContainerIdentifierDNSServerIPv6Address, PCOContainerIdentifierPCSCFIPv6Address:
+		l = net.IPv6len
+	default:
+		return nil, ErrInvalidType
+	}
+
+	switch len(c.Contents) {
+	case 0:
+		// request from MS does not contain the address.
+		return nil, ErrIEValueNotFound
+	case l:
+		return net.IP(c.Contents), nil
+	default:
+		return nil, ErrInvalidLength
+	}
+}
+
+// IPv4LinkMTU returns the MTU in the container if the container is IPv4 Link MTU.
+func (c *PCOContainer) IPv4LinkMTU() (uint16, error) {
+	if c.ID != PCOContainerIdentifierIPv4LinkMTU {
+		return 0, ErrInvalidType
+	}
+	if len(c.Contents) < 2 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return binary.BigEndian.Uint16(c.Contents[0:2]), nil
+}
+
+// SelectedBearerControlMode returns the bearer control mode in the container if
+// the container is Selected Bearer Control Mode.
+func (c *PCOContainer) SelectedBearerControlMode() (uint8, error) {
+	if c.ID != PCOContainerIdentifierSelectedBearerControlMode {
+		return 0, ErrInvalidType
+	}
+	if len(c.Contents) < 1 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return c.Contents[0], nil
+}
+
+// NBIFOMMode returns the NBIFOM mode in the container if the container is NBIFOM mode.
+func (c *PCOContainer) NBIFOMMode() (uint8, error) {
+	if c.ID != PCOContainerIdentifierNBIFOMMode {
+		return 0, ErrInvalidType
+	}
+	if len(c.Contents) < 1 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return c.Contents[0], nil
+}
+
+// APNRateControlParameters returns the APNRateControlParameters in the container
+// if the container is APN rate control parameters.
+func (c *PCOContainer) APNRateControlParameters() (*APNRateControlParameters, error) {
+	if c.ID != PCOContainerIdentifierAPNRateControlParameters {
+		return nil, ErrInvalidType
+	}
+
+	return ParseAPNRateControlParameters(c.Contents)
+}
+
+// PPP returns the contents of the container in PCOPPP if the container is any
+// of PPP protocols(LCP, PAP, CHAP or IPCP).
+func (c *PCOContainer) PPP() (*PCOPPP, error) {
+	switch c.ID {
+	case PCOProtocolIdentifierLCP, PCOProtocolIdentifierPAP, PCOProtocolIdentifierCHAP, PCOProtocolIdentifierIPCP:
+		return ParsePCOPPP(c.Contents)
+	default:
+		return nil, ErrInvalidType
+	}
+}
+
+// APNRateControlParameters represents the contents of APN rate control parameters
+// container in PCO.
+type APNRateControlParameters struct {
+	// AER is the Additional exception reports flag.
+	AER               bool
+	UplinkTimeUnit    uint8
+	MaximumUplinkRate uint32 // 24 bits
+}
+
+// NewAPNRateControlParameters creates a new APNRateControlParameters.
+func NewAPNRateControlParameters(aer bool, unit uint8, rate uint32) *APNRateControlParameters {
+	return &APNRateControlParameters{
+		AER:               aer,
+		UplinkTimeUnit:    unit,
+		MaximumUplinkRate: rate,
+	}
+}
+
+// Marshal serializes APNRateControlParameters.
+func (p *APNRateControlParameters) Marshal() ([]byte, error) {
+	b := make([]byte, p.MarshalLen())
+	if err := p.MarshalTo(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalTo serializes APNRateControlParameters.
+func (p *APNRateControlParameters) MarshalTo(b []byte) error {
+	if len(b) < p.MarshalLen() {
+		return io.ErrUnexpectedEOF
+	}
+
+	b[0] = p.UplinkTimeUnit & 0x07
+	if p.AER {
+		b[0] |= 0x08
+	}
+	b[1] = uint8(p.MaximumUplinkRate >> 16)
+	binary.BigEndian.PutUint16(b[2:4], uint16(p.MaximumUplinkRate))
+
+	return nil
+}
+
+// ParseAPNRateControlParameters decodes APNRateControlParameters.
+func ParseAPNRateControlParameters(b []byte) (*APNRateControlParameters, error) {
+	p := &APNRateControlParameters{}
+	if err := p.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// UnmarshalBinary decodes given bytes into APNRateControlParameters.
+func (p *APNRateControlParameters) UnmarshalBinary(b []byte) error {
+	if len(b) < 4 {
+		return io.ErrUnexpectedEOF
+	}
+
+	p.AER = b[0]&0x08 != 0
+	p.UplinkTimeUnit = b[0] & 0x07
+	p.MaximumUplinkRate = uint32(b[1])<<16 | uint32(binary.BigEndian.Uint16(b[2:4]))
+
+	return nil
+}
+
+// MarshalLen returns the serial length of APNRateControlParameters in int.
+func (p *APNRateControlParameters) MarshalLen() int {
+	return 4
+}
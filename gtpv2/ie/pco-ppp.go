@@ -13,11 +13,11 @@ import (
 const (
 	PCOPPPConfigurationRequest = 0x01
 	PCOPPPConfigurationAck     = 0x02
+	PCOPPPConfigurationNak     = 0x03
+	PCOPPPConfigurationReject  = 0x04
 )
 
 // PCOPPP represents a PPP header and its contents used in PCO.
-//
-// TODO: create another package with full implementation.
 type PCOPPP struct {
 	Code       uint8
 	Identifier uint8
@@ -130,6 +130,24 @@ func (p *PCOPPP) MarshalLen() int {
 	return 4 + len(p.Payload)
 }
 
+// IPCPOptions decodes the Payload of PCOPPP as a list of IPCPOption.
+func (p *PCOPPP) IPCPOptions() ([]*IPCPOption, error) {
+	var opts []*IPCPOption
+	for offset := 0; offset < len(p.Payload); {
+		o, err := ParseIPCPOption(p.Payload[offset:])
+		if err != nil {
+			return nil, err
+		}
+		if o.Length < 2 {
+			return nil, ErrInvalidLength
+		}
+		opts = append(opts, o)
+		offset += int(o.Length)
+	}
+
+	return opts, nil
+}
+
 // PAPFields represents a PAP payload on PPP protocol.
 //
 // TODO: create another package with full implementation.
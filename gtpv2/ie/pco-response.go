@@ -0,0 +1,195 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ie
+
+import (
+	"net"
+)
+
+// PCOResponseConfig is the set of values that the network returns in response to
+// the PCO requested by the UE, which is typically used by P-GW.
+type PCOResponseConfig struct {
+	// DNSServers are the IPv4 and/or IPv6 addresses of the DNS servers, returned
+	// in DNS Server IPv4/IPv6 Address containers and IPCP options.
+	DNSServers []net.IP
+
+	// PCSCFServers are the IPv4 and/or IPv6 addresses of the P-CSCFs.
+	PCSCFServers []net.IP
+
+	// IPv4LinkMTU is returned if requested and not zero.
+	IPv4LinkMTU uint16
+
+	// BearerControlMode is returned in Selected Bearer Control Mode container if
+	// the MS supports the network-requested bearer control and it is not zero.
+	BearerControlMode uint8
+
+	// NBIFOM lets the network accept the NBIFOM request, returning NBIFOM accepted
+	// indicator and the NBIFOM mode requested by the UE.
+	NBIFOM bool
+
+	// APNRateControl is returned if the UE supports APN rate control and it is not nil.
+	APNRateControl *APNRateControlParameters
+}
+
+// NewPCOResponse creates a new IE in response to the PCO, APCO or ePCO IE given as
+// req with the values in cfg. The type of IE returned is the same as req.
+//
+// The requests that are not supported or not configured in cfg are just ignored,
+// as the network is allowed to do so.
+func NewPCOResponse(req *IE, cfg *PCOResponseConfig) (*IE, error) {
+	switch req.Type {
+	case ProtocolConfigurationOptions:
+		f, err := req.ProtocolConfigurationOptions()
+		if err != nil {
+			return nil, err
+		}
+		return NewProtocolConfigurationOptions(
+			f.ConfigurationProtocol, NewPCOResponseContainers(f.ProtocolOrContainers, cfg)...,
+		), nil
+	case AdditionalProtocolConfigurationOptions:
+		f, err := req.AdditionalProtocolConfigurationOptions()
+		if err != nil {
+			return nil, err
+		}
+		return NewAdditionalProtocolConfigurationOptions(
+			f.ConfigurationProtocol, NewPCOResponseContainers(f.ProtocolOrContainers, cfg)...,
+		), nil
+	case ExtendedProtocolConfigurationOptions:
+		f, err := req.ExtendedProtocolConfigurationOptions()
+		if err != nil {
+			return nil, err
+		}
+		return NewExtendedProtocolConfigurationOptions(
+			f.ConfigurationProtocol, NewPCOResponseContainers(f.ProtocolOrContainers, cfg)...,
+		), nil
+	default:
+		return nil, &InvalidTypeError{Type: req.Type}
+	}
+}
+
+// NewPCOResponseContainers returns the containers in response to the containers
+// requested by the UE, in the order of the requests.
+func NewPCOResponseContainers(req []*PCOContainer, cfg *PCOResponseConfig) []*PCOContainer {
+	var (
+		rsp  []*PCOContainer
+		done = map[uint16]bool{}
+	)
+	for _, c := range req {
+		if done[c.ID] {
+			continue
+		}
+		done[c.ID] = true
+
+		switch c.ID {
+		case PCOProtocolIdentifierIPCP:
+			if r := ipcpResponse(c, cfg.DNSServers); r != nil {
+				rsp = append(rsp, r)
+			}
+		case PCOContainerIdentifierDNSServerIPv4Address:
+			for _, ip := range filterIPs(cfg.DNSServers, true) {
+				rsp = append(rsp, NewPCOContainerDNSServerAddress(ip))
+			}
+		case PCOContainerIdentifierDNSServerIPv6Address:
+			for _, ip := range filterIPs(cfg.DNSServers, false) {
+				rsp = append(rsp, NewPCOContainerDNSServerAddress(ip))
+			}
+		case PCOContainerIdentifierPCSCFIPv4Address:
+			for _, ip := range filterIPs(cfg.PCSCFServers, true) {
+				rsp = append(rsp, NewPCOContainerPCSCFAddress(ip))
+			}
+		case PCOContainerIdentifierPCSCFIPv6Address:
+			for _, ip := range filterIPs(cfg.PCSCFServers, false) {
+				rsp = append(rsp, NewPCOContainerPCSCFAddress(ip))
+			}
+		case PCOContainerIdentifierIPv4LinkMTU:
+			if cfg.IPv4LinkMTU != 0 {
+				rsp = append(rsp, NewPCOContainerIPv4LinkMTU(cfg.IPv4LinkMTU))
+			}
+		case PCOContainerIdentifierMSSupportOfNetworkRequestedBearerControlIndicator:
+			if cfg.BearerControlMode != 0 {
+				rsp = append(rsp, NewPCOContainerSelectedBearerControlMode(cfg.BearerControlMode))
+			}
+		case PCOContainerIdentifierNBIFOMRequestIndicator:
+			if cfg.NBIFOM {
+				rsp = append(rsp, NewPCOContainer(PCOContainerIdentifierNBIFOMAcceptedIndicator, nil))
+			}
+		case PCOContainerIdentifierNBIFOMMode:
+			if mode, err := c.NBIFOMMode(); err == nil && cfg.NBIFOM {
+				rsp = append(rsp, NewPCOContainerNBIFOMMode(mode))
+			}
+		case PCOContainerIdentifierAPNRateControlSupportIndicator:
+			if cfg.APNRateControl != nil {
+				rsp = append(rsp, NewPCOContainerAPNRateControlParameters(cfg.APNRateControl))
+			}
+		}
+	}
+
+	return rsp
+}
+
+// ipcpResponse returns the IPCP Configuration-Ack or Configuration-Nak container
+// in response to the DNS addresses requested in IPCP Configuration-Request.
+// It returns nil if no response is needed.
+func ipcpResponse(c *PCOContainer, dnsServers []net.IP) *PCOContainer {
+	ppp, err := c.PPP()
+	if err != nil || ppp.Code != PCOPPPConfigurationRequest {
+		return nil
+	}
+	opts, err := ppp.IPCPOptions()
+	if err != nil {
+		return nil
+	}
+
+	servers := filterIPs(dnsServers, true)
+	var (
+		rspOpts []*IPCPOption
+		code    uint8 = PCOPPPConfigurationAck
+	)
+	for _, o := range opts {
+		var n int
+		switch o.Type {
+		case IPCPOptionPrimaryDNS:
+			n = 0
+		case IPCPOptionSecondaryDNS:
+			n = 1
+		default:
+			continue
+		}
+		if n >= len(servers) {
+			continue
+		}
+
+		if !servers[n].Equal(net.IP(o.Payload)) {
+			code = PCOPPPConfigurationNak
+		}
+		rspOpts = append(rspOpts, NewIPCPOption(o.Type, servers[n]))
+	}
+	if len(rspOpts) == 0 {
+		return nil
+	}
+
+	b, err := NewPCOPPPWithIPCPOptions(code, ppp.Identifier, rspOpts...).Marshal()
+	if err != nil {
+		return nil
+	}
+	return NewPCOContainer(PCOProtocolIdentifierIPCP, b)
+}
+
+// filterIPs returns the IPv4 addresses in ips if v4 is true, or IPv6 addresses otherwise.
+func filterIPs(ips []net.IP, v4 bool) []net.IP {
+	var filtered []net.IP
+	for _, ip := range ips {
+		if v := ip.To4(); v != nil {
+			if v4 {
+				filtered = append(filtered, v)
+			}
+			continue
+		}
+		if !v4 && ip.To16() != nil {
+			filtered = append(filtered, ip.To16())
+		}
+	}
+	return filtered
+}
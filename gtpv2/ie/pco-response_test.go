@@ -0,0 +1,154 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ie_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+func TestPCOContainers(t *testing.T) {
+	if got, err := ie.NewPCOContainerDNSServerAddress(net.ParseIP("2001:db8::53")).IPAddress(); err != nil || got.String() != "2001:db8::53" {
+		t.Errorf("wrong DNS server address: %v, %v", got, err)
+	}
+	if _, err := ie.NewPCOContainer(ie.PCOContainerIdentifierDNSServerIPv4Address, nil).IPAddress(); err == nil {
+		t.Error("expected error for a request without address")
+	}
+	if got, err := ie.NewPCOContainerIPv4LinkMTU(1400).IPv4LinkMTU(); err != nil || got != 1400 {
+		t.Errorf("wrong MTU: %v, %v", got, err)
+	}
+	if got, err := ie.NewPCOContainerSelectedBearerControlMode(ie.BearerControlModeMSNW).SelectedBearerControlMode(); err != nil || got != ie.BearerControlModeMSNW {
+		t.Errorf("wrong bearer control mode: %v, %v", got, err)
+	}
+	if got, err := ie.NewPCOContainerNBIFOMMode(ie.NBIFOMModeNetworkInitiated).NBIFOMMode(); err != nil || got != ie.NBIFOMModeNetworkInitiated {
+		t.Errorf("wrong NBIFOM mode: %v, %v", got, err)
+	}
+	if _, err := ie.NewPCOContainerIPv4LinkMTU(1400).NBIFOMMode(); err == nil {
+		t.Error("expected error for a wrong container")
+	}
+
+	c := ie.NewPCOContainerAPNRateControlParameters(ie.NewAPNRateControlParameters(true, ie.APNRateControlUnitHour, 0x012345))
+	if diff := cmp.Diff(c.Contents, []byte{0x0a, 0x01, 0x23, 0x45}); diff != "" {
+		t.Error(diff)
+	}
+	p, err := c.APNRateControlParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(p, ie.NewAPNRateControlParameters(true, ie.APNRateControlUnitHour, 0x012345)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestExtendedPCOLongContainer(t *testing.T) {
+	contents := make([]byte, 300)
+	i := ie.NewExtendedProtocolConfigurationOptions(0, ie.NewPCOContainer(0xff00, contents))
+
+	f, err := i.ExtendedProtocolConfigurationOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ProtocolOrContainers) != 1 || len(f.ProtocolOrContainers[0].Contents) != 300 {
+		t.Errorf("wrong containers: %v", f.ProtocolOrContainers)
+	}
+}
+
+func TestNewPCOResponse(t *testing.T) {
+	cfg := &ie.PCOResponseConfig{
+		DNSServers:        []net.IP{net.ParseIP("192.0.2.53"), net.ParseIP("2001:db8::53"), net.ParseIP("192.0.2.54")},
+		PCSCFServers:      []net.IP{net.ParseIP("2001:db8::5060")},
+		IPv4LinkMTU:       1400,
+		BearerControlMode: ie.BearerControlModeMSNW,
+		NBIFOM:            true,
+		APNRateControl:    ie.NewAPNRateControlParameters(false, ie.APNRateControlUnitMinute, 100),
+	}
+
+	ipcpReq, err := ie.NewPCOPPPWithIPCPOptions(
+		ie.PCOPPPConfigurationRequest, 1,
+		ie.NewIPCPOptionPrimaryDNS(net.IPv4zero),
+		ie.NewIPCPOptionSecondaryDNS(net.IPv4zero),
+	).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipcpRsp, err := ie.NewPCOPPPWithIPCPOptions(
+		ie.PCOPPPConfigurationNak, 1,
+		ie.NewIPCPOptionPrimaryDNS(net.ParseIP("192.0.2.53")),
+		ie.NewIPCPOptionSecondaryDNS(net.ParseIP("192.0.2.54")),
+	).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := []*ie.PCOContainer{
+		ie.NewPCOContainer(ie.PCOProtocolIdentifierIPCP, ipcpReq),
+		ie.NewPCOContainer(ie.PCOProtocolIdentifierPAP, []byte{0x01, 0x00, 0x00, 0x06, 0x00, 0x00}),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierDNSServerIPv4Address, nil),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierDNSServerIPv6Address, nil),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierDNSServerIPv4Address, nil),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierPCSCFIPv4Address, nil),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierPCSCFIPv6Address, nil),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierIPv4LinkMTU, nil),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierIPAddressAllocationViaNASSignalling, nil),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierMSSupportOfNetworkRequestedBearerControlIndicator, nil),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierNBIFOMRequestIndicator, nil),
+		ie.NewPCOContainerNBIFOMMode(ie.NBIFOMModeUEInitiated),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierAPNRateControlSupportIndicator, nil),
+	}
+	want := []*ie.PCOContainer{
+		ie.NewPCOContainer(ie.PCOProtocolIdentifierIPCP, ipcpRsp),
+		ie.NewPCOContainerDNSServerAddress(net.ParseIP("192.0.2.53")),
+		ie.NewPCOContainerDNSServerAddress(net.ParseIP("192.0.2.54")),
+		ie.NewPCOContainerDNSServerAddress(net.ParseIP("2001:db8::53")),
+		ie.NewPCOContainerPCSCFAddress(net.ParseIP("2001:db8::5060")),
+		ie.NewPCOContainerIPv4LinkMTU(1400),
+		ie.NewPCOContainerSelectedBearerControlMode(ie.BearerControlModeMSNW),
+		ie.NewPCOContainer(ie.PCOContainerIdentifierNBIFOMAcceptedIndicator, nil),
+		ie.NewPCOContainerNBIFOMMode(ie.NBIFOMModeUEInitiated),
+		ie.NewPCOContainerAPNRateControlParameters(cfg.APNRateControl),
+	}
+
+	for _, typ := range []struct {
+		description string
+		req         *ie.IE
+		want        *ie.IE
+	}{
+		{"PCO", ie.NewProtocolConfigurationOptions(0, req...), ie.NewProtocolConfigurationOptions(0, want...)},
+		{"APCO", ie.NewAdditionalProtocolConfigurationOptions(0, req...), ie.NewAdditionalProtocolConfigurationOptions(0, want...)},
+		{"ePCO", ie.NewExtendedProtocolConfigurationOptions(0, req...), ie.NewExtendedProtocolConfigurationOptions(0, want...)},
+	} {
+		t.Run(typ.description, func(t *testing.T) {
+			got, err := ie.NewPCOResponse(typ.req, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got.Payload, typ.want.Payload); diff != "" {
+				t.Error(diff)
+			}
+			if got.Type != typ.req.Type {
+				t.Errorf("wrong type. got: %d, want: %d", got.Type, typ.req.Type)
+			}
+		})
+	}
+
+	t.Run("nothing configured", func(t *testing.T) {
+		got, err := ie.NewPCOResponse(ie.NewProtocolConfigurationOptions(0, req...), &ie.PCOResponseConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got.Payload, []byte{0x80}); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		if _, err := ie.NewPCOResponse(ie.NewRecovery(1), cfg); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
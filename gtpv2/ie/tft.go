@@ -517,7 +517,7 @@ func (c *TFTPFComponent) IPv6RemoteAddress() (*net.IPNet, error) {
 		return nil, io.ErrUnexpectedEOF
 	}
 
-	return &net.IPNet{IP: c.Contents[:16], Mask: c.Contents[17:32]}, nil
+	return &net.IPNet{IP: c.Contents[:16], Mask: c.Contents[16:32]}, nil
 }
 
 // IPv6RemoteAddressPrefixLength returns IPv6RemoteAddressPrefixLength in *net.IPNet
@@ -532,7 +532,7 @@ func (c *TFTPFComponent) IPv6RemoteAddressPrefixLength() (*net.IPNet, error) {
 
 	ipnet := &net.IPNet{
 		IP:   net.IP(c.Contents[:16]),
-		Mask: net.CIDRMask(int(c.Contents[17]), 128),
+		Mask: net.CIDRMask(int(c.Contents[16]), 128),
 	}
 	return ipnet, nil
 }
@@ -549,7 +549,7 @@ func (c *TFTPFComponent) IPv6LocalAddressPrefixLength() (*net.IPNet, error) {
 
 	ipnet := &net.IPNet{
 		IP:   net.IP(c.Contents[:16]),
-		Mask: net.CIDRMask(int(c.Contents[17]), 128),
+		Mask: net.CIDRMask(int(c.Contents[16]), 128),
 	}
 	return ipnet, nil
 }
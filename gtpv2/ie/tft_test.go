@@ -215,3 +215,40 @@ func TestTrafficFlowTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestTFTPFComponentIPv6(t *testing.T) {
+	ip := net.ParseIP("2001:db8::1")
+	want := &net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}
+
+	cases := []struct {
+		description string
+		component   *ie.TFTPFComponent
+		getter      func(*ie.TFTPFComponent) (*net.IPNet, error)
+	}{
+		{
+			"IPv6RemoteAddress",
+			ie.NewTFTPFComponentIPv6RemoteAddress(ip, net.CIDRMask(64, 128)),
+			(*ie.TFTPFComponent).IPv6RemoteAddress,
+		}, {
+			"IPv6RemoteAddressPrefixLength",
+			ie.NewTFTPFComponentIPv6RemoteAddressPrefixLength(ip, 64),
+			(*ie.TFTPFComponent).IPv6RemoteAddressPrefixLength,
+		}, {
+			"IPv6LocalAddressPrefixLength",
+			ie.NewTFTPFComponentIPv6LocalAddressPrefixLength(ip, 64),
+			(*ie.TFTPFComponent).IPv6LocalAddressPrefixLength,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			got, err := c.getter(c.component)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got.String(), want.String()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2
+
+import (
+	"net"
+	"sync"
+)
+
+// indexKind is the kind of the secondary index of Sessions.
+type indexKind int
+
+const (
+	indexMSISDN indexKind = iota
+	indexSubscriberIP
+	indexAPN
+	indexPeer
+	indexFQCSID
+	numIndexKinds
+)
+
+// sessionIndex is the secondary indexes of the Sessions registered in Conn.
+type sessionIndex struct {
+	mu      sync.RWMutex
+	entries [numIndexKinds]map[string]map[*Session]struct{}
+	keys    map[*Session][numIndexKinds][]string
+}
+
+func newSessionIndex() *sessionIndex {
+	idx := &sessionIndex{keys: map[*Session][numIndexKinds][]string{}}
+	for i := range idx.entries {
+		idx.entries[i] = map[string]map[*Session]struct{}{}
+	}
+	return idx
+}
+
+// indexKeys returns the current keys of the Session for each kind of index.
+func indexKeys(s *Session) [numIndexKinds][]string {
+	var keys [numIndexKinds][]string
+	if s.Subscriber != nil && s.MSISDN != "" {
+		keys[indexMSISDN] = []string{s.MSISDN}
+	}
+	if peer := s.PeerAddr(); peer != nil {
+		keys[indexPeer] = []string{peer.String()}
+	}
+	keys[indexFQCSID] = s.fqcsidKeys()
+
+	seen := map[string]bool{}
+	s.bearerMap.rangeWithFunc(func(k, v interface{}) bool {
+		br := v.(*Bearer)
+		if br.SubscriberIP != "" && !seen["ip:"+br.SubscriberIP] {
+			seen["ip:"+br.SubscriberIP] = true
+			keys[indexSubscriberIP] = append(keys[indexSubscriberIP], br.SubscriberIP)
+		}
+		if br.APN != "" && !seen["apn:"+br.APN] {
+			seen["apn:"+br.APN] = true
+			keys[indexAPN] = append(keys[indexAPN], br.APN)
+		}
+		return true
+	})
+	return keys
+}
+
+// update replaces the entries of the Session with its current keys.
+func (idx *sessionIndex) update(s *Session) {
+	keys := indexKeys(s)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(s)
+	for kind, ks := range keys {
+		for _, k := range ks {
+			m, ok := idx.entries[kind][k]
+			if !ok {
+				m = map[*Session]struct{}{}
+				idx.entries[kind][k] = m
+			}
+			m[s] = struct{}{}
+		}
+	}
+	idx.keys[s] = keys
+}
+
+// remove removes all the entries of the Session.
+func (idx *sessionIndex) remove(s *Session) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(s)
+}
+
+func (idx *sessionIndex) removeLocked(s *Session) {
+	old, ok := idx.keys[s]
+	if !ok {
+		return
+	}
+	for kind, ks := range old {
+		for _, k := range ks {
+			delete(idx.entries[kind][k], s)
+			if len(idx.entries[kind][k]) == 0 {
+				delete(idx.entries[kind], k)
+			}
+		}
+	}
+	delete(idx.keys, s)
+}
+
+// lookup returns the Sessions whose current key of the kind is k.
+//
+// The Sessions modified without Reindex are filtered out by checking the current
+// keys, so that the stale entries are never returned.
+func (idx *sessionIndex) lookup(kind indexKind, k string) []*Session {
+	idx.mu.RLock()
+	candidates := make([]*Session, 0, len(idx.entries[kind][k]))
+	for s := range idx.entries[kind][k] {
+		candidates = append(candidates, s)
+	}
+	idx.mu.RUnlock()
+
+	var sessions []*Session
+	for _, s := range candidates {
+		for _, cur := range indexKeys(s)[kind] {
+			if cur == k {
+				sessions = append(sessions, s)
+				break
+			}
+		}
+	}
+	return sessions
+}
+
+// GetSessionByMSISDN returns Session looked up by MSISDN of the Subscriber.
+func (c *Conn) GetSessionByMSISDN(msisdn string) (*Session, error) {
+	if ss := c.index.lookup(indexMSISDN, msisdn); len(ss) > 0 {
+		return ss[0], nil
+	}
+	return nil, ErrSessionNotFound
+}
+
+// GetSessionBySubscriberIP returns Session looked up by the IP address assigned to
+// the subscriber, which is the SubscriberIP of any of the Bearers in the Session.
+func (c *Conn) GetSessionBySubscriberIP(ip string) (*Session, error) {
+	if ss := c.index.lookup(indexSubscriberIP, ip); len(ss) > 0 {
+		return ss[0], nil
+	}
+	return nil, ErrSessionNotFound
+}
+
+// GetSessionsByAPN returns all the Sessions that have any Bearer with the APN given.
+func (c *Conn) GetSessionsByAPN(apn string) []*Session {
+	return c.index.lookup(indexAPN, apn)
+}
+
+// GetSessionsByPeer returns all the Sessions with the peer node given.
+func (c *Conn) GetSessionsByPeer(peer net.Addr) []*Session {
+	return c.index.lookup(indexPeer, peer.String())
+}
@@ -0,0 +1,91 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2_test
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+func imsisOf(sessions []*gtpv2.Session) []string {
+	imsis := []string{}
+	for _, s := range sessions {
+		imsis = append(imsis, s.IMSI)
+	}
+	sort.Strings(imsis)
+	return imsis
+}
+
+func TestSessionIndex(t *testing.T) {
+	conn := gtpv2.NewConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2123}, gtpv2.IFTypeS5S8PGWGTPC, 0)
+
+	sess1 := newTestSession(t, "001010000000001")
+	sess1.MSISDN = "819000000001"
+	sess2 := newTestSession(t, "001010000000002")
+	sess2.MSISDN = "819000000002"
+	sess2.GetDefaultBearer().SubscriberIP = "10.10.10.2"
+	sess2.GetDefaultBearer().APN = "ims"
+	sess2.UpdatePeerAddr(&net.UDPAddr{IP: net.ParseIP("127.0.0.9"), Port: 2123})
+
+	conn.RegisterSession(0x11111111, sess1)
+	conn.RegisterSession(0x22222222, sess2)
+
+	t.Run("lookup", func(t *testing.T) {
+		if got, err := conn.GetSessionByMSISDN("819000000002"); err != nil || got != sess2 {
+			t.Errorf("wrong session by MSISDN: %v, %v", got, err)
+		}
+		if got, err := conn.GetSessionBySubscriberIP("10.10.10.1"); err != nil || got != sess1 {
+			t.Errorf("wrong session by IP: %v, %v", got, err)
+		}
+		if diff := cmp.Diff([]string{"001010000000001", "001010000000002"}, imsisOf(conn.GetSessionsByAPN("internet"))); diff != "" {
+			t.Errorf("wrong sessions by APN: %s", diff)
+		}
+		if diff := cmp.Diff([]string{"001010000000001"}, imsisOf(conn.GetSessionsByPeer(&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 2123}))); diff != "" {
+			t.Errorf("wrong sessions by peer: %s", diff)
+		}
+		if _, err := conn.GetSessionByMSISDN("819000000009"); !errors.Is(err, gtpv2.ErrSessionNotFound) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		// updated with the methods of Session.
+		sess1.UpdatePeerAddr(&net.UDPAddr{IP: net.ParseIP("127.0.0.9"), Port: 2123})
+		if diff := cmp.Diff([]string{"001010000000001", "001010000000002"}, imsisOf(conn.GetSessionsByPeer(&net.UDPAddr{IP: net.ParseIP("127.0.0.9"), Port: 2123}))); diff != "" {
+			t.Errorf("wrong sessions by peer: %s", diff)
+		}
+		sess1.RemoveBearer("dedicated")
+		sess2.RemoveBearer("dedicated")
+		if diff := cmp.Diff([]string{"001010000000001"}, imsisOf(conn.GetSessionsByAPN("internet"))); diff != "" {
+			t.Errorf("wrong sessions by APN: %s", diff)
+		}
+
+		// updated directly, which is not returned by the stale index.
+		sess1.GetDefaultBearer().SubscriberIP = "10.10.10.3"
+		if _, err := conn.GetSessionBySubscriberIP("10.10.10.1"); !errors.Is(err, gtpv2.ErrSessionNotFound) {
+			t.Errorf("stale session found: %v", err)
+		}
+		sess1.Reindex()
+		if got, err := conn.GetSessionBySubscriberIP("10.10.10.3"); err != nil || got != sess1 {
+			t.Errorf("wrong session by IP: %v, %v", got, err)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		conn.RemoveSession(sess2)
+		if _, err := conn.GetSessionByMSISDN("819000000002"); !errors.Is(err, gtpv2.ErrSessionNotFound) {
+			t.Errorf("removed session found: %v", err)
+		}
+		if got := conn.GetSessionsByAPN("ims"); len(got) != 0 {
+			t.Errorf("removed session found by APN: %v", imsisOf(got))
+		}
+	})
+}
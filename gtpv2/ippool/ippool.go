@@ -0,0 +1,594 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package ippool provides the allocator of the UE's IP addresses for PDN Address
+// Allocation(PAA), which is typically used by P-GW.
+//
+// Pool manages the IPv4 address ranges and the IPv6 prefixes per APN. An IPv6
+// prefix larger than /64 is delegated to UEs by /64. The allocations can be tied to
+// gtpv2.Session so that they are released when the Session is removed from Conn,
+// and can be saved to and loaded from a file to survive the restart.
+package ippool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// IPv6PrefixLength is the length of IPv6 prefix allocated to a UE.
+const IPv6PrefixLength = 64
+
+// Error definitions.
+var (
+	ErrUnknownAPN          = errors.New("no pool is configured for the APN")
+	ErrExhausted           = errors.New("no address is available in the pool")
+	ErrAddressInUse        = errors.New("address is already allocated to another UE")
+	ErrPDNTypeNotSupported = errors.New("PDN type is not supported by the pool for the APN")
+	ErrInvalidRange        = errors.New("invalid address range")
+	ErrNotAllocated        = errors.New("address is not allocated")
+)
+
+// Allocation is the addresses allocated to a UE.
+type Allocation struct {
+	APN string
+
+	// Owner identifies the UE, typically the IMSI.
+	Owner string
+
+	// IPv4 is the IPv4 address allocated, or nil.
+	IPv4 net.IP
+
+	// IPv6 is the /64 prefix allocated, or nil.
+	IPv6 net.IP
+
+	// Static reports whether the addresses are the ones requested by UE.
+	Static bool
+}
+
+// PDNType returns the PDN Type of the Allocation.
+func (a *Allocation) PDNType() uint8 {
+	switch {
+	case a.IPv4 != nil && a.IPv6 != nil:
+		return gtpv2.PDNTypeIPv4v6
+	case a.IPv6 != nil:
+		return gtpv2.PDNTypeIPv6
+	default:
+		return gtpv2.PDNTypeIPv4
+	}
+}
+
+// PAA returns the PDNAddressAllocation IE with the addresses in the Allocation.
+func (a *Allocation) PAA() *ie.IE {
+	switch a.PDNType() {
+	case gtpv2.PDNTypeIPv4v6:
+		return ie.NewPDNAddressAllocationDualNetIP(a.IPv4, a.IPv6, IPv6PrefixLength)
+	case gtpv2.PDNTypeIPv6:
+		return ie.NewPDNAddressAllocationNetIP(a.IPv6, IPv6PrefixLength)
+	default:
+		return ie.NewPDNAddressAllocationNetIP(a.IPv4, 0)
+	}
+}
+
+// String returns the addresses in the Allocation in human readable format.
+func (a *Allocation) String() string {
+	switch a.PDNType() {
+	case gtpv2.PDNTypeIPv4v6:
+		return fmt.Sprintf("%s %s/%d", a.IPv4, a.IPv6, IPv6PrefixLength)
+	case gtpv2.PDNTypeIPv6:
+		return fmt.Sprintf("%s/%d", a.IPv6, IPv6PrefixLength)
+	default:
+		return a.IPv4.String()
+	}
+}
+
+// Pool allocates the IP addresses of UEs per APN.
+//
+// Pool is safe for concurrent use.
+type Pool struct {
+	// Quarantine is the duration that a released address is not allocated to
+	// the other UEs. It should be set before allocating any addresses.
+	Quarantine time.Duration
+
+	mu   sync.Mutex
+	apns map[string]*apnPool
+	now  func() time.Time
+}
+
+// apnPool is the set of address blocks for an APN.
+type apnPool struct {
+	v4, v6 []*block
+
+	// static is the static addresses that are not in any block, keyed by address.
+	static map[string]*Allocation
+}
+
+// NewPool creates a new Pool without any addresses.
+func NewPool() *Pool {
+	return &Pool{
+		apns: map[string]*apnPool{},
+		now:  time.Now,
+	}
+}
+
+func (p *Pool) apn(name string) *apnPool {
+	a, ok := p.apns[name]
+	if !ok {
+		a = &apnPool{static: map[string]*Allocation{}}
+		p.apns[name] = a
+	}
+	return a
+}
+
+// AddIPv4Range adds the IPv4 addresses from start to end (inclusive) to the pool for apn.
+func (p *Pool) AddIPv4Range(apn string, start, end net.IP) error {
+	s, e := start.To4(), end.To4()
+	if s == nil || e == nil {
+		return ErrInvalidRange
+	}
+	first, last := binary.BigEndian.Uint32(s), binary.BigEndian.Uint32(e)
+	if first > last {
+		return ErrInvalidRange
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b := newBlock(false, uint64(first), uint64(last)-uint64(first)+1)
+	a := p.apn(apn)
+	for _, other := range a.v4 {
+		if b.overlaps(other) {
+			return ErrInvalidRange
+		}
+	}
+	a.v4 = append(a.v4, b)
+	return nil
+}
+
+// AddIPv4Prefix adds the IPv4 addresses in prefix, e.g., "10.45.0.0/16", to the pool
+// for apn. The network and broadcast addresses are excluded if the prefix is /30
+// or shorter.
+func (p *Pool) AddIPv4Prefix(apn, prefix string) error {
+	_, n, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return err
+	}
+	v4 := n.IP.To4()
+	ones, bits := n.Mask.Size()
+	if v4 == nil || bits != 32 {
+		return ErrInvalidRange
+	}
+
+	first := binary.BigEndian.Uint32(v4)
+	last := first | (1<<(32-ones) - 1)
+	if ones <= 30 {
+		first, last = first+1, last-1
+	}
+	return p.AddIPv4Range(apn, uint32ToIP(first), uint32ToIP(last))
+}
+
+// AddIPv6Prefix adds the IPv6 prefix, e.g., "2001:db8::/48", to the pool for apn.
+// The prefix is delegated to UEs by /64, and thus it should be /64 or shorter.
+func (p *Pool) AddIPv6Prefix(apn, prefix string) error {
+	_, n, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return err
+	}
+	ones, bits := n.Mask.Size()
+	if bits != 128 || ones == 0 || ones > IPv6PrefixLength {
+		return ErrInvalidRange
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b := newBlock(true, binary.BigEndian.Uint64(n.IP[:8]), 1<<(IPv6PrefixLength-ones))
+	a := p.apn(apn)
+	for _, other := range a.v6 {
+		if b.overlaps(other) {
+			return ErrInvalidRange
+		}
+	}
+	a.v6 = append(a.v6, b)
+	return nil
+}
+
+// Allocate allocates the addresses of pdnType(gtpv2.PDNTypeIPv4, gtpv2.PDNTypeIPv6
+// or gtpv2.PDNTypeIPv4v6) for owner from the pool for apn.
+//
+// staticV4 and staticV6 are the addresses requested by UE, which are honoured if
+// not allocated to the other UE. They can be nil or unspecified address to let the
+// pool choose. Note that the static addresses are allocated even if they are out of
+// the ranges in the pool.
+//
+// If pdnType is gtpv2.PDNTypeIPv4v6 but the pool for apn has only one of the families,
+// the address of that family is allocated. Check PDNType of the Allocation returned.
+// The static address that is already allocated to the same owner, e.g., on re-attach,
+// is taken over by the new Allocation.
+func (p *Pool) Allocate(apn, owner string, pdnType uint8, staticV4, staticV6 net.IP) (*Allocation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a, ok := p.apns[apn]
+	if !ok {
+		return nil, ErrUnknownAPN
+	}
+
+	wantV4 := pdnType == gtpv2.PDNTypeIPv4 || pdnType == gtpv2.PDNTypeIPv4v6
+	wantV6 := pdnType == gtpv2.PDNTypeIPv6 || pdnType == gtpv2.PDNTypeIPv4v6
+	if pdnType == gtpv2.PDNTypeIPv4v6 {
+		wantV4 = wantV4 && (len(a.v4) > 0 || isSpecified(staticV4))
+		wantV6 = wantV6 && (len(a.v6) > 0 || isSpecified(staticV6))
+	}
+	if !wantV4 && !wantV6 {
+		return nil, ErrPDNTypeNotSupported
+	}
+
+	alloc := &Allocation{APN: apn, Owner: owner}
+	if wantV4 {
+		ip, static, err := p.allocate(a, false, alloc, staticV4)
+		if err != nil {
+			return nil, err
+		}
+		alloc.IPv4, alloc.Static = ip, static
+	}
+	if wantV6 {
+		ip, static, err := p.allocate(a, true, alloc, staticV6)
+		if err != nil {
+			if alloc.IPv4 != nil {
+				p.free(a, alloc.IPv4, false, false)
+			}
+			return nil, err
+		}
+		alloc.IPv6, alloc.Static = ip, alloc.Static || static
+	}
+
+	return alloc, nil
+}
+
+// allocate allocates an address of the family to alloc. p.mu must be held.
+func (p *Pool) allocate(a *apnPool, v6 bool, alloc *Allocation, static net.IP) (net.IP, bool, error) {
+	blocks := a.v4
+	if v6 {
+		blocks = a.v6
+	}
+
+	if isSpecified(static) {
+		ip := normalize(static, v6)
+		if ip == nil {
+			return nil, false, ErrInvalidRange
+		}
+
+		for _, b := range blocks {
+			off, ok := b.offsetOf(ip)
+			if !ok {
+				continue
+			}
+			if other, ok := b.used[off]; ok {
+				if other.Owner != alloc.Owner {
+					return nil, false, ErrAddressInUse
+				}
+			}
+			delete(b.quarantine, off)
+			b.used[off] = alloc
+			return b.ipAt(off), true, nil
+		}
+
+		if other, ok := a.static[ip.String()]; ok && other.Owner != alloc.Owner {
+			return nil, false, ErrAddressInUse
+		}
+		a.static[ip.String()] = alloc
+		return ip, true, nil
+	}
+
+	if len(blocks) == 0 {
+		return nil, false, ErrPDNTypeNotSupported
+	}
+	now := p.now()
+	for _, b := range blocks {
+		if off, ok := b.allocate(now); ok {
+			b.used[off] = alloc
+			return b.ipAt(off), false, nil
+		}
+	}
+	return nil, false, ErrExhausted
+}
+
+// AllocateFromRequest allocates the addresses for the UE that sent the Create
+// Session Request, taking the APN, PDN Type and static addresses in PAA from req,
+// and IMSI as the owner.
+func (p *Pool) AllocateFromRequest(req *message.CreateSessionRequest) (*Allocation, error) {
+	if req.APN == nil {
+		return nil, &gtpv2.RequiredIEMissingError{Type: ie.AccessPointName}
+	}
+	apn, err := req.APN.AccessPointName()
+	if err != nil {
+		return nil, err
+	}
+
+	var owner string
+	if req.IMSI != nil {
+		owner, err = req.IMSI.IMSI()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pdnType := gtpv2.PDNTypeIPv4
+	if req.PDNType != nil {
+		pdnType, err = req.PDNType.PDNType()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var staticV4, staticV6 net.IP
+	if req.PAA != nil {
+		f, err := ie.ParsePDNAddressAllocationFields(req.PAA.Payload)
+		if err != nil {
+			return nil, err
+		}
+		staticV4, staticV6 = f.IPv4Address, f.IPv6Address
+	}
+
+	return p.Allocate(apn, owner, pdnType, staticV4, staticV6)
+}
+
+// AllocateForSession allocates the addresses with AllocateFromRequest, and ties the
+// Allocation to sess; the SubscriberIP and APN of the default bearer are set, and
+// the addresses are released when sess is removed from Conn with RemoveSession.
+func (p *Pool) AllocateForSession(sess *gtpv2.Session, req *message.CreateSessionRequest) (*Allocation, error) {
+	alloc, err := p.AllocateFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if br := sess.GetDefaultBearer(); br != nil {
+		br.APN = alloc.APN
+		if alloc.IPv4 != nil {
+			br.SubscriberIP = alloc.IPv4.String()
+		} else {
+			br.SubscriberIP = alloc.IPv6.String()
+		}
+	}
+	sess.Reindex()
+	sess.AddReleaseFunc(func() {
+		_ = p.Release(alloc)
+	})
+	return alloc, nil
+}
+
+// Release releases the addresses in alloc. The addresses in the ranges of the
+// pool are quarantined for the duration specified by Quarantine, while the static
+// addresses out of the ranges are just released.
+func (p *Pool) Release(alloc *Allocation) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a, ok := p.apns[alloc.APN]
+	if !ok {
+		return ErrUnknownAPN
+	}
+
+	var err error
+	if alloc.IPv4 != nil {
+		if !p.owned(a, alloc.IPv4, false, alloc.Owner) {
+			err = ErrNotAllocated
+		} else {
+			p.free(a, alloc.IPv4, false, true)
+		}
+	}
+	if alloc.IPv6 != nil {
+		if !p.owned(a, alloc.IPv6, true, alloc.Owner) {
+			err = ErrNotAllocated
+		} else {
+			p.free(a, alloc.IPv6, true, true)
+		}
+	}
+	return err
+}
+
+// owned reports whether ip is allocated to owner. p.mu must be held.
+func (p *Pool) owned(a *apnPool, ip net.IP, v6 bool, owner string) bool {
+	ip = normalize(ip, v6)
+	if ip == nil {
+		return false
+	}
+	if other, ok := a.static[ip.String()]; ok {
+		return other.Owner == owner
+	}
+
+	blocks := a.v4
+	if v6 {
+		blocks = a.v6
+	}
+	for _, b := range blocks {
+		if off, ok := b.offsetOf(ip); ok {
+			other, ok := b.used[off]
+			return ok && other.Owner == owner
+		}
+	}
+	return false
+}
+
+// free frees ip. p.mu must be held.
+func (p *Pool) free(a *apnPool, ip net.IP, v6, quarantine bool) {
+	ip = normalize(ip, v6)
+	if _, ok := a.static[ip.String()]; ok {
+		delete(a.static, ip.String())
+		return
+	}
+
+	blocks := a.v4
+	if v6 {
+		blocks = a.v6
+	}
+	for _, b := range blocks {
+		if off, ok := b.offsetOf(ip); ok {
+			delete(b.used, off)
+			if quarantine && p.Quarantine > 0 {
+				b.quarantine[off] = p.now().Add(p.Quarantine)
+			}
+			return
+		}
+	}
+}
+
+// Lookup returns the Allocation of owner in the pool for apn.
+func (p *Pool) Lookup(apn, owner string) (*Allocation, error) {
+	for _, alloc := range p.Allocations() {
+		if alloc.APN == apn && alloc.Owner == owner {
+			return alloc, nil
+		}
+	}
+	return nil, ErrNotAllocated
+}
+
+// Allocations returns all the Allocations in the pool.
+func (p *Pool) Allocations() []*Allocation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := map[*Allocation]bool{}
+	var allocs []*Allocation
+	add := func(alloc *Allocation) {
+		if !seen[alloc] {
+			seen[alloc] = true
+			allocs = append(allocs, alloc)
+		}
+	}
+	for _, a := range p.apns {
+		for _, b := range append(append([]*block{}, a.v4...), a.v6...) {
+			for _, alloc := range b.used {
+				add(alloc)
+			}
+		}
+		for _, alloc := range a.static {
+			add(alloc)
+		}
+	}
+	return allocs
+}
+
+// Stats returns the number of addresses in use and available in the pool for apn.
+// The IPv4 addresses and IPv6 prefixes are counted separately.
+func (p *Pool) Stats(apn string) (v4Used, v4Free, v6Used, v6Free uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a, ok := p.apns[apn]
+	if !ok {
+		return
+	}
+	for _, b := range a.v4 {
+		v4Used += uint64(len(b.used))
+		v4Free += b.size - uint64(len(b.used))
+	}
+	for _, b := range a.v6 {
+		v6Used += uint64(len(b.used))
+		v6Free += b.size - uint64(len(b.used))
+	}
+	return
+}
+
+func isSpecified(ip net.IP) bool {
+	return ip != nil && !ip.IsUnspecified()
+}
+
+// normalize returns ip in 4-byte form for IPv4, or the /64 prefix for IPv6.
+func normalize(ip net.IP, v6 bool) net.IP {
+	if !v6 {
+		return ip.To4()
+	}
+	if ip.To4() != nil || ip.To16() == nil {
+		return nil
+	}
+	return ip.To16().Mask(net.CIDRMask(IPv6PrefixLength, 128))
+}
+
+func uint32ToIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
+
+// block is a contiguous range of IPv4 addresses or IPv6 /64 prefixes.
+type block struct {
+	v6 bool
+
+	// base is the first IPv4 address, or the upper 64 bits of the first IPv6 prefix.
+	base, size uint64
+
+	// next is the offset to start searching from.
+	next uint64
+
+	used       map[uint64]*Allocation
+	quarantine map[uint64]time.Time
+}
+
+func newBlock(v6 bool, base, size uint64) *block {
+	return &block{
+		v6:         v6,
+		base:       base,
+		size:       size,
+		used:       map[uint64]*Allocation{},
+		quarantine: map[uint64]time.Time{},
+	}
+}
+
+func (b *block) overlaps(other *block) bool {
+	return b.base < other.base+other.size && other.base < b.base+b.size
+}
+
+func (b *block) offsetOf(ip net.IP) (uint64, bool) {
+	var v uint64
+	if b.v6 {
+		v = binary.BigEndian.Uint64(ip[:8])
+	} else {
+		v = uint64(binary.BigEndian.Uint32(ip))
+	}
+	if v < b.base || v-b.base >= b.size {
+		return 0, false
+	}
+	return v - b.base, true
+}
+
+func (b *block) ipAt(off uint64) net.IP {
+	if b.v6 {
+		ip := make(net.IP, net.IPv6len)
+		binary.BigEndian.PutUint64(ip[:8], b.base+off)
+		return ip
+	}
+	return uint32ToIP(uint32(b.base + off))
+}
+
+// allocate finds a free offset that is not quarantined, round-robin.
+func (b *block) allocate(now time.Time) (uint64, bool) {
+	if uint64(len(b.used)) >= b.size {
+		return 0, false
+	}
+
+	for i := uint64(0); i < b.size; i++ {
+		off := (b.next + i) % b.size
+		if _, ok := b.used[off]; ok {
+			continue
+		}
+		if until, ok := b.quarantine[off]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(b.quarantine, off)
+		}
+		b.next = (off + 1) % b.size
+		return off, true
+	}
+	return 0, false
+}
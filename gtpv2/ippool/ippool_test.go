@@ -0,0 +1,273 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ippool_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/ippool"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+func newPool(t *testing.T) *ippool.Pool {
+	t.Helper()
+
+	p := ippool.NewPool()
+	if err := p.AddIPv4Prefix("internet", "10.45.0.0/30"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddIPv6Prefix("internet", "2001:db8:0:4::/62"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddIPv4Range("v4only", net.ParseIP("192.0.2.10"), net.ParseIP("192.0.2.11")); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAllocateIPv4(t *testing.T) {
+	p := newPool(t)
+
+	a1, err := p.Allocate("internet", "001010000000001", gtpv2.PDNTypeIPv4, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := p.Allocate("internet", "001010000000002", gtpv2.PDNTypeIPv4, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a1.String() + "," + a2.String(); got != "10.45.0.1,10.45.0.2" {
+		t.Errorf("wrong addresses: %s", got)
+	}
+	if got := a1.PAA().MustIPAddress(); got != "10.45.0.1" {
+		t.Errorf("wrong PAA: %s", got)
+	}
+
+	if _, err := p.Allocate("internet", "001010000000003", gtpv2.PDNTypeIPv4, nil, nil); !errors.Is(err, ippool.ErrExhausted) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := p.Allocate("unknown", "001010000000003", gtpv2.PDNTypeIPv4, nil, nil); !errors.Is(err, ippool.ErrUnknownAPN) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := p.Allocate("v4only", "001010000000003", gtpv2.PDNTypeIPv6, nil, nil); !errors.Is(err, ippool.ErrPDNTypeNotSupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := p.Release(a1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Release(a1); !errors.Is(err, ippool.ErrNotAllocated) {
+		t.Errorf("unexpected error on double release: %v", err)
+	}
+	a3, err := p.Allocate("internet", "001010000000003", gtpv2.PDNTypeIPv4, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a3.IPv4.String(); got != "10.45.0.1" {
+		t.Errorf("released address not reused: %s", got)
+	}
+}
+
+func TestAllocateIPv6AndDual(t *testing.T) {
+	p := newPool(t)
+
+	a, err := p.Allocate("internet", "001010000000001", gtpv2.PDNTypeIPv4v6, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.String(); got != "10.45.0.1 2001:db8:0:4::/64" {
+		t.Errorf("wrong addresses: %s", got)
+	}
+	paa, err := ie.ParsePDNAddressAllocationFields(a.PAA().Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paa.PDNType != gtpv2.PDNTypeIPv4v6 || paa.IPv6PrefixLength != 64 {
+		t.Errorf("wrong PAA: %+v", paa)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Allocate("internet", "", gtpv2.PDNTypeIPv6, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.Allocate("internet", "", gtpv2.PDNTypeIPv6, nil, nil); !errors.Is(err, ippool.ErrExhausted) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, v6Used, v6Free := p.Stats("internet"); v6Used != 4 || v6Free != 0 {
+		t.Errorf("wrong stats: %d, %d", v6Used, v6Free)
+	}
+
+	// the IPv4 address is not leaked when IPv6 is exhausted.
+	if _, err := p.Allocate("internet", "", gtpv2.PDNTypeIPv4v6, nil, nil); !errors.Is(err, ippool.ErrExhausted) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v4Used, _, _, _ := p.Stats("internet"); v4Used != 1 {
+		t.Errorf("IPv4 address leaked: %d", v4Used)
+	}
+
+	// falls back to IPv4 if no IPv6 prefix is configured.
+	a, err = p.Allocate("v4only", "", gtpv2.PDNTypeIPv4v6, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.PDNType() != gtpv2.PDNTypeIPv4 {
+		t.Errorf("wrong PDN type: %d", a.PDNType())
+	}
+}
+
+func TestAllocateStatic(t *testing.T) {
+	p := newPool(t)
+
+	a, err := p.Allocate("internet", "001010000000001", gtpv2.PDNTypeIPv4, net.ParseIP("10.45.0.2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Static || a.IPv4.String() != "10.45.0.2" {
+		t.Errorf("static address not honoured: %+v", a)
+	}
+	if _, err := p.Allocate("internet", "001010000000002", gtpv2.PDNTypeIPv4, net.ParseIP("10.45.0.2"), nil); !errors.Is(err, ippool.ErrAddressInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the dynamic allocation skips the static address.
+	d, err := p.Allocate("internet", "001010000000002", gtpv2.PDNTypeIPv4, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.IPv4.String() != "10.45.0.1" {
+		t.Errorf("wrong address: %s", d.IPv4)
+	}
+
+	// out of the ranges.
+	out, err := p.Allocate("internet", "001010000000003", gtpv2.PDNTypeIPv4v6, net.ParseIP("198.51.100.1"), net.ParseIP("2001:db8:ffff::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "198.51.100.1 2001:db8:ffff::/64" {
+		t.Errorf("wrong addresses: %s", got)
+	}
+	if err := p.Release(out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	p := ippool.NewPool()
+	p.Quarantine = 50 * time.Millisecond
+	if err := p.AddIPv4Range("internet", net.ParseIP("10.45.0.1"), net.ParseIP("10.45.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := p.Allocate("internet", "001010000000001", gtpv2.PDNTypeIPv4, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Release(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Allocate("internet", "001010000000002", gtpv2.PDNTypeIPv4, nil, nil); !errors.Is(err, ippool.ErrExhausted) {
+		t.Errorf("quarantined address allocated: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := p.Allocate("internet", "001010000000002", gtpv2.PDNTypeIPv4, nil, nil); err != nil {
+		t.Errorf("address not released after quarantine: %v", err)
+	}
+}
+
+func TestAllocateForSession(t *testing.T) {
+	p := newPool(t)
+
+	conn := gtpv2.NewConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2123}, gtpv2.IFTypeS5S8PGWGTPC, 0)
+	sess := gtpv2.NewSession(&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 2123}, &gtpv2.Subscriber{IMSI: "001010000000001"})
+
+	req := message.NewCreateSessionRequest(
+		0, 0,
+		ie.NewIMSI("001010000000001"),
+		ie.NewAccessPointName("internet"),
+		ie.NewPDNType(gtpv2.PDNTypeIPv4v6),
+		ie.NewPDNAddressAllocationDual("0.0.0.0", "::", 0),
+	)
+	a, err := p.AllocateForSession(sess, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sess.GetDefaultBearer().SubscriberIP; got != a.IPv4.String() {
+		t.Errorf("wrong SubscriberIP: %s", got)
+	}
+	if got, err := p.Lookup("internet", "001010000000001"); err != nil || got != a {
+		t.Errorf("allocation not found: %v, %v", got, err)
+	}
+
+	conn.RegisterSession(0x11111111, sess)
+	conn.RemoveSession(sess)
+	if v4Used, _, v6Used, _ := p.Stats("internet"); v4Used != 0 || v6Used != 0 {
+		t.Errorf("not released on RemoveSession: %d, %d", v4Used, v6Used)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	p := newPool(t)
+	p.Quarantine = time.Hour
+
+	a, err := p.Allocate("internet", "001010000000001", gtpv2.PDNTypeIPv4v6, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	released, err := p.Allocate("internet", "001010000000002", gtpv2.PDNTypeIPv4, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Release(released); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pool.json")
+	if err := p.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newPool(t)
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := restored.Lookup("internet", "001010000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != a.String() {
+		t.Errorf("wrong allocation restored: %s", got)
+	}
+
+	// both addresses are either allocated or quarantined.
+	if _, err := restored.Allocate("internet", "001010000000003", gtpv2.PDNTypeIPv4, nil, nil); !errors.Is(err, ippool.ErrExhausted) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// conflicting allocation.
+	conflict := newPool(t)
+	if _, err := conflict.Allocate("internet", "001010000000009", gtpv2.PDNTypeIPv4, net.ParseIP("10.45.0.1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := p.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := conflict.Load(&buf); !errors.Is(err, ippool.ErrAddressInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := ippool.NewPool().LoadFile(filepath.Join(t.TempDir(), "not-exist.json")); err != nil {
+		t.Errorf("unexpected error for missing file: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ippool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotVersion is the version of the format written by Save.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version     int            `json:"version"`
+	Allocations []*Allocation  `json:"allocations"`
+	Quarantined []*quarantined `json:"quarantined,omitempty"`
+}
+
+type quarantined struct {
+	APN   string    `json:"apn"`
+	IP    net.IP    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// Save writes the allocations and the quarantined addresses in the pool to w in JSON.
+//
+// The address ranges are not saved; they should be configured again before Load.
+func (p *Pool) Save(w io.Writer) error {
+	s := &snapshot{Version: snapshotVersion, Allocations: p.Allocations()}
+	sort.Slice(s.Allocations, func(i, j int) bool {
+		if s.Allocations[i].APN != s.Allocations[j].APN {
+			return s.Allocations[i].APN < s.Allocations[j].APN
+		}
+		return s.Allocations[i].Owner < s.Allocations[j].Owner
+	})
+
+	p.mu.Lock()
+	now := p.now()
+	for name, a := range p.apns {
+		for _, b := range append(append([]*block{}, a.v4...), a.v6...) {
+			for off, until := range b.quarantine {
+				if now.Before(until) {
+					s.Quarantined = append(s.Quarantined, &quarantined{APN: name, IP: b.ipAt(off), Until: until})
+				}
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// Load reads the allocations and the quarantined addresses written by Save from r,
+// and restores them in the pool. The address ranges for the APNs in r should be
+// configured in advance.
+//
+// It returns error if any of the allocations conflicts with the ones in the pool,
+// in which case the allocations before the conflicting one are kept restored.
+func (p *Pool) Load(r io.Reader) error {
+	s := &snapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return err
+	}
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unsupported version of allocations: %d", s.Version)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, alloc := range s.Allocations {
+		a, ok := p.apns[alloc.APN]
+		if !ok {
+			return fmt.Errorf("failed to restore %s for %s: %w", alloc, alloc.Owner, ErrUnknownAPN)
+		}
+		if alloc.IPv4 != nil {
+			if _, _, err := p.allocate(a, false, alloc, alloc.IPv4); err != nil {
+				return fmt.Errorf("failed to restore %s for %s: %w", alloc, alloc.Owner, err)
+			}
+		}
+		if alloc.IPv6 != nil {
+			if _, _, err := p.allocate(a, true, alloc, alloc.IPv6); err != nil {
+				return fmt.Errorf("failed to restore %s for %s: %w", alloc, alloc.Owner, err)
+			}
+		}
+	}
+
+	for _, q := range s.Quarantined {
+		a, ok := p.apns[q.APN]
+		if !ok {
+			continue
+		}
+		v6 := q.IP.To4() == nil
+		blocks := a.v4
+		if v6 {
+			blocks = a.v6
+		}
+		ip := normalize(q.IP, v6)
+		if ip == nil {
+			continue
+		}
+		for _, b := range blocks {
+			if off, ok := b.offsetOf(ip); ok {
+				if _, used := b.used[off]; !used {
+					b.quarantine[off] = q.Until
+				}
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// SaveFile saves the pool to the file at path with Save. The file is replaced
+// atomically so that it is not corrupted on crash.
+func (p *Pool) SaveFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := p.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadFile loads the pool from the file at path with Load. It does nothing if
+// the file does not exist.
+func (p *Pool) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	return p.Load(f)
+}
@@ -0,0 +1,420 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package loadgen provides a signalling load generator for benchmarking S-GW/P-GW,
+// built on gtpv2.Conn.
+//
+// Generator emulates an MME (on S11) or an S-GW (on S5/S8) that creates sessions for
+// a range of IMSIs at the target rate, runs a mix of procedures during the hold time,
+// and deletes them. Requests are retransmitted on T3 expiry up to N3 times, so that
+// it keeps working under packet loss.
+//
+// See examples/loadgen for the command-line interface built on this package.
+package loadgen
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Role is the role of the node the Generator emulates.
+type Role int
+
+// Role definitions.
+const (
+	RoleMME Role = iota // sends requests to S-GW on S11
+	RoleSGW             // sends requests to P-GW on S5/S8
+)
+
+// Procedure is a type of procedure the Generator runs.
+type Procedure int
+
+// Procedure definitions.
+const (
+	ProcedureCreateSession Procedure = iota
+	ProcedureModifyBearer
+	ProcedureReleaseAccessBearers
+	ProcedureDeleteSession
+)
+
+// String returns the name of the Procedure.
+func (p Procedure) String() string {
+	switch p {
+	case ProcedureCreateSession:
+		return "CreateSession"
+	case ProcedureModifyBearer:
+		return "ModifyBearer"
+	case ProcedureReleaseAccessBearers:
+		return "ReleaseAccessBearers"
+	case ProcedureDeleteSession:
+		return "DeleteSession"
+	default:
+		return "Unknown(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// Config is a configuration of Generator.
+type Config struct {
+	Role Role
+
+	// IMSIStart is the first IMSI in the range, and Subscribers is the number of
+	// IMSIs in the range. The IMSIs are used in order and reused after the sessions
+	// with them are deleted.
+	IMSIStart   string
+	Subscribers int
+
+	MCC, MNC string
+	APN      string
+
+	// PGWAddress is the IP of P-GW put in the S5/S8 P-GW F-TEID on RoleMME.
+	PGWAddress string
+
+	// Rate is the number of session attempts (Create Session) per second.
+	Rate float64
+
+	// Duration is how long the Generator keeps starting new sessions. The sessions
+	// started before it expires are run till the end.
+	Duration time.Duration
+
+	// HoldTime is the time between Create Session and Delete Session.
+	HoldTime time.Duration
+
+	// Mix is the number of each procedure run during HoldTime per session.
+	// Only ProcedureModifyBearer and ProcedureReleaseAccessBearers(RoleMME only)
+	// are allowed.
+	Mix map[Procedure]int
+
+	// T3 is the retransmission timer and N3 is the maximum number of
+	// retransmissions. Defaults to 3s and 2.
+	T3 time.Duration
+	N3 int
+}
+
+// withDefaults returns a copy of the Config with the defaults set to the fields
+// not given.
+func (c *Config) withDefaults() *Config {
+	cfg := *c
+	if cfg.T3 == 0 {
+		cfg.T3 = 3 * time.Second
+	}
+	if cfg.N3 == 0 {
+		cfg.N3 = 2
+	}
+	return &cfg
+}
+
+func (c *Config) validate() error {
+	if _, err := strconv.ParseUint(c.IMSIStart, 10, 64); err != nil {
+		return fmt.Errorf("invalid IMSIStart: %w", err)
+	}
+	if c.Subscribers <= 0 {
+		return errors.New("Subscribers must be positive")
+	}
+	if c.Rate <= 0 {
+		return errors.New("Rate must be positive")
+	}
+
+	for p := range c.Mix {
+		switch p {
+		case ProcedureModifyBearer:
+		case ProcedureReleaseAccessBearers:
+			if c.Role != RoleMME {
+				return errors.New("ReleaseAccessBearers is only available on RoleMME")
+			}
+		default:
+			return fmt.Errorf("%s is not allowed in Mix", p)
+		}
+	}
+	return nil
+}
+
+// Generator generates the signalling load over a gtpv2.Conn.
+type Generator struct {
+	conn    *gtpv2.Conn
+	peer    net.Addr
+	cfg     *Config
+	localIP string
+
+	imsiStart uint64
+	mix       []Procedure
+	tx        *transactor
+	stats     *stats
+	teid      uint32
+}
+
+// New creates a new Generator that sends requests to peer over conn.
+//
+// The Conn should be serving already. New registers the handlers for the responses.
+// As the Generator does not register any Session to the Conn, the validation of the
+// incoming messages should be disabled with DisableValidation before serving.
+//
+// cfg is not modified, and should not be modified after New is called.
+func New(conn *gtpv2.Conn, peer net.Addr, cfg *Config) (*Generator, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	ip, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Generator{
+		conn:    conn,
+		peer:    peer,
+		cfg:     cfg,
+		localIP: ip,
+		tx:      newTransactor(conn, cfg.T3, cfg.N3),
+		stats:   newStats(),
+		teid:    randomUint32(),
+	}
+	g.imsiStart, _ = strconv.ParseUint(cfg.IMSIStart, 10, 64)
+
+	procs := make([]Procedure, 0, len(cfg.Mix))
+	for p := range cfg.Mix {
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i] < procs[j] })
+	for _, p := range procs {
+		for i := 0; i < cfg.Mix[p]; i++ {
+			g.mix = append(g.mix, p)
+		}
+	}
+
+	conn.AddHandlers(map[uint8]gtpv2.HandlerFunc{
+		message.MsgTypeCreateSessionResponse:        g.tx.handleResponse,
+		message.MsgTypeModifyBearerResponse:         g.tx.handleResponse,
+		message.MsgTypeReleaseAccessBearersResponse: g.tx.handleResponse,
+		message.MsgTypeDeleteSessionResponse:        g.tx.handleResponse,
+	})
+	return g, nil
+}
+
+// Report returns the statistics at the moment. It is safe to call this while Run
+// is working.
+func (g *Generator) Report() *Report {
+	return g.stats.snapshot()
+}
+
+// Run starts sessions at the configured rate until Duration expires or ctx is
+// canceled, waits for the sessions to be deleted, and returns the Report.
+func (g *Generator) Run(ctx context.Context) (*Report, error) {
+	g.stats.begin()
+
+	interval := time.Duration(float64(time.Second) / g.cfg.Rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var deadline <-chan time.Time
+	if g.cfg.Duration > 0 {
+		timer := time.NewTimer(g.cfg.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	var (
+		wg   sync.WaitGroup
+		busy = make([]int32, g.cfg.Subscribers)
+		next int
+	)
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-deadline:
+			break loop
+		case <-ticker.C:
+		}
+
+		idx := next
+		next = (next + 1) % g.cfg.Subscribers
+		if !atomic.CompareAndSwapInt32(&busy[idx], 0, 1) {
+			g.stats.skip()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer atomic.StoreInt32(&busy[idx], 0)
+
+			g.runSession(ctx, fmt.Sprintf("%015d", g.imsiStart+uint64(idx)))
+		}()
+	}
+
+	wg.Wait()
+	return g.Report(), ctx.Err()
+}
+
+// result is the result of a transaction.
+type result struct {
+	rsp      message.Message
+	cause    uint8
+	latency  time.Duration
+	retries  int
+	timedOut bool
+	err      error
+}
+
+func (r *result) accepted() bool {
+	return r.err == nil && !r.timedOut &&
+		(r.cause == gtpv2.CauseRequestAccepted || r.cause == gtpv2.CauseRequestAcceptedPartially)
+}
+
+func (g *Generator) transact(ctx context.Context, p Procedure, msg message.Message) *result {
+	start := time.Now()
+	rsp, retries, err := g.tx.request(ctx, msg, g.peer)
+	r := &result{rsp: rsp, latency: time.Since(start), retries: retries}
+	switch {
+	case errors.Is(err, gtpv2.ErrTimeout):
+		r.timedOut = true
+	case err != nil:
+		r.err = err
+	default:
+		r.cause, r.err = causeOf(rsp)
+	}
+
+	// don't count the transactions aborted by the caller.
+	if ctx.Err() == nil {
+		g.stats.record(p, r)
+	}
+	return r
+}
+
+func (g *Generator) runSession(ctx context.Context, imsi string) {
+	senderIF, s5PGW := gtpv2.IFTypeS11MMEGTPC, ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8PGWGTPC, 0, g.cfg.PGWAddress, "").WithInstance(1)
+	bearerIEs := []*ie.IE{
+		ie.NewEPSBearerID(5),
+		ie.NewBearerQoS(1, 2, 1, 9, 0, 0, 0, 0),
+	}
+	if g.cfg.Role == RoleSGW {
+		senderIF, s5PGW = gtpv2.IFTypeS5S8SGWGTPC, nil
+		bearerIEs = append(bearerIEs,
+			ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8SGWGTPU, g.nextTEID(), g.localIP, "").WithInstance(2),
+		)
+	}
+
+	localTEID := g.nextTEID()
+	csReq := message.NewCreateSessionRequest(
+		0, 0,
+		ie.NewIMSI(imsi),
+		ie.NewServingNetwork(g.cfg.MCC, g.cfg.MNC),
+		ie.NewRATType(gtpv2.RATTypeEUTRAN),
+		ie.NewFullyQualifiedTEID(senderIF, localTEID, g.localIP, ""),
+		s5PGW,
+		ie.NewAccessPointName(g.cfg.APN),
+		ie.NewSelectionMode(gtpv2.SelectionModeMSorNetworkProvidedAPNSubscribedVerified),
+		ie.NewPDNType(gtpv2.PDNTypeIPv4),
+		ie.NewPDNAddressAllocation("0.0.0.0"),
+		ie.NewAggregateMaximumBitRate(0, 0),
+		ie.NewBearerContext(bearerIEs...),
+	)
+
+	r := g.transact(ctx, ProcedureCreateSession, csReq)
+	if !r.accepted() {
+		return
+	}
+	csRsp, ok := r.rsp.(*message.CreateSessionResponse)
+	if !ok || csRsp.SenderFTEIDC == nil {
+		return
+	}
+	peerTEID, err := csRsp.SenderFTEIDC.TEID()
+	if err != nil {
+		return
+	}
+
+	// run the procedures in the mix evenly distributed over the hold time.
+	interval := g.cfg.HoldTime / time.Duration(len(g.mix)+1)
+	for _, p := range g.mix {
+		if !sleep(ctx, interval) {
+			return
+		}
+
+		switch p {
+		case ProcedureModifyBearer:
+			g.transact(ctx, p, message.NewModifyBearerRequest(
+				peerTEID, 0,
+				ie.NewBearerContext(
+					ie.NewEPSBearerID(5),
+					ie.NewFullyQualifiedTEID(gtpv2.IFTypeS1UeNodeBGTPU, g.nextTEID(), g.localIP, ""),
+				),
+			))
+		case ProcedureReleaseAccessBearers:
+			g.transact(ctx, p, message.NewReleaseAccessBearersRequest(peerTEID, 0))
+		}
+	}
+	if !sleep(ctx, interval) {
+		return
+	}
+
+	g.transact(ctx, ProcedureDeleteSession, message.NewDeleteSessionRequest(
+		peerTEID, 0, ie.NewEPSBearerID(5),
+	))
+}
+
+func (g *Generator) nextTEID() uint32 {
+	for {
+		if t := atomic.AddUint32(&g.teid, 1); t != 0 {
+			return t
+		}
+	}
+}
+
+func randomUint32() uint32 {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func causeOf(msg message.Message) (uint8, error) {
+	var c *ie.IE
+	switch m := msg.(type) {
+	case *message.CreateSessionResponse:
+		c = m.Cause
+	case *message.ModifyBearerResponse:
+		c = m.Cause
+	case *message.ReleaseAccessBearersResponse:
+		c = m.Cause
+	case *message.DeleteSessionResponse:
+		c = m.Cause
+	default:
+		return 0, &gtpv2.UnexpectedTypeError{Msg: msg}
+	}
+
+	if c == nil {
+		return 0, &gtpv2.RequiredIEMissingError{Type: ie.Cause}
+	}
+	return c.Cause()
+}
@@ -0,0 +1,192 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package loadgen_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/loadgen"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/gtpv2/testutils"
+)
+
+// lossySGW drops the first transmission of every 4th request to let the generator retransmit,
+// and rejects the Create Session of the IMSI given.
+func lossySGW(conn *gtpv2.Conn, rejectIMSI string) {
+	var (
+		mu    sync.Mutex
+		count int
+		seen  = map[uint32]bool{}
+	)
+	drop := func(msg message.Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if seen[msg.Sequence()] {
+			return false
+		}
+		seen[msg.Sequence()] = true
+		count++
+		return count%4 == 0
+	}
+
+	conn.AddHandlers(map[uint8]gtpv2.HandlerFunc{
+		message.MsgTypeCreateSessionRequest: func(c *gtpv2.Conn, raddr net.Addr, msg message.Message) error {
+			if drop(msg) {
+				return nil
+			}
+			req := msg.(*message.CreateSessionRequest)
+			otei := req.SenderFTEIDC.MustTEID()
+
+			cause := gtpv2.CauseRequestAccepted
+			if req.IMSI.MustIMSI() == rejectIMSI {
+				cause = gtpv2.CauseNoResourcesAvailable
+			}
+			return c.RespondTo(raddr, msg, message.NewCreateSessionResponse(
+				otei, 0,
+				ie.NewCause(cause, 0, 0, 0, nil),
+				ie.NewFullyQualifiedTEID(gtpv2.IFTypeS11S4SGWGTPC, otei+1, "127.0.0.52", ""),
+			))
+		},
+		message.MsgTypeModifyBearerRequest: func(c *gtpv2.Conn, raddr net.Addr, msg message.Message) error {
+			if drop(msg) {
+				return nil
+			}
+			return c.RespondTo(raddr, msg, message.NewModifyBearerResponse(
+				msg.TEID()-1, 0, ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			))
+		},
+		message.MsgTypeDeleteSessionRequest: func(c *gtpv2.Conn, raddr net.Addr, msg message.Message) error {
+			if drop(msg) {
+				return nil
+			}
+			return c.RespondTo(raddr, msg, message.NewDeleteSessionResponse(
+				msg.TEID()-1, 0, ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			))
+		},
+	})
+}
+
+func TestGenerator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mmeConn := testutils.ListenConn(ctx, t, "127.0.0.51", gtpv2.IFTypeS11MMEGTPC)
+	sgwConn := testutils.ListenConn(ctx, t, "127.0.0.52", gtpv2.IFTypeS11S4SGWGTPC)
+	lossySGW(sgwConn, "001010000000003")
+
+	gen, err := loadgen.New(mmeConn, sgwConn.LocalAddr(), &loadgen.Config{
+		Role:        loadgen.RoleMME,
+		IMSIStart:   "001010000000000",
+		Subscribers: 10,
+		MCC:         "001",
+		MNC:         "01",
+		APN:         "loadgen.example",
+		PGWAddress:  "127.0.0.47",
+		Rate:        100,
+		Duration:    100 * time.Millisecond,
+		HoldTime:    100 * time.Millisecond,
+		Mix:         map[loadgen.Procedure]int{loadgen.ProcedureModifyBearer: 2},
+		T3:          50 * time.Millisecond,
+		N3:          3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := gen.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := rep.Procedures[loadgen.ProcedureCreateSession]
+	if cs == nil || cs.Attempted == 0 {
+		t.Fatalf("no Create Session attempted: %v", rep)
+	}
+	if cs.TimedOut != 0 {
+		t.Errorf("Create Session timed out unexpectedly: %d", cs.TimedOut)
+	}
+	if got := cs.Causes[gtpv2.CauseNoResourcesAvailable]; got != 1 {
+		t.Errorf("wrong number of rejections. got: %d, want: 1", got)
+	}
+	if got, want := cs.Succeeded, cs.Attempted-1; got != want {
+		t.Errorf("wrong number of successful Create Session. got: %d, want: %d", got, want)
+	}
+
+	mb := rep.Procedures[loadgen.ProcedureModifyBearer]
+	ds := rep.Procedures[loadgen.ProcedureDeleteSession]
+	if mb == nil || ds == nil {
+		t.Fatalf("procedures missing: %v", rep)
+	}
+	if got, want := mb.Succeeded, cs.Succeeded*2; got != want {
+		t.Errorf("wrong number of Modify Bearer. got: %d, want: %d", got, want)
+	}
+	if got, want := ds.Succeeded, cs.Succeeded; got != want {
+		t.Errorf("wrong number of Delete Session. got: %d, want: %d", got, want)
+	}
+
+	var retrans int
+	for _, p := range rep.Procedures {
+		retrans += p.Retransmissions
+	}
+	if retrans == 0 {
+		t.Error("no retransmission happened")
+	}
+	if cs.Percentile(99) < cs.Percentile(50) {
+		t.Errorf("invalid percentiles: p50=%s, p99=%s", cs.Percentile(50), cs.Percentile(99))
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	cases := []struct {
+		description string
+		cfg         *loadgen.Config
+	}{
+		{
+			"invalid IMSI",
+			&loadgen.Config{IMSIStart: "foo", Subscribers: 1, Rate: 1},
+		}, {
+			"no subscribers",
+			&loadgen.Config{IMSIStart: "001010000000000", Rate: 1},
+		}, {
+			"ReleaseAccessBearers on S-GW",
+			&loadgen.Config{
+				Role: loadgen.RoleSGW, IMSIStart: "001010000000000", Subscribers: 1, Rate: 1,
+				Mix: map[loadgen.Procedure]int{loadgen.ProcedureReleaseAccessBearers: 1},
+			},
+		},
+	}
+
+	conn := gtpv2.NewConn(&net.UDPAddr{IP: net.IPv4zero, Port: 2123}, gtpv2.IFTypeS11MMEGTPC, 0)
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if _, err := loadgen.New(conn, nil, c.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn := testutils.ListenConn(ctx, t, "127.0.0.53", gtpv2.IFTypeS11MMEGTPC)
+
+	// the defaults are not written to the Config given.
+	cfg := &loadgen.Config{IMSIStart: "001010000000000", Subscribers: 1, Rate: 1}
+	if _, err := loadgen.New(conn, nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.T3 != 0 || cfg.N3 != 0 {
+		t.Errorf("Config is modified: T3=%s, N3=%d", cfg.T3, cfg.N3)
+	}
+}
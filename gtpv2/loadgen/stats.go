@@ -0,0 +1,187 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package loadgen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcedureStats is the statistics of a Procedure.
+type ProcedureStats struct {
+	Attempted int
+	Succeeded int
+	Failed    int // rejected with non-accepted Cause
+	TimedOut  int // no response after N3 retransmissions
+
+	Retransmissions int
+
+	// Causes is the distribution of the Cause values in responses.
+	Causes map[uint8]int
+
+	// latencies of the successful and rejected transactions, from the first
+	// transmission to the response.
+	latencies []time.Duration
+}
+
+// Percentile returns the p-th percentile (0 < p <= 100) of the latencies.
+func (p *ProcedureStats) Percentile(pct float64) time.Duration {
+	if len(p.latencies) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(p.latencies))
+	copy(sorted, p.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(float64(len(sorted))*pct/100+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// Report is the result of a load test.
+type Report struct {
+	Elapsed    time.Duration
+	Procedures map[Procedure]*ProcedureStats
+
+	// Skipped is the number of session attempts skipped as all the subscribers
+	// in the range were busy at the moment.
+	Skipped int
+}
+
+// Throughput returns the number of completed (succeeded or failed) transactions
+// per second.
+func (r *Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+
+	var n int
+	for _, p := range r.Procedures {
+		n += p.Succeeded + p.Failed
+	}
+	return float64(n) / r.Elapsed.Seconds()
+}
+
+// String returns the Report in human readable format.
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "elapsed: %s, throughput: %.1f tps, skipped: %d\n", r.Elapsed, r.Throughput(), r.Skipped)
+
+	procs := make([]Procedure, 0, len(r.Procedures))
+	for p := range r.Procedures {
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i] < procs[j] })
+
+	for _, proc := range procs {
+		p := r.Procedures[proc]
+		fmt.Fprintf(&sb,
+			"%s: attempted=%d succeeded=%d failed=%d timedout=%d retrans=%d p50=%s p90=%s p99=%s\n",
+			proc, p.Attempted, p.Succeeded, p.Failed, p.TimedOut, p.Retransmissions,
+			p.Percentile(50), p.Percentile(90), p.Percentile(99),
+		)
+
+		causes := make([]int, 0, len(p.Causes))
+		for c := range p.Causes {
+			causes = append(causes, int(c))
+		}
+		sort.Ints(causes)
+		for _, c := range causes {
+			fmt.Fprintf(&sb, "  cause %d: %d\n", c, p.Causes[uint8(c)])
+		}
+	}
+	return sb.String()
+}
+
+type stats struct {
+	mu    sync.Mutex
+	start time.Time
+	*Report
+}
+
+func newStats() *stats {
+	return &stats{Report: &Report{Procedures: map[Procedure]*ProcedureStats{}}}
+}
+
+func (s *stats) proc(p Procedure) *ProcedureStats {
+	ps, ok := s.Procedures[p]
+	if !ok {
+		ps = &ProcedureStats{Causes: map[uint8]int{}}
+		s.Procedures[p] = ps
+	}
+	return ps
+}
+
+func (s *stats) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.start = time.Now()
+}
+
+func (s *stats) skip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Skipped++
+}
+
+func (s *stats) record(p Procedure, r *result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps := s.proc(p)
+	ps.Attempted++
+	ps.Retransmissions += r.retries
+
+	switch {
+	case r.timedOut:
+		ps.TimedOut++
+		return
+	case r.err != nil:
+		ps.Failed++
+		return
+	}
+
+	ps.latencies = append(ps.latencies, r.latency)
+	ps.Causes[r.cause]++
+	if r.accepted() {
+		ps.Succeeded++
+	} else {
+		ps.Failed++
+	}
+}
+
+// snapshot returns a copy of the Report.
+func (s *stats) snapshot() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var elapsed time.Duration
+	if !s.start.IsZero() {
+		elapsed = time.Since(s.start)
+	}
+
+	r := &Report{Elapsed: elapsed, Skipped: s.Skipped, Procedures: map[Procedure]*ProcedureStats{}}
+	for k, v := range s.Procedures {
+		c := *v
+		c.Causes = map[uint8]int{}
+		for cause, n := range v.Causes {
+			c.Causes[cause] = n
+		}
+		c.latencies = append([]time.Duration(nil), v.latencies...)
+		r.Procedures[k] = &c
+	}
+	return r
+}
@@ -0,0 +1,96 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package loadgen
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// transactor sends requests and correlates the responses with them by Sequence
+// Number, retransmitting the same bytes on T3 expiry up to N3 times (TS 29.274 7.6).
+type transactor struct {
+	conn *gtpv2.Conn
+	t3   time.Duration
+	n3   int
+
+	mu      sync.Mutex
+	pending map[uint32]chan message.Message
+}
+
+func newTransactor(conn *gtpv2.Conn, t3 time.Duration, n3 int) *transactor {
+	return &transactor{
+		conn:    conn,
+		t3:      t3,
+		n3:      n3,
+		pending: map[uint32]chan message.Message{},
+	}
+}
+
+// handleResponse is a gtpv2.HandlerFunc that passes the response to the waiting request.
+func (t *transactor) handleResponse(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+	t.mu.Lock()
+	ch, ok := t.pending[msg.Sequence()]
+	if ok {
+		delete(t.pending, msg.Sequence())
+	}
+	t.mu.Unlock()
+
+	if !ok {
+		// late response to a timed out request, or duplicate caused by retransmission.
+		return nil
+	}
+
+	ch <- msg
+	return nil
+}
+
+// request sends msg to raddr and waits for the response.
+// It returns the number of retransmissions as well as the response.
+func (t *transactor) request(ctx context.Context, msg message.Message, raddr net.Addr) (message.Message, int, error) {
+	seq := t.conn.IncSequence()
+	msg.SetSequenceNumber(seq)
+
+	b, err := message.Marshal(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ch := make(chan message.Message, 1)
+	t.mu.Lock()
+	t.pending[seq] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, seq)
+		t.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(t.t3)
+	defer timer.Stop()
+
+	for retries := 0; ; retries++ {
+		if _, err := t.conn.WriteTo(b, raddr); err != nil {
+			return nil, retries, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, retries, ctx.Err()
+		case rsp := <-ch:
+			return rsp, retries, nil
+		case <-timer.C:
+			if retries >= t.n3 {
+				return nil, retries, gtpv2.ErrTimeout
+			}
+			timer.Reset(t.t3)
+		}
+	}
+}
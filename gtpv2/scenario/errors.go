@@ -0,0 +1,61 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package scenario
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTimeout indicates that the expected message did not come in time.
+	ErrTimeout = errors.New("timed out waiting for message")
+
+	// ErrNoMessageToReply indicates that a step tried to reply before receiving
+	// any message.
+	ErrNoMessageToReply = errors.New("no message received to reply to")
+)
+
+// InvalidStepError indicates that a step has none or more than one action.
+type InvalidStepError struct {
+	Index int
+	Name  string
+}
+
+// Error returns the index and name of the step.
+func (e *InvalidStepError) Error() string {
+	return fmt.Sprintf("step %d (%s) must have exactly one of send, expect, pause, set or loop", e.Index, e.Name)
+}
+
+// UnknownTypeError indicates that the name of message or IE type is unknown.
+type UnknownTypeError struct {
+	Kind, Name string
+}
+
+// Error returns the unknown name.
+func (e *UnknownTypeError) Error() string {
+	return fmt.Sprintf("unknown %s type: %s", e.Kind, e.Name)
+}
+
+// UndefinedVariableError indicates that a variable is referred before defined.
+type UndefinedVariableError struct {
+	Name string
+}
+
+// Error returns the name of the variable.
+func (e *UndefinedVariableError) Error() string {
+	return fmt.Sprintf("undefined variable: %s", e.Name)
+}
+
+// MismatchError indicates that the received message did not match the expectation.
+type MismatchError struct {
+	Path      string
+	Got, Want string
+}
+
+// Error returns the path to the value with the values received and expected.
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("mismatch at %s: got %q, want %q", e.Path, e.Got, e.Want)
+}
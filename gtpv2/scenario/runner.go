@@ -0,0 +1,414 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// DefaultExpectTimeout is the duration to wait for the expected message when
+// timeout is not given in the step.
+const DefaultExpectTimeout = 5 * time.Second
+
+// StepResult is the result of a step.
+type StepResult struct {
+	Name    string
+	Kind    string
+	Passed  bool
+	Err     error
+	Elapsed time.Duration
+}
+
+// String returns the StepResult in human readable format.
+func (s *StepResult) String() string {
+	status := "PASS"
+	if !s.Passed {
+		status = "FAIL"
+	}
+
+	str := fmt.Sprintf("[%s] %s (%s, %s)", status, s.Name, s.Kind, s.Elapsed)
+	if s.Err != nil {
+		str += ": " + s.Err.Error()
+	}
+	return str
+}
+
+// Result is the result of a Scenario.
+type Result struct {
+	Name    string
+	Passed  bool
+	Steps   []*StepResult
+	Elapsed time.Duration
+}
+
+type received struct {
+	addr net.Addr
+	msg  message.Message
+}
+
+// Runner runs Scenarios over a gtpv2.Conn.
+type Runner struct {
+	conn *gtpv2.Conn
+	peer net.Addr
+	vars *Vars
+
+	rxCh chan *received
+	last *received
+
+	// expected is the message types expected in the running Scenario, and
+	// sent is the SequenceNumbers of the requests sent in it. They are used
+	// to discard the messages unrelated to the running Scenario.
+	expected map[uint8]bool
+	sent     map[uint32]bool
+}
+
+// NewRunner creates a new Runner that works over conn and sends messages to peer.
+//
+// The Conn should be serving already, i.e., ListenAndServe or Dial should have been
+// called. While running a Scenario, Runner replaces the handlers for the message
+// types expected in it, and restores the previous ones when Run returns.
+//
+// Runner does not register any Session to the Conn, so the validation of the
+// incoming messages should be disabled by the caller with DisableValidation
+// before serving, unless the Sessions for the expected messages are registered.
+//
+// While waiting for a message in an expect step, Runner discards the ones not
+// from the peer, the ones of the types not expected in the Scenario, and the
+// responses to the requests not sent in the current Run.
+func NewRunner(conn *gtpv2.Conn, peer net.Addr) *Runner {
+	return &Runner{
+		conn: conn,
+		peer: peer,
+		vars: NewVars(nil),
+		rxCh: make(chan *received, 1024),
+	}
+}
+
+// Vars returns the variables in the Runner, including the ones captured in the
+// last Run.
+func (r *Runner) Vars() *Vars {
+	return r.vars
+}
+
+// Run runs the steps in sc in order and stops at the first failure.
+//
+// The returned error is the one of the failed step, which is also available in
+// Result. The Result is always returned even if the error is not nil.
+func (r *Runner) Run(ctx context.Context, sc *Scenario) (*Result, error) {
+	r.vars = NewVars(sc.Vars)
+	r.last = nil
+	r.expected = make(map[uint8]bool)
+	r.sent = make(map[uint32]bool)
+	r.drain()
+
+	for _, t := range expectedTypes(sc.Steps) {
+		if r.expected[t] {
+			continue
+		}
+		r.expected[t] = true
+
+		if prev, ok := r.conn.Handler(t); ok {
+			defer r.conn.AddHandler(t, prev)
+		} else {
+			defer r.conn.RemoveHandler(t)
+		}
+		r.conn.AddHandler(t, r.handle)
+	}
+
+	start := time.Now()
+	res := &Result{Name: sc.Name, Passed: true}
+	err := r.runSteps(ctx, "", sc.Steps, res)
+	if err != nil {
+		res.Passed = false
+	}
+	res.Elapsed = time.Since(start)
+	return res, err
+}
+
+func (r *Runner) handle(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+	select {
+	case r.rxCh <- &received{addr: senderAddr, msg: msg}:
+		return nil
+	default:
+		return fmt.Errorf("receive queue is full, discarding %s", msg.MessageTypeName())
+	}
+}
+
+// drain discards the messages left in the queue by the previous Run.
+func (r *Runner) drain() {
+	for {
+		select {
+		case <-r.rxCh:
+		default:
+			return
+		}
+	}
+}
+
+// related reports whether rx is worth matching in the running Scenario.
+func (r *Runner) related(rx *received) bool {
+	if !r.expected[rx.msg.MessageType()] || !sameHost(rx.addr, r.peer) {
+		return false
+	}
+	if isResponse(rx.msg) {
+		return r.sent[rx.msg.Sequence()]
+	}
+	return true
+}
+
+// sameHost reports whether a and b have the same IP address. The port is not
+// compared, as the peer may send the requests from another port.
+func sameHost(a, b net.Addr) bool {
+	ua, ok1 := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	if !ok1 || !ok2 {
+		return a.String() == b.String()
+	}
+	return ua.IP.Equal(ub.IP)
+}
+
+// isResponse reports whether msg is sent in response to a request, which has
+// the SequenceNumber of the request.
+func isResponse(msg message.Message) bool {
+	name := msg.MessageTypeName()
+	return strings.HasSuffix(name, "Response") ||
+		strings.HasSuffix(name, "Acknowledge") ||
+		strings.HasSuffix(name, "Failure Indication")
+}
+
+func expectedTypes(steps []*Step) []uint8 {
+	var types []uint8
+	for _, s := range steps {
+		if s.Expect != nil {
+			// validated in Load.
+			t, _ := messageType(s.Expect.Message)
+			types = append(types, t)
+		}
+		if s.Loop != nil {
+			types = append(types, expectedTypes(s.Loop.Steps)...)
+		}
+	}
+	return types
+}
+
+func (r *Runner) runSteps(ctx context.Context, prefix string, steps []*Step, res *Result) error {
+	for _, s := range steps {
+		if s.Loop != nil {
+			if err := r.runLoop(ctx, prefix+s.displayName(), s.Loop, res); err != nil {
+				return err
+			}
+			continue
+		}
+
+		start := time.Now()
+		err := r.runStep(ctx, s)
+		sr := &StepResult{
+			Name:    prefix + s.displayName(),
+			Kind:    s.kind(),
+			Passed:  err == nil,
+			Err:     err,
+			Elapsed: time.Since(start),
+		}
+		res.Steps = append(res.Steps, sr)
+		if err != nil {
+			return fmt.Errorf("step %q failed: %w", sr.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) runLoop(ctx context.Context, name string, l *Loop, res *Result) error {
+	for i := 0; i < l.Count; i++ {
+		if l.Var != "" {
+			r.vars.Set(l.Var, strconv.Itoa(i))
+		}
+		if err := r.runSteps(ctx, fmt.Sprintf("%s[%d]/", name, i), l.Steps, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) runStep(ctx context.Context, s *Step) error {
+	switch {
+	case s.Send != nil:
+		return r.send(s.Send)
+	case s.Expect != nil:
+		return r.expect(ctx, s.Expect)
+	case s.Pause != "":
+		d, err := time.ParseDuration(s.Pause)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+			return nil
+		}
+	case s.Set != nil:
+		for k, v := range s.Set {
+			val, err := r.vars.Expand(v)
+			if err != nil {
+				return err
+			}
+			r.vars.Set(k, val)
+		}
+		return nil
+	default:
+		return &InvalidStepError{Name: s.Name}
+	}
+}
+
+func (r *Runner) send(s *Send) error {
+	typ, err := messageType(s.Message)
+	if err != nil {
+		return err
+	}
+
+	ies := make([]*ie.IE, len(s.IEs))
+	for n, t := range s.IEs {
+		i, err := buildIE(t, r.vars)
+		if err != nil {
+			return err
+		}
+		ies[n] = i
+	}
+
+	var msg message.Message
+	if s.TEID == "" && !hasTEID(typ) {
+		msg = message.NewGenericWithoutTEID(typ, 0, 0, ies...)
+	} else {
+		teidStr, err := r.vars.Expand(s.TEID)
+		if err != nil {
+			return err
+		}
+		var teid uint64
+		if teidStr != "" {
+			teid, err = strconv.ParseUint(teidStr, 0, 32)
+			if err != nil {
+				return fmt.Errorf("invalid TEID: %w", err)
+			}
+		}
+		msg = message.NewGeneric(typ, uint32(teid), 0, ies...)
+	}
+
+	if s.Reply {
+		if r.last == nil {
+			return ErrNoMessageToReply
+		}
+		return r.conn.RespondTo(r.last.addr, r.last.msg, msg)
+	}
+
+	seq, err := r.conn.SendMessageTo(msg, r.peer)
+	if err != nil {
+		return err
+	}
+	r.sent[seq] = true
+	r.vars.Set("last_seq", strconv.FormatUint(uint64(seq), 10))
+	return nil
+}
+
+func (r *Runner) expect(ctx context.Context, e *Expect) error {
+	typ, err := messageType(e.Message)
+	if err != nil {
+		return err
+	}
+
+	timeout := DefaultExpectTimeout
+	if e.Timeout != "" {
+		if timeout, err = time.ParseDuration(e.Timeout); err != nil {
+			return err
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var rx *received
+	for rx == nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return ErrTimeout
+		case rx = <-r.rxCh:
+			if !r.related(rx) {
+				rx = nil
+			}
+		}
+	}
+	r.last = rx
+
+	msg := rx.msg
+	if msg.MessageType() != typ {
+		return &MismatchError{
+			Path: "message",
+			Got:  strconv.Itoa(int(msg.MessageType())),
+			Want: strconv.Itoa(int(typ)),
+		}
+	}
+
+	if e.TEID != "" {
+		want, err := r.vars.Expand(e.TEID)
+		if err != nil {
+			return err
+		}
+		got := strconv.FormatUint(uint64(msg.TEID()), 10)
+		if !matchValue(got, want) {
+			return &MismatchError{Path: "header.teid", Got: got, Want: want}
+		}
+	}
+
+	for key, name := range e.Capture {
+		switch key {
+		case "teid":
+			r.vars.Set(name, strconv.FormatUint(uint64(msg.TEID()), 10))
+		case "seq":
+			r.vars.Set(name, strconv.FormatUint(uint64(msg.Sequence()), 10))
+		default:
+			return fmt.Errorf("unknown header field to capture: %s", key)
+		}
+	}
+
+	ies, err := messageIEs(msg)
+	if err != nil {
+		return err
+	}
+	return matchIEs(e.Message, ies, e.IEs, r.vars)
+}
+
+// messageIEs returns all the IEs in msg regardless of its type.
+func messageIEs(msg message.Message) ([]*ie.IE, error) {
+	b, err := message.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := message.ParseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	return ie.ParseMultiIEs(h.Payload)
+}
+
+// hasTEID reports whether the message of typ has TEID in the header by default.
+func hasTEID(typ uint8) bool {
+	switch typ {
+	case message.MsgTypeEchoRequest, message.MsgTypeEchoResponse, message.MsgTypeVersionNotSupportedIndication:
+		return false
+	default:
+		return true
+	}
+}
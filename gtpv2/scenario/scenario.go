@@ -0,0 +1,257 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package scenario provides a declarative GTPv2-C call-flow engine.
+//
+// A Scenario is described in YAML as a list of steps; each step sends a message
+// built from IE templates, expects a message matched by IE matchers, pauses, sets
+// variables, or loops over a nested list of steps. The values received can be
+// captured into variables and used in the later steps, e.g., to send Modify Bearer
+// Request to the TEID that the peer allocated in Create Session Response.
+//
+// See examples/scenario-runner for the command-line interface built on this package.
+package scenario
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Scenario is a set of steps to be run over a gtpv2.Conn.
+type Scenario struct {
+	Name string `yaml:"name"`
+
+	// Local and Peer are the default addresses used by the command-line runner.
+	// Runner does not use them, as it is given a Conn and the peer address.
+	Local     string `yaml:"local"`
+	Peer      string `yaml:"peer"`
+	Interface string `yaml:"interface"`
+
+	// Vars are the initial variables available in the steps.
+	Vars map[string]string `yaml:"vars"`
+
+	Steps []*Step `yaml:"steps"`
+}
+
+// Step is a single step in a Scenario.
+//
+// Exactly one of Send, Expect, Pause, Set and Loop should be set.
+type Step struct {
+	Name   string            `yaml:"name"`
+	Send   *Send             `yaml:"send"`
+	Expect *Expect           `yaml:"expect"`
+	Pause  string            `yaml:"pause"`
+	Set    map[string]string `yaml:"set"`
+	Loop   *Loop             `yaml:"loop"`
+}
+
+// Send describes a message to be sent.
+type Send struct {
+	// Message is the name (e.g., CreateSessionRequest) or the number of the message type.
+	Message string `yaml:"message"`
+
+	// TEID is the TEID in the header. It is omitted for the messages without TEID,
+	// like Echo Request, unless it is explicitly given.
+	TEID string `yaml:"teid"`
+
+	// Reply lets the message be sent to the sender of the last received message,
+	// with the same Sequence Number.
+	Reply bool `yaml:"reply"`
+
+	IEs []*IETemplate `yaml:"ies"`
+}
+
+// Expect describes a message expected to be received.
+type Expect struct {
+	Message string `yaml:"message"`
+
+	// Timeout is the duration to wait for the message, in the format accepted by
+	// time.ParseDuration. The default is 5s.
+	Timeout string `yaml:"timeout"`
+
+	// TEID is compared with the TEID in the header if given.
+	TEID string `yaml:"teid"`
+
+	// Capture stores the header values into variables. The keys are "teid" or "seq"
+	// and the values are the names of the variables.
+	Capture map[string]string `yaml:"capture"`
+
+	IEs []*IETemplate `yaml:"ies"`
+}
+
+// Loop runs the nested Steps Count times.
+type Loop struct {
+	Count int `yaml:"count"`
+
+	// Var is the name of the variable that holds the index of the iteration,
+	// starting from 0.
+	Var string `yaml:"var"`
+
+	Steps []*Step `yaml:"steps"`
+}
+
+// IETemplate is an IE to be sent, or a matcher for the IE received.
+//
+// The fields other than the ones explicitly defined (e.g., value, teid, ipv4) are
+// set to Fields. See the README for the fields available for each type of IE.
+type IETemplate struct {
+	// Type is the name (e.g., FullyQualifiedTEID) or the number of the IE type.
+	Type     string `yaml:"type"`
+	Instance uint8  `yaml:"instance"`
+
+	// IEs is the child IEs of grouped IE.
+	IEs []*IETemplate `yaml:"ies"`
+
+	// Absent lets the matcher succeed only when the IE does not exist.
+	// Only used in Expect.
+	Absent bool `yaml:"absent"`
+
+	// Capture stores the field values into variables. The keys are the name of
+	// the fields and the values are the names of the variables.
+	Capture map[string]string `yaml:"capture"`
+
+	Fields map[string]interface{} `yaml:",inline"`
+}
+
+// Load decodes a Scenario from r.
+func Load(r io.Reader) (*Scenario, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &Scenario{}
+	if err := yaml.Unmarshal(b, sc); err != nil {
+		return nil, fmt.Errorf("failed to decode scenario: %w", err)
+	}
+	if err := sc.validate(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// LoadFile decodes a Scenario from the file at path.
+func LoadFile(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// LocalIFType returns the local interface type given in the Scenario by name or
+// number, or fallback if not given.
+func (sc *Scenario) LocalIFType(fallback uint8) (uint8, error) {
+	if sc.Interface == "" {
+		return fallback, nil
+	}
+	return interfaceType(sc.Interface)
+}
+
+func (sc *Scenario) validate() error {
+	return validateSteps(sc.Steps)
+}
+
+func validateSteps(steps []*Step) error {
+	for i, s := range steps {
+		n := 0
+		if s.Send != nil {
+			n++
+			if _, err := messageType(s.Send.Message); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+			if err := validateIEs(s.Send.IEs); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+		if s.Expect != nil {
+			n++
+			if _, err := messageType(s.Expect.Message); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+			if s.Expect.Timeout != "" {
+				if _, err := time.ParseDuration(s.Expect.Timeout); err != nil {
+					return fmt.Errorf("step %d: %w", i, err)
+				}
+			}
+			if err := validateIEs(s.Expect.IEs); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+		if s.Pause != "" {
+			n++
+			if _, err := time.ParseDuration(s.Pause); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+		if s.Set != nil {
+			n++
+		}
+		if s.Loop != nil {
+			n++
+			if err := validateSteps(s.Loop.Steps); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+
+		if n != 1 {
+			return &InvalidStepError{Index: i, Name: s.Name}
+		}
+	}
+	return nil
+}
+
+func validateIEs(ies []*IETemplate) error {
+	for _, i := range ies {
+		if _, err := ieType(i.Type); err != nil {
+			return err
+		}
+		if err := validateIEs(i.IEs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kind returns the kind of the step in string.
+func (s *Step) kind() string {
+	switch {
+	case s.Send != nil:
+		return "send"
+	case s.Expect != nil:
+		return "expect"
+	case s.Pause != "":
+		return "pause"
+	case s.Set != nil:
+		return "set"
+	case s.Loop != nil:
+		return "loop"
+	default:
+		return "unknown"
+	}
+}
+
+// displayName returns the name of the step, or the generated one if not given.
+func (s *Step) displayName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	switch {
+	case s.Send != nil:
+		return "send " + s.Send.Message
+	case s.Expect != nil:
+		return "expect " + s.Expect.Message
+	case s.Pause != "":
+		return "pause " + s.Pause
+	default:
+		return s.kind()
+	}
+}
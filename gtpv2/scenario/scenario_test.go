@@ -0,0 +1,302 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package scenario_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/gtpv2/scenario"
+	"github.com/wmnsk/go-gtp/gtpv2/testutils"
+)
+
+const mmeScenario = `
+name: mme
+vars:
+  imsi: "001010123456789"
+steps:
+  - name: create session
+    send:
+      message: CreateSessionRequest
+      ies:
+        - {type: IMSI, value: "${imsi}"}
+        - {type: RATType, value: 6}
+        - type: FullyQualifiedTEID
+          interface: S11MMEGTPC
+          teid: "${random_teid}"
+          ipv4: 127.0.0.41
+          capture: {teid: mme_teid}
+        - type: BearerContext
+          ies:
+            - {type: EPSBearerID, value: 5}
+  - expect:
+      message: CreateSessionResponse
+      teid: "${mme_teid}"
+      timeout: 3s
+      ies:
+        - {type: Cause, value: 16}
+        - type: FullyQualifiedTEID
+          interface: 11
+          capture: {teid: sgw_teid}
+        - {type: PDNAddressAllocation, ipv4: 10.0.0.1}
+        - {type: AggregateMaximumBitRate, absent: true}
+  - loop:
+      count: 2
+      var: i
+      steps:
+        - send:
+            message: ModifyBearerRequest
+            teid: "${sgw_teid}"
+        - expect:
+            message: ModifyBearerResponse
+            ies:
+              - {type: Cause, value: 16}
+`
+
+const sgwScenario = `
+name: sgw
+steps:
+  - expect:
+      message: CreateSessionRequest
+      timeout: 3s
+      ies:
+        - {type: IMSI, value: "001010123456789"}
+        - type: FullyQualifiedTEID
+          capture: {teid: mme_teid}
+        - type: BearerContext
+          ies:
+            - {type: EPSBearerID, value: 5}
+  - send:
+      message: CreateSessionResponse
+      reply: true
+      teid: "${mme_teid}"
+      ies:
+        - {type: Cause, value: 16}
+        - {type: FullyQualifiedTEID, interface: S11S4SGWGTPC, teid: 0x11223344, ipv4: 127.0.0.42}
+        - {type: PDNAddressAllocation, ipv4: 10.0.0.1}
+  - loop:
+      count: 2
+      steps:
+        - expect:
+            message: ModifyBearerRequest
+            teid: 0x11223344
+        - send:
+            message: ModifyBearerResponse
+            reply: true
+            teid: "${mme_teid}"
+            ies:
+              - {type: Cause, value: 16}
+`
+
+func TestRunner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mmeConn := testutils.ListenConn(ctx, t, "127.0.0.41", gtpv2.IFTypeS11MMEGTPC)
+	sgwConn := testutils.ListenConn(ctx, t, "127.0.0.42", gtpv2.IFTypeS11S4SGWGTPC)
+
+	mmeSc, err := scenario.Load(strings.NewReader(mmeScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgwSc, err := scenario.Load(strings.NewReader(sgwScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sgwRunner := scenario.NewRunner(sgwConn, mmeConn.LocalAddr())
+	sgwDone := make(chan error)
+	go func() {
+		_, err := sgwRunner.Run(ctx, sgwSc)
+		sgwDone <- err
+	}()
+
+	mmeRunner := scenario.NewRunner(mmeConn, sgwConn.LocalAddr())
+	res, err := mmeRunner.Run(ctx, mmeSc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sgwDone; err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Passed {
+		t.Errorf("scenario not passed: %v", res.Steps)
+	}
+	if got, want := len(res.Steps), 6; got != want {
+		t.Errorf("wrong number of steps. got: %d, want: %d", got, want)
+	}
+	if got, ok := mmeRunner.Vars().Get("sgw_teid"); !ok || got != "287454020" {
+		t.Errorf("wrong value captured: %s", got)
+	}
+}
+
+func TestRunnerMismatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mmeConn := testutils.ListenConn(ctx, t, "127.0.0.43", gtpv2.IFTypeS11MMEGTPC)
+	sgwConn := testutils.ListenConn(ctx, t, "127.0.0.44", gtpv2.IFTypeS11S4SGWGTPC)
+
+	sgwSc, err := scenario.Load(strings.NewReader(`
+steps:
+  - expect: {message: EchoRequest}
+  - send:
+      message: EchoResponse
+      reply: true
+      ies:
+        - {type: Recovery, value: 1}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = scenario.NewRunner(sgwConn, mmeConn.LocalAddr()).Run(ctx, sgwSc)
+	}()
+
+	mmeSc, err := scenario.Load(strings.NewReader(`
+steps:
+  - send:
+      message: EchoRequest
+      ies:
+        - {type: Recovery, value: 0}
+  - expect:
+      message: EchoResponse
+      ies:
+        - {type: Recovery, value: 2}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := scenario.NewRunner(mmeConn, sgwConn.LocalAddr()).Run(ctx, mmeSc)
+	var mismatch *scenario.MismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Passed {
+		t.Error("scenario should not pass")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	cases := []struct {
+		description string
+		yaml        string
+	}{
+		{
+			"no action",
+			"steps: [{name: empty}]",
+		}, {
+			"multiple actions",
+			"steps: [{pause: 1s, set: {a: b}}]",
+		}, {
+			"unknown message",
+			"steps: [{send: {message: FooRequest}}]",
+		}, {
+			"unknown IE",
+			"steps: [{send: {message: EchoRequest, ies: [{type: Foo}]}}]",
+		}, {
+			"invalid duration",
+			"steps: [{pause: soon}]",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if _, err := scenario.Load(strings.NewReader(c.yaml)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestRunnerStaleMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mmeConn := testutils.ListenConn(ctx, t, "127.0.0.45", gtpv2.IFTypeS11MMEGTPC)
+	sgwConn := testutils.ListenConn(ctx, t, "127.0.0.46", gtpv2.IFTypeS11S4SGWGTPC)
+
+	// the response to the second request is left in the queue.
+	first, err := scenario.Load(strings.NewReader(`
+steps:
+  - send: {message: EchoRequest, ies: [{type: Recovery, value: 0}]}
+  - send: {message: EchoRequest, ies: [{type: Recovery, value: 0}]}
+  - expect: {message: EchoResponse}
+  - pause: 100ms
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := scenario.Load(strings.NewReader(`
+steps:
+  - send: {message: EchoRequest, ies: [{type: Recovery, value: 0}]}
+  - expect: {message: EchoResponse, capture: {seq: got_seq}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := scenario.NewRunner(mmeConn, sgwConn.LocalAddr())
+	if _, err := runner.Run(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Run(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := runner.Vars().Get("got_seq")
+	want, _ := runner.Vars().Get("last_seq")
+	if got != want {
+		t.Errorf("stale response matched: got seq %s, want %s", got, want)
+	}
+}
+
+func TestRunnerRestoresHandlers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mmeConn := testutils.ListenConn(ctx, t, "127.0.0.49", gtpv2.IFTypeS11MMEGTPC)
+	sgwConn := testutils.ListenConn(ctx, t, "127.0.0.50", gtpv2.IFTypeS11S4SGWGTPC)
+
+	echoCh := make(chan message.Message, 1)
+	mmeConn.AddHandler(message.MsgTypeEchoResponse, func(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+		echoCh <- msg
+		return nil
+	})
+
+	// the Scenario fails at the last step, which should not matter.
+	sc, err := scenario.Load(strings.NewReader(`
+steps:
+  - send: {message: EchoRequest, ies: [{type: Recovery, value: 0}]}
+  - expect: {message: EchoResponse}
+  - expect: {message: CreateSessionRequest, timeout: 10ms}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scenario.NewRunner(mmeConn, sgwConn.LocalAddr()).Run(ctx, sc); !errors.Is(err, scenario.ErrTimeout) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := mmeConn.Handler(message.MsgTypeCreateSessionRequest); ok {
+		t.Error("handler added by Runner is left")
+	}
+	if _, err := mmeConn.EchoRequest(sgwConn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-echoCh:
+	case <-time.After(5 * time.Second):
+		t.Error("handler replaced by Runner is not restored")
+	}
+}
@@ -0,0 +1,504 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package scenario
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+var (
+	msgTypes = map[string]uint8{}
+	ieTypes  = map[string]uint8{}
+)
+
+// ifTypes is the names of the interface types that can be used in F-TEID.
+var ifTypes = map[string]uint8{
+	"s1uenodebgtpu":   gtpv2.IFTypeS1UeNodeBGTPU,
+	"s1usgwgtpu":      gtpv2.IFTypeS1USGWGTPU,
+	"s12rncgtpu":      gtpv2.IFTypeS12RNCGTPU,
+	"s12sgwgtpu":      gtpv2.IFTypeS12SGWGTPU,
+	"s5s8sgwgtpu":     gtpv2.IFTypeS5S8SGWGTPU,
+	"s5s8pgwgtpu":     gtpv2.IFTypeS5S8PGWGTPU,
+	"s5s8sgwgtpc":     gtpv2.IFTypeS5S8SGWGTPC,
+	"s5s8pgwgtpc":     gtpv2.IFTypeS5S8PGWGTPC,
+	"s5s8sgwpmipv6":   gtpv2.IFTypeS5S8SGWPMIPv6,
+	"s5s8pgwpmipv6":   gtpv2.IFTypeS5S8PGWPMIPv6,
+	"s11mmegtpc":      gtpv2.IFTypeS11MMEGTPC,
+	"s11s4sgwgtpc":    gtpv2.IFTypeS11S4SGWGTPC,
+	"s10mmegtpc":      gtpv2.IFTypeS10MMEGTPC,
+	"s3mmegtpc":       gtpv2.IFTypeS3MMEGTPC,
+	"s3sgsngtpc":      gtpv2.IFTypeS3SGSNGTPC,
+	"s4sgsngtpu":      gtpv2.IFTypeS4SGSNGTPU,
+	"s4sgwgtpu":       gtpv2.IFTypeS4SGWGTPU,
+	"s4sgsngtpc":      gtpv2.IFTypeS4SGSNGTPC,
+	"s16sgsngtpc":     gtpv2.IFTypeS16SGSNGTPC,
+	"enodebgtpufordl": gtpv2.IFTypeeNodeBGTPUForDL,
+	"enodebgtpuforul": gtpv2.IFTypeeNodeBGTPUForUL,
+	"rncgtpufordata":  gtpv2.IFTypeRNCGTPUForData,
+	"sgsngtpufordata": gtpv2.IFTypeSGSNGTPUForData,
+	"sgwupfgtpufordl": gtpv2.IFTypeSGWUPFGTPUForDL,
+	"smmbmsgwgtpc":    gtpv2.IFTypeSmMBMSGWGTPC,
+	"snmbmsgwgtpc":    gtpv2.IFTypeSnMBMSGWGTPC,
+	"smmmegtpc":       gtpv2.IFTypeSmMMEGTPC,
+	"snsgsngtpc":      gtpv2.IFTypeSnSGSNGTPC,
+	"sgwgtpuforul":    gtpv2.IFTypeSGWGTPUForUL,
+	"snsgsngtpu":      gtpv2.IFTypeSnSGSNGTPU,
+	"s2bepdggtpc":     gtpv2.IFTypeS2bePDGGTPC,
+	"s2buepdggtpu":    gtpv2.IFTypeS2bUePDGGTPU,
+	"s2bpgwgtpc":      gtpv2.IFTypeS2bPGWGTPC,
+	"s2bupgwgtpu":     gtpv2.IFTypeS2bUPGWGTPU,
+	"s2atwangtpu":     gtpv2.IFTypeS2aTWANGTPU,
+	"s2atwangtpc":     gtpv2.IFTypeS2aTWANGTPC,
+	"s2apgwgtpc":      gtpv2.IFTypeS2aPGWGTPC,
+	"s2apgwgtpu":      gtpv2.IFTypeS2aPGWGTPU,
+	"s11mmegtpu":      gtpv2.IFTypeS11MMEGTPU,
+	"s11sgwgtpu":      gtpv2.IFTypeS11SGWGTPU,
+}
+
+func init() {
+	// build the name tables from the ones defined in message and ie packages,
+	// so that the names in the scenario are always the same as MessageTypeName()
+	// and Name() returns.
+	for t := 1; t < 256; t++ {
+		m, err := message.Parse([]byte{0x48, uint8(t), 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+		if err != nil {
+			continue
+		}
+		if _, ok := m.(*message.Generic); ok {
+			continue
+		}
+		msgTypes[normalize(m.MessageTypeName())] = uint8(t)
+	}
+
+	for t := 1; t < 256; t++ {
+		n := ie.New(uint8(t), 0, nil).Name()
+		if n == "Undefined" || n == "Reserved" {
+			continue
+		}
+		ieTypes[normalize(n)] = uint8(t)
+	}
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(name))
+}
+
+func lookupType(table map[string]uint8, kind, name string) (uint8, error) {
+	if v, err := strconv.ParseUint(name, 0, 8); err == nil {
+		return uint8(v), nil
+	}
+	if v, ok := table[normalize(name)]; ok {
+		return v, nil
+	}
+	return 0, &UnknownTypeError{Kind: kind, Name: name}
+}
+
+func messageType(name string) (uint8, error) {
+	return lookupType(msgTypes, "message", name)
+}
+
+func ieType(name string) (uint8, error) {
+	return lookupType(ieTypes, "IE", name)
+}
+
+func interfaceType(name string) (uint8, error) {
+	return lookupType(ifTypes, "interface", name)
+}
+
+// fieldSet is a helper to retrieve the values of IETemplate.Fields with variables expanded.
+type fieldSet struct {
+	fields map[string]interface{}
+	vars   *Vars
+}
+
+func (f *fieldSet) str(key string) (string, bool, error) {
+	v, ok := f.fields[key]
+	if !ok {
+		return "", false, nil
+	}
+
+	s, err := f.vars.Expand(fmt.Sprint(v))
+	if err != nil {
+		return "", false, err
+	}
+	return s, true, nil
+}
+
+func (f *fieldSet) uint(key string, bitSize int) (uint64, error) {
+	s, ok, err := f.str(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	v, err := strconv.ParseUint(s, 0, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value in %s: %w", key, err)
+	}
+	return v, nil
+}
+
+func (f *fieldSet) uint8(key string) (uint8, error) {
+	v, err := f.uint(key, 8)
+	return uint8(v), err
+}
+
+func (f *fieldSet) uint32(key string) (uint32, error) {
+	v, err := f.uint(key, 32)
+	return uint32(v), err
+}
+
+// buildIE creates an IE from the template with variables expanded.
+//
+// The values given in Capture are stored into vars after the IE is created, so
+// that the generated values like ${random_teid} can be referred later.
+func buildIE(t *IETemplate, vars *Vars) (*ie.IE, error) {
+	typ, err := ieType(t.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := buildIEByType(typ, t, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %s: %w", t.Type, err)
+	}
+	i.SetInstance(t.Instance)
+
+	if len(t.Capture) != 0 {
+		fields := ieFields(i)
+		for key, name := range t.Capture {
+			v, ok := fields[key]
+			if !ok {
+				return nil, fmt.Errorf("failed to capture %s in %s: no such field", key, t.Type)
+			}
+			vars.Set(name, v)
+		}
+	}
+	return i, nil
+}
+
+func buildIEByType(typ uint8, t *IETemplate, vars *Vars) (*ie.IE, error) {
+	f := &fieldSet{fields: t.Fields, vars: vars}
+
+	if s, ok, err := f.str("hex"); err != nil {
+		return nil, err
+	} else if ok {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return ie.New(typ, 0, b), nil
+	}
+
+	if len(t.IEs) != 0 {
+		children := make([]*ie.IE, len(t.IEs))
+		for n, c := range t.IEs {
+			child, err := buildIE(c, vars)
+			if err != nil {
+				return nil, err
+			}
+			children[n] = child
+		}
+
+		g := ie.New(typ, 0, nil)
+		if !g.IsGrouped() {
+			return nil, fmt.Errorf("%s is not a grouped IE", t.Type)
+		}
+		g.Add(children...)
+		return g, nil
+	}
+
+	value, _, err := f.str("value")
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case ie.IMSI:
+		return ie.NewIMSI(value), nil
+	case ie.MSISDN:
+		return ie.NewMSISDN(value), nil
+	case ie.MobileEquipmentIdentity:
+		return ie.NewMobileEquipmentIdentity(value), nil
+	case ie.AccessPointName:
+		return ie.NewAccessPointName(value), nil
+	case ie.IPAddress:
+		return ie.NewIPAddress(value), nil
+	case ie.Cause:
+		v, err := f.uint8("value")
+		if err != nil {
+			return nil, err
+		}
+		return ie.NewCause(v, 0, 0, 0, nil), nil
+	case ie.Recovery, ie.EPSBearerID, ie.RATType, ie.PDNType, ie.SelectionMode:
+		v, err := f.uint8("value")
+		if err != nil {
+			return nil, err
+		}
+		return ie.New(typ, 0, []byte{v}), nil
+	case ie.ChargingID:
+		v, err := f.uint32("value")
+		if err != nil {
+			return nil, err
+		}
+		return ie.NewChargingID(v), nil
+	case ie.ServingNetwork:
+		mcc, _, err := f.str("mcc")
+		if err != nil {
+			return nil, err
+		}
+		mnc, _, err := f.str("mnc")
+		if err != nil {
+			return nil, err
+		}
+		return ie.NewServingNetwork(mcc, mnc), nil
+	case ie.FullyQualifiedTEID:
+		ifName, _, err := f.str("interface")
+		if err != nil {
+			return nil, err
+		}
+		ifType, err := interfaceType(ifName)
+		if err != nil {
+			return nil, err
+		}
+		teid, err := f.uint32("teid")
+		if err != nil {
+			return nil, err
+		}
+		v4, _, err := f.str("ipv4")
+		if err != nil {
+			return nil, err
+		}
+		v6, _, err := f.str("ipv6")
+		if err != nil {
+			return nil, err
+		}
+		return ie.NewFullyQualifiedTEID(ifType, teid, v4, v6), nil
+	case ie.PDNAddressAllocation:
+		v4, _, err := f.str("ipv4")
+		if err != nil {
+			return nil, err
+		}
+		v6, _, err := f.str("ipv6")
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := f.uint8("prefix")
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case v4 != "" && v6 != "":
+			return ie.NewPDNAddressAllocationDual(v4, v6, prefix), nil
+		case v6 != "":
+			return ie.NewPDNAddressAllocationIPv6(v6, prefix), nil
+		default:
+			return ie.NewPDNAddressAllocation(v4), nil
+		}
+	case ie.AggregateMaximumBitRate:
+		ul, err := f.uint32("ul")
+		if err != nil {
+			return nil, err
+		}
+		dl, err := f.uint32("dl")
+		if err != nil {
+			return nil, err
+		}
+		return ie.NewAggregateMaximumBitRate(ul, dl), nil
+	case ie.BearerQoS:
+		var v [4]uint8
+		for n, key := range []string{"pci", "pl", "pvi", "qci"} {
+			if v[n], err = f.uint8(key); err != nil {
+				return nil, err
+			}
+		}
+		var br [4]uint64
+		for n, key := range []string{"mbr_ul", "mbr_dl", "gbr_ul", "gbr_dl"} {
+			if br[n], err = f.uint(key, 40); err != nil {
+				return nil, err
+			}
+		}
+		return ie.NewBearerQoS(v[0], v[1], v[2], v[3], br[0], br[1], br[2], br[3]), nil
+	default:
+		return nil, fmt.Errorf("fields are not supported for %s, use hex instead", t.Type)
+	}
+}
+
+// ieFields returns the values in IE in string, keyed by the same names used in
+// IETemplate.Fields. "hex" is always available.
+func ieFields(i *ie.IE) map[string]string {
+	f := map[string]string{"hex": hex.EncodeToString(i.Payload)}
+
+	switch i.Type {
+	case ie.IMSI:
+		f["value"], _ = i.IMSI()
+	case ie.MSISDN:
+		f["value"], _ = i.MSISDN()
+	case ie.MobileEquipmentIdentity:
+		f["value"], _ = i.MobileEquipmentIdentity()
+	case ie.AccessPointName:
+		f["value"], _ = i.AccessPointName()
+	case ie.IPAddress:
+		f["value"], _ = i.IPAddress()
+	case ie.Cause:
+		if v, err := i.Cause(); err == nil {
+			f["value"] = strconv.Itoa(int(v))
+		}
+	case ie.Recovery:
+		f["value"] = uint8String(i.Recovery())
+	case ie.EPSBearerID:
+		f["value"] = uint8String(i.EPSBearerID())
+	case ie.RATType:
+		f["value"] = uint8String(i.RATType())
+	case ie.PDNType:
+		f["value"] = uint8String(i.PDNType())
+	case ie.SelectionMode:
+		f["value"] = uint8String(i.SelectionMode())
+	case ie.ChargingID:
+		if v, err := i.ChargingID(); err == nil {
+			f["value"] = strconv.FormatUint(uint64(v), 10)
+		}
+	case ie.ServingNetwork:
+		f["mcc"], _ = i.MCC()
+		f["mnc"], _ = i.MNC()
+	case ie.FullyQualifiedTEID:
+		if v, err := i.FullyQualifiedTEID(); err == nil {
+			f["interface"] = strconv.Itoa(int(v.InterfaceType))
+			f["teid"] = strconv.FormatUint(uint64(v.TEIDGREKey), 10)
+			if v.IPv4Address != nil {
+				f["ipv4"] = v.IPv4Address.String()
+			}
+			if v.IPv6Address != nil {
+				f["ipv6"] = v.IPv6Address.String()
+			}
+		}
+	case ie.PDNAddressAllocation:
+		if v, err := ie.ParsePDNAddressAllocationFields(i.Payload); err == nil {
+			if v.IPv4Address != nil {
+				f["ipv4"] = v.IPv4Address.String()
+			}
+			if v.IPv6Address != nil {
+				f["ipv6"] = v.IPv6Address.String()
+				f["prefix"] = strconv.Itoa(int(v.IPv6PrefixLength))
+			}
+		}
+	case ie.AggregateMaximumBitRate:
+		if v, err := i.AggregateMaximumBitRate(); err == nil {
+			f["ul"] = strconv.FormatUint(uint64(v.APNAMBRForUplink), 10)
+			f["dl"] = strconv.FormatUint(uint64(v.APNAMBRForDownlink), 10)
+		}
+	case ie.BearerQoS:
+		if v, err := i.BearerQoS(); err == nil {
+			f["pci"] = strconv.Itoa(int(v.ARP >> 6 & 0x01))
+			f["pl"] = strconv.Itoa(int(v.ARP >> 2 & 0x0f))
+			f["pvi"] = strconv.Itoa(int(v.ARP & 0x01))
+			f["qci"] = strconv.Itoa(int(v.QCI))
+			f["mbr_ul"] = strconv.FormatUint(v.MaximumBitRateForUplink, 10)
+			f["mbr_dl"] = strconv.FormatUint(v.MaximumBitRateForDownlink, 10)
+			f["gbr_ul"] = strconv.FormatUint(v.GuaranteedBitRateForUplink, 10)
+			f["gbr_dl"] = strconv.FormatUint(v.GuaranteedBitRateForDownlink, 10)
+		}
+	}
+
+	for k, v := range f {
+		if v == "" {
+			delete(f, k)
+		}
+	}
+	return f
+}
+
+func uint8String(v uint8, err error) string {
+	if err != nil {
+		return ""
+	}
+	return strconv.Itoa(int(v))
+}
+
+// matchIEs checks if the IEs received satisfy the matchers, and captures the
+// values into vars.
+func matchIEs(path string, ies []*ie.IE, matchers []*IETemplate, vars *Vars) error {
+	for _, m := range matchers {
+		typ, err := ieType(m.Type)
+		if err != nil {
+			return err
+		}
+		p := fmt.Sprintf("%s/%s[%d]", path, m.Type, m.Instance)
+
+		var found *ie.IE
+		for _, i := range ies {
+			if i.Type == typ && i.Instance() == m.Instance {
+				found = i
+				break
+			}
+		}
+
+		if m.Absent {
+			if found != nil {
+				return &MismatchError{Path: p, Got: "present", Want: "absent"}
+			}
+			continue
+		}
+		if found == nil {
+			return &MismatchError{Path: p, Got: "absent", Want: "present"}
+		}
+
+		got := ieFields(found)
+		f := &fieldSet{fields: m.Fields, vars: vars}
+		for key := range m.Fields {
+			want, _, err := f.str(key)
+			if err != nil {
+				return err
+			}
+			if !matchValue(got[key], want) {
+				return &MismatchError{Path: p + "." + key, Got: got[key], Want: want}
+			}
+		}
+
+		if len(m.IEs) != 0 {
+			if err := matchIEs(p, found.ChildIEs, m.IEs, vars); err != nil {
+				return err
+			}
+		}
+
+		for key, name := range m.Capture {
+			v, ok := got[key]
+			if !ok {
+				return &MismatchError{Path: p + "." + key, Got: "", Want: "(any value to capture)"}
+			}
+			vars.Set(name, v)
+		}
+	}
+	return nil
+}
+
+// matchValue compares the values in string, treating them as numbers or IP
+// addresses if possible. "*" matches any non-empty value.
+func matchValue(got, want string) bool {
+	if want == "*" {
+		return got != ""
+	}
+	if got == want {
+		return true
+	}
+
+	if g, err := strconv.ParseUint(got, 0, 64); err == nil {
+		if w, err := strconv.ParseUint(want, 0, 64); err == nil {
+			return g == w
+		}
+	}
+
+	if g := net.ParseIP(got); g != nil {
+		return g.Equal(net.ParseIP(want))
+	}
+	return false
+}
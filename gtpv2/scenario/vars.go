@@ -0,0 +1,84 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package scenario
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+var varRef = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// Vars is a set of variables available in the steps of a Scenario.
+//
+// In addition to the ones set by user, the following are built-in;
+//
+//	${random_teid}: a random non-zero TEID, generated each time it is referred.
+//	${last_seq}: the Sequence Number of the message sent last.
+type Vars struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+// NewVars creates a new Vars with the initial values given.
+func NewVars(init map[string]string) *Vars {
+	v := &Vars{m: map[string]string{}}
+	for k, val := range init {
+		v.m[k] = val
+	}
+	return v
+}
+
+// Get returns the value of the variable.
+func (v *Vars) Get(name string) (string, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	val, ok := v.m[name]
+	return val, ok
+}
+
+// Set sets the value of the variable.
+func (v *Vars) Set(name, val string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.m[name] = val
+}
+
+// Expand replaces ${name} in s with the values of the variables.
+func (v *Vars) Expand(s string) (string, error) {
+	var err error
+	out := varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := varRef.FindStringSubmatch(ref)[1]
+		switch name {
+		case "random_teid":
+			return strconv.FormatUint(uint64(randomTEID()), 10)
+		}
+
+		val, ok := v.Get(name)
+		if !ok {
+			err = &UndefinedVariableError{Name: name}
+			return ref
+		}
+		return val
+	})
+	return out, err
+}
+
+func randomTEID() uint32 {
+	b := make([]byte, 4)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 1
+		}
+		if t := binary.BigEndian.Uint32(b); t != 0 {
+			return t
+		}
+	}
+}
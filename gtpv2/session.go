@@ -5,6 +5,7 @@
 package gtpv2
 
 import (
+	"encoding/json"
 	"net"
 	"sync"
 	"time"
@@ -42,6 +43,15 @@ type Session struct {
 	peerAddr       net.Addr
 	peerAddrString string
 
+	// releaseFuncs are called when Session is removed from Conn.
+	releaseFuncs []func()
+
+	// fqcsids are the FQ-CSIDs associated with Session by the type of node.
+	fqcsids map[uint8]*FQCSID
+
+	// indexes are the secondary indexes of the Conns that Session is registered to.
+	indexes map[*sessionIndex]struct{}
+
 	// Subscriber is a Subscriber associated with Session.
 	*Subscriber
 }
@@ -96,13 +106,29 @@ func (s *Session) IsActive() bool {
 
 // PeerAddr returns the address of the peer node associated with Session.
 func (s *Session) PeerAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s.peerAddr
 }
 
+// hasPeer reports whether the string form of peer is the one of the address of
+// the peer node associated with Session.
+func (s *Session) hasPeer(peer net.Addr) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return peer.String() == s.peerAddrString
+}
+
 // UpdatePeerAddr updates the address of the peer node associated with Session.
 func (s *Session) UpdatePeerAddr(peer net.Addr) {
+	s.mu.Lock()
 	s.peerAddr = peer
 	s.peerAddrString = peer.String()
+	s.mu.Unlock()
+
+	s.Reindex()
 }
 
 // AddTEID adds TEID to session with InterfaceType.
@@ -124,6 +150,68 @@ func (s *Session) GetTEID(ifType uint8) (uint32, error) {
 	return 0, ErrTEIDNotFound
 }
 
+// AddReleaseFunc adds fn to be called when the Session is removed from Conn with
+// RemoveSession, which can be used to release the resources allocated for the Session,
+// e.g., the IP address of the subscriber.
+//
+// The functions are called only once in the order they are added.
+func (s *Session) AddReleaseFunc(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.releaseFuncs = append(s.releaseFuncs, fn)
+}
+
+// release calls the functions added with AddReleaseFunc.
+func (s *Session) release() {
+	s.mu.Lock()
+	fns := s.releaseFuncs
+	s.releaseFuncs = nil
+	s.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// Reindex updates the indexes of the Conns that Session is registered to, which are
+// used in the lookups such as GetSessionBySubscriberIP.
+//
+// It is called automatically when the Bearers or the peer address are updated with
+// the methods of Session. Call it after modifying the fields of Subscriber or Bearer
+// directly, e.g., after assigning the SubscriberIP of the default bearer.
+func (s *Session) Reindex() {
+	s.mu.Lock()
+	indexes := make([]*sessionIndex, 0, len(s.indexes))
+	for idx := range s.indexes {
+		indexes = append(indexes, idx)
+	}
+	s.mu.Unlock()
+
+	for _, idx := range indexes {
+		idx.update(s)
+	}
+}
+
+func (s *Session) addIndex(idx *sessionIndex) {
+	s.mu.Lock()
+	if s.indexes == nil {
+		s.indexes = map[*sessionIndex]struct{}{}
+	}
+	s.indexes[idx] = struct{}{}
+	s.mu.Unlock()
+
+	idx.update(s)
+}
+
+func (s *Session) removeIndex(idx *sessionIndex) {
+	s.mu.Lock()
+	delete(s.indexes, idx)
+	s.mu.Unlock()
+
+	idx.remove(s)
+}
+
 // PassMessageTo passes the message (typically "triggerred message") to the session
 // expecting to receive it.
 //
@@ -166,11 +254,13 @@ func (s *Session) WaitMessage(seq uint32, timeout time.Duration) (message.Messag
 // always available after created a Session.
 func (s *Session) AddBearer(name string, br *Bearer) {
 	s.bearerMap.store(name, br)
+	s.Reindex()
 }
 
 // RemoveBearer removes a Bearer looked up by name.
 func (s *Session) RemoveBearer(name string) {
 	s.bearerMap.delete(name)
+	s.Reindex()
 }
 
 // RemoveBearerByEBI removes a Bearer looked up by name.
@@ -180,6 +270,7 @@ func (s *Session) RemoveBearerByEBI(ebi uint8) {
 		return
 	}
 	s.bearerMap.delete(name)
+	s.Reindex()
 }
 
 // GetDefaultBearer returns the default bearer.
@@ -197,6 +288,7 @@ func (s *Session) GetDefaultBearer() *Bearer {
 func (s *Session) SetDefaultBearer(bearer *Bearer) {
 	// it is not expected that the default bearer cannot be found.
 	s.bearerMap.store("default", bearer)
+	s.Reindex()
 }
 
 // LookupBearerByName looks up Bearer registered in Session by name.
@@ -354,3 +446,88 @@ func (s *Session) BearerCount() int {
 
 	return count
 }
+
+// sessionJSON is the serializable form of Session.
+type sessionJSON struct {
+	Active      bool               `json:"active"`
+	PeerAddress *addrJSON          `json:"peer_address,omitempty"`
+	TEIDs       map[uint8]uint32   `json:"teids,omitempty"`
+	Bearers     map[string]*Bearer `json:"bearers,omitempty"`
+	Subscriber  *Subscriber        `json:"subscriber,omitempty"`
+	FQCSIDs     map[uint8]*FQCSID  `json:"fq_csids,omitempty"`
+}
+
+// MarshalJSON serializes Session into JSON, including the TEIDs and Bearers.
+//
+// The messages in the queue and the functions added with AddReleaseFunc are not
+// serialized.
+func (s *Session) MarshalJSON() ([]byte, error) {
+	j := &sessionJSON{
+		Active:      s.IsActive(),
+		PeerAddress: newAddrJSON(s.PeerAddr()),
+		TEIDs:       map[uint8]uint32{},
+		Bearers:     map[string]*Bearer{},
+		Subscriber:  s.Subscriber,
+	}
+	s.teidMap.syncMap.Range(func(k, v interface{}) bool {
+		j.TEIDs[k.(uint8)] = v.(uint32)
+		return true
+	})
+	s.bearerMap.rangeWithFunc(func(k, v interface{}) bool {
+		j.Bearers[k.(string)] = v.(*Bearer)
+		return true
+	})
+	s.mu.Lock()
+	if len(s.fqcsids) > 0 {
+		j.FQCSIDs = make(map[uint8]*FQCSID, len(s.fqcsids))
+		for nodeType, f := range s.fqcsids {
+			j.FQCSIDs[nodeType] = f
+		}
+	}
+	s.mu.Unlock()
+
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes the JSON serialized with MarshalJSON into Session.
+//
+// It should be called on a Session that is not in use, typically a new(Session).
+func (s *Session) UnmarshalJSON(data []byte) error {
+	j := &sessionJSON{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return err
+	}
+
+	peer, err := j.PeerAddress.addr()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.isActive = j.Active
+	s.peerAddr = peer
+	s.peerAddrString = ""
+	if peer != nil {
+		s.peerAddrString = peer.String()
+	}
+	s.teidMap = newTeidMap()
+	for ifType, teid := range j.TEIDs {
+		s.teidMap.store(ifType, teid)
+	}
+	s.bearerMap = &bearerMap{}
+	for name, br := range j.Bearers {
+		s.bearerMap.store(name, br)
+	}
+	s.fqcsids = j.FQCSIDs
+	if s.msgQueue == nil {
+		s.msgQueue = make(chan message.Message, 1000)
+	}
+	s.Subscriber = j.Subscriber
+	if s.Subscriber == nil {
+		s.Subscriber = &Subscriber{}
+	}
+
+	return nil
+}
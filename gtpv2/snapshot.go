@@ -0,0 +1,149 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SnapshotVersion is the version of the format written by Snapshot.
+const SnapshotVersion = 1
+
+type snapshot struct {
+	Version        int                `json:"version"`
+	LocalIfType    uint8              `json:"local_if_type"`
+	RestartCounter uint8              `json:"restart_counter"`
+	Sequence       uint32             `json:"sequence"`
+	Sessions       []*snapshotSession `json:"sessions"`
+}
+
+type snapshotSession struct {
+	IncomingTEID uint32   `json:"incoming_teid"`
+	Session      *Session `json:"session"`
+}
+
+// Snapshot writes the Sessions registered in Conn to w in a versioned JSON format,
+// including the Bearers, TEIDs per interface, peer addresses and Subscribers, along
+// with the RestartCounter and the last SequenceNumber.
+//
+// The snapshot can be loaded into a new Conn with Restore, e.g., after restarting
+// the process for upgrade, so that the UEs do not need to attach again.
+func (c *Conn) Snapshot(w io.Writer) error {
+	c.mu.Lock()
+	s := &snapshot{
+		Version:        SnapshotVersion,
+		LocalIfType:    c.localIfType,
+		RestartCounter: c.RestartCounter,
+		Sequence:       c.sequence,
+	}
+	c.mu.Unlock()
+
+	for _, sess := range c.Sessions() {
+		itei, err := sess.GetTEID(s.LocalIfType)
+		if err != nil {
+			return fmt.Errorf("failed to find incoming TEID of %s: %w", sess.IMSI, err)
+		}
+		s.Sessions = append(s.Sessions, &snapshotSession{IncomingTEID: itei, Session: sess})
+	}
+	sort.Slice(s.Sessions, func(i, j int) bool {
+		return s.Sessions[i].IncomingTEID < s.Sessions[j].IncomingTEID
+	})
+
+	return json.NewEncoder(w).Encode(s)
+}
+
+// Restore reads the snapshot written by Snapshot from r, and registers the Sessions
+// in it to Conn. The incoming TEIDs are reserved in the TEIDAllocator so that they
+// are not allocated for the other Sessions.
+//
+// The RestartCounter and the SequenceNumber are also restored, and the RestartCounter
+// is incremented if EnableRestartCounterBump is called. Note that the peers are
+// expected to remove all the Sessions if they see the RestartCounter incremented.
+//
+// It returns error if the snapshot is for a different interface type, or any of the
+// incoming TEIDs is duplicated in the snapshot or already taken in Conn. Nothing is
+// changed in Conn in that case.
+func (c *Conn) Restore(r io.Reader) error {
+	s := &snapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return err
+	}
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported version %d: %w", s.Version, ErrInvalidSnapshot)
+	}
+
+	c.mu.Lock()
+	ifType := c.localIfType
+	c.mu.Unlock()
+	if s.LocalIfType != ifType {
+		return fmt.Errorf("interface type %d does not match %d: %w", s.LocalIfType, ifType, ErrInvalidSnapshot)
+	}
+
+	var sessions []*snapshotSession
+	seen := map[uint32]bool{}
+	for _, ss := range s.Sessions {
+		if ss.Session == nil {
+			continue
+		}
+		if seen[ss.IncomingTEID] {
+			return fmt.Errorf("TEID %#08x is duplicated: %w", ss.IncomingTEID, ErrInvalidSnapshot)
+		}
+		seen[ss.IncomingTEID] = true
+		sessions = append(sessions, ss)
+	}
+
+	// reserve all the TEIDs first not to register any Session on conflict.
+	store := c.SessionStore()
+	for i, ss := range sessions {
+		if ok := store.Reserve(ss.IncomingTEID); !ok {
+			for _, reserved := range sessions[:i] {
+				store.Release(reserved.IncomingTEID)
+			}
+			return fmt.Errorf("TEID %#08x is already in use: %w", ss.IncomingTEID, ErrInvalidSnapshot)
+		}
+	}
+
+	c.mu.Lock()
+	c.RestartCounter = s.RestartCounter
+	if c.bumpOnRestore {
+		c.RestartCounter++
+	}
+	c.sequence = s.Sequence & maxSequence
+	c.mu.Unlock()
+
+	alloc := c.TEIDAllocator()
+	for _, ss := range sessions {
+		if err := alloc.Reserve(ss.IncomingTEID); err != nil {
+			// the TEID out of the range of allocator does not collide.
+			logf("failed to reserve TEID of %s: %v", ss.Session.IMSI, err)
+		}
+		c.RegisterSession(ss.IncomingTEID, ss.Session)
+	}
+
+	return nil
+}
+
+// EnableRestartCounterBump makes Restore increment the RestartCounter restored
+// from the snapshot, which is disabled by default.
+//
+// See also: DisableRestartCounterBump.
+func (c *Conn) EnableRestartCounterBump() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bumpOnRestore = true
+}
+
+// DisableRestartCounterBump makes Restore keep the RestartCounter restored from
+// the snapshot as it is, so that the peers keep the Sessions.
+//
+// See also: EnableRestartCounterBump.
+func (c *Conn) DisableRestartCounterBump() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bumpOnRestore = false
+}
@@ -0,0 +1,126 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	laddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2123}
+
+	conn := gtpv2.NewConn(laddr, gtpv2.IFTypeS11S4SGWGTPC, 3)
+	conn.IncSequence()
+	var teids []uint32
+	for _, imsi := range []string{"001010000000001", "001010000000002"} {
+		sess := newTestSession(t, imsi)
+		itei := conn.NewSenderFTEID("127.0.0.1", "").MustTEID()
+		conn.RegisterSession(itei, sess)
+		teids = append(teids, itei)
+	}
+
+	var buf bytes.Buffer
+	if err := conn.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.String()
+
+	t.Run("restore", func(t *testing.T) {
+		restored := gtpv2.NewConn(laddr, gtpv2.IFTypeS11S4SGWGTPC, 0)
+		if err := restored.Restore(strings.NewReader(snapshot)); err != nil {
+			t.Fatal(err)
+		}
+
+		if restored.RestartCounter != 3 {
+			t.Errorf("wrong RestartCounter: %d", restored.RestartCounter)
+		}
+		if got := restored.SequenceNumber(); got != conn.SequenceNumber() {
+			t.Errorf("wrong SequenceNumber: %d", got)
+		}
+		if got := restored.SessionCount(); got != 2 {
+			t.Errorf("wrong number of sessions: %d", got)
+		}
+		for i, imsi := range []string{"001010000000001", "001010000000002"} {
+			sess, err := restored.GetSessionByTEID(teids[i], &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 2123})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sess.IMSI != imsi {
+				t.Errorf("wrong session for %#08x: %s", teids[i], sess.IMSI)
+			}
+			if br := sess.GetDefaultBearer(); br.SubscriberIP != "10.10.10.1" || br.OutgoingTEID() != 0x44444444 {
+				t.Errorf("wrong bearer: %+v", br)
+			}
+		}
+
+		// the restored TEIDs are not allocated again.
+		if err := restored.TEIDAllocator().Reserve(teids[0]); err == nil {
+			t.Error("restored TEID is not reserved")
+		}
+
+		// restoring twice conflicts.
+		if err := restored.Restore(strings.NewReader(snapshot)); !errors.Is(err, gtpv2.ErrInvalidSnapshot) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		restored := gtpv2.NewConn(laddr, gtpv2.IFTypeS11S4SGWGTPC, 0)
+		restored.RegisterSession(teids[1], newTestSession(t, "001010000000003"))
+
+		duplicated := strings.Replace(snapshot, strconv.FormatUint(uint64(teids[1]), 10), strconv.FormatUint(uint64(teids[0]), 10), 1)
+		for _, sn := range []string{snapshot, duplicated} {
+			if err := restored.Restore(strings.NewReader(sn)); !errors.Is(err, gtpv2.ErrInvalidSnapshot) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+
+		// nothing is changed by Restore failed.
+		if restored.RestartCounter != 0 || restored.SequenceNumber() != 0 {
+			t.Errorf("counters are changed: %d, %d", restored.RestartCounter, restored.SequenceNumber())
+		}
+		if got := restored.SessionCount(); got != 1 {
+			t.Errorf("wrong number of sessions: %d", got)
+		}
+		if ok := restored.SessionStore().Reserve(teids[0]); !ok {
+			t.Error("TEID is left reserved in SessionStore")
+		}
+		if err := restored.TEIDAllocator().Reserve(teids[0]); err != nil {
+			t.Errorf("TEID is left reserved in TEIDAllocator: %v", err)
+		}
+	})
+
+	t.Run("bump", func(t *testing.T) {
+		restored := gtpv2.NewConn(laddr, gtpv2.IFTypeS11S4SGWGTPC, 0)
+		restored.EnableRestartCounterBump()
+		if err := restored.Restore(strings.NewReader(snapshot)); err != nil {
+			t.Fatal(err)
+		}
+		if restored.RestartCounter != 4 {
+			t.Errorf("wrong RestartCounter: %d", restored.RestartCounter)
+		}
+	})
+
+	t.Run("interface-mismatch", func(t *testing.T) {
+		restored := gtpv2.NewConn(laddr, gtpv2.IFTypeS5S8PGWGTPC, 0)
+		if err := restored.Restore(strings.NewReader(snapshot)); !errors.Is(err, gtpv2.ErrInvalidSnapshot) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("version-mismatch", func(t *testing.T) {
+		restored := gtpv2.NewConn(laddr, gtpv2.IFTypeS11S4SGWGTPC, 0)
+		if err := restored.Restore(strings.NewReader(`{"version":99}`)); !errors.Is(err, gtpv2.ErrInvalidSnapshot) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
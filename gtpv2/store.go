@@ -0,0 +1,166 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2
+
+import (
+	"sync"
+)
+
+// SessionStore is the interface that stores the Sessions registered in Conn.
+//
+// The Sessions are looked up by the incoming TEID of the local interface and by
+// IMSI. The implementation should be safe for concurrent use.
+//
+// By default, Conn uses the one created by NewMemorySessionStore, which keeps the
+// Sessions in memory. Session is serializable with encoding/json so that the
+// implementation can persist or replicate the Sessions.
+type SessionStore interface {
+	// Store registers session with its incoming TEID and IMSI. The one that has
+	// already been registered with the same key is replaced.
+	Store(itei uint32, session *Session) error
+
+	// Reserve marks itei as taken without Session, and reports whether it
+	// succeeded. It fails if itei is already taken.
+	Reserve(itei uint32) bool
+
+	// Release unmarks itei marked by Reserve. It does nothing if a Session has
+	// been registered with itei.
+	Release(itei uint32)
+
+	// LoadByTEID returns the Session registered with itei.
+	LoadByTEID(itei uint32) (*Session, bool)
+
+	// LoadByIMSI returns the Session registered with imsi.
+	LoadByIMSI(imsi string) (*Session, bool)
+
+	// Delete removes session and returns the incoming TEIDs that were registered
+	// with it.
+	Delete(session *Session) []uint32
+
+	// Range calls fn for each Session in the store until fn returns false.
+	Range(fn func(session *Session) bool)
+}
+
+// MemorySessionStore is a SessionStore that keeps the Sessions in memory.
+type MemorySessionStore struct {
+	mu     sync.RWMutex
+	byTEID map[uint32]*Session
+	byIMSI map[string]*Session
+	teids  map[*Session][]uint32
+}
+
+// NewMemorySessionStore creates a new MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		byTEID: map[uint32]*Session{},
+		byIMSI: map[string]*Session{},
+		teids:  map[*Session][]uint32{},
+	}
+}
+
+// Store registers session with its incoming TEID and IMSI.
+func (m *MemorySessionStore) Store(itei uint32, session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old := m.byTEID[itei]; old != nil && old != session {
+		m.forget(old, itei)
+	}
+	m.byTEID[itei] = session
+	m.byIMSI[session.IMSI] = session
+	for _, t := range m.teids[session] {
+		if t == itei {
+			return nil
+		}
+	}
+	m.teids[session] = append(m.teids[session], itei)
+	return nil
+}
+
+// forget removes itei from the TEIDs of session.
+func (m *MemorySessionStore) forget(session *Session, itei uint32) {
+	teids := m.teids[session]
+	for i, t := range teids {
+		if t == itei {
+			m.teids[session] = append(teids[:i:i], teids[i+1:]...)
+			break
+		}
+	}
+}
+
+// Reserve marks itei as taken without Session.
+func (m *MemorySessionStore) Reserve(itei uint32) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.byTEID[itei]; ok {
+		return false
+	}
+	m.byTEID[itei] = nil
+	return true
+}
+
+// Release unmarks itei marked by Reserve.
+func (m *MemorySessionStore) Release(itei uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.byTEID[itei]; ok && session == nil {
+		delete(m.byTEID, itei)
+	}
+}
+
+// LoadByTEID returns the Session registered with itei.
+func (m *MemorySessionStore) LoadByTEID(itei uint32) (*Session, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session := m.byTEID[itei]
+	return session, session != nil
+}
+
+// LoadByIMSI returns the Session registered with imsi.
+func (m *MemorySessionStore) LoadByIMSI(imsi string) (*Session, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.byIMSI[imsi]
+	return session, ok
+}
+
+// Delete removes session and returns the incoming TEIDs that were registered with it.
+func (m *MemorySessionStore) Delete(session *Session) []uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.byIMSI[session.IMSI] == session {
+		delete(m.byIMSI, session.IMSI)
+	}
+
+	teids := m.teids[session]
+	for _, t := range teids {
+		if m.byTEID[t] == session {
+			delete(m.byTEID, t)
+		}
+	}
+	delete(m.teids, session)
+	return teids
+}
+
+// Range calls fn for each Session in the store until fn returns false.
+func (m *MemorySessionStore) Range(fn func(session *Session) bool) {
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.byIMSI))
+	for _, s := range m.byIMSI {
+		sessions = append(sessions, s)
+	}
+	m.mu.RUnlock()
+
+	for _, s := range sessions {
+		if !fn(s) {
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package gtpv2_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+func newTestSession(t *testing.T, imsi string) *gtpv2.Session {
+	t.Helper()
+
+	sess := gtpv2.NewSession(
+		&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 2123},
+		&gtpv2.Subscriber{
+			IMSI: imsi, MSISDN: "819012345678", IMEI: "123456789012345",
+			Location: &gtpv2.Location{MCC: "001", MNC: "01", TAI: 0x0001, ECI: 0x01234567},
+		},
+	)
+	if err := sess.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	sess.AddTEID(gtpv2.IFTypeS11S4SGWGTPC, 0x11111111)
+	sess.AddTEID(gtpv2.IFTypeS11MMEGTPC, 0x22222222)
+
+	br := sess.GetDefaultBearer()
+	br.EBI = 5
+	br.APN = "internet"
+	br.SubscriberIP = "10.10.10.1"
+	br.ChargingID = 1
+	br.QCI = 9
+	br.SetIncomingTEID(0x33333333)
+	br.SetOutgoingTEID(0x44444444)
+	br.SetRemoteAddress(&net.UDPAddr{IP: net.ParseIP("127.0.0.3"), Port: 2152})
+
+	sess.AddBearer("dedicated", gtpv2.NewBearer(6, "internet", &gtpv2.QoSProfile{QCI: 1, GBRUL: 64000, GBRDL: 64000}))
+	return sess
+}
+
+func TestSessionJSON(t *testing.T) {
+	sess := newTestSession(t, "001010000000001")
+
+	b, err := json.Marshal(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &gtpv2.Session{}
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(sess.Subscriber, got.Subscriber); diff != "" {
+		t.Error(diff)
+	}
+	if !got.IsActive() {
+		t.Error("session is not active")
+	}
+	if got.PeerAddr().String() != sess.PeerAddr().String() {
+		t.Errorf("wrong peer address: %s", got.PeerAddr())
+	}
+	for _, ifType := range []uint8{gtpv2.IFTypeS11S4SGWGTPC, gtpv2.IFTypeS11MMEGTPC} {
+		want, _ := sess.GetTEID(ifType)
+		if teid, err := got.GetTEID(ifType); err != nil || teid != want {
+			t.Errorf("wrong TEID for %d: %#08x, %v", ifType, teid, err)
+		}
+	}
+	if got.BearerCount() != 2 {
+		t.Errorf("wrong number of bearers: %d", got.BearerCount())
+	}
+
+	br := got.GetDefaultBearer()
+	want := sess.GetDefaultBearer()
+	if diff := cmp.Diff(want.QoSProfile, br.QoSProfile); diff != "" {
+		t.Error(diff)
+	}
+	if br.EBI != want.EBI || br.APN != want.APN || br.SubscriberIP != want.SubscriberIP || br.ChargingID != want.ChargingID {
+		t.Errorf("wrong bearer: %+v", br)
+	}
+	if br.IncomingTEID() != want.IncomingTEID() || br.OutgoingTEID() != want.OutgoingTEID() {
+		t.Errorf("wrong TEIDs in bearer: %#08x, %#08x", br.IncomingTEID(), br.OutgoingTEID())
+	}
+	if br.RemoteAddress().String() != want.RemoteAddress().String() {
+		t.Errorf("wrong remote address: %s", br.RemoteAddress())
+	}
+	if ebi := got.LookupEBIByName("dedicated"); ebi != 6 {
+		t.Errorf("wrong EBI for dedicated bearer: %d", ebi)
+	}
+}
+
+// countingStore is a SessionStore that counts the calls to Store and Delete.
+type countingStore struct {
+	*gtpv2.MemorySessionStore
+	stored, deleted int
+}
+
+func (s *countingStore) Store(itei uint32, session *gtpv2.Session) error {
+	s.stored++
+	return s.MemorySessionStore.Store(itei, session)
+}
+
+func (s *countingStore) Delete(session *gtpv2.Session) []uint32 {
+	s.deleted++
+	return s.MemorySessionStore.Delete(session)
+}
+
+func TestSessionStore(t *testing.T) {
+	store := &countingStore{MemorySessionStore: gtpv2.NewMemorySessionStore()}
+
+	conn := gtpv2.NewConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2123}, gtpv2.IFTypeS11S4SGWGTPC, 0)
+	conn.SetSessionStore(store)
+
+	sess1 := newTestSession(t, "001010000000001")
+	sess2 := newTestSession(t, "001010000000002")
+	conn.RegisterSession(0x11111111, sess1)
+	conn.RegisterSession(0x22222222, sess2)
+	if store.stored != 2 {
+		t.Errorf("store not used: %d", store.stored)
+	}
+
+	if got, err := conn.GetSessionByTEID(0x22222222, sess2.PeerAddr()); err != nil || got != sess2 {
+		t.Errorf("wrong session by TEID: %v, %v", got, err)
+	}
+	if got, err := conn.GetSessionByIMSI("001010000000001"); err != nil || got != sess1 {
+		t.Errorf("wrong session by IMSI: %v, %v", got, err)
+	}
+	if got := conn.SessionCount(); got != 2 {
+		t.Errorf("wrong number of sessions: %d", got)
+	}
+
+	conn.RemoveSessionByIMSI("001010000000001")
+	if store.deleted != 1 {
+		t.Errorf("store not used: %d", store.deleted)
+	}
+	if _, err := conn.GetSessionByTEID(0x11111111, sess1.PeerAddr()); err == nil {
+		t.Error("removed session found by TEID")
+	}
+	if got := conn.SessionCount(); got != 1 {
+		t.Errorf("wrong number of sessions: %d", got)
+	}
+
+	// the TEID reserved with NewSenderFTEID is not looked up.
+	fTEID := conn.NewSenderFTEID("127.0.0.1", "")
+	if _, ok := store.LoadByTEID(fTEID.MustTEID()); ok {
+		t.Error("reserved TEID found")
+	}
+	if store.Reserve(fTEID.MustTEID()) {
+		t.Error("reserved TEID reserved twice")
+	}
+}
@@ -0,0 +1,559 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package testutils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Role is the role of a mock Peer.
+type Role int
+
+// Role definitions.
+const (
+	RoleMME Role = iota
+	RoleSGW
+	RolePGW
+)
+
+// Behavior customizes how a Peer responds to a type of request.
+// The zero value responds with the default response immediately.
+type Behavior struct {
+	// Cause replaces the Cause in the default response. If it is not an accepted
+	// one, the response has only the Cause and IEs.
+	Cause uint8
+
+	// IEs are put in the response, replacing the default IE with the same type
+	// and instance if any.
+	IEs []*ie.IE
+
+	// Delay is the time to wait before responding.
+	Delay time.Duration
+
+	// Drop drops all the requests, and DropFirst drops the first DropFirst
+	// transmissions of the requests(retransmissions are not counted twice).
+	Drop      bool
+	DropFirst int
+
+	// Respond, if set, builds the response instead of the default one. Returning
+	// nil message drops the request. Cause and IEs are ignored.
+	Respond func(req message.Message) (message.Message, error)
+}
+
+// Received is a message received by a Peer.
+type Received struct {
+	From    net.Addr
+	Message message.Message
+	At      time.Time
+}
+
+type peerSession struct {
+	remoteTEID uint32
+	ebis       []uint8
+}
+
+type txKey struct {
+	addr string
+	seq  uint32
+}
+
+// Peer is a programmable mock of MME, S-GW or P-GW that works on a gtpv2.Conn.
+//
+// It answers Create/Modify/Delete Session, Release Access Bearers, Create/Update/
+// Delete Bearer and Downlink Data Notification with the responses with sensible
+// defaults, allocating TEIDs, PAA and Charging ID. The responses can be customized
+// for each message type with On, and the received messages can be inspected.
+// The response to a retransmitted request is the same as the one to the original.
+type Peer struct {
+	Conn *gtpv2.Conn
+
+	role Role
+	ip   string
+
+	mu         sync.Mutex
+	pco        *ie.PCOResponseConfig
+	behaviors  map[uint8]*Behavior
+	dropped    map[uint8]int
+	received   []*Received
+	cursors    map[uint8]int
+	notifyCh   chan struct{}
+	sessions   map[uint32]*peerSession
+	responses  map[txKey]message.Message
+	teid       uint32
+	ueIP       uint32
+	chargingID uint32
+}
+
+// NewPeer creates a new Peer that works on conn with the given role, registering
+// the handlers to conn. conn should be started serving by the caller.
+//
+// The validation of conn is disabled so that the requests with unknown TEIDs can
+// be inspected.
+func NewPeer(conn *gtpv2.Conn, role Role) *Peer {
+	ip, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		ip = conn.LocalAddr().String()
+	}
+
+	p := &Peer{
+		Conn:       conn,
+		role:       role,
+		ip:         ip,
+		behaviors:  map[uint8]*Behavior{},
+		dropped:    map[uint8]int{},
+		cursors:    map[uint8]int{},
+		notifyCh:   make(chan struct{}),
+		sessions:   map[uint32]*peerSession{},
+		responses:  map[txKey]message.Message{},
+		teid:       0x10000000 * uint32(role+1),
+		ueIP:       binary.BigEndian.Uint32(net.IPv4(10, 45, 0, 1).To4()),
+		chargingID: 1,
+	}
+
+	conn.DisableValidation()
+	conn.AddHandlers(map[uint8]gtpv2.HandlerFunc{
+		message.MsgTypeCreateSessionRequest:         p.handle,
+		message.MsgTypeModifyBearerRequest:          p.handle,
+		message.MsgTypeDeleteSessionRequest:         p.handle,
+		message.MsgTypeReleaseAccessBearersRequest:  p.handle,
+		message.MsgTypeCreateBearerRequest:          p.handle,
+		message.MsgTypeUpdateBearerRequest:          p.handle,
+		message.MsgTypeDeleteBearerRequest:          p.handle,
+		message.MsgTypeDownlinkDataNotification:     p.handle,
+		message.MsgTypeCreateSessionResponse:        p.record,
+		message.MsgTypeModifyBearerResponse:         p.record,
+		message.MsgTypeDeleteSessionResponse:        p.record,
+		message.MsgTypeReleaseAccessBearersResponse: p.record,
+		message.MsgTypeCreateBearerResponse:         p.record,
+		message.MsgTypeUpdateBearerResponse:         p.record,
+		message.MsgTypeDeleteBearerResponse:         p.record,
+	})
+	return p
+}
+
+// On sets the Behavior for the requests of msgType. Passing nil restores the default.
+func (p *Peer) On(msgType uint8, b *Behavior) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if b == nil {
+		delete(p.behaviors, msgType)
+	} else {
+		p.behaviors[msgType] = b
+	}
+	delete(p.dropped, msgType)
+}
+
+// SetPCO sets the config used to respond to PCO, APCO and ePCO in Create Session
+// Request. Passing nil stops responding to them, which is the default.
+func (p *Peer) SetPCO(cfg *ie.PCOResponseConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pco = cfg
+}
+
+// Reset clears the Behaviors, the received messages and the sessions.
+func (p *Peer) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.behaviors = map[uint8]*Behavior{}
+	p.dropped = map[uint8]int{}
+	p.received = nil
+	p.cursors = map[uint8]int{}
+	p.sessions = map[uint32]*peerSession{}
+	p.responses = map[txKey]message.Message{}
+}
+
+// Sessions returns the number of sessions the Peer has at the moment.
+func (p *Peer) Sessions() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.sessions)
+}
+
+// Received returns the messages of msgType received so far, including the
+// retransmitted and dropped ones.
+func (p *Peer) Received(msgType uint8) []*Received {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var rs []*Received
+	for _, r := range p.received {
+		if r.Message.MessageType() == msgType {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// Next waits for the message of msgType that has not been returned by Next yet,
+// and returns it. It returns gtpv2.ErrTimeout if no message comes within timeout.
+func (p *Peer) Next(msgType uint8, timeout time.Duration) (message.Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		p.mu.Lock()
+		var seen int
+		for _, r := range p.received {
+			if r.Message.MessageType() != msgType {
+				continue
+			}
+			if seen == p.cursors[msgType] {
+				p.cursors[msgType]++
+				p.mu.Unlock()
+				return r.Message, nil
+			}
+			seen++
+		}
+		notifyCh := p.notifyCh
+		p.mu.Unlock()
+
+		select {
+		case <-notifyCh:
+		case <-timer.C:
+			return nil, gtpv2.ErrTimeout
+		}
+	}
+}
+
+// Expect is the same as Next, but it fails t if no message comes within timeout.
+func (p *Peer) Expect(t testing.TB, msgType uint8, timeout time.Duration) message.Message {
+	t.Helper()
+
+	msg, err := p.Next(msgType, timeout)
+	if err != nil {
+		t.Fatalf("%s not received: %v", msgTypeName(msgType), err)
+	}
+	return msg
+}
+
+// AssertReceived fails t if the number of messages of msgType received so far
+// is not n.
+func (p *Peer) AssertReceived(t testing.TB, msgType uint8, n int) {
+	t.Helper()
+
+	if got := len(p.Received(msgType)); got != n {
+		t.Errorf("wrong number of %s received. got: %d, want: %d", msgTypeName(msgType), got, n)
+	}
+}
+
+func msgTypeName(msgType uint8) string {
+	// parse an empty message to get the name, as there is no lookup table.
+	m, err := message.Parse([]byte{0x48, msgType, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if err != nil {
+		return fmt.Sprintf("Unknown (%d)", msgType)
+	}
+	return m.MessageTypeName()
+}
+
+func (p *Peer) record(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.received = append(p.received, &Received{From: senderAddr, Message: msg, At: time.Now()})
+	close(p.notifyCh)
+	p.notifyCh = make(chan struct{})
+	return nil
+}
+
+func (p *Peer) handle(c *gtpv2.Conn, senderAddr net.Addr, msg message.Message) error {
+	if err := p.record(c, senderAddr, msg); err != nil {
+		return err
+	}
+
+	key := txKey{senderAddr.String(), msg.Sequence()}
+
+	p.mu.Lock()
+	b := p.behaviors[msg.MessageType()]
+	if b == nil {
+		b = &Behavior{}
+	}
+	rsp, retransmitted := p.responses[key]
+	drop := b.Drop
+	if !retransmitted && !drop && p.dropped[msg.MessageType()] < b.DropFirst {
+		p.dropped[msg.MessageType()]++
+		drop = true
+	}
+	p.mu.Unlock()
+
+	if drop {
+		return nil
+	}
+
+	if !retransmitted {
+		var err error
+		if b.Respond != nil {
+			rsp, err = b.Respond(msg)
+		} else {
+			rsp, err = p.respond(msg, b)
+		}
+		if err != nil {
+			return err
+		}
+		if rsp == nil {
+			return nil
+		}
+
+		p.mu.Lock()
+		p.responses[key] = rsp
+		p.mu.Unlock()
+	}
+
+	if b.Delay > 0 {
+		time.Sleep(b.Delay)
+	}
+	return c.RespondTo(senderAddr, msg, rsp)
+}
+
+// respond builds the default response to req, customized with b.
+func (p *Peer) respond(req message.Message, b *Behavior) (message.Message, error) {
+	cause := b.Cause
+	if cause == 0 {
+		cause = gtpv2.CauseRequestAccepted
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		teid uint32
+		sess *peerSession
+		ies  []*ie.IE
+	)
+	if req.MessageType() == message.MsgTypeCreateSessionRequest {
+		csr := req.(*message.CreateSessionRequest)
+		if csr.SenderFTEIDC == nil {
+			return p.build(req, 0, gtpv2.CauseMandatoryIEMissing, b.IEs)
+		}
+		teid = csr.SenderFTEIDC.MustTEID()
+	} else {
+		var ok bool
+		sess, ok = p.sessions[req.TEID()]
+		if !ok {
+			return p.build(req, 0, gtpv2.CauseContextNotFound, b.IEs)
+		}
+		teid = sess.remoteTEID
+	}
+
+	if !accepted(cause) {
+		return p.build(req, teid, cause, b.IEs)
+	}
+
+	switch m := req.(type) {
+	case *message.CreateSessionRequest:
+		local := p.nextTEID()
+		sess = &peerSession{remoteTEID: teid}
+		p.sessions[local] = sess
+
+		ies = append(ies, ie.NewFullyQualifiedTEID(p.cIFType(), local, p.ip, ""))
+		if p.role == RoleSGW {
+			ies = append(ies, ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8PGWGTPC, p.nextTEID(), p.ip, "").WithInstance(1))
+		}
+		ies = append(ies, p.allocatePAA(m.PDNType), ie.NewAPNRestriction(gtpv2.APNRestrictionNoExistingContextsorRestriction))
+		if p.pco != nil {
+			for _, pco := range []*ie.IE{m.PCO, m.APCO, m.EPCO} {
+				if pco == nil {
+					continue
+				}
+				if rsp, err := ie.NewPCOResponse(pco, p.pco); err == nil {
+					ies = append(ies, rsp)
+				}
+			}
+		}
+
+		for _, bc := range m.BearerContextsToBeCreated {
+			ebi := ebiOf(bc)
+			sess.ebis = append(sess.ebis, ebi)
+			ies = append(ies, ie.NewBearerContext(
+				ie.NewEPSBearerID(ebi),
+				ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+				p.uFTEID(),
+				ie.NewChargingID(p.nextChargingID()),
+			))
+		}
+	case *message.ModifyBearerRequest:
+		for _, bc := range m.BearerContextsToBeModified {
+			ies = append(ies, ie.NewBearerContext(
+				ie.NewEPSBearerID(ebiOf(bc)),
+				ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+				p.uFTEID(),
+			))
+		}
+	case *message.DeleteSessionRequest:
+		delete(p.sessions, req.TEID())
+	case *message.CreateBearerRequest:
+		for range m.BearerContexts {
+			ies = append(ies, ie.NewBearerContext(
+				ie.NewEPSBearerID(p.nextEBI(sess)),
+				ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+				p.uFTEID(),
+			))
+		}
+	case *message.UpdateBearerRequest:
+		for _, bc := range m.BearerContexts {
+			ies = append(ies, ie.NewBearerContext(
+				ie.NewEPSBearerID(ebiOf(bc)),
+				ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			))
+		}
+	case *message.DeleteBearerRequest:
+		if m.LinkedEBI != nil {
+			delete(p.sessions, req.TEID())
+			ies = append(ies, ie.NewEPSBearerID(m.LinkedEBI.MustEPSBearerID()))
+		}
+		for _, ebi := range m.EBIs {
+			ies = append(ies, ie.NewBearerContext(
+				ie.NewEPSBearerID(ebi.MustEPSBearerID()),
+				ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			))
+		}
+	}
+
+	return p.build(req, teid, cause, append(ies, b.IEs...))
+}
+
+// build creates the response message to req with the IEs given. The latter IEs
+// replace the former ones with the same type and instance.
+func (p *Peer) build(req message.Message, teid uint32, cause uint8, ies []*ie.IE) (message.Message, error) {
+	merged := []*ie.IE{ie.NewCause(cause, 0, 0, 0, nil)}
+	for _, i := range ies {
+		replaced := false
+		for n, m := range merged {
+			if m.Type == i.Type && m.Instance() == i.Instance() && i.Type != ie.BearerContext {
+				merged[n] = i
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, i)
+		}
+	}
+
+	switch req.MessageType() {
+	case message.MsgTypeCreateSessionRequest:
+		return message.NewCreateSessionResponse(teid, 0, merged...), nil
+	case message.MsgTypeModifyBearerRequest:
+		return message.NewModifyBearerResponse(teid, 0, merged...), nil
+	case message.MsgTypeDeleteSessionRequest:
+		return message.NewDeleteSessionResponse(teid, 0, merged...), nil
+	case message.MsgTypeReleaseAccessBearersRequest:
+		return message.NewReleaseAccessBearersResponse(teid, 0, merged...), nil
+	case message.MsgTypeCreateBearerRequest:
+		return message.NewCreateBearerResponse(teid, 0, merged...), nil
+	case message.MsgTypeUpdateBearerRequest:
+		return message.NewUpdateBearerResponse(teid, 0, merged...), nil
+	case message.MsgTypeDeleteBearerRequest:
+		return message.NewDeleteBearerResponse(teid, 0, merged...), nil
+	case message.MsgTypeDownlinkDataNotification:
+		return message.NewDownlinkDataNotificationAcknowledge(teid, 0, merged...), nil
+	default:
+		return nil, fmt.Errorf("no response defined for %s", req.MessageTypeName())
+	}
+}
+
+// cIFType returns the interface type of the C-Plane F-TEID of the Peer.
+func (p *Peer) cIFType() uint8 {
+	switch p.role {
+	case RoleMME:
+		return gtpv2.IFTypeS11MMEGTPC
+	case RoleSGW:
+		return gtpv2.IFTypeS11S4SGWGTPC
+	default:
+		return gtpv2.IFTypeS5S8PGWGTPC
+	}
+}
+
+// uFTEID returns a new U-Plane F-TEID of the Peer.
+func (p *Peer) uFTEID() *ie.IE {
+	switch p.role {
+	case RoleMME:
+		return ie.NewFullyQualifiedTEID(gtpv2.IFTypeS1UeNodeBGTPU, p.nextTEID(), p.ip, "")
+	case RoleSGW:
+		return ie.NewFullyQualifiedTEID(gtpv2.IFTypeS1USGWGTPU, p.nextTEID(), p.ip, "")
+	default:
+		return ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8PGWGTPU, p.nextTEID(), p.ip, "").WithInstance(2)
+	}
+}
+
+// allocatePAA allocates the UE's IP address. p.mu must be held.
+func (p *Peer) allocatePAA(pdnType *ie.IE) *ie.IE {
+	v4 := make(net.IP, 4)
+	binary.BigEndian.PutUint32(v4, p.ueIP)
+	v6 := net.ParseIP(fmt.Sprintf("2001:db8:%x:%x::", p.ueIP>>16, p.ueIP&0xffff))
+	p.ueIP++
+
+	typ := gtpv2.PDNTypeIPv4
+	if pdnType != nil {
+		if t, err := pdnType.PDNType(); err == nil {
+			typ = t
+		}
+	}
+
+	switch typ {
+	case gtpv2.PDNTypeIPv6:
+		return ie.NewPDNAddressAllocationNetIP(v6, 64)
+	case gtpv2.PDNTypeIPv4v6:
+		return ie.NewPDNAddressAllocationDualNetIP(v4, v6, 64)
+	default:
+		return ie.NewPDNAddressAllocationNetIP(v4, 0)
+	}
+}
+
+// nextTEID returns a new TEID. p.mu must be held.
+func (p *Peer) nextTEID() uint32 {
+	p.teid++
+	return p.teid
+}
+
+// nextChargingID returns a new Charging ID. p.mu must be held.
+func (p *Peer) nextChargingID() uint32 {
+	id := p.chargingID
+	p.chargingID++
+	return id
+}
+
+// nextEBI allocates an unused EBI in the session. p.mu must be held.
+func (p *Peer) nextEBI(sess *peerSession) uint8 {
+	for ebi := uint8(5); ebi <= 15; ebi++ {
+		used := false
+		for _, e := range sess.ebis {
+			if e == ebi {
+				used = true
+				break
+			}
+		}
+		if !used {
+			sess.ebis = append(sess.ebis, ebi)
+			return ebi
+		}
+	}
+	return 0
+}
+
+func ebiOf(bc *ie.IE) uint8 {
+	ebi, err := bc.FindByType(ie.EPSBearerID, 0)
+	if err != nil {
+		return 0
+	}
+	return ebi.MustEPSBearerID()
+}
+
+func accepted(cause uint8) bool {
+	return cause >= gtpv2.CauseRequestAccepted && cause < gtpv2.CauseContextNotFound
+}
@@ -0,0 +1,236 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package testutils_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/gtpv2/testutils"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+type client struct {
+	t     *testing.T
+	conn  *gtpv2.Conn
+	peer  net.Addr
+	rspCh chan message.Message
+}
+
+func setupPeer(ctx context.Context, t *testing.T) (*client, *testutils.Peer) {
+	t.Helper()
+
+	n := vnet.New(1)
+	mmeConn, err := n.NewConn("10.0.0.1:2123", gtpv2.IFTypeS11MMEGTPC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgwConn, err := n.NewConn("10.0.0.2:2123", gtpv2.IFTypeS11S4SGWGTPC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &client{t: t, conn: mmeConn, peer: sgwConn.LocalAddr(), rspCh: make(chan message.Message, 10)}
+	mmeConn.DisableValidation()
+	for _, typ := range []uint8{
+		message.MsgTypeCreateSessionResponse,
+		message.MsgTypeModifyBearerResponse,
+		message.MsgTypeDeleteSessionResponse,
+	} {
+		mmeConn.AddHandler(typ, func(_ *gtpv2.Conn, _ net.Addr, msg message.Message) error {
+			c.rspCh <- msg
+			return nil
+		})
+	}
+
+	sgw := testutils.NewPeer(sgwConn, testutils.RoleSGW)
+	for _, conn := range []*gtpv2.Conn{mmeConn, sgwConn} {
+		go func(conn *gtpv2.Conn) {
+			_ = conn.Serve(ctx)
+		}(conn)
+	}
+	return c, sgw
+}
+
+// send sends msg with the sequence number given, and returns the response if any.
+func (c *client) send(msg message.Message, seq uint32) message.Message {
+	c.t.Helper()
+
+	msg.SetSequenceNumber(seq)
+	b, err := message.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.conn.WriteTo(b, c.peer); err != nil {
+		c.t.Fatal(err)
+	}
+
+	select {
+	case rsp := <-c.rspCh:
+		return rsp
+	case <-time.After(200 * time.Millisecond):
+		return nil
+	}
+}
+
+func csr() *message.CreateSessionRequest {
+	return message.NewCreateSessionRequest(
+		0, 0,
+		ie.NewIMSI("001010123456789"),
+		ie.NewFullyQualifiedTEID(gtpv2.IFTypeS11MMEGTPC, 0x11111111, "10.0.0.1", ""),
+		ie.NewPDNType(gtpv2.PDNTypeIPv4),
+		ie.NewBearerContext(ie.NewEPSBearerID(5)),
+	)
+}
+
+func TestPeerDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, sgw := setupPeer(ctx, t)
+
+	rsp, ok := c.send(csr(), 1).(*message.CreateSessionResponse)
+	if !ok {
+		t.Fatal("no Create Session Response")
+	}
+	if got := rsp.TEID(); got != 0x11111111 {
+		t.Errorf("wrong TEID in header. got: %#x, want: %#x", got, 0x11111111)
+	}
+	if got := rsp.Cause.MustCause(); got != gtpv2.CauseRequestAccepted {
+		t.Errorf("wrong cause. got: %d", got)
+	}
+	if got := rsp.PAA.MustIPAddress(); got != "10.45.0.1" {
+		t.Errorf("wrong PAA. got: %s", got)
+	}
+	if rsp.SenderFTEIDC == nil || len(rsp.BearerContextsCreated) != 1 {
+		t.Fatalf("IEs missing: %v", rsp)
+	}
+	if _, err := rsp.BearerContextsCreated[0].FindByType(ie.ChargingID, 0); err != nil {
+		t.Errorf("no Charging ID: %v", err)
+	}
+	if got := sgw.Sessions(); got != 1 {
+		t.Errorf("wrong number of sessions. got: %d, want: 1", got)
+	}
+
+	sgwTEID := rsp.SenderFTEIDC.MustTEID()
+	req := sgw.Expect(t, message.MsgTypeCreateSessionRequest, time.Second).(*message.CreateSessionRequest)
+	if got := req.IMSI.MustIMSI(); got != "001010123456789" {
+		t.Errorf("wrong IMSI received. got: %s", got)
+	}
+
+	mbr := message.NewModifyBearerRequest(sgwTEID, 0, ie.NewBearerContext(ie.NewEPSBearerID(5)))
+	if rsp := c.send(mbr, 2); rsp == nil || rsp.(*message.ModifyBearerResponse).Cause.MustCause() != gtpv2.CauseRequestAccepted {
+		t.Errorf("unexpected Modify Bearer Response: %v", rsp)
+	}
+
+	if rsp := c.send(message.NewDeleteSessionRequest(sgwTEID, 0), 3); rsp == nil || rsp.TEID() != 0x11111111 {
+		t.Errorf("unexpected Delete Session Response: %v", rsp)
+	}
+	if got := sgw.Sessions(); got != 0 {
+		t.Errorf("session not deleted. got: %d", got)
+	}
+
+	// the session no longer exists.
+	rsp2, ok := c.send(message.NewDeleteSessionRequest(sgwTEID, 0), 4).(*message.DeleteSessionResponse)
+	if !ok || rsp2.Cause.MustCause() != gtpv2.CauseContextNotFound {
+		t.Errorf("unexpected Delete Session Response: %v", rsp2)
+	}
+	sgw.AssertReceived(t, message.MsgTypeDeleteSessionRequest, 2)
+}
+
+func TestPeerBehavior(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, sgw := setupPeer(ctx, t)
+
+	t.Run("reject", func(t *testing.T) {
+		sgw.On(message.MsgTypeCreateSessionRequest, &testutils.Behavior{Cause: gtpv2.CauseNoResourcesAvailable})
+		defer sgw.On(message.MsgTypeCreateSessionRequest, nil)
+
+		rsp, ok := c.send(csr(), 10).(*message.CreateSessionResponse)
+		if !ok {
+			t.Fatal("no Create Session Response")
+		}
+		if got := rsp.Cause.MustCause(); got != gtpv2.CauseNoResourcesAvailable {
+			t.Errorf("wrong cause. got: %d", got)
+		}
+		if rsp.PAA != nil || rsp.SenderFTEIDC != nil {
+			t.Errorf("unexpected IEs in rejection: %v", rsp)
+		}
+	})
+
+	t.Run("override IEs", func(t *testing.T) {
+		sgw.On(message.MsgTypeCreateSessionRequest, &testutils.Behavior{
+			IEs: []*ie.IE{ie.NewPDNAddressAllocation("192.0.2.1")},
+		})
+		defer sgw.On(message.MsgTypeCreateSessionRequest, nil)
+
+		rsp, ok := c.send(csr(), 11).(*message.CreateSessionResponse)
+		if !ok {
+			t.Fatal("no Create Session Response")
+		}
+		if got := rsp.PAA.MustIPAddress(); got != "192.0.2.1" {
+			t.Errorf("PAA not overridden. got: %s", got)
+		}
+	})
+
+	t.Run("drop and retransmit", func(t *testing.T) {
+		sgw.On(message.MsgTypeCreateSessionRequest, &testutils.Behavior{DropFirst: 1})
+		defer sgw.On(message.MsgTypeCreateSessionRequest, nil)
+
+		if rsp := c.send(csr(), 12); rsp != nil {
+			t.Fatalf("got response to dropped request: %v", rsp)
+		}
+		first := c.send(csr(), 12)
+		if first == nil {
+			t.Fatal("no response to retransmission")
+		}
+
+		// the same response is sent to the further retransmission.
+		second := c.send(csr(), 12)
+		if second == nil {
+			t.Fatal("no response to retransmission")
+		}
+		if first.(*message.CreateSessionResponse).SenderFTEIDC.MustTEID() != second.(*message.CreateSessionResponse).SenderFTEIDC.MustTEID() {
+			t.Error("different response to retransmission")
+		}
+	})
+
+	t.Run("PCO", func(t *testing.T) {
+		sgw.SetPCO(&ie.PCOResponseConfig{DNSServers: []net.IP{net.ParseIP("192.0.2.53")}})
+		defer sgw.SetPCO(nil)
+
+		req := csr()
+		req.PCO = ie.NewProtocolConfigurationOptions(0, ie.NewPCOContainer(ie.PCOContainerIdentifierDNSServerIPv4Address, nil))
+		rsp, ok := c.send(req, 14).(*message.CreateSessionResponse)
+		if !ok || rsp.PCO == nil {
+			t.Fatalf("no PCO in response: %v", rsp)
+		}
+		pco := rsp.PCO.MustProtocolConfigurationOptions()
+		if len(pco.ProtocolOrContainers) != 1 {
+			t.Fatalf("wrong containers: %v", pco.ProtocolOrContainers)
+		}
+		if got, err := pco.ProtocolOrContainers[0].IPAddress(); err != nil || got.String() != "192.0.2.53" {
+			t.Errorf("wrong DNS server: %v, %v", got, err)
+		}
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		sgw.On(message.MsgTypeCreateSessionRequest, &testutils.Behavior{Delay: 100 * time.Millisecond})
+		defer sgw.On(message.MsgTypeCreateSessionRequest, nil)
+
+		start := time.Now()
+		if rsp := c.send(csr(), 13); rsp == nil {
+			t.Fatal("no response")
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("responded too early: %s", elapsed)
+		}
+	})
+}
@@ -2,13 +2,17 @@
 // Use of this source code is governed by a MIT-style license that can be
 // found in the LICENSE file.
 
-// Package testutils is an internal package to be used for unit tests. Don't use this.
+// Package testutils is an internal package to be used for unit tests. Don't use this,
+// except for Peer, which is a mock MME/S-GW/P-GW for testing the nodes built on gtpv2.Conn.
 package testutils
 
 import (
+	"context"
+	"net"
 	"testing"
 
 	"github.com/pascaldekloe/goe/verify"
+	"github.com/wmnsk/go-gtp/gtpv2"
 	"github.com/wmnsk/go-gtp/gtpv2/message"
 )
 
@@ -31,6 +35,30 @@ type ParseFunc func([]byte) (Serializable, error)
 // TestBearerInfo is just for testing gtpv2.Messages. Don't use this.
 var TestBearerInfo = struct{ TEID, Seq uint32 }{0x11223344, 0x00000001}
 
+// ListenConn is just for testing the packages built on gtpv2.Conn. Don't use this.
+//
+// It returns the Conn listening on GTPCPort of the IP given and serving until ctx
+// is done, with the validation disabled as the tests do not register Sessions.
+func ListenConn(ctx context.Context, t testing.TB, ip string, ifType uint8) *gtpv2.Conn {
+	t.Helper()
+
+	laddr, err := net.ResolveUDPAddr("udp", ip+gtpv2.GTPCPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := gtpv2.NewConn(laddr, ifType, 0)
+	conn.DisableValidation()
+	if err := conn.Listen(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		_ = conn.Serve(ctx)
+	}()
+	return conn
+}
+
 // Run is just for testing gtpv2.Messages. Don't use this.
 func Run(t *testing.T, cases []TestCase, decode ParseFunc) {
 	t.Helper()
@@ -0,0 +1,311 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package tft
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// Direction is the direction of the traffic to be classified.
+type Direction uint8
+
+// Direction definitions.
+const (
+	Downlink Direction = iota + 1
+	Uplink
+)
+
+// matcher reports whether a packet matches a component of packet filter.
+type matcher func(p *packet, dir Direction) bool
+
+// filter is a packet filter of a bearer compiled to be evaluated.
+type filter struct {
+	ebi        uint8
+	id         uint8
+	precedence uint8
+	direction  uint8
+	matchers   []matcher
+}
+
+// appliesTo reports whether the filter is applied to the traffic in dir.
+// The pre-Rel-7 filters are applied to the downlink only.
+func (f *filter) appliesTo(dir Direction) bool {
+	switch f.direction {
+	case ie.TFTPFPreRel7TFTFilter, ie.TFTPFDownlinkOnly:
+		return dir == Downlink
+	case ie.TFTPFUplinkOnly:
+		return dir == Uplink
+	default:
+		return true
+	}
+}
+
+// match reports whether the packet matches all the components of the filter.
+func (f *filter) match(p *packet, dir Direction) bool {
+	if !f.appliesTo(dir) {
+		return false
+	}
+	for _, m := range f.matchers {
+		if !m(p, dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// remote and local return the address of the remote side and the UE side.
+func (p *packet) remote(dir Direction) net.IP {
+	if dir == Downlink {
+		return p.src
+	}
+	return p.dst
+}
+
+func (p *packet) local(dir Direction) net.IP {
+	if dir == Downlink {
+		return p.dst
+	}
+	return p.src
+}
+
+// remotePort and localPort return the port of the remote side and the UE side.
+func (p *packet) remotePort(dir Direction) uint16 {
+	if dir == Downlink {
+		return p.srcPort
+	}
+	return p.dstPort
+}
+
+func (p *packet) localPort(dir Direction) uint16 {
+	if dir == Downlink {
+		return p.dstPort
+	}
+	return p.srcPort
+}
+
+// Filter is a packet filter evaluated on its own, without the Classifier.
+// This is useful to detect the packets with TFT-like filters other than the
+// bearer selection, e.g., the SDF filters of the forwarding rules.
+type Filter struct {
+	f *filter
+}
+
+// NewFilter creates a new Filter from the packet filter.
+func NewFilter(pf *ie.TFTPacketFilter) (*Filter, error) {
+	f, err := compile(0, pf)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{f: f}, nil
+}
+
+// Match reports whether the IPv4 or IPv6 packet in pkt in the direction given
+// matches the Filter. The malformed packets match no Filter.
+func (f *Filter) Match(pkt []byte, dir Direction) bool {
+	p, err := parseIP(pkt)
+	if err != nil {
+		return false
+	}
+	return f.f.match(p, dir)
+}
+
+// compile compiles the packet filter of the bearer with ebi.
+func compile(ebi uint8, pf *ie.TFTPacketFilter) (*filter, error) {
+	f := &filter{
+		ebi:        ebi,
+		id:         pf.Identifier,
+		precedence: pf.EvaluationPrecedence,
+		direction:  pf.Direction,
+	}
+
+	for _, c := range pf.Components {
+		m, err := compileComponent(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile packet filter %d: %w", pf.Identifier, err)
+		}
+		f.matchers = append(f.matchers, m)
+	}
+	return f, nil
+}
+
+func compileComponent(c *ie.TFTPFComponent) (matcher, error) {
+	switch c.Type {
+	case ie.PFCompIPv4RemoteAddress, ie.PFCompIPv4LocalAddress:
+		var n *net.IPNet
+		var err error
+		if c.Type == ie.PFCompIPv4RemoteAddress {
+			n, err = c.IPv4RemoteAddress()
+		} else {
+			n, err = c.IPv4LocalAddress()
+		}
+		if err != nil {
+			return nil, err
+		}
+		remote := c.Type == ie.PFCompIPv4RemoteAddress
+		return func(p *packet, dir Direction) bool {
+			return p.ipVersion == 4 && n.Contains(p.addr(dir, remote))
+		}, nil
+	case ie.PFCompIPv6RemoteAddress, ie.PFCompIPv6RemoteAddressPrefixLength, ie.PFCompIPv6LocalAddressPrefixLength:
+		var n *net.IPNet
+		var err error
+		switch c.Type {
+		case ie.PFCompIPv6RemoteAddress:
+			n, err = c.IPv6RemoteAddress()
+		case ie.PFCompIPv6RemoteAddressPrefixLength:
+			n, err = c.IPv6RemoteAddressPrefixLength()
+		default:
+			n, err = c.IPv6LocalAddressPrefixLength()
+		}
+		if err != nil {
+			return nil, err
+		}
+		remote := c.Type != ie.PFCompIPv6LocalAddressPrefixLength
+		return func(p *packet, dir Direction) bool {
+			return p.ipVersion == 6 && n.Contains(p.addr(dir, remote))
+		}, nil
+	case ie.PFCompProtocolIdentifierNextHeader:
+		proto, err := c.ProtocolIdentifierNextHeader()
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet, _ Direction) bool {
+			return p.ipVersion != 0 && p.proto == proto
+		}, nil
+	case ie.PFCompSingleLocalPort, ie.PFCompLocalPortRange:
+		low, high, err := portRange(c)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet, dir Direction) bool {
+			port := p.localPort(dir)
+			return p.hasPorts && low <= port && port <= high
+		}, nil
+	case ie.PFCompSingleRemotePort, ie.PFCompRemotePortRange:
+		low, high, err := portRange(c)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet, dir Direction) bool {
+			port := p.remotePort(dir)
+			return p.hasPorts && low <= port && port <= high
+		}, nil
+	case ie.PFCompSecurityParameterIndex:
+		spi, err := c.SecurityParameterIndex()
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet, _ Direction) bool {
+			return p.hasSPI && p.spi == spi
+		}, nil
+	case ie.PFCompTypeOfServiceTrafficClass:
+		class, mask, err := c.TypeOfServiceTrafficClass()
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet, _ Direction) bool {
+			return p.ipVersion != 0 && p.tos&mask == class&mask
+		}, nil
+	case ie.PFCompFlowLabel:
+		label, err := c.FlowLabel()
+		if err != nil {
+			return nil, err
+		}
+		label &= 0x000fffff
+		return func(p *packet, _ Direction) bool {
+			return p.ipVersion == 6 && p.flowLabel == label
+		}, nil
+	case ie.PFCompDestinationMACAddress:
+		mac, err := c.DestinationMACAddress()
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet, _ Direction) bool {
+			return p.isEthernet && bytes.Equal(p.dstMAC, mac)
+		}, nil
+	case ie.PFCompSourceMACAddress:
+		mac, err := c.SourceMACAddress()
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet, _ Direction) bool {
+			return p.isEthernet && bytes.Equal(p.srcMAC, mac)
+		}, nil
+	case ie.PFCompDot1QCTAGVID:
+		vid, err := c.Dot1QCTAGVID()
+		if err != nil {
+			return nil, err
+		}
+		vid &= 0x0fff
+		return func(p *packet, _ Direction) bool {
+			return p.hasCTAG && p.ctagVID == vid
+		}, nil
+	case ie.PFCompDot1QSTAGVID:
+		vid, err := c.Dot1QSTAGVID()
+		if err != nil {
+			return nil, err
+		}
+		vid &= 0x0fff
+		return func(p *packet, _ Direction) bool {
+			return p.hasSTAG && p.stagVID == vid
+		}, nil
+	case ie.PFCompDot1QCTAGPCPDEI:
+		pcpdei, err := c.Dot1QCTAGPCPDEI()
+		if err != nil {
+			return nil, err
+		}
+		pcpdei &= 0x0f
+		return func(p *packet, _ Direction) bool {
+			return p.hasCTAG && p.ctagPCPDEI == pcpdei
+		}, nil
+	case ie.PFCompDot1QSTAGPCPDEI:
+		pcpdei, err := c.Dot1QSTAGPCPDEI()
+		if err != nil {
+			return nil, err
+		}
+		pcpdei &= 0x0f
+		return func(p *packet, _ Direction) bool {
+			return p.hasSTAG && p.stagPCPDEI == pcpdei
+		}, nil
+	case ie.PFCompEthertype:
+		etype, err := c.Ethertype()
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet, _ Direction) bool {
+			return p.isEthernet && p.etherType == etype
+		}, nil
+	default:
+		return nil, &UnsupportedComponentError{Type: c.Type}
+	}
+}
+
+// addr returns the remote address if remote is true, or the local one.
+func (p *packet) addr(dir Direction, remote bool) net.IP {
+	if remote {
+		return p.remote(dir)
+	}
+	return p.local(dir)
+}
+
+// portRange returns the range of ports in the single port or port range
+// component.
+func portRange(c *ie.TFTPFComponent) (uint16, uint16, error) {
+	switch c.Type {
+	case ie.PFCompSingleLocalPort:
+		port, err := c.SingleLocalPort()
+		return port, port, err
+	case ie.PFCompSingleRemotePort:
+		port, err := c.SingleRemotePort()
+		return port, port, err
+	case ie.PFCompLocalPortRange:
+		return c.LocalPortRange()
+	default:
+		return c.RemotePortRange()
+	}
+}
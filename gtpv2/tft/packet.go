@@ -0,0 +1,222 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package tft
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// IP protocol numbers used to find the ports and SPI.
+const (
+	protoHopByHop = 0
+	protoTCP      = 6
+	protoUDP      = 17
+	protoRouting  = 43
+	protoFragment = 44
+	protoESP      = 50
+	protoAH       = 51
+	protoDstOpts  = 60
+	protoSCTP     = 132
+	protoUDPLite  = 136
+)
+
+// EtherTypes used to parse the Ethernet frame.
+const (
+	etherTypeIPv4  = 0x0800
+	etherTypeIPv6  = 0x86dd
+	etherTypeCTAG  = 0x8100
+	etherTypeSTAG  = 0x88a8
+	etherTypeQinQ  = 0x9100
+	etherHeaderLen = 14
+)
+
+// packet is the fields of a packet evaluated by the packet filters.
+type packet struct {
+	// IP header, valid if ipVersion is 4 or 6.
+	ipVersion int
+	src, dst  net.IP
+	proto     uint8
+	tos       uint8
+	flowLabel uint32
+	hasPorts  bool
+	srcPort   uint16
+	dstPort   uint16
+	hasSPI    bool
+	spi       uint32
+
+	// Ethernet header, valid if isEthernet is true.
+	isEthernet     bool
+	srcMAC, dstMAC net.HardwareAddr
+	etherType      uint16
+	hasCTAG        bool
+	ctagVID        uint16
+	ctagPCPDEI     uint8
+	hasSTAG        bool
+	stagVID        uint16
+	stagPCPDEI     uint8
+}
+
+// parseIP parses the IPv4 or IPv6 packet in b.
+func parseIP(b []byte) (*packet, error) {
+	p := &packet{}
+	if err := p.parseIP(b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseEthernet parses the Ethernet frame in b, and the IP packet in it if any.
+func parseEthernet(b []byte) (*packet, error) {
+	if len(b) < etherHeaderLen {
+		return nil, ErrMalformedPacket
+	}
+
+	p := &packet{
+		isEthernet: true,
+		dstMAC:     net.HardwareAddr(b[0:6]),
+		srcMAC:     net.HardwareAddr(b[6:12]),
+	}
+
+	offset := 12
+	for {
+		if len(b) < offset+2 {
+			return nil, ErrMalformedPacket
+		}
+		p.etherType = binary.BigEndian.Uint16(b[offset : offset+2])
+		offset += 2
+
+		switch p.etherType {
+		case etherTypeSTAG, etherTypeQinQ:
+			// S-TAG comes outside of C-TAG.
+			if p.hasSTAG || p.hasCTAG || len(b) < offset+2 {
+				return nil, ErrMalformedPacket
+			}
+			tci := binary.BigEndian.Uint16(b[offset : offset+2])
+			p.hasSTAG, p.stagVID, p.stagPCPDEI = true, tci&0x0fff, uint8(tci>>12)
+			offset += 2
+		case etherTypeCTAG:
+			if p.hasCTAG || len(b) < offset+2 {
+				return nil, ErrMalformedPacket
+			}
+			tci := binary.BigEndian.Uint16(b[offset : offset+2])
+			p.hasCTAG, p.ctagVID, p.ctagPCPDEI = true, tci&0x0fff, uint8(tci>>12)
+			offset += 2
+		case etherTypeIPv4, etherTypeIPv6:
+			if err := p.parseIP(b[offset:]); err != nil {
+				return nil, err
+			}
+			return p, nil
+		default:
+			return p, nil
+		}
+	}
+}
+
+func (p *packet) parseIP(b []byte) error {
+	if len(b) < 1 {
+		return ErrMalformedPacket
+	}
+
+	switch b[0] >> 4 {
+	case 4:
+		return p.parseIPv4(b)
+	case 6:
+		return p.parseIPv6(b)
+	default:
+		return ErrMalformedPacket
+	}
+}
+
+func (p *packet) parseIPv4(b []byte) error {
+	if len(b) < 20 {
+		return ErrMalformedPacket
+	}
+	hlen := int(b[0]&0x0f) * 4
+	if hlen < 20 || len(b) < hlen {
+		return ErrMalformedPacket
+	}
+
+	p.ipVersion = 4
+	p.tos = b[1]
+	p.proto = b[9]
+	p.src = net.IP(b[12:16])
+	p.dst = net.IP(b[16:20])
+
+	// only the first fragment has the upper layer header.
+	if binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 {
+		return nil
+	}
+	p.parseUpperLayer(b[hlen:])
+	return nil
+}
+
+func (p *packet) parseIPv6(b []byte) error {
+	if len(b) < 40 {
+		return ErrMalformedPacket
+	}
+
+	p.ipVersion = 6
+	v := binary.BigEndian.Uint32(b[0:4])
+	p.tos = uint8(v >> 20)
+	p.flowLabel = v & 0x000fffff
+	p.src = net.IP(b[8:24])
+	p.dst = net.IP(b[24:40])
+
+	// skip the extension headers to find the upper layer protocol.
+	next, offset := b[6], 40
+	for {
+		switch next {
+		case protoHopByHop, protoRouting, protoDstOpts:
+			if len(b) < offset+2 {
+				return ErrMalformedPacket
+			}
+			next, offset = b[offset], offset+(int(b[offset+1])+1)*8
+		case protoFragment:
+			if len(b) < offset+8 {
+				return ErrMalformedPacket
+			}
+			first := binary.BigEndian.Uint16(b[offset+2:offset+4])&0xfff8 == 0
+			next, offset = b[offset], offset+8
+			if !first {
+				p.proto = next
+				return nil
+			}
+		default:
+			p.proto = next
+			if len(b) < offset {
+				return ErrMalformedPacket
+			}
+			p.parseUpperLayer(b[offset:])
+			return nil
+		}
+	}
+}
+
+// parseUpperLayer gets the ports or SPI from the upper layer header in b, if
+// available.
+func (p *packet) parseUpperLayer(b []byte) {
+	switch p.proto {
+	case protoTCP, protoUDP, protoSCTP, protoUDPLite:
+		if len(b) < 4 {
+			return
+		}
+		p.hasPorts = true
+		p.srcPort = binary.BigEndian.Uint16(b[0:2])
+		p.dstPort = binary.BigEndian.Uint16(b[2:4])
+	case protoESP:
+		if len(b) < 4 {
+			return
+		}
+		p.hasSPI = true
+		p.spi = binary.BigEndian.Uint32(b[0:4])
+	case protoAH:
+		if len(b) < 8 {
+			return
+		}
+		p.hasSPI = true
+		p.spi = binary.BigEndian.Uint32(b[4:8])
+	}
+}
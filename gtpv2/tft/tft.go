@@ -0,0 +1,202 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package tft provides the packet classifier with Traffic Flow Template (TFT),
+// which selects the bearer of a session to carry a packet.
+//
+// The packet filters in the TFTs of the bearers are evaluated in the order of
+// the evaluation precedence, and the packets that match none of them are
+// carried on the default bearer, as described in §15.3, TS 23.060. This is
+// typically used on P-GW to steer the downlink traffic onto the dedicated
+// bearers, with the EBI and outgoing TEID of the bearer selected.
+package tft
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// Error definitions.
+var (
+	ErrMalformedPacket  = errors.New("malformed packet")
+	ErrNoMatchingBearer = errors.New("no bearer matches the packet")
+	ErrInvalidOperation = errors.New("invalid TFT operation")
+)
+
+// UnsupportedComponentError indicates that the type of packet filter component
+// is not supported.
+type UnsupportedComponentError struct {
+	Type uint8
+}
+
+// Error returns the type of component.
+func (e *UnsupportedComponentError) Error() string {
+	return fmt.Sprintf("unsupported packet filter component type: %#x", e.Type)
+}
+
+// Classifier classifies the packets of a session into the bearers with TFT.
+type Classifier struct {
+	mu       sync.RWMutex
+	bearers  map[uint8]*gtpv2.Bearer
+	filters  []*filter // sorted by the evaluation precedence
+	fallback *gtpv2.Bearer
+}
+
+// NewClassifier creates a new Classifier with no bearers.
+func NewClassifier() *Classifier {
+	return &Classifier{bearers: map[uint8]*gtpv2.Bearer{}}
+}
+
+// NewClassifierFromSession creates a new Classifier with the bearers in the
+// Session and their TFTs given by EBI. The default bearer of the Session is
+// used for the packets that match none of the packet filters.
+func NewClassifierFromSession(sess *gtpv2.Session, tfts map[uint8]*ie.TrafficFlowTemplate) (*Classifier, error) {
+	c := NewClassifier()
+	c.SetDefaultBearer(sess.GetDefaultBearer())
+
+	for _, b := range sess.Bearers() {
+		tft, ok := tfts[b.EBI]
+		if !ok {
+			continue
+		}
+		if err := c.Apply(b, tft); err != nil {
+			return nil, fmt.Errorf("failed to apply TFT of bearer %d: %w", b.EBI, err)
+		}
+	}
+	return c, nil
+}
+
+// SetDefaultBearer sets the bearer used for the packets that match none of the
+// packet filters. If nil, such packets are not classified.
+func (c *Classifier) SetDefaultBearer(b *gtpv2.Bearer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallback = b
+}
+
+// Apply applies the TFT operation to the packet filters of the bearer, e.g.,
+// given in Bearer TFT IE of Create Bearer Request or Update Bearer Request.
+//
+// With TFTOpCreateNewTFT the packet filters of the bearer are replaced with the
+// ones in tft, and with TFTOpAddPacketFiltersToExistingTFT or
+// TFTOpReplacePacketFiltersInExistingTFT the ones with the same identifier are
+// replaced or added. TFTOpDeleteExistingTFT and
+// TFTOpDeletePacketFiltersFromExistingTFT delete all or the ones specified.
+func (c *Classifier) Apply(b *gtpv2.Bearer, tft *ie.TrafficFlowTemplate) error {
+	var filters []*filter
+	for _, pf := range tft.PacketFilters {
+		f, err := compile(b.EBI, pf)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, f)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch tft.OperationCode {
+	case ie.TFTOpIgnoreThisIE, ie.TFTOpNoTFTOperation:
+		return nil
+	case ie.TFTOpCreateNewTFT:
+		c.deleteFilters(b.EBI, func(*filter) bool { return true })
+	case ie.TFTOpDeleteExistingTFT:
+		c.deleteFilters(b.EBI, func(*filter) bool { return true })
+		delete(c.bearers, b.EBI)
+		return nil
+	case ie.TFTOpAddPacketFiltersToExistingTFT, ie.TFTOpReplacePacketFiltersInExistingTFT:
+		ids := map[uint8]bool{}
+		for _, f := range filters {
+			ids[f.id] = true
+		}
+		c.deleteFilters(b.EBI, func(f *filter) bool { return ids[f.id] })
+	case ie.TFTOpDeletePacketFiltersFromExistingTFT:
+		ids := map[uint8]bool{}
+		for _, id := range tft.PacketFilterIdentifiers {
+			ids[id] = true
+		}
+		c.deleteFilters(b.EBI, func(f *filter) bool { return ids[f.id] })
+		return nil
+	default:
+		return fmt.Errorf("%w: %d", ErrInvalidOperation, tft.OperationCode)
+	}
+
+	c.bearers[b.EBI] = b
+	c.filters = append(c.filters, filters...)
+	sort.SliceStable(c.filters, func(i, j int) bool {
+		return c.filters[i].precedence < c.filters[j].precedence
+	})
+	return nil
+}
+
+// RemoveBearer removes the bearer and its packet filters.
+func (c *Classifier) RemoveBearer(ebi uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deleteFilters(ebi, func(*filter) bool { return true })
+	delete(c.bearers, ebi)
+	if c.fallback != nil && c.fallback.EBI == ebi {
+		c.fallback = nil
+	}
+}
+
+// deleteFilters deletes the packet filters of the bearer that fn returns true.
+func (c *Classifier) deleteFilters(ebi uint8, fn func(*filter) bool) {
+	filters := c.filters[:0]
+	for _, f := range c.filters {
+		if f.ebi == ebi && fn(f) {
+			continue
+		}
+		filters = append(filters, f)
+	}
+	for i := len(filters); i < len(c.filters); i++ {
+		c.filters[i] = nil
+	}
+	c.filters = filters
+}
+
+// Classify returns the bearer to carry the IPv4 or IPv6 packet in pkt in the
+// direction given. ErrNoMatchingBearer is returned if the packet matches none
+// of the packet filters and no default bearer is set.
+func (c *Classifier) Classify(pkt []byte, dir Direction) (*gtpv2.Bearer, error) {
+	p, err := parseIP(pkt)
+	if err != nil {
+		return nil, err
+	}
+	return c.classify(p, dir)
+}
+
+// ClassifyEthernet returns the bearer to carry the Ethernet frame in frame in
+// the direction given, which is for the PDN connection of Ethernet PDN type.
+// The IP components of the packet filters are evaluated against the IPv4 or
+// IPv6 packet in the frame, if any.
+func (c *Classifier) ClassifyEthernet(frame []byte, dir Direction) (*gtpv2.Bearer, error) {
+	p, err := parseEthernet(frame)
+	if err != nil {
+		return nil, err
+	}
+	return c.classify(p, dir)
+}
+
+func (c *Classifier) classify(p *packet, dir Direction) (*gtpv2.Bearer, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, f := range c.filters {
+		if f.match(p, dir) {
+			return c.bearers[f.ebi], nil
+		}
+	}
+
+	if c.fallback == nil {
+		return nil, ErrNoMatchingBearer
+	}
+	return c.fallback, nil
+}
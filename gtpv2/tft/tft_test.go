@@ -0,0 +1,342 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package tft_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/tft"
+)
+
+const (
+	protoTCP = 6
+	protoUDP = 17
+	protoESP = 50
+)
+
+var (
+	ueV4  = net.ParseIP("192.168.0.1").To4()
+	ueV6  = net.ParseIP("2001:db8:1::1")
+	ueMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+)
+
+// ipv4Packet returns an IPv4 packet from src to dst, with the header of
+// upper layer given.
+func ipv4Packet(src, dst net.IP, tos, proto uint8, l4 []byte) []byte {
+	b := make([]byte, 20+len(l4))
+	b[0] = 0x45
+	b[1] = tos
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64
+	b[9] = proto
+	copy(b[12:16], src.To4())
+	copy(b[16:20], dst.To4())
+	copy(b[20:], l4)
+	return b
+}
+
+// ipv6Packet returns an IPv6 packet from src to dst, with the header of
+// upper layer given.
+func ipv6Packet(src, dst net.IP, tc uint8, label uint32, proto uint8, l4 []byte) []byte {
+	b := make([]byte, 40+len(l4))
+	binary.BigEndian.PutUint32(b[0:4], 6<<28|uint32(tc)<<20|label&0xfffff)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(l4)))
+	b[6] = proto
+	b[7] = 64
+	copy(b[8:24], src.To16())
+	copy(b[24:40], dst.To16())
+	copy(b[40:], l4)
+	return b
+}
+
+func ports(src, dst uint16) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b[0:2], src)
+	binary.BigEndian.PutUint16(b[2:4], dst)
+	return b
+}
+
+// ethernetFrame returns an Ethernet frame from src to dst, with the tags given
+// as pairs of TPID and TCI.
+func ethernetFrame(dst, src net.HardwareAddr, etype uint16, payload []byte, tags ...uint16) []byte {
+	b := append(append([]byte{}, dst...), src...)
+	for _, tag := range tags {
+		b = binary.BigEndian.AppendUint16(b, tag)
+	}
+	b = binary.BigEndian.AppendUint16(b, etype)
+	return append(b, payload...)
+}
+
+func newBearer(ebi uint8, teid uint32) *gtpv2.Bearer {
+	b := gtpv2.NewBearer(ebi, "some.apn.example", &gtpv2.QoSProfile{})
+	b.SetOutgoingTEID(teid)
+	return b
+}
+
+func TestClassifier(t *testing.T) {
+	sess := gtpv2.NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2123}, &gtpv2.Subscriber{})
+	sess.GetDefaultBearer().EBI = 5
+	sess.GetDefaultBearer().SetOutgoingTEID(0x55555555)
+
+	voice, video, ipsec, eth := newBearer(6, 0x66666666), newBearer(7, 0x77777777), newBearer(8, 0x88888888), newBearer(9, 0x99999999)
+	for _, b := range []*gtpv2.Bearer{voice, video, ipsec, eth} {
+		sess.AddBearer(b.APN+string(rune(b.EBI)), b)
+	}
+
+	tfts := map[uint8]*ie.TrafficFlowTemplate{
+		6: ie.NewTrafficFlowTemplate(ie.TFTOpCreateNewTFT, []*ie.TFTPacketFilter{
+			ie.NewTFTPacketFilter(
+				ie.TFTPFBidirectional, 1, 10,
+				ie.NewTFTPFComponentIPv4RemoteAddress(net.ParseIP("10.0.0.0"), net.IPv4Mask(255, 255, 255, 0)),
+				ie.NewTFTPFComponentProtocolIdentifierNextHeader(protoUDP),
+				ie.NewTFTPFComponentRemotePortRange(5060, 5070),
+			),
+		}, nil, nil),
+		7: ie.NewTrafficFlowTemplate(ie.TFTOpCreateNewTFT, []*ie.TFTPacketFilter{
+			ie.NewTFTPacketFilter(
+				ie.TFTPFDownlinkOnly, 1, 20,
+				ie.NewTFTPFComponentIPv6RemoteAddressPrefixLength(net.ParseIP("2001:db8:ffff::"), 48),
+				ie.NewTFTPFComponentIPv6LocalAddressPrefixLength(ueV6, 64),
+				ie.NewTFTPFComponentSingleLocalPort(8080),
+			),
+			ie.NewTFTPacketFilter(
+				ie.TFTPFBidirectional, 2, 5,
+				ie.NewTFTPFComponentFlowLabel(0x12345),
+			),
+		}, nil, nil),
+		8: ie.NewTrafficFlowTemplate(ie.TFTOpCreateNewTFT, []*ie.TFTPacketFilter{
+			ie.NewTFTPacketFilter(
+				ie.TFTPFUplinkOnly, 1, 30,
+				ie.NewTFTPFComponentSecurityParameterIndex(0xdeadbeef),
+			),
+			ie.NewTFTPacketFilter(
+				ie.TFTPFBidirectional, 2, 40,
+				ie.NewTFTPFComponentTypeOfServiceTrafficClass(0xb8, 0xfc),
+				ie.NewTFTPFComponentSingleRemotePort(443),
+			),
+		}, nil, nil),
+		9: ie.NewTrafficFlowTemplate(ie.TFTOpCreateNewTFT, []*ie.TFTPacketFilter{
+			ie.NewTFTPacketFilter(
+				ie.TFTPFBidirectional, 1, 50,
+				ie.NewTFTPFComponentDestinationMACAddress(ueMAC),
+				ie.NewTFTPFComponentDot1QSTAGVID(200),
+				ie.NewTFTPFComponentDot1QCTAGVID(100),
+				ie.NewTFTPFComponentDot1QCTAGPCPDEI(0x0a),
+				ie.NewTFTPFComponentEthertype(0x0800),
+			),
+			ie.NewTFTPacketFilter(
+				ie.TFTPFUplinkOnly, 2, 60,
+				ie.NewTFTPFComponentSourceMACAddress(ueMAC),
+				ie.NewTFTPFComponentDot1QSTAGPCPDEI(0x03),
+			),
+		}, nil, nil),
+	}
+
+	classifier, err := tft.NewClassifierFromSession(sess, tfts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	cases := []struct {
+		description string
+		ethernet    bool
+		pkt         []byte
+		dir         tft.Direction
+		ebi         uint8
+	}{
+		{
+			"IPv4/remote-port-range/downlink",
+			false, ipv4Packet(net.ParseIP("10.0.0.10"), ueV4, 0, protoUDP, ports(5062, 40000)),
+			tft.Downlink, 6,
+		}, {
+			"IPv4/remote-port-range/uplink",
+			false, ipv4Packet(ueV4, net.ParseIP("10.0.0.10"), 0, protoUDP, ports(40000, 5070)),
+			tft.Uplink, 6,
+		}, {
+			"IPv4/remote-port-range/out-of-range",
+			false, ipv4Packet(net.ParseIP("10.0.0.10"), ueV4, 0, protoUDP, ports(5071, 40000)),
+			tft.Downlink, 5,
+		}, {
+			"IPv4/remote-address/not-matched",
+			false, ipv4Packet(net.ParseIP("10.0.1.10"), ueV4, 0, protoUDP, ports(5062, 40000)),
+			tft.Downlink, 5,
+		}, {
+			"IPv6/local-port/downlink",
+			false, ipv6Packet(net.ParseIP("2001:db8:ffff::1"), ueV6, 0, 0, protoTCP, ports(50000, 8080)),
+			tft.Downlink, 7,
+		}, {
+			"IPv6/local-port/uplink-not-applied",
+			false, ipv6Packet(ueV6, net.ParseIP("2001:db8:ffff::1"), 0, 0, protoTCP, ports(8080, 50000)),
+			tft.Uplink, 5,
+		}, {
+			"IPv6/flow-label/precedence",
+			false, ipv6Packet(net.ParseIP("2001:db8:ffff::1"), ueV6, 0, 0x12345, protoTCP, ports(50000, 8080)),
+			tft.Downlink, 7,
+		}, {
+			"IPv4/SPI/uplink",
+			false, ipv4Packet(ueV4, net.ParseIP("10.0.2.1"), 0, protoESP, []byte{0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 1}),
+			tft.Uplink, 8,
+		}, {
+			"IPv4/SPI/downlink-not-applied",
+			false, ipv4Packet(net.ParseIP("10.0.2.1"), ueV4, 0, protoESP, []byte{0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 1}),
+			tft.Downlink, 5,
+		}, {
+			"IPv4/TOS",
+			false, ipv4Packet(net.ParseIP("10.0.2.1"), ueV4, 0xb9, protoTCP, ports(443, 50000)),
+			tft.Downlink, 8,
+		}, {
+			"IPv6/traffic-class",
+			false, ipv6Packet(ueV6, net.ParseIP("2001:db8:2::1"), 0xba, 1, protoTCP, ports(50000, 443)),
+			tft.Uplink, 8,
+		}, {
+			"Ethernet/double-tagged",
+			true, ethernetFrame(
+				ueMAC, server, 0x0800, ipv4Packet(net.ParseIP("10.0.3.1"), ueV4, 0, protoUDP, ports(1, 2)),
+				0x88a8, 0x30c8, 0x8100, 0xa064,
+			),
+			tft.Downlink, 9,
+		}, {
+			"Ethernet/IP-components",
+			true, ethernetFrame(
+				ueMAC, server, 0x0800, ipv4Packet(net.ParseIP("10.0.0.1"), ueV4, 0, protoUDP, ports(5060, 2)),
+				0x88a8, 0x30c8, 0x8100, 0xa064,
+			),
+			tft.Downlink, 6,
+		}, {
+			"Ethernet/source-MAC/uplink",
+			true, ethernetFrame(server, ueMAC, 0x88cc, nil, 0x88a8, 0x30c8),
+			tft.Uplink, 9,
+		}, {
+			"Ethernet/wrong-VID",
+			true, ethernetFrame(
+				ueMAC, server, 0x0800, ipv4Packet(net.ParseIP("10.0.3.1"), ueV4, 0, protoUDP, ports(1, 2)),
+				0x88a8, 0x30c9, 0x8100, 0xa064,
+			),
+			tft.Downlink, 5,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			classify := classifier.Classify
+			if c.ethernet {
+				classify = classifier.ClassifyEthernet
+			}
+
+			b, err := classify(c.pkt, c.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.EBI != c.ebi {
+				t.Errorf("wrong EBI: got %d, want %d", b.EBI, c.ebi)
+			}
+		})
+	}
+
+	t.Run("operations", func(t *testing.T) {
+		downlink := ipv4Packet(net.ParseIP("10.0.0.10"), ueV4, 0, protoUDP, ports(5062, 40000))
+
+		// the filter with the same identifier is replaced.
+		if err := classifier.Apply(voice, ie.NewTrafficFlowTemplate(ie.TFTOpReplacePacketFiltersInExistingTFT, []*ie.TFTPacketFilter{
+			ie.NewTFTPacketFilter(
+				ie.TFTPFBidirectional, 1, 10,
+				ie.NewTFTPFComponentIPv4LocalAddress(ueV4, net.IPv4Mask(255, 255, 255, 255)),
+				ie.NewTFTPFComponentSingleLocalPort(40001),
+			),
+		}, nil, nil)); err != nil {
+			t.Fatal(err)
+		}
+		b, err := classifier.Classify(downlink, tft.Downlink)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.EBI != 5 {
+			t.Errorf("wrong EBI: got %d, want %d", b.EBI, 5)
+		}
+
+		if err := classifier.Apply(voice, ie.NewTrafficFlowTemplate(ie.TFTOpAddPacketFiltersToExistingTFT, []*ie.TFTPacketFilter{
+			ie.NewTFTPacketFilter(
+				ie.TFTPFDownlinkOnly, 2, 11,
+				ie.NewTFTPFComponentSingleLocalPort(40000),
+			),
+		}, nil, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if b, err = classifier.Classify(downlink, tft.Downlink); err != nil {
+			t.Fatal(err)
+		}
+		if b.OutgoingTEID() != 0x66666666 {
+			t.Errorf("wrong TEID: got %#x, want %#x", b.OutgoingTEID(), 0x66666666)
+		}
+
+		if err := classifier.Apply(voice, ie.NewTrafficFlowTemplate(ie.TFTOpDeletePacketFiltersFromExistingTFT, nil, []uint8{2}, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if b, err = classifier.Classify(downlink, tft.Downlink); err != nil {
+			t.Fatal(err)
+		}
+		if b.EBI != 5 {
+			t.Errorf("wrong EBI: got %d, want %d", b.EBI, 5)
+		}
+
+		// no bearer is selected without the default bearer.
+		classifier.RemoveBearer(5)
+		if _, err := classifier.Classify(downlink, tft.Downlink); !errors.Is(err, tft.ErrNoMatchingBearer) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := classifier.Classify([]byte{0x45, 0x00}, tft.Downlink); !errors.Is(err, tft.ErrMalformedPacket) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+
+		var uerr *tft.UnsupportedComponentError
+		err := classifier.Apply(voice, ie.NewTrafficFlowTemplate(ie.TFTOpCreateNewTFT, []*ie.TFTPacketFilter{
+			ie.NewTFTPacketFilter(ie.TFTPFBidirectional, 1, 10, ie.NewTFTPFComponent(0xff, []byte{0})),
+		}, nil, nil))
+		if !errors.As(err, &uerr) {
+			t.Errorf("got unexpected error: %v", err)
+		}
+	})
+}
+
+func TestFilter(t *testing.T) {
+	f, err := tft.NewFilter(ie.NewTFTPacketFilter(
+		ie.TFTPFUplinkOnly, 1, 10,
+		ie.NewTFTPFComponentProtocolIdentifierNextHeader(protoUDP),
+		ie.NewTFTPFComponentSingleRemotePort(53),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := net.ParseIP("10.0.0.53")
+	cases := []struct {
+		description string
+		pkt         []byte
+		dir         tft.Direction
+		matched     bool
+	}{
+		{"uplink", ipv4Packet(ueV4, server, 0, protoUDP, ports(40000, 53)), tft.Uplink, true},
+		{"downlink", ipv4Packet(server, ueV4, 0, protoUDP, ports(53, 40000)), tft.Downlink, false},
+		{"other-port", ipv4Packet(ueV4, server, 0, protoUDP, ports(40000, 54)), tft.Uplink, false},
+		{"malformed", []byte{0x45, 0x00}, tft.Uplink, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if got := f.Match(c.pkt, c.dir); got != c.matched {
+				t.Errorf("wrong result: got %v, want %v", got, c.matched)
+			}
+		})
+	}
+}
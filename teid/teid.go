@@ -0,0 +1,247 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package teid provides the allocators of Tunnel Endpoint Identifier(TEID) that
+// can be used with gtpv1.UPlaneConn and gtpv2.Conn.
+package teid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultQuarantine is the Quarantine of the Allocator that is used by
+// gtpv1.UPlaneConn and gtpv2.Conn when no Allocator is set explicitly.
+//
+// It is long enough to cover the retransmissions of a request(T3-RESPONSE * N3-REQUESTS).
+const DefaultQuarantine = 30 * time.Second
+
+// Error definitions.
+var (
+	// ErrExhausted indicates that no TEID is available in the range.
+	ErrExhausted = errors.New("no TEID available")
+
+	// ErrInUse indicates that the TEID is already allocated.
+	ErrInUse = errors.New("TEID is already in use")
+
+	// ErrNotAllocated indicates that the TEID to be freed is not allocated.
+	ErrNotAllocated = errors.New("TEID is not allocated")
+
+	// ErrOutOfRange indicates that the TEID is not in the range of the allocator.
+	ErrOutOfRange = errors.New("TEID is out of range")
+
+	// ErrInvalidRange indicates that the range or partition given is invalid.
+	ErrInvalidRange = errors.New("invalid range of TEID")
+)
+
+// Allocator is the interface that allocates and frees the TEIDs.
+//
+// The implementation should be safe for concurrent use, and should never
+// return 0, which is reserved for the messages without TEID.
+type Allocator interface {
+	// Allocate returns a TEID that is not in use.
+	Allocate() (uint32, error)
+
+	// Reserve marks the TEID given as in use, e.g., when restoring the
+	// sessions or when the TEID is chosen by the user.
+	Reserve(teid uint32) error
+
+	// Free releases the TEID so that it can be allocated again.
+	Free(teid uint32) error
+}
+
+type freed struct {
+	teid uint32
+	at   time.Time
+}
+
+// RangeAllocator is the default implementation of Allocator, which allocates the
+// TEIDs from a range in O(1).
+//
+// The TEIDs that have never been used are allocated in a scrambled order so that
+// they are not easy to guess. The TEIDs freed are reused in the order they are
+// freed, after the Quarantine has passed.
+type RangeAllocator struct {
+	// Quarantine is the duration that a freed TEID is kept from being allocated again.
+	Quarantine time.Duration
+
+	mu      sync.Mutex
+	min     uint32
+	size    uint64
+	cursor  uint64
+	stride  uint64
+	issued  uint64
+	used    map[uint32]struct{}
+	queue   []freed
+	head    int
+	freedAt map[uint32]time.Time
+	now     func() time.Time
+}
+
+// NewAllocator creates a new RangeAllocator that allocates TEIDs between min and
+// max, both inclusive. 0 is never allocated even if min is 0.
+func NewAllocator(min, max uint32) (*RangeAllocator, error) {
+	if min == 0 {
+		min = 1
+	}
+	if min > max {
+		return nil, fmt.Errorf("%#08x-%#08x: %w", min, max, ErrInvalidRange)
+	}
+
+	a := &RangeAllocator{
+		min:     min,
+		size:    uint64(max) - uint64(min) + 1,
+		used:    map[uint32]struct{}{},
+		freedAt: map[uint32]time.Time{},
+		now:     time.Now,
+	}
+	a.cursor = randomUint64() % a.size
+	a.stride = randomUint64()%a.size + 1
+	for gcd(a.stride, a.size) != 1 {
+		a.stride++
+	}
+	return a, nil
+}
+
+// NewPartitionedAllocator creates a new RangeAllocator that allocates TEIDs whose
+// most significant bits are id, i.e., the TEID space is divided into 2^bits
+// partitions and the id-th one is used.
+//
+// This is useful to avoid collisions among the instances or the workers that
+// share the same IP address.
+func NewPartitionedAllocator(bits uint8, id uint32) (*RangeAllocator, error) {
+	if bits >= 32 || uint64(id) >= 1<<bits {
+		return nil, fmt.Errorf("partition %d of %d bits: %w", id, bits, ErrInvalidRange)
+	}
+	if bits == 0 {
+		return NewAllocator(1, math.MaxUint32)
+	}
+
+	shift := 32 - bits
+	min := id << shift
+	return NewAllocator(min, min|(1<<shift-1))
+}
+
+// Contains reports whether the TEID is in the range of the allocator.
+func (a *RangeAllocator) Contains(teid uint32) bool {
+	return teid >= a.min && uint64(teid-a.min) < a.size
+}
+
+// Allocate returns a TEID that is not in use, or ErrExhausted if all the TEIDs
+// in the range are in use or in quarantine.
+func (a *RangeAllocator) Allocate() (uint32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	for a.head < len(a.queue) {
+		f := a.queue[a.head]
+		if at, ok := a.freedAt[f.teid]; !ok || !at.Equal(f.at) {
+			// reserved or freed again after this entry.
+			a.pop()
+			continue
+		}
+		if now.Sub(f.at) < a.Quarantine {
+			break
+		}
+		a.pop()
+		delete(a.freedAt, f.teid)
+		a.used[f.teid] = struct{}{}
+		return f.teid, nil
+	}
+
+	for a.issued < a.size {
+		teid := a.min + uint32(a.cursor)
+		a.cursor = (a.cursor + a.stride) % a.size
+		a.issued++
+
+		if _, ok := a.used[teid]; ok {
+			continue
+		}
+		if _, ok := a.freedAt[teid]; ok {
+			continue
+		}
+		a.used[teid] = struct{}{}
+		return teid, nil
+	}
+
+	return 0, ErrExhausted
+}
+
+// Reserve marks the TEID given as in use. It returns ErrInUse if the TEID is
+// already allocated, and the TEID in quarantine can be reserved.
+func (a *RangeAllocator) Reserve(teid uint32) error {
+	if teid == 0 || !a.Contains(teid) {
+		return fmt.Errorf("%#08x: %w", teid, ErrOutOfRange)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.used[teid]; ok {
+		return fmt.Errorf("%#08x: %w", teid, ErrInUse)
+	}
+	delete(a.freedAt, teid)
+	a.used[teid] = struct{}{}
+	return nil
+}
+
+// Free releases the TEID, which is allocated again after the Quarantine.
+func (a *RangeAllocator) Free(teid uint32) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.used[teid]; !ok {
+		return fmt.Errorf("%#08x: %w", teid, ErrNotAllocated)
+	}
+	delete(a.used, teid)
+
+	f := freed{teid: teid, at: a.now()}
+	a.freedAt[teid] = f.at
+	a.queue = append(a.queue, f)
+	return nil
+}
+
+// InUse returns the number of TEIDs in use.
+func (a *RangeAllocator) InUse() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.used)
+}
+
+func (a *RangeAllocator) pop() {
+	a.head++
+	if a.head == len(a.queue) {
+		a.queue, a.head = a.queue[:0], 0
+		return
+	}
+
+	// compact the queue not to keep growing.
+	if a.head >= 1024 && a.head*2 >= len(a.queue) {
+		n := copy(a.queue, a.queue[a.head:])
+		a.queue, a.head = a.queue[:n], 0
+	}
+}
+
+func randomUint64() uint64 {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
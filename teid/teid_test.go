@@ -0,0 +1,156 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package teid_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/teid"
+)
+
+func TestAllocate(t *testing.T) {
+	a, err := teid.NewAllocator(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[uint32]bool{}
+	for i := 0; i < 100; i++ {
+		v, err := a.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v == 0 || v > 100 {
+			t.Fatalf("TEID out of range: %d", v)
+		}
+		if seen[v] {
+			t.Fatalf("TEID allocated twice: %d", v)
+		}
+		seen[v] = true
+	}
+	if _, err := a.Allocate(); !errors.Is(err, teid.ErrExhausted) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := a.InUse(); got != 100 {
+		t.Errorf("wrong number of TEIDs in use: %d", got)
+	}
+
+	if err := a.Free(50); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Free(50); !errors.Is(err, teid.ErrNotAllocated) {
+		t.Errorf("unexpected error on double free: %v", err)
+	}
+	if v, err := a.Allocate(); err != nil || v != 50 {
+		t.Errorf("freed TEID not reused: %d, %v", v, err)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	cases := []struct {
+		description string
+		bits        uint8
+		id          uint32
+		min, max    uint32
+	}{
+		{"whole", 0, 0, 1, 0xffffffff},
+		{"first-of-4", 2, 0, 1, 0x3fffffff},
+		{"last-of-4", 2, 3, 0xc0000000, 0xffffffff},
+		{"id-of-256", 8, 0x12, 0x12000000, 0x12ffffff},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			a, err := teid.NewPartitionedAllocator(c.bits, c.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !a.Contains(c.min) || !a.Contains(c.max) || a.Contains(c.min-1) || (c.max != 0xffffffff && a.Contains(c.max+1)) {
+				t.Errorf("wrong range")
+			}
+			for i := 0; i < 1000; i++ {
+				v, err := a.Allocate()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v < c.min || v > c.max {
+					t.Fatalf("TEID out of partition: %#08x", v)
+				}
+			}
+		})
+	}
+
+	if _, err := teid.NewPartitionedAllocator(2, 4); !errors.Is(err, teid.ErrInvalidRange) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := teid.NewAllocator(10, 9); !errors.Is(err, teid.ErrInvalidRange) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	a, err := teid.NewAllocator(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Quarantine = 50 * time.Millisecond
+
+	v, err := a.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Free(v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Allocate(); !errors.Is(err, teid.ErrExhausted) {
+		t.Errorf("TEID in quarantine allocated: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if v, err := a.Allocate(); err != nil || v != 1 {
+		t.Errorf("TEID not reused after quarantine: %d, %v", v, err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	a, err := teid.NewAllocator(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Quarantine = time.Hour
+
+	if err := a.Reserve(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reserve(2); !errors.Is(err, teid.ErrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := a.Reserve(4); !errors.Is(err, teid.ErrOutOfRange) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		v, err := a.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v == 2 {
+			t.Errorf("reserved TEID allocated")
+		}
+	}
+
+	// the TEID in quarantine can be reserved explicitly.
+	if err := a.Free(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reserve(2); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := a.Allocate(); !errors.Is(err, teid.ErrExhausted) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
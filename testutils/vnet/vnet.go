@@ -0,0 +1,462 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package vnet provides an in-memory virtual network for testing the Conns in
+// go-gtp without binding any sockets.
+//
+// A Network has endpoints(PacketConn) that implement net.PacketConn with UDP
+// addresses, and the links between the hosts(IPs) can be impaired by loss,
+// duplication, delay, jitter, reordering and partition. The random decisions are
+// made with the seed given to New, so that the tests are reproducible.
+//
+//	n := vnet.New(1)
+//	n.SetLink("127.0.0.1", "127.0.0.2", vnet.Impairment{Loss: 0.3})
+//	mme, _ := n.NewConn("127.0.0.1:2123", gtpv2.IFTypeS11MMEGTPC, 0)
+//	sgw, _ := n.NewConn("127.0.0.2:2123", gtpv2.IFTypeS11S4SGWGTPC, 0)
+//	go mme.Serve(ctx)
+//	go sgw.Serve(ctx)
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+// DefaultQueueLen is the number of packets each PacketConn can hold before they
+// are read. Packets arriving at the full queue are dropped, as a socket does.
+const DefaultQueueLen = 1024
+
+// DefaultReorderTimeout is how long a reordered packet is held at most when no
+// subsequent packet comes on the same link.
+const DefaultReorderTimeout = 50 * time.Millisecond
+
+// ErrAddrInUse indicates that the address is already used by another PacketConn.
+var ErrAddrInUse = errors.New("address already in use")
+
+// Impairment is the set of impairments applied to the packets on a link.
+// The probabilities are in the range of 0 to 1.
+type Impairment struct {
+	// Loss is the probability that a packet is dropped.
+	Loss float64
+
+	// Duplicate is the probability that a packet is delivered twice.
+	Duplicate float64
+
+	// Delay is the constant latency of the link, and Jitter is the maximum
+	// additional latency chosen at random for each packet. Jitter may reorder
+	// the packets as a result.
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// Reorder is the probability that a packet is held and delivered right after
+	// the next packet on the link(or after DefaultReorderTimeout if no packet comes).
+	Reorder float64
+}
+
+// LinkStats is the counters of a link.
+type LinkStats struct {
+	Sent       int // packets written to the link
+	Delivered  int // packets queued at the receiver, including duplicates
+	Lost       int // packets dropped by Loss
+	Duplicated int // packets duplicated
+	Reordered  int // packets held by Reorder
+	Blocked    int // packets dropped by partition
+	Unreached  int // packets dropped as no PacketConn is bound to the destination or its queue is full
+}
+
+type hostPair struct {
+	from, to string
+}
+
+type link struct {
+	imp    Impairment
+	custom bool // imp is set by SetLink
+	stats  LinkStats
+	held   *packet
+}
+
+type packet struct {
+	src     *net.UDPAddr
+	dst     string
+	payload []byte
+}
+
+// Network is an in-memory network that PacketConns are attached to.
+type Network struct {
+	mu          sync.Mutex
+	rand        *rand.Rand
+	conns       map[string]*PacketConn
+	defaultImp  Impairment
+	links       map[hostPair]*link
+	partitioned map[hostPair]bool
+	nextPort    int
+}
+
+// New creates a new Network. The seed is used for the random decisions on impairments.
+func New(seed int64) *Network {
+	return &Network{
+		rand:        rand.New(rand.NewSource(seed)),
+		conns:       map[string]*PacketConn{},
+		links:       map[hostPair]*link{},
+		partitioned: map[hostPair]bool{},
+		nextPort:    49152,
+	}
+}
+
+// SetDefault sets the Impairment applied to the links that have no specific one
+// set by SetLink.
+func (n *Network) SetDefault(imp Impairment) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.defaultImp = imp
+	for _, l := range n.links {
+		if !l.custom {
+			l.imp = imp
+		}
+	}
+}
+
+// SetLink sets the Impairment applied to the packets from host "from" to host "to".
+// The hosts are given as IP addresses. The link is one-directional; call SetLink
+// twice with the hosts swapped to impair both directions.
+func (n *Network) SetLink(from, to string, imp Impairment) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	l := n.link(from, to)
+	l.imp = imp
+	l.custom = true
+}
+
+// Partition blocks the packets between host a and host b in both directions
+// until Heal is called.
+func (n *Network) Partition(a, b string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.partitioned[hostPair{a, b}] = true
+	n.partitioned[hostPair{b, a}] = true
+}
+
+// Heal removes the partition between host a and host b.
+func (n *Network) Heal(a, b string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.partitioned, hostPair{a, b})
+	delete(n.partitioned, hostPair{b, a})
+}
+
+// Stats returns the counters of the link from host "from" to host "to".
+func (n *Network) Stats(from, to string) LinkStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if l, ok := n.links[hostPair{from, to}]; ok {
+		return l.stats
+	}
+	return LinkStats{}
+}
+
+// Listen creates a new PacketConn bound to addr, given in "IP:Port" format.
+// If the port is 0, an ephemeral port is chosen.
+func (n *Network) Listen(addr string) (*PacketConn, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if laddr.IP == nil || laddr.IP.IsUnspecified() {
+		return nil, fmt.Errorf("vnet: IP must be specified: %s", addr)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if laddr.Port == 0 {
+		for {
+			laddr.Port = n.nextPort
+			n.nextPort++
+			if _, ok := n.conns[laddr.String()]; !ok {
+				break
+			}
+		}
+	}
+
+	if _, ok := n.conns[laddr.String()]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "udp", Addr: laddr, Err: ErrAddrInUse}
+	}
+
+	c := &PacketConn{
+		network:   n,
+		laddr:     laddr,
+		rxCh:      make(chan *packet, DefaultQueueLen),
+		closeCh:   make(chan struct{}),
+		deadlineC: make(chan struct{}),
+	}
+	n.conns[laddr.String()] = c
+	return c, nil
+}
+
+// NewConn creates a new gtpv2.Conn that works on the PacketConn bound to addr.
+// Serve should be called to start serving as usual.
+func (n *Network) NewConn(addr string, localIfType, counter uint8) (*gtpv2.Conn, error) {
+	pc, err := n.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return gtpv2.NewConnWithPacketConn(pc, localIfType, counter), nil
+}
+
+// NewUPlaneConn creates a new gtpv1.UPlaneConn that works on the PacketConn bound
+// to addr. ListenAndServe should be called to start serving as usual.
+func (n *Network) NewUPlaneConn(addr string) (*gtpv1.UPlaneConn, error) {
+	pc, err := n.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return gtpv1.NewUPlaneConnWithPacketConn(pc), nil
+}
+
+// link returns the link from host "from" to host "to". n.mu must be held.
+func (n *Network) link(from, to string) *link {
+	key := hostPair{from, to}
+	l, ok := n.links[key]
+	if !ok {
+		l = &link{imp: n.defaultImp}
+		n.links[key] = l
+	}
+	return l
+}
+
+func (n *Network) unbind(c *PacketConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conns[c.laddr.String()] == c {
+		delete(n.conns, c.laddr.String())
+	}
+}
+
+// send applies the impairments of the link and schedules the delivery of p.
+func (n *Network) send(p *packet, dst *net.UDPAddr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := hostPair{p.src.IP.String(), dst.IP.String()}
+	l := n.link(key.from, key.to)
+	l.stats.Sent++
+
+	if n.partitioned[key] {
+		l.stats.Blocked++
+		return
+	}
+	if n.chance(l.imp.Loss) {
+		l.stats.Lost++
+		return
+	}
+
+	copies := 1
+	if n.chance(l.imp.Duplicate) {
+		l.stats.Duplicated++
+		copies++
+	}
+
+	if l.held == nil && n.chance(l.imp.Reorder) {
+		l.stats.Reordered++
+		l.held = p
+		time.AfterFunc(DefaultReorderTimeout+l.imp.Delay, func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if l.held == p {
+				l.held = nil
+				n.deliverLocked(l, p)
+			}
+		})
+		return
+	}
+
+	for i := 0; i < copies; i++ {
+		n.schedule(l, p)
+	}
+
+	if held := l.held; held != nil {
+		l.held = nil
+		n.schedule(l, held)
+	}
+}
+
+// schedule delivers p after the delay of the link. n.mu must be held.
+func (n *Network) schedule(l *link, p *packet) {
+	d := l.imp.Delay
+	if l.imp.Jitter > 0 {
+		d += time.Duration(n.rand.Int63n(int64(l.imp.Jitter)))
+	}
+
+	if d <= 0 {
+		n.deliverLocked(l, p)
+		return
+	}
+
+	time.AfterFunc(d, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		n.deliverLocked(l, p)
+	})
+}
+
+// deliverLocked queues p at the destination. n.mu must be held.
+func (n *Network) deliverLocked(l *link, p *packet) {
+	c, ok := n.conns[p.dst]
+	if !ok {
+		l.stats.Unreached++
+		return
+	}
+
+	select {
+	case c.rxCh <- p:
+		l.stats.Delivered++
+	default:
+		l.stats.Unreached++
+	}
+}
+
+// chance returns true with the probability p. n.mu must be held.
+func (n *Network) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	return n.rand.Float64() < p
+}
+
+// PacketConn is an endpoint on the Network, which implements net.PacketConn.
+type PacketConn struct {
+	network *Network
+	laddr   *net.UDPAddr
+	rxCh    chan *packet
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	deadlineC    chan struct{} // closed when readDeadline is changed
+}
+
+// ReadFrom reads a packet from the connection, copying the payload into p.
+// It implements the net.PacketConn ReadFrom method.
+func (c *PacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.readDeadline, c.deadlineC
+		c.mu.Unlock()
+
+		select {
+		case <-c.closeCh:
+			return 0, nil, c.opError("read", net.ErrClosed)
+		default:
+		}
+
+		var (
+			timeout <-chan time.Time
+			timer   *time.Timer
+		)
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case <-c.closeCh:
+			err = c.opError("read", net.ErrClosed)
+		case pkt := <-c.rxCh:
+			n, addr = copy(p, pkt.payload), pkt.src
+		case <-timeout:
+			err = c.opError("read", os.ErrDeadlineExceeded)
+		case <-changed:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if addr != nil || err != nil {
+			return n, addr, err
+		}
+	}
+}
+
+// WriteTo writes a packet with payload p to addr. As UDP does, it succeeds even
+// if the packet is not delivered.
+// It implements the net.PacketConn WriteTo method.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	select {
+	case <-c.closeCh:
+		return 0, c.opError("write", net.ErrClosed)
+	default:
+	}
+
+	dst, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return 0, c.opError("write", err)
+	}
+
+	payload := make([]byte, len(p))
+	copy(payload, p)
+	c.network.send(&packet{src: c.laddr, dst: dst.String(), payload: payload}, dst)
+	return len(p), nil
+}
+
+// Close closes the connection and releases the address.
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+		c.network.unbind(c)
+	})
+	return nil
+}
+
+// LocalAddr returns the local network address, which is *net.UDPAddr.
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// SetDeadline sets the read deadline. Writes never block on PacketConn.
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future ReadFrom calls and any
+// currently-blocked ReadFrom call.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	close(c.deadlineC)
+	c.deadlineC = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline does nothing, as writes never block on PacketConn.
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.laddr, Err: err}
+}
@@ -0,0 +1,311 @@
+// Copyright 2019-2023 go-gtp authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package vnet_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"github.com/wmnsk/go-gtp/testutils/vnet"
+)
+
+func pair(t *testing.T, n *vnet.Network) (a, b *vnet.PacketConn) {
+	t.Helper()
+
+	a, err := n.Listen("10.0.0.1:2123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = n.Listen("10.0.0.2:2123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// receive reads packets until no packet comes for 100ms.
+func receive(t *testing.T, c *vnet.PacketConn) [][]byte {
+	t.Helper()
+
+	var got [][]byte
+	buf := make([]byte, 1500)
+	for {
+		if err := c.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return got
+			}
+			t.Fatal(err)
+		}
+		got = append(got, append([]byte(nil), buf[:n]...))
+	}
+}
+
+func send(t *testing.T, c *vnet.PacketConn, to net.Addr, payloads ...byte) {
+	t.Helper()
+
+	for _, p := range payloads {
+		if _, err := c.WriteTo([]byte{p}, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	n := vnet.New(1)
+	a, b := pair(t, n)
+
+	send(t, a, b.LocalAddr(), 1, 2, 3)
+
+	buf := make([]byte, 10)
+	for i := byte(1); i <= 3; i++ {
+		l, from, err := b.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from.String() != a.LocalAddr().String() {
+			t.Errorf("wrong source. got: %s, want: %s", from, a.LocalAddr())
+		}
+		if !bytes.Equal(buf[:l], []byte{i}) {
+			t.Errorf("wrong payload. got: %x, want: %x", buf[:l], i)
+		}
+	}
+
+	if got, want := n.Stats("10.0.0.1", "10.0.0.2"), (vnet.LinkStats{Sent: 3, Delivered: 3}); got != want {
+		t.Errorf("wrong stats. got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestImpairments(t *testing.T) {
+	cases := []struct {
+		description string
+		imp         vnet.Impairment
+		check       func(t *testing.T, got [][]byte, st vnet.LinkStats)
+	}{
+		{
+			"loss",
+			vnet.Impairment{Loss: 1},
+			func(t *testing.T, got [][]byte, st vnet.LinkStats) {
+				if len(got) != 0 || st.Lost != 4 {
+					t.Errorf("packets not lost: %x, %+v", got, st)
+				}
+			},
+		}, {
+			"duplicate",
+			vnet.Impairment{Duplicate: 1},
+			func(t *testing.T, got [][]byte, st vnet.LinkStats) {
+				want := [][]byte{{1}, {1}, {2}, {2}, {3}, {3}, {4}, {4}}
+				if len(got) != len(want) || st.Duplicated != 4 {
+					t.Errorf("packets not duplicated: %x, %+v", got, st)
+				}
+			},
+		}, {
+			"reorder",
+			vnet.Impairment{Reorder: 1},
+			func(t *testing.T, got [][]byte, st vnet.LinkStats) {
+				// every other packet is held until the next one is sent.
+				want := [][]byte{{2}, {1}, {4}, {3}}
+				for i := range want {
+					if i >= len(got) || !bytes.Equal(got[i], want[i]) {
+						t.Fatalf("packets not reordered: got: %x, want: %x", got, want)
+					}
+				}
+			},
+		}, {
+			"delay",
+			vnet.Impairment{Delay: 50 * time.Millisecond, Jitter: 10 * time.Millisecond},
+			func(t *testing.T, got [][]byte, st vnet.LinkStats) {
+				if len(got) != 4 {
+					t.Errorf("packets lost: %x, %+v", got, st)
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			n := vnet.New(1)
+			a, b := pair(t, n)
+			n.SetLink("10.0.0.1", "10.0.0.2", c.imp)
+
+			start := time.Now()
+			send(t, a, b.LocalAddr(), 1, 2, 3, 4)
+
+			buf := make([]byte, 10)
+			if c.imp.Delay > 0 {
+				if _, _, err := b.ReadFrom(buf); err != nil {
+					t.Fatal(err)
+				}
+				if elapsed := time.Since(start); elapsed < c.imp.Delay {
+					t.Errorf("delivered too early: %s", elapsed)
+				}
+				c.check(t, append([][]byte{{0}}, receive(t, b)...), n.Stats("10.0.0.1", "10.0.0.2"))
+				return
+			}
+			c.check(t, receive(t, b), n.Stats("10.0.0.1", "10.0.0.2"))
+		})
+	}
+}
+
+func TestPartition(t *testing.T) {
+	n := vnet.New(1)
+	a, b := pair(t, n)
+
+	n.Partition("10.0.0.1", "10.0.0.2")
+	send(t, a, b.LocalAddr(), 1)
+	if got := receive(t, b); len(got) != 0 {
+		t.Errorf("delivered over partition: %x", got)
+	}
+
+	n.Heal("10.0.0.2", "10.0.0.1")
+	send(t, a, b.LocalAddr(), 2)
+	if got := receive(t, b); len(got) != 1 {
+		t.Errorf("not delivered after healed: %x", got)
+	}
+
+	if got, want := n.Stats("10.0.0.1", "10.0.0.2"), (vnet.LinkStats{Sent: 2, Delivered: 1, Blocked: 1}); got != want {
+		t.Errorf("wrong stats. got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestClose(t *testing.T) {
+	n := vnet.New(1)
+	a, b := pair(t, n)
+
+	errCh := make(chan error)
+	go func() {
+		_, _, err := b.ReadFrom(make([]byte, 10))
+		errCh <- err
+	}()
+
+	b.Close()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadFrom not unblocked")
+	}
+
+	// the address can be reused after closed, and the packets to the closed one are just lost.
+	send(t, a, b.LocalAddr(), 1)
+	if _, err := n.Listen(b.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.Listen(a.LocalAddr().String()); err == nil {
+		t.Error("bound to the address in use")
+	}
+	if got := n.Stats("10.0.0.1", "10.0.0.2").Unreached; got != 1 {
+		t.Errorf("wrong number of unreached packets. got: %d, want: 1", got)
+	}
+}
+
+func TestConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	cli, err := n.NewConn("10.0.0.1:2123", gtpv2.IFTypeS11MMEGTPC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := n.NewConn("10.0.0.2:2123", gtpv2.IFTypeS11S4SGWGTPC, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rspCh := make(chan *message.EchoResponse)
+	cli.AddHandler(message.MsgTypeEchoResponse, func(c *gtpv2.Conn, raddr net.Addr, msg message.Message) error {
+		rspCh <- msg.(*message.EchoResponse)
+		return nil
+	})
+
+	for _, c := range []*gtpv2.Conn{cli, srv} {
+		if err := c.Listen(ctx); err != nil {
+			t.Fatal(err)
+		}
+		go func(c *gtpv2.Conn) {
+			if err := c.Serve(ctx); err != nil {
+				t.Error(err)
+			}
+		}(c)
+	}
+
+	// the request is lost on the lossy link, and the next one is answered after recovered.
+	n.SetLink("10.0.0.1", "10.0.0.2", vnet.Impairment{Loss: 1})
+	if _, err := cli.EchoRequest(srv.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-rspCh:
+		t.Fatal("got response to the lost request")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	n.SetLink("10.0.0.1", "10.0.0.2", vnet.Impairment{})
+	if _, err := cli.EchoRequest(srv.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case rsp := <-rspCh:
+		if got := rsp.Recovery.MustRecovery(); got != 7 {
+			t.Errorf("wrong restart counter. got: %d, want: 7", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no response")
+	}
+}
+
+func TestUPlaneConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := vnet.New(1)
+	enb, err := n.NewUPlaneConn("10.0.0.1:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgw, err := n.NewUPlaneConn("10.0.0.2:2152")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgw.DisableErrorIndication()
+
+	for _, u := range []interface{ ListenAndServe(context.Context) error }{enb, sgw} {
+		go func(u interface{ ListenAndServe(context.Context) error }) {
+			if err := u.ListenAndServe(ctx); err != nil {
+				t.Error(err)
+			}
+		}(u)
+	}
+
+	if _, err := enb.WriteToGTP(0x11223344, []byte{0xde, 0xad, 0xbe, 0xef}, sgw.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 100)
+	l, from, teid, err := sgw.ReadFromGTP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "10.0.0.1:2152" || teid != 0x11223344 || !bytes.Equal(buf[:l], []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("unexpected T-PDU: from=%s, teid=%#x, payload=%x", from, teid, buf[:l])
+	}
+}